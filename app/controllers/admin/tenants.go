@@ -0,0 +1,156 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/twinj/uuid"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// HandleCreateTenant registers a tenant and builds its schema
+func HandleCreateTenant(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+	traceId := r.Header.Get("trace-id")
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+
+	var requestBody pkg.TenantRequest
+	err = json.Unmarshal(body, &requestBody)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestBody.Name == "" {
+		requestBody.Name = requestBody.TenantNamespace
+	}
+
+	tenant, err := pkg.CreateTenant(requestBody.TenantNamespace, requestBody.Name)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, statusOf(err))
+		return
+	}
+	logs.Logger.Info("Provisioned tenant ", tenant.TenantNamespace)
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(struct {
+		Data pkg.Tenant `json:"data"`
+		Meta pkg.Meta   `json:"meta"`
+	}{
+		Data: tenant,
+		Meta: pkg.Meta{
+			Timestamp:     time.Now(),
+			TransactionId: transactionId.String(),
+			TraceId:       traceId,
+			Status:        "SUCCESS",
+		},
+	})
+}
+
+func HandleFetchTenants(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+	traceId := r.Header.Get("trace-id")
+
+	tenants, err := pkg.FetchTenants()
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(struct {
+		Data []pkg.Tenant `json:"data"`
+		Meta pkg.Meta     `json:"meta"`
+	}{
+		Data: tenants,
+		Meta: pkg.Meta{
+			Timestamp:     time.Now(),
+			TransactionId: transactionId.String(),
+			TraceId:       traceId,
+			Status:        "SUCCESS",
+		},
+	})
+}
+
+// HandleSuspendTenant stops the tenant's schedules and token refreshes while keeping its data
+func HandleSuspendTenant(w http.ResponseWriter, r *http.Request) {
+	setTenantStatus(w, r, pkg.TenantSuspended, "Tenant suspended")
+}
+
+func HandleResumeTenant(w http.ResponseWriter, r *http.Request) {
+	setTenantStatus(w, r, pkg.TenantActive, "Tenant resumed")
+}
+
+func setTenantStatus(w http.ResponseWriter, r *http.Request, status string, uiMessage string) {
+	transactionId := uuid.NewV4()
+	traceId := r.Header.Get("trace-id")
+	namespace := mux.Vars(r)["namespace"]
+
+	err := pkg.SetTenantStatus(namespace, status)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, statusOf(err))
+		return
+	}
+	logs.Logger.Infof("Tenant %s is now %s", namespace, status)
+
+	_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
+		Data: pkg.Data{
+			Id:        namespace,
+			UiMessage: uiMessage,
+		},
+		Meta: pkg.Meta{
+			Timestamp:     time.Now(),
+			TransactionId: transactionId.String(),
+			TraceId:       traceId,
+			Status:        "SUCCESS",
+		},
+	})
+}
+
+// HandleDropTenant deletes a suspended tenant along with all of its data
+func HandleDropTenant(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+	traceId := r.Header.Get("trace-id")
+	namespace := mux.Vars(r)["namespace"]
+
+	err := pkg.DropTenant(namespace)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, statusOf(err))
+		return
+	}
+	logs.Logger.Info("Dropped tenant ", namespace)
+
+	_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
+		Data: pkg.Data{
+			Id:        namespace,
+			UiMessage: "Tenant dropped",
+		},
+		Meta: pkg.Meta{
+			Timestamp:     time.Now(),
+			TransactionId: transactionId.String(),
+			TraceId:       traceId,
+			Status:        "SUCCESS",
+		},
+	})
+}
+
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, pkg.ErrInvalidNamespace):
+		return http.StatusBadRequest
+	case errors.Is(err, pkg.ErrTenantNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, pkg.ErrTenantExists), errors.Is(err, pkg.ErrTenantActive):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,135 @@
+/* Package apikeys serves the API keys of a tenant, servers such as a CI or a CMS call the API with them instead of a user token.
+A key is shown once when it is created, only its hash is stored. */
+package apikeys
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/twinj/uuid"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"net/http"
+	"time"
+)
+
+// Handler serves the API key endpoints using the repository it is given
+type Handler struct {
+	Keys repository.ApiKeyRepository
+}
+
+func NewHandler(keys repository.ApiKeyRepository) *Handler {
+	return &Handler{Keys: keys}
+}
+
+/* HandleCreateApiKey serves POST /api-keys, the response holds the key itself, it cannot be fetched again.
+The name is required and the scopes have to be known, the message of the 400 tells which one is not. */
+func (h *Handler) HandleCreateApiKey(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+
+	headers, err := pkg.ValidateHeaders(r)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, "", err, http.StatusBadRequest)
+		return
+	}
+	traceId := headers["trace-id"]
+	tenantNamespace := headers["tenant-namespace"]
+
+	var request pkg.ApiKeyRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+	request, err = pkg.ValidateApiKeyRequest(request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
+			Data: pkg.Data{UiMessage: err.Error()},
+			Meta: responseMeta(transactionId, traceId, "FAILED"),
+		})
+		return
+	}
+
+	var createdBy string
+	if claims, ok := pkg.ClaimsFromContext(r.Context()); ok {
+		createdBy = claims.Subject
+	}
+	key, err := pkg.NewApiKey(request, createdBy)
+	if err == nil {
+		err = h.Keys.Create(tenantNamespace, key)
+	}
+	if err != nil {
+		_ = logs.Logger.Error(err)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
+	}
+	logs.Logger.Infof("API key %s created in %s by %s", key.KeyId, tenantNamespace, createdBy)
+
+	key.CreatedAt = time.Now()
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(pkg.ApiKeyResponse{Data: key, Meta: responseMeta(transactionId, traceId, "SUCCESS")})
+}
+
+// HandleListApiKeys serves GET /api-keys, revoked keys are listed along with when they were revoked
+func (h *Handler) HandleListApiKeys(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+
+	headers, err := pkg.ValidateHeaders(r)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, "", err, http.StatusBadRequest)
+		return
+	}
+	traceId := headers["trace-id"]
+	tenantNamespace := headers["tenant-namespace"]
+
+	keys, err := h.Keys.List(tenantNamespace)
+	if err != nil {
+		_ = logs.Logger.Error(err)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(pkg.ApiKeyListResponse{Data: keys, Meta: responseMeta(transactionId, traceId, "SUCCESS")})
+}
+
+// HandleRevokeApiKey serves DELETE /api-keys/{id}, the key is refused from then on, 404 when it is unknown or revoked already
+func (h *Handler) HandleRevokeApiKey(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+
+	headers, err := pkg.ValidateHeaders(r)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, "", err, http.StatusBadRequest)
+		return
+	}
+	traceId := headers["trace-id"]
+	tenantNamespace := headers["tenant-namespace"]
+
+	keyId := mux.Vars(r)["id"]
+	err = h.Keys.Revoke(tenantNamespace, keyId)
+	if err == repository.ErrNotFound {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		_ = logs.Logger.Error(err)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
+	}
+	logs.Logger.Infof("API key %s revoked in %s", keyId, tenantNamespace)
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
+		Data: pkg.Data{Id: keyId, UiMessage: "API Key Revoked!"},
+		Meta: responseMeta(transactionId, traceId, "SUCCESS"),
+	})
+}
+
+func responseMeta(transactionId uuid.UUID, traceId string, status string) pkg.Meta {
+	return pkg.Meta{
+		Timestamp:     time.Now(),
+		TransactionId: transactionId.String(),
+		TraceId:       traceId,
+		Status:        status,
+	}
+}
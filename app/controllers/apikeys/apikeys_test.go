@@ -0,0 +1,78 @@
+package apikeys
+
+import (
+	"encoding/json"
+	"github.com/cristalhq/jwt"
+	"github.com/gorilla/mux"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/auth"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newRequest(method string, target string, keyId string, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("trace-id", "trace")
+	req = mux.SetURLVars(req, map[string]string{"id": keyId})
+	ctx := pkg.WithTenant(req.Context(), pkg.Tenant{TenantNamespace: "postit", Status: pkg.TenantActive})
+	ctx = pkg.WithClaims(ctx, &auth.Claims{StandardClaims: jwt.StandardClaims{Subject: "ama"}})
+	return req.WithContext(ctx)
+}
+
+func TestApiKeys(t *testing.T) {
+	h := NewHandler(repository.NewMemoryApiKeyRepository())
+
+	rec := httptest.NewRecorder()
+	h.HandleCreateApiKey(rec, newRequest(http.MethodPost, "/api-keys", "", `{"name":"CI","scopes":["posts:write","media:write"]}`))
+	var created pkg.ApiKeyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("expected the key to be created got %d: %s", rec.Code, rec.Body)
+	}
+	key := created.Data
+	if !strings.HasPrefix(key.Key, pkg.ApiKeyPrefix) || !strings.HasPrefix(key.Key, key.Prefix) || key.CreatedBy != "ama" || strings.Contains(rec.Body.String(), pkg.HashApiKey(key.Key)) {
+		t.Fatalf("expected the key and no hash in the response got %s", rec.Body)
+	}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no name", `{"name":" ","scopes":["posts:write"]}`},
+		{"no scopes", `{"name":"CMS","scopes":[]}`},
+		{"unknown scope", `{"name":"CMS","scopes":["tenants:write"]}`},
+	}
+	for _, test := range tests {
+		rec = httptest.NewRecorder()
+		h.HandleCreateApiKey(rec, newRequest(http.MethodPost, "/api-keys", "", test.body))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400 got %d", test.name, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleListApiKeys(rec, newRequest(http.MethodGet, "/api-keys", "", ""))
+	var listed pkg.ApiKeyListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || len(listed.Data) != 1 {
+		t.Fatalf("expected the key to be listed got %d: %s", rec.Code, rec.Body)
+	}
+	if listed.Data[0].Key != "" || listed.Data[0].Prefix != key.Prefix || strings.Join(listed.Data[0].Scopes, " ") != "posts:write media:write" {
+		t.Fatalf("expected the key to be listed without its secret got %+v", listed.Data[0])
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleRevokeApiKey(rec, newRequest(http.MethodDelete, "/api-keys/"+key.KeyId, key.KeyId, ""))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the key to be revoked got %d", rec.Code)
+	}
+	if _, err := h.Keys.Authenticate("postit", pkg.HashApiKey(key.Key)); err != repository.ErrNotFound {
+		t.Fatalf("expected a revoked key to be refused got %v", err)
+	}
+	rec = httptest.NewRecorder()
+	h.HandleRevokeApiKey(rec, newRequest(http.MethodDelete, "/api-keys/"+key.KeyId, key.KeyId, ""))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected a second revocation to answer 404 got %d", rec.Code)
+	}
+}
@@ -0,0 +1,174 @@
+package media
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/twinj/uuid"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/pipeline"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HandleListMedia serves GET /media, see pkg.ParseMediaQuery for the parameters
+func (h *Handler) HandleListMedia(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+
+	headers, err := pkg.ValidateHeaders(r)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, "", err, http.StatusBadRequest)
+		return
+	}
+	traceId := headers["trace-id"]
+	tenantNamespace := headers["tenant-namespace"]
+
+	query, err := pkg.ParseMediaQuery(r.URL.Query())
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.Media.List(tenantNamespace, query)
+	if err != nil {
+		_ = logs.Logger.Error(err)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(pkg.MediaListResponse{
+		Data: page.Media,
+		Meta: pkg.PageMeta{
+			Meta:       responseMeta(transactionId, traceId, "SUCCESS"),
+			NextCursor: page.NextCursor,
+			Total:      page.Total,
+		},
+	})
+}
+
+/* HandleUpdateMedia serves PATCH /media/{id}, it renames and tags a media record.
+Uploads changed this way are kept in the library even when no post uses them. */
+func (h *Handler) HandleUpdateMedia(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+
+	headers, err := pkg.ValidateHeaders(r)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, "", err, http.StatusBadRequest)
+		return
+	}
+	traceId := headers["trace-id"]
+	tenantNamespace := headers["tenant-namespace"]
+
+	var update pkg.MediaUpdate
+	err = json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+
+	media, err := h.Media.Get(tenantNamespace, mux.Vars(r)["id"])
+	if err == nil {
+		if update.Name != nil {
+			media.Name = strings.TrimSpace(*update.Name)
+		}
+		if update.Tags != nil {
+			media.Tags = pkg.NormalizeTags(update.Tags)
+		}
+		if len(media.Name) > 255 {
+			pkg.SendErrorResponse(w, transactionId, traceId, fmt.Errorf("name may be 255 characters at most"), http.StatusBadRequest)
+			return
+		}
+		err = h.Media.Update(tenantNamespace, media)
+	}
+	if err == repository.ErrNotFound {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		_ = logs.Logger.Error(err)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
+	}
+
+	media.Attached = true
+	_ = json.NewEncoder(w).Encode(pkg.MediaResponse{Data: media, Meta: responseMeta(transactionId, traceId, "SUCCESS")})
+}
+
+/* HandleDeleteMedia serves DELETE /media/{id}.
+Media still used by posts are refused with 409 unless ?force=true, which removes them from those posts as well. */
+func (h *Handler) HandleDeleteMedia(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+
+	headers, err := pkg.ValidateHeaders(r)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, "", err, http.StatusBadRequest)
+		return
+	}
+	traceId := headers["trace-id"]
+	tenantNamespace := headers["tenant-namespace"]
+
+	force := false
+	if value := r.URL.Query().Get("force"); value != "" {
+		force, err = strconv.ParseBool(value)
+		if err != nil {
+			pkg.SendErrorResponse(w, transactionId, traceId, fmt.Errorf("force must be true or false"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	mediaId := mux.Vars(r)["id"]
+	released := false
+	media, err := h.Media.Get(tenantNamespace, mediaId)
+	if err == nil {
+		released, err = h.Media.Delete(tenantNamespace, mediaId, force)
+	}
+	if err == repository.ErrInUse {
+		// the posts are looked up again, another one may have taken the media up in between
+		media, _ = h.Media.Get(tenantNamespace, mediaId)
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
+			Data: pkg.Data{
+				Id:        mediaId,
+				UiMessage: fmt.Sprintf("The media is used by posts %s, delete it with force=true to remove it from them", strings.Join(media.PostIds, ", ")),
+			},
+			Meta: responseMeta(transactionId, traceId, "FAILED"),
+		})
+		return
+	}
+	if err == repository.ErrNotFound {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		_ = logs.Logger.Error(err)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the record is gone, content shared with other records stays and content left behind in the store is only logged
+	if released {
+		err = pipeline.Remove(h.Store, tenantNamespace, media)
+		if err != nil {
+			_ = logs.Logger.Errorf("unable to remove the content of media %s: %v", media.MediaId, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
+		Data: pkg.Data{Id: media.MediaId, UiMessage: "Media Deleted!"},
+		Meta: responseMeta(transactionId, traceId, "SUCCESS"),
+	})
+}
+
+func responseMeta(transactionId uuid.UUID, traceId string, status string) pkg.Meta {
+	return pkg.Meta{
+		Timestamp:     time.Now(),
+		TransactionId: transactionId.String(),
+		TraceId:       traceId,
+		Status:        status,
+	}
+}
@@ -0,0 +1,165 @@
+package media
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newLibraryRequest(method string, target string, mediaId string, body io.Reader) *http.Request {
+	req := newMediaTargetRequest(mediaId, target)
+	req.Method = method
+	req.Body = io.NopCloser(body)
+	req.Header.Set("trace-id", "trace")
+	if mediaId == "" {
+		req = mux.SetURLVars(req, nil)
+	}
+	return req
+}
+
+// newLibrary stores a media record per name, the first one being the oldest
+func newLibrary(t *testing.T, names ...string) (*Handler, *repository.MemoryPostRepository, []pkg.Media) {
+	posts := repository.NewMemoryPostRepository()
+	h := NewHandler(repository.NewMemoryMediaRepository(posts), storage.NewMemoryStore())
+
+	var library []pkg.Media
+	for i, name := range names {
+		media := pkg.NewMedia([]byte(name))
+		media.Name = name
+		media.CreatedAt = time.Now().Add(time.Duration(i-len(names)) * time.Minute)
+		key, _ := pkg.MediaKey("postit", media.MediaId)
+		if err := h.Store.Put(key, []byte(name), media.ContentType); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.Media.Create("postit", media); err != nil {
+			t.Fatal(err)
+		}
+		library = append(library, media)
+	}
+	return h, posts, library
+}
+
+func TestHandleListMedia(t *testing.T) {
+	h, _, library := newLibrary(t, "bakery front.jpg", "bread.png", "Cakes.png")
+	library[1].Tags = []string{"#Bread", "menu"}
+	if err := h.Media.Update("postit", library[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(query string) pkg.MediaListResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.HandleListMedia(rec, newLibraryRequest(http.MethodGet, "/media?"+query, "", strings.NewReader("")))
+		var response pkg.MediaListResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected the library got %d: %s", query, rec.Code, rec.Body)
+		}
+		return response
+	}
+
+	page := list("limit=2")
+	if page.Meta.Total != 3 || len(page.Data) != 2 || page.Data[0].Name != "Cakes.png" || page.Meta.NextCursor == "" {
+		t.Fatalf("expected the newest media first got %+v", page)
+	}
+	page = list("limit=2&after=" + page.Meta.NextCursor)
+	if len(page.Data) != 1 || page.Data[0].Name != "bakery front.jpg" || page.Meta.NextCursor != "" {
+		t.Fatalf("expected the last page got %+v", page)
+	}
+
+	page = list("tag=bread")
+	if len(page.Data) != 1 || page.Data[0].MediaId != library[1].MediaId || strings.Join(page.Data[0].Tags, ",") != "bread,menu" {
+		t.Fatalf("expected the tagged media got %+v", page.Data)
+	}
+	if page = list("q=CAKE"); len(page.Data) != 1 || page.Data[0].Name != "Cakes.png" {
+		t.Fatalf("expected the name to be searched got %+v", page.Data)
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleListMedia(rec, newLibraryRequest(http.MethodGet, "/media?kind=audio", "", strings.NewReader("")))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown kind got %d", rec.Code)
+	}
+}
+
+func TestHandleUpdateMedia(t *testing.T) {
+	h, _, library := newLibrary(t, "IMG_0001.jpg")
+	mediaId := library[0].MediaId
+
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"name":" Storefront ","tags":["Shop","shop",""]}`)
+	h.HandleUpdateMedia(rec, newLibraryRequest(http.MethodPatch, "/media/"+mediaId, mediaId, body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body)
+	}
+
+	stored, _ := h.Media.Get("postit", mediaId)
+	if stored.Name != "Storefront" || strings.Join(stored.Tags, ",") != "shop" || !stored.Attached {
+		t.Fatalf("expected the media to be renamed, tagged and kept got %+v", stored)
+	}
+
+	// tags are left alone when only the name is sent
+	rec = httptest.NewRecorder()
+	h.HandleUpdateMedia(rec, newLibraryRequest(http.MethodPatch, "/media/"+mediaId, mediaId, strings.NewReader(`{"name":"Shop"}`)))
+	if stored, _ = h.Media.Get("postit", mediaId); stored.Name != "Shop" || len(stored.Tags) != 1 {
+		t.Fatalf("unexpected media %+v", stored)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleUpdateMedia(rec, newLibraryRequest(http.MethodPatch, "/media/missing", "missing", strings.NewReader(`{"name":"x"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", rec.Code)
+	}
+}
+
+func TestHandleDeleteMedia(t *testing.T) {
+	h, posts, library := newLibrary(t, "used.png", "unused.png")
+	used, unused := library[0].MediaId, library[1].MediaId
+	post := pkg.DbPost{PostId: "8b0b2b0c-0a4b-4c55-9d8b-0d0d7e2f1f0a", Media: pkg.MediaRefs([]string{used, unused})}
+	if err := posts.Create("postit", post); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, _ := h.Media.Get("postit", used)
+	if len(stored.PostIds) != 1 || stored.PostIds[0] != post.PostId {
+		t.Fatalf("expected the post to be tracked got %+v", stored.PostIds)
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleDeleteMedia(rec, newLibraryRequest(http.MethodDelete, "/media/"+used, used, strings.NewReader("")))
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), post.PostId) {
+		t.Fatalf("expected 409 naming the post got %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := h.Media.Get("postit", used); err != nil {
+		t.Fatalf("expected the media to be kept got %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleDeleteMedia(rec, newLibraryRequest(http.MethodDelete, "/media/"+used+"?force=true", used, strings.NewReader("")))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := h.Media.Get("postit", used); err != repository.ErrNotFound {
+		t.Fatalf("expected the media to be deleted got %v", err)
+	}
+	if keys, _ := h.Store.List("postit/"); len(keys) != 1 {
+		t.Fatalf("expected the content to be removed got %v", keys)
+	}
+	storedPost, _ := posts.Get("postit", post.PostId)
+	if len(storedPost.Media) != 1 || storedPost.Media[0].Id != unused {
+		t.Fatalf("expected the media to be removed from the post got %+v", storedPost.Media)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleDeleteMedia(rec, newLibraryRequest(http.MethodDelete, "/media/"+used, used, strings.NewReader("")))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", rec.Code)
+	}
+}
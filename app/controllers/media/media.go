@@ -0,0 +1,93 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/twinj/uuid"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
+	"net/http"
+)
+
+// Handler serves the content of media records from the media store
+type Handler struct {
+	Media repository.MediaRepository
+	Store storage.MediaStore
+}
+
+func NewHandler(media repository.MediaRepository, store storage.MediaStore) *Handler {
+	return &Handler{Media: media, Store: store}
+}
+
+/* HandleGetMedia serves GET /media/{id}.
+Like every media route it needs the token or API key of the caller, img tags cannot send them,
+so clients fetch the content with their credentials and show it from a blob url.
+The trace-id header is optional here, only the credentials are needed to fetch content.
+http.ServeContent answers range and conditional requests, the checksum doubles as ETag. */
+func (h *Handler) HandleGetMedia(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+	traceId := r.Header.Get("trace-id")
+
+	tenant, ok := pkg.TenantFromContext(r.Context())
+	if !ok {
+		pkg.SendErrorResponse(w, transactionId, traceId, pkg.ErrTenantNotResolved, http.StatusBadRequest)
+		return
+	}
+
+	mediaId := mux.Vars(r)["id"]
+	media, err := h.Media.Get(tenant.TenantNamespace, mediaId)
+	if err == repository.ErrNotFound {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		_ = logs.Logger.Error(err)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
+	}
+
+	// ?rendition= picks one of the renditions instead of the original
+	key, err := pkg.MediaKey(tenant.TenantNamespace, media.BlobId)
+	contentType, etag := media.ContentType, media.Checksum
+	if name := r.URL.Query().Get("rendition"); name != "" {
+		rendition, ok := findRendition(media, name)
+		if !ok {
+			pkg.SendErrorResponse(w, transactionId, traceId, fmt.Errorf("unknown rendition %s", name), http.StatusNotFound)
+			return
+		}
+		key, err = pkg.RenditionKey(tenant.TenantNamespace, media.BlobId, rendition.Name)
+		contentType, etag = rendition.ContentType, media.Checksum+"-"+rendition.Name
+	}
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
+	}
+	content, err := h.Store.Get(key)
+	if err == storage.ErrNotFound {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		_ = logs.Logger.Error(err)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
+	}
+
+	// media records never change, a new image gets a new id
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	http.ServeContent(w, r, "", media.CreatedAt, bytes.NewReader(content))
+}
+
+func findRendition(media pkg.Media, name string) (pkg.Rendition, bool) {
+	for _, rendition := range media.Renditions {
+		if rendition.Name == name {
+			return rendition, true
+		}
+	}
+	return pkg.Rendition{}, false
+}
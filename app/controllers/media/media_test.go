@@ -0,0 +1,97 @@
+package media
+
+import (
+	"github.com/gorilla/mux"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newMediaRequest(mediaId string) *http.Request {
+	return newMediaTargetRequest(mediaId, "/media/"+mediaId)
+}
+
+func newMediaTargetRequest(mediaId string, target string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": mediaId})
+	return req.WithContext(pkg.WithTenant(req.Context(), pkg.Tenant{TenantNamespace: "postit", Status: pkg.TenantActive}))
+}
+
+func TestHandleGetMedia(t *testing.T) {
+	h := NewHandler(repository.NewMemoryMediaRepository(nil), storage.NewMemoryStore())
+	data := []byte("GIF89a some image data")
+	media := pkg.NewMedia(data)
+	key, _ := pkg.MediaKey("postit", media.MediaId)
+	if err := h.Store.Put(key, data, media.ContentType); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Media.Create("postit", media); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleGetMedia(rec, newMediaRequest(media.MediaId))
+	if rec.Code != http.StatusOK || rec.Body.String() != string(data) {
+		t.Fatalf("expected the media content got %d: %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Content-Type") != "image/gif" {
+		t.Fatalf("unexpected content type %s", rec.Header().Get("Content-Type"))
+	}
+	etag := rec.Header().Get("ETag")
+	if etag != `"`+media.Checksum+`"` {
+		t.Fatalf("unexpected etag %s", etag)
+	}
+
+	rec = httptest.NewRecorder()
+	req := newMediaRequest(media.MediaId)
+	req.Header.Set("Range", "bytes=0-5")
+	h.HandleGetMedia(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "GIF89a" {
+		t.Fatalf("expected the first six bytes got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	req = newMediaRequest(media.MediaId)
+	req.Header.Set("If-None-Match", etag)
+	h.HandleGetMedia(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleGetMedia(rec, newMediaRequest("missing"))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", rec.Code)
+	}
+}
+
+func TestHandleGetMediaRendition(t *testing.T) {
+	h := NewHandler(repository.NewMemoryMediaRepository(nil), storage.NewMemoryStore())
+	media := pkg.NewMedia([]byte("GIF89a original"))
+	media.Renditions = []pkg.Rendition{{Name: "twitter_card", Network: "twitter", ContentType: "image/png"}}
+	key, _ := pkg.RenditionKey("postit", media.MediaId, "twitter_card")
+	if err := h.Store.Put(key, []byte("card"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Media.Create("postit", media); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleGetMedia(rec, newMediaTargetRequest(media.MediaId, pkg.RenditionUrl(media.MediaId, "twitter_card")))
+	if rec.Code != http.StatusOK || rec.Body.String() != "card" || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("expected the rendition got %d: %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("ETag") != `"`+media.Checksum+`-twitter_card"` {
+		t.Fatalf("unexpected etag %s", rec.Header().Get("ETag"))
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleGetMedia(rec, newMediaTargetRequest(media.MediaId, pkg.RenditionUrl(media.MediaId, "poster")))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown rendition got %d", rec.Code)
+	}
+}
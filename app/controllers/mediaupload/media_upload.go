@@ -2,26 +2,79 @@ package mediaupload
 
 import (
 	"encoding/json"
-	"github.com/disintegration/imaging"
+	"fmt"
+	"github.com/gorilla/mux"
 	"github.com/twinj/uuid"
 	"gitlab.com/pbobby001/postit-api/pkg"
 	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/pipeline"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
+	"io"
 	"io/ioutil"
-	"mime/multipart"
 	"net/http"
 	"os"
-	"path"
-	"path/filepath"
+	"strconv"
 	"time"
 )
 
 const (
 	_ int = iota
-	_ = 1 << (10 * iota)
+	_     = 1 << (10 * iota)
 	MB
 )
 
-func HandleMediaUpload(w http.ResponseWriter, r *http.Request) {
+/* Handler keeps uploads as unattached media records until a post is created with them.
+Every upload gets its own id, posts attach uploads through media_ids.
+Files larger than AsyncThreshold are processed after the request returned, their job is polled through HandleUploadJob.
+At most MEDIA_MAX_JOBS jobs run at once, further large uploads are answered with 503 until one finished.
+Requests larger than MaxUploadSize are refused, uploads are held in memory while they are processed.
+Uploads may take Timeout to be sent and processed, the router gives the upload route this deadline instead of the server's. */
+type Handler struct {
+	Media          repository.MediaRepository
+	Jobs           repository.UploadJobRepository
+	Store          storage.MediaStore
+	AsyncThreshold int
+	MaxUploadSize  int
+	Timeout        time.Duration
+	// slots holds a value for every running job
+	slots chan struct{}
+}
+
+func NewHandler(media repository.MediaRepository, jobs repository.UploadJobRepository, store storage.MediaStore) *Handler {
+	return &Handler{
+		Media:          media,
+		Jobs:           jobs,
+		Store:          store,
+		AsyncThreshold: sizeFromEnv("MEDIA_ASYNC_THRESHOLD", 2*MB),
+		MaxUploadSize:  sizeFromEnv("MEDIA_MAX_UPLOAD_SIZE", 100*MB),
+		Timeout:        durationFromEnv("MEDIA_UPLOAD_TIMEOUT", 10*time.Minute),
+		slots:          make(chan struct{}, sizeFromEnv("MEDIA_MAX_JOBS", 4)),
+	}
+}
+
+// durationFromEnv reads a positive duration like 10m from the environment variable
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || duration <= 0 {
+		return fallback
+	}
+	return duration
+}
+
+// sizeFromEnv reads a positive number, mostly a size in bytes, from the environment variable
+func sizeFromEnv(name string, fallback int) int {
+	size, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || size <= 0 {
+		return fallback
+	}
+	return size
+}
+
+/* HandleMediaUpload answers with the stored media, its dimensions, content type and size,
+or with a structured error telling why the file was rejected.
+Large files are answered with 202 and a job to poll instead. */
+func (h *Handler) HandleMediaUpload(w http.ResponseWriter, r *http.Request) {
 
 	transactionId := uuid.NewV4()
 
@@ -36,12 +89,24 @@ func HandleMediaUpload(w http.ResponseWriter, r *http.Request) {
 	tenantNamespace := headers["tenant-namespace"]
 
 	// Logging the headers
-	logs.Logger.Info("Headers => TraceId: " + traceId + ", TenantNamespace: "+tenantNamespace)
+	logs.Logger.Info("Headers => TraceId: " + traceId + ", TenantNamespace: " + tenantNamespace)
+
+	if r.ContentLength > int64(h.MaxUploadSize) {
+		sendUploadError(w, transactionId, traceId, &pkg.UploadError{Code: pkg.UploadFileTooBig, Message: fmt.Sprintf("uploads may be %d bytes at most", h.MaxUploadSize)})
+		return
+	}
+	// requests without a content length are cut off at the limit as well
+	body := &countingBody{ReadCloser: r.Body}
+	r.Body = http.MaxBytesReader(w, body, int64(h.MaxUploadSize))
 
 	err = r.ParseMultipartForm(10 * MB)
+	if err != nil && body.read > int64(h.MaxUploadSize) {
+		sendUploadError(w, transactionId, traceId, &pkg.UploadError{Code: pkg.UploadFileTooBig, Message: fmt.Sprintf("uploads may be %d bytes at most", h.MaxUploadSize)})
+		return
+	}
 	if err != nil {
 		_ = logs.Logger.Error(err)
-		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		sendUploadError(w, transactionId, traceId, &pkg.UploadError{Code: pkg.UploadMissingFile, Message: "the request is not a multipart form"})
 		return
 	}
 
@@ -49,183 +114,92 @@ func HandleMediaUpload(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logs.Logger.Info("Error Retrieving the File")
 		_ = logs.Logger.Error(err)
-		w.WriteHeader(http.StatusBadRequest)
+		sendUploadError(w, transactionId, traceId, &pkg.UploadError{Code: pkg.UploadMissingFile, Message: "media_file is missing"})
 		return
 	}
-	defer func() {
-		err = file.Close()
-		if err != nil {
-			_ = logs.Logger.Error(err)
-			return
-		}
-	}()
 
 	logs.Logger.Info("Uploaded File: ", handler.Filename)
 	logs.Logger.Info("File Size: ", handler.Size)
 	logs.Logger.Info("MIME Header: ", handler.Header)
 
-	//extension := strings.Split(handler.Filename, ".")[1]
-
-	//logs.Logger.Info("Image extension ", extension)
-	//imageExt = extension
-
-	f := make(chan multipart.File)
-	go parseMultipartToFile(f, tenantNamespace, handler.Filename)
-	f <- file
-	close(f)
-
-	_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
-		Data: pkg.Data{
-			Id:        "",
-			UiMessage: "FILE UPLOADING",
-		},
-		Meta: pkg.Meta{
-			Timestamp:     time.Now(),
-			TransactionId: transactionId.String(),
-			TraceId:       traceId,
-			Status:        "SUCCESS",
-		},
-	})
-}
-
-func parseMultipartToFile(fileChannel <-chan multipart.File, nmsp string, filename string) {
-	// Listen on the file channel
-	for file := range fileChannel {
-
-		// read the file bytes
-		fileBytes, err := ioutil.ReadAll(file)
-		if err != nil {
-			_ = logs.Logger.Error(err)
-			return
-		}
-
-		// get the working directory for generating the path for image storage
-		wd, err := os.Getwd()
-		if err != nil {
-			_ = logs.Logger.Error(err)
-			return
-		}
-
-		// join the working directory path with the path for image storage
-		join := filepath.Join(wd, "pkg/"+nmsp)
-
-		// create a new directory for storing the image
-		err = os.Mkdir(join, 0755)
-		if err != nil {
-			if os.IsExist(err) {
-				_ = logs.Logger.Warn(err)
-			} else {
-				_ = logs.Logger.Error(err)
-				return
-			}
-		}
-
-		jsonFileToBeCreated := join + "/f.json"
-
-		if _, err := os.Stat(jsonFileToBeCreated); os.IsNotExist(err) {
-			logs.Logger.Info("File doesnt Exist creating")
-			// but create a json file for storing the file info
-			jsonFile, err :=  os.Create(jsonFileToBeCreated)
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-
-			// create a map format for storing the file info
-			fileData := make(map[string]string)
-			fileData[filename] = join + "/" + filename
-
-			// encode the file info to json bytes
-			jsonFileData, err := json.Marshal(fileData)
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-
-			// write the json bytes to the json file created
-			_, err = jsonFile.Write(jsonFileData)
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-			_ = jsonFile.Close()
-		} else {
-
-			jsFile, err := os.OpenFile(jsonFileToBeCreated, os.O_RDWR, 0644)
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-
-			readFile, err := ioutil.ReadAll(jsFile)
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-
-			fileData := make(map[string]string)
-			err = json.Unmarshal(readFile, &fileData)
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-			// create a map format for storing the file info
-			fileData[filename] = join + "/" + filename
-			logs.Logger.Info(fileData)
-
-			// encode the file info to json bytes
-			jsonFileData, err := json.Marshal(fileData)
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-			logs.Logger.Info(string(jsonFileData))
-
-			// write the json bytes to the json file created
-			err = ioutil.WriteFile(jsonFileToBeCreated, jsonFileData, 0644)
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-			err = jsFile.Close()
-			if err != nil {
-				logs.Logger.Info(err)
-				return
-			}
-		}
+	fileBytes, err := ioutil.ReadAll(file)
+	_ = file.Close()
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+	upload := pipeline.Upload{Name: handler.Filename, Data: fileBytes, DeclaredType: handler.Header.Get("Content-Type")}
 
-		tempFile, err := os.Create(join + "/" + filename)
+	// videos may come with the still image to show for them
+	poster, _, err := r.FormFile("poster_file")
+	if err == nil {
+		upload.Poster, err = ioutil.ReadAll(poster)
+		_ = poster.Close()
 		if err != nil {
-			_ = logs.Logger.Error(err)
+			pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
 			return
 		}
+	}
 
-		_, err = tempFile.Write(fileBytes)
-		if err != nil {
-			_ = logs.Logger.Error(err)
+	if len(fileBytes) > h.AsyncThreshold {
+		select {
+		case h.slots <- struct{}{}:
+		default:
+			w.Header().Set("Retry-After", "30")
+			sendUploadError(w, transactionId, traceId, &pkg.UploadError{Code: pkg.UploadBusy, Message: "too many uploads are being processed, try again later"})
 			return
 		}
 
-		img, err := imaging.Open(tempFile.Name())
+		now := time.Now()
+		job := pkg.UploadJob{JobId: uuid.NewV4().String(), Status: pkg.JobProcessing, UploadedBy: uploader(r), CreatedAt: now, UpdatedAt: now}
+		err = h.Jobs.Create(tenantNamespace, job)
 		if err != nil {
+			<-h.slots
 			_ = logs.Logger.Error(err)
+			pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
 			return
 		}
+		go h.runJob(tenantNamespace, job, upload)
 
-		imb := imaging.AdjustBrightness(img, -5)
-		src := imaging.Resize(imb, 500, 0, imaging.Lanczos)
-		err = imaging.Save(src, tempFile.Name())
-		if err != nil {
-			_ = logs.Logger.Error(err)
-			return
-		}
-		_ = tempFile.Close()
+		w.Header().Set("Location", "/file/upload/jobs/"+job.JobId)
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(pkg.UploadJobResponse{
+			Data: job,
+			Meta: pkg.Meta{
+				Timestamp:     time.Now(),
+				TransactionId: transactionId.String(),
+				TraceId:       traceId,
+				Status:        "SUCCESS",
+			},
+		})
+		return
+	}
 
-		logs.Logger.Info("Successfully resized image...")
+	media, err := h.process(tenantNamespace, uploader(r), upload)
+	if uploadError, ok := err.(*pkg.UploadError); ok {
+		sendUploadError(w, transactionId, traceId, uploadError)
+		return
+	}
+	if err != nil {
+		_ = logs.Logger.Error(err)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
 	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(pkg.MediaResponse{
+		Data: media,
+		Meta: pkg.Meta{
+			Timestamp:     time.Now(),
+			TransactionId: transactionId.String(),
+			TraceId:       traceId,
+			Status:        "SUCCESS",
+		},
+	})
 }
 
-func HandleCancelMediaUpload(w http.ResponseWriter, r *http.Request) {
+// HandleUploadJob reports the status of an upload job, the media once it is done or the error once it failed
+func (h *Handler) HandleUploadJob(w http.ResponseWriter, r *http.Request) {
 	transactionId := uuid.NewV4()
 
 	headers, err := pkg.ValidateHeaders(r)
@@ -241,90 +215,169 @@ func HandleCancelMediaUpload(w http.ResponseWriter, r *http.Request) {
 	// Logging the headers
 	logs.Logger.Info("Headers => TraceId: " + traceId + ", TenantNamespace: " + tenantNamespace)
 
-	fileName := r.URL.Query().Get("file_name")
-	logs.Logger.Info(fileName)
-
-	workingDir, err := os.Getwd()
+	// jobs are only visible to their uploader, like the uploads themselves
+	job, err := h.Jobs.Get(tenantNamespace, mux.Vars(r)["id"])
+	if err == nil && job.UploadedBy != uploader(r) {
+		err = repository.ErrNotFound
+	}
+	if err == repository.ErrNotFound {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		_ = logs.Logger.Error(err)
-		w.WriteHeader(http.StatusBadRequest)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
 		return
 	}
-	logs.Logger.Info(workingDir)
 
-	imageStoragePath := path.Join(workingDir, "/pkg/" + tenantNamespace)
-	logs.Logger.Info(imageStoragePath)
+	_ = json.NewEncoder(w).Encode(pkg.UploadJobResponse{
+		Data: job,
+		Meta: pkg.Meta{
+			Timestamp:     time.Now(),
+			TransactionId: transactionId.String(),
+			TraceId:       traceId,
+			Status:        "SUCCESS",
+		},
+	})
+}
 
-	if imageStoragePath == "" {
-		_ = logs.Logger.Warn("No image has been uploaded to server")
-		w.WriteHeader(http.StatusGone)
-		_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
-			Data: pkg.Data{
-				Id:        "",
-				UiMessage: "no file found, please upload image!",
-			},
-			Meta: pkg.Meta{
-				Timestamp:     time.Now(),
-				TransactionId: transactionId.String(),
-				TraceId:       traceId,
-				Status:        "SUCCESS",
-			},
-		})
-		return
-	}
+/* runJob processes a large upload and finishes its job with the media or the error, then frees its slot.
+Jobs interrupted by a restart stay processing until mediagc fails them. */
+func (h *Handler) runJob(tenantNamespace string, job pkg.UploadJob, upload pipeline.Upload) {
+	defer func() { <-h.slots }()
 
-	jsFile, err := os.OpenFile(imageStoragePath + "/f.json", os.O_RDWR, 0644)
+	media, err := h.process(tenantNamespace, job.UploadedBy, upload)
+	job.Status, job.Media = pkg.JobDone, &media
 	if err != nil {
-		_ = logs.Logger.Error(err)
-		return
+		uploadError, ok := err.(*pkg.UploadError)
+		if !ok {
+			_ = logs.Logger.Error(err)
+			uploadError = &pkg.UploadError{Code: pkg.UploadFailed, Message: "the file could not be stored"}
+		}
+		job.Status, job.Media, job.Error = pkg.JobFailed, nil, uploadError
 	}
 
-	readFile, err := ioutil.ReadAll(jsFile)
+	err = h.Jobs.Finish(tenantNamespace, job)
 	if err != nil {
 		_ = logs.Logger.Error(err)
-		return
 	}
+}
 
-	fileData := make(map[string]string)
-	err = json.Unmarshal(readFile, &fileData)
+/* process runs an upload through the pipeline and stores it as an unattached upload of uploadedBy.
+Files that are not supported, cannot be decoded or fit no network are rejected with a *pkg.UploadError. */
+func (h *Handler) process(tenantNamespace string, uploadedBy string, upload pipeline.Upload) (pkg.Media, error) {
+	processed, err := pipeline.Process(upload)
 	if err != nil {
-		_ = logs.Logger.Error(err)
-		return
+		return pkg.Media{}, err
 	}
 
-	delete(fileData, fileName)
+	processed.Media.UploadedBy = uploadedBy
+	return pipeline.Save(h.Store, h.Media, tenantNamespace, processed)
+}
 
-	jsonFileData, err := json.Marshal(fileData)
-	if err != nil {
-		_ = logs.Logger.Error(err)
-		return
+// sendUploadError writes uploadError with the status matching its code
+func sendUploadError(w http.ResponseWriter, transactionId uuid.UUID, traceId string, uploadError *pkg.UploadError) {
+	status := http.StatusBadRequest
+	switch uploadError.Code {
+	case pkg.UploadFileTooBig:
+		status = http.StatusRequestEntityTooLarge
+	case pkg.UploadUnsupportedMedia:
+		status = http.StatusUnsupportedMediaType
+	case pkg.UploadInvalidImage, pkg.UploadInvalidVideo, pkg.UploadLimitExceeded:
+		status = http.StatusUnprocessableEntity
+	case pkg.UploadFailed:
+		status = http.StatusInternalServerError
+	case pkg.UploadBusy:
+		status = http.StatusServiceUnavailable
+	}
+
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(pkg.UploadErrorResponse{
+		Error: *uploadError,
+		Meta: pkg.Meta{
+			Timestamp:     time.Now(),
+			TransactionId: transactionId.String(),
+			TraceId:       traceId,
+			Status:        "FAILED",
+		},
+	})
+}
+
+// countingBody counts the bytes read from a request body, more than the limit means MaxBytesReader cut it off
+type countingBody struct {
+	io.ReadCloser
+	read int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	return n, err
+}
+
+// uploader returns the subject of the request's token, uploads are listed and cleared per uploader
+func uploader(r *http.Request) string {
+	claims, ok := pkg.ClaimsFromContext(r.Context())
+	if !ok {
+		return ""
 	}
-	logs.Logger.Info(string(jsonFileData))
+	return claims.Subject
+}
 
-	// write the json bytes to the json file created
-	err = ioutil.WriteFile(imageStoragePath + "/f.json", jsonFileData, 0644)
+/* deleteUpload removes an unattached upload of uploadedBy and releases its content, uploads of others are not found.
+The content and renditions are only removed when no other media record of the tenant shares them. */
+func (h *Handler) deleteUpload(tenantNamespace string, uploadedBy string, mediaId string) error {
+	media, err := h.Media.Get(tenantNamespace, mediaId)
 	if err != nil {
-		_ = logs.Logger.Error(err)
-		return
+		return err
+	}
+	if media.UploadedBy != uploadedBy {
+		return repository.ErrNotFound
+	}
+	released, err := h.Media.DeleteUpload(tenantNamespace, mediaId)
+	if err != nil || !released {
+		return err
 	}
-	err = jsFile.Close()
+	return pipeline.Remove(h.Store, tenantNamespace, media)
+}
+
+func (h *Handler) HandleCancelMediaUpload(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+
+	headers, err := pkg.ValidateHeaders(r)
 	if err != nil {
-		logs.Logger.Info(err)
+		pkg.SendErrorResponse(w, transactionId, "", err, http.StatusBadRequest)
 		return
 	}
 
-	err = os.Remove(imageStoragePath + "/" + fileName)
+	//Get the relevant headers
+	traceId := headers["trace-id"]
+	tenantNamespace := headers["tenant-namespace"]
+
+	// Logging the headers
+	logs.Logger.Info("Headers => TraceId: " + traceId + ", TenantNamespace: " + tenantNamespace)
+
+	uploadId := r.URL.Query().Get("upload_id")
+	logs.Logger.Info(uploadId)
+
+	// uploads a post uses by now are left alone, like jobs uploads are only visible to their uploader
+	err = h.deleteUpload(tenantNamespace, uploader(r), uploadId)
+	if err == repository.ErrNotFound {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		_ = logs.Logger.Error(err)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
 		return
 	}
 
 	_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
-		Data: pkg.Data {
+		Data: pkg.Data{
 			Id:        "",
 			UiMessage: "FILE DELETED",
 		},
-		Meta: pkg.Meta {
+		Meta: pkg.Meta{
 			Timestamp:     time.Now(),
 			TransactionId: transactionId.String(),
 			TraceId:       traceId,
@@ -333,7 +386,7 @@ func HandleCancelMediaUpload(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func DeleteUploadedFiles(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) DeleteUploadedFiles(w http.ResponseWriter, r *http.Request) {
 	transactionId := uuid.NewV4()
 
 	headers, err := pkg.ValidateHeaders(r)
@@ -349,24 +402,21 @@ func DeleteUploadedFiles(w http.ResponseWriter, r *http.Request) {
 	// Logging the headers
 	logs.Logger.Info("Headers => TraceId: " + traceId + ", TenantNamespace: " + tenantNamespace)
 
-	workingDir, err := os.Getwd()
+	// only the caller's own unattached uploads are removed
+	uploads, err := h.Media.ListUploads(tenantNamespace, uploader(r))
+	for i := 0; err == nil && i < len(uploads); i++ {
+		err = h.deleteUpload(tenantNamespace, uploader(r), uploads[i].MediaId)
+		if err == repository.ErrNotFound {
+			err = nil
+		}
+	}
 	if err != nil {
 		_ = logs.Logger.Error(err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	logs.Logger.Info(workingDir)
-
-	imageStoragePath := path.Join(workingDir, "/pkg/" + tenantNamespace)
-	logs.Logger.Info(imageStoragePath)
-
-	if imageStoragePath == "" {
-		_ = logs.Logger.Warn("No image has been uploaded to server")
-		w.WriteHeader(http.StatusGone)
+		w.WriteHeader(http.StatusInternalServerError)
 		_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
 			Data: pkg.Data{
 				Id:        "",
-				UiMessage: "no file found, please upload image!",
+				UiMessage: "something went wrong! contact admin",
 			},
 			Meta: pkg.Meta{
 				Timestamp:     time.Now(),
@@ -378,40 +428,16 @@ func DeleteUploadedFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = os.RemoveAll(imageStoragePath)
-	if err != nil {
-		_ = logs.Logger.Error(err)
-		if os.IsNotExist(err) {
-
-		} else {
-			_ = logs.Logger.Error(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
-				Data: pkg.Data{
-					Id:        "",
-					UiMessage: "something went wrong! contact admin",
-				},
-				Meta: pkg.Meta{
-					Timestamp:     time.Now(),
-					TransactionId: transactionId.String(),
-					TraceId:       traceId,
-					Status:        "SUCCESS",
-				},
-			})
-			return
-		}
-	}
-
 	_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
-		Data: pkg.Data {
+		Data: pkg.Data{
 			Id:        "",
 			UiMessage: "FILES DELETED",
 		},
-		Meta: pkg.Meta {
+		Meta: pkg.Meta{
 			Timestamp:     time.Now(),
 			TransactionId: transactionId.String(),
 			TraceId:       traceId,
 			Status:        "SUCCESS",
 		},
 	})
-}
\ No newline at end of file
+}
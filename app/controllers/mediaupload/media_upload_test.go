@@ -0,0 +1,277 @@
+package mediaupload
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/cristalhq/jwt"
+	"github.com/gorilla/mux"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/auth"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newUploadRequest(t *testing.T, method string, target string, body io.Reader, subject string) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("trace-id", "trace")
+	ctx := pkg.WithTenant(req.Context(), pkg.Tenant{TenantNamespace: "postit", Status: pkg.TenantActive})
+	ctx = pkg.WithClaims(ctx, &auth.Claims{StandardClaims: jwt.StandardClaims{Subject: subject}})
+	return req.WithContext(ctx)
+}
+
+func uploadForm(t *testing.T, filename string, data []byte) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("media_file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = part.Write(data)
+	_ = form.Close()
+	return &body, form.FormDataContentType()
+}
+
+func pngImage(t *testing.T, width int, height int) []byte {
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return img.Bytes()
+}
+
+func TestHandleMediaUpload(t *testing.T) {
+	h := NewHandler(repository.NewMemoryMediaRepository(nil), repository.NewMemoryUploadJobRepository(), storage.NewMemoryStore())
+
+	body, contentType := uploadForm(t, "cat.png", pngImage(t, 1000, 400))
+	req := newUploadRequest(t, http.MethodPost, "/file/upload", body, "ama")
+	req.Header.Set("Content-Type", contentType)
+
+	rec := httptest.NewRecorder()
+	h.HandleMediaUpload(rec, req)
+	var response pkg.MediaResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("expected the stored media got %d: %s", rec.Code, rec.Body)
+	}
+	if response.Data.Width != 1000 || response.Data.Height != 400 || response.Data.ContentType != "image/png" || response.Data.Size == 0 {
+		t.Fatalf("unexpected upload result %+v", response.Data)
+	}
+
+	media, err := h.Media.Get("postit", response.Data.MediaId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.Attached || media.UploadedBy != "ama" || media.ContentType != "image/png" || media.Name != "cat.png" {
+		t.Fatalf("unexpected upload %+v", media)
+	}
+	key, _ := pkg.MediaKey("postit", media.MediaId)
+	if _, err := h.Store.Get(key); err != nil {
+		t.Fatalf("expected the original to be kept got %v", err)
+	}
+	previewKey, _ := pkg.RenditionKey("postit", media.MediaId, "preview")
+	preview, err := h.Store.Get(previewKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.DecodeConfig(bytes.NewReader(preview))
+	if err != nil || decoded.Width != 500 || decoded.Height != 200 {
+		t.Fatalf("expected a 500px preview got %+v: %v", decoded, err)
+	}
+
+	// another user of the tenant clearing their uploads keeps this one
+	rec = httptest.NewRecorder()
+	h.DeleteUploadedFiles(rec, newUploadRequest(t, http.MethodDelete, "/delete/all", nil, "kofi"))
+	if _, err := h.Media.Get("postit", media.MediaId); err != nil {
+		t.Fatalf("expected the upload to survive got %v", err)
+	}
+
+	// nor can they cancel it
+	rec = httptest.NewRecorder()
+	h.HandleCancelMediaUpload(rec, newUploadRequest(t, http.MethodDelete, "/file/upload?upload_id="+media.MediaId, nil, "kofi"))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", rec.Code)
+	}
+	if _, err := h.Media.Get("postit", media.MediaId); err != nil {
+		t.Fatalf("expected the upload to survive got %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleCancelMediaUpload(rec, newUploadRequest(t, http.MethodDelete, "/file/upload?upload_id="+media.MediaId, nil, "ama"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body)
+	}
+	for _, k := range []string{key, previewKey} {
+		if _, err := h.Store.Get(k); err != storage.ErrNotFound {
+			t.Fatalf("expected %s to be removed got %v", k, err)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleCancelMediaUpload(rec, newUploadRequest(t, http.MethodDelete, "/file/upload?upload_id="+media.MediaId, nil, "ama"))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", rec.Code)
+	}
+}
+
+func TestHandleMediaUploadSharesContent(t *testing.T) {
+	h := NewHandler(repository.NewMemoryMediaRepository(nil), repository.NewMemoryUploadJobRepository(), storage.NewMemoryStore())
+	data := pngImage(t, 300, 300)
+
+	var uploads []pkg.Media
+	for _, name := range []string{"cat.png", "same cat.png"} {
+		body, contentType := uploadForm(t, name, data)
+		req := newUploadRequest(t, http.MethodPost, "/file/upload", body, "ama")
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		h.HandleMediaUpload(rec, req)
+		var response pkg.MediaResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != http.StatusCreated {
+			t.Fatalf("expected the stored media got %d: %s", rec.Code, rec.Body)
+		}
+		uploads = append(uploads, response.Data)
+	}
+	keys, _ := h.Store.List("postit/")
+	if uploads[0].MediaId == uploads[1].MediaId || len(keys) != 1+len(pkg.Renditions) {
+		t.Fatalf("expected two records sharing the content got %+v with %v", uploads, keys)
+	}
+
+	// cancelling the first upload keeps the content the second one shares
+	rec := httptest.NewRecorder()
+	h.HandleCancelMediaUpload(rec, newUploadRequest(t, http.MethodDelete, "/file/upload?upload_id="+uploads[0].MediaId, nil, "ama"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body)
+	}
+	if keys, _ = h.Store.List("postit/"); len(keys) != 1+len(pkg.Renditions) {
+		t.Fatalf("expected the shared content to be kept got %v", keys)
+	}
+
+	rec = httptest.NewRecorder()
+	h.DeleteUploadedFiles(rec, newUploadRequest(t, http.MethodDelete, "/delete/all", nil, "ama"))
+	if keys, _ = h.Store.List("postit/"); len(keys) != 0 {
+		t.Fatalf("expected the content to be removed with the last upload got %v", keys)
+	}
+}
+
+func TestHandleMediaUploadRejectsFiles(t *testing.T) {
+	h := NewHandler(repository.NewMemoryMediaRepository(nil), repository.NewMemoryUploadJobRepository(), storage.NewMemoryStore())
+
+	tests := []struct {
+		name   string
+		data   []byte
+		status int
+		code   string
+	}{
+		{"notes.txt", []byte("not an image at all"), http.StatusUnsupportedMediaType, pkg.UploadUnsupportedMedia},
+		{"cut.png", pngImage(t, 100, 100)[:60], http.StatusUnprocessableEntity, pkg.UploadInvalidImage},
+	}
+	for _, test := range tests {
+		body, contentType := uploadForm(t, test.name, test.data)
+		req := newUploadRequest(t, http.MethodPost, "/file/upload", body, "ama")
+		req.Header.Set("Content-Type", contentType)
+
+		rec := httptest.NewRecorder()
+		h.HandleMediaUpload(rec, req)
+		var response pkg.UploadErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != test.status || response.Error.Code != test.code {
+			t.Errorf("%s: expected %d %s got %d: %s", test.name, test.status, test.code, rec.Code, rec.Body)
+		}
+	}
+
+	h.MaxUploadSize = 1024
+	body, contentType := uploadForm(t, "big.png", pngImage(t, 400, 400))
+	req := newUploadRequest(t, http.MethodPost, "/file/upload", body, "ama")
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	h.HandleMediaUpload(rec, req)
+	var response pkg.UploadErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != http.StatusRequestEntityTooLarge || response.Error.Code != pkg.UploadFileTooBig {
+		t.Errorf("expected %d %s got %d: %s", http.StatusRequestEntityTooLarge, pkg.UploadFileTooBig, rec.Code, rec.Body)
+	}
+
+	// without a content length the body is cut off while the form is parsed
+	body, contentType = uploadForm(t, "big.png", pngImage(t, 400, 400))
+	req = newUploadRequest(t, http.MethodPost, "/file/upload", body, "ama")
+	req.Header.Set("Content-Type", contentType)
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	h.HandleMediaUpload(rec, req)
+	response = pkg.UploadErrorResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != http.StatusRequestEntityTooLarge || response.Error.Code != pkg.UploadFileTooBig {
+		t.Errorf("expected %d %s got %d: %s", http.StatusRequestEntityTooLarge, pkg.UploadFileTooBig, rec.Code, rec.Body)
+	}
+
+	if uploads, _ := h.Media.ListUploads("postit", "ama"); len(uploads) != 0 {
+		t.Fatalf("expected no uploads got %+v", uploads)
+	}
+}
+
+func TestHandleMediaUploadJob(t *testing.T) {
+	h := NewHandler(repository.NewMemoryMediaRepository(nil), repository.NewMemoryUploadJobRepository(), storage.NewMemoryStore())
+	h.AsyncThreshold = 10
+
+	body, contentType := uploadForm(t, "cat.png", pngImage(t, 800, 800))
+	req := newUploadRequest(t, http.MethodPost, "/file/upload", body, "ama")
+	req.Header.Set("Content-Type", contentType)
+
+	rec := httptest.NewRecorder()
+	h.HandleMediaUpload(rec, req)
+	var response pkg.UploadJobResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != http.StatusAccepted || response.Data.JobId == "" {
+		t.Fatalf("expected a job got %d: %s", rec.Code, rec.Body)
+	}
+	jobId := response.Data.JobId
+
+	// the job is only visible to its uploader
+	req = mux.SetURLVars(newUploadRequest(t, http.MethodGet, "/file/upload/jobs/"+jobId, nil, "kofi"), map[string]string{"id": jobId})
+	rec = httptest.NewRecorder()
+	h.HandleUploadJob(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", rec.Code)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for response.Data.Status == pkg.JobProcessing && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		req = mux.SetURLVars(newUploadRequest(t, http.MethodGet, "/file/upload/jobs/"+jobId, nil, "ama"), map[string]string{"id": jobId})
+		rec = httptest.NewRecorder()
+		h.HandleUploadJob(rec, req)
+		response = pkg.UploadJobResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("expected the job got %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	if response.Data.Status != pkg.JobDone || response.Data.Media == nil || response.Data.Media.Width != 800 || response.Data.Media.Height != 800 {
+		t.Fatalf("expected the job to finish with the media got %+v", response.Data)
+	}
+	if _, err := h.Media.Get("postit", response.Data.Media.MediaId); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleMediaUploadJobsBusy(t *testing.T) {
+	h := NewHandler(repository.NewMemoryMediaRepository(nil), repository.NewMemoryUploadJobRepository(), storage.NewMemoryStore())
+	h.AsyncThreshold = 10
+	h.slots = make(chan struct{}, 1)
+	h.slots <- struct{}{}
+
+	body, contentType := uploadForm(t, "cat.png", pngImage(t, 800, 800))
+	req := newUploadRequest(t, http.MethodPost, "/file/upload", body, "ama")
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	h.HandleMediaUpload(rec, req)
+	var response pkg.UploadErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != http.StatusServiceUnavailable || response.Error.Code != pkg.UploadBusy {
+		t.Fatalf("expected %d %s got %d: %s", http.StatusServiceUnavailable, pkg.UploadBusy, rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Errorf("expected a Retry-After header")
+	}
+}
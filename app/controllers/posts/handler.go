@@ -0,0 +1,69 @@
+package posts
+
+import (
+	"fmt"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/pipeline"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
+)
+
+// Handler serves the post and schedule endpoints using the repositories it is given
+type Handler struct {
+	Posts     repository.PostRepository
+	Schedules repository.ScheduleRepository
+	Accounts  repository.AccountRepository
+	Media     repository.MediaRepository
+	Store     storage.MediaStore
+}
+
+func NewHandler(posts repository.PostRepository, schedules repository.ScheduleRepository, accounts repository.AccountRepository, media repository.MediaRepository, store storage.MediaStore) *Handler {
+	return &Handler{
+		Posts:     posts,
+		Schedules: schedules,
+		Accounts:  accounts,
+		Media:     media,
+		Store:     store,
+	}
+}
+
+// storeMedia runs images sent inline through the pipeline and saves them as attached media records, the references keep the order of images
+func (h *Handler) storeMedia(tenantNamespace string, images [][]byte) ([]pkg.MediaRef, error) {
+	var ids []string
+	for _, image := range images {
+		processed, err := pipeline.Process(pipeline.Upload{Data: image})
+		if err != nil {
+			return nil, err
+		}
+		processed.Media.Attached = true
+		media, err := pipeline.Save(h.Store, h.Media, tenantNamespace, processed)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, media.MediaId)
+	}
+	return pkg.MediaRefs(ids), nil
+}
+
+/* mediaRefs checks that the media a client refers to belong to the tenant and drops repeated ids.
+The post repository attaches them along with the post, a record removed in between fails it with repository.ErrUnknownMedia. */
+func (h *Handler) mediaRefs(tenantNamespace string, mediaIds []string) ([]pkg.MediaRef, error) {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, mediaId := range mediaIds {
+		if seen[mediaId] {
+			continue
+		}
+		seen[mediaId] = true
+
+		_, err := h.Media.Get(tenantNamespace, mediaId)
+		if err == repository.ErrNotFound {
+			return nil, fmt.Errorf("unknown media %s", mediaId)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, mediaId)
+	}
+	return pkg.MediaRefs(ids), nil
+}
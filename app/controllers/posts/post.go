@@ -2,20 +2,16 @@ package posts
 
 import (
 	"encoding/json"
-	"fmt"
-	"github.com/lib/pq"
 	"github.com/twinj/uuid"
-	"gitlab.com/pbobby001/postit-api/db"
 	"gitlab.com/pbobby001/postit-api/pkg"
 	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"path/filepath"
 	"time"
 )
 
-func HandleCreatePost(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HandleCreatePost(w http.ResponseWriter, r *http.Request) {
 	logs.Logger.Info("===========================================")
 	logs.Logger.Info("Handling create post ...")
 	logs.Logger.Info("===========================================")
@@ -59,90 +55,13 @@ func HandleCreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	wd, err := os.Getwd()
+	// media_ids lists the uploads returned by /file/upload
+	media, err := h.mediaRefs(tenantNamespace, post.MediaIds)
 	if err != nil {
-		_ = logs.Logger.Error(err)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
 		return
 	}
 
-	path := filepath.Join(wd, "pkg/"+tenantNamespace)
-	logs.Logger.Info(path)
-
-	fileInfo, err := ioutil.ReadDir(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			_ = logs.Logger.Warn(err)
-		} else {
-			_ = logs.Logger.Error(err)
-			return
-		}
-	}
-
-	var imagePaths []string
-	var images [][]byte
-	var imageBytes []byte
-
-	if fileInfo != nil {
-		for _, file := range fileInfo {
-			logs.Logger.Info(file.Name())
-
-			if file.Name() == "f.json" {
-				continue
-			}
-
-			fileLocation := filepath.Join(path, file.Name())
-
-			openImage, err := os.Open(fileLocation)
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-
-			imageBytes, err = ioutil.ReadAll(openImage)
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-			err = openImage.Close()
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-			images = append(images, imageBytes)
-
-			jsonFile, err := os.Open(filepath.Join(path, "f.json"))
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-
-			readFile, err := ioutil.ReadAll(jsonFile)
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-			err = jsonFile.Close()
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-
-			fileData := make(map[string]string)
-			err = json.Unmarshal(readFile, &fileData)
-			if err != nil {
-				_ = logs.Logger.Error(err)
-				return
-			}
-
-			imagePath := fileData[file.Name()]
-			logs.Logger.Info(imagePath)
-			imagePaths = append(imagePaths, imagePath)
-		}
-	}
-
-	// imagePaths
-	logs.Logger.Info(imagePaths)
-
 	// Generate hashtag list
 	hashTagList := pkg.GenerateHashTags(post.HashTags)
 	logs.Logger.Info("HashTags: ", hashTagList)
@@ -151,29 +70,23 @@ func HandleCreatePost(w http.ResponseWriter, r *http.Request) {
 	Totally unnecessary but I did it anyway */
 	post.HashTags = hashTagList
 
-	// TODO: Build and use a crud service
-	//build query
-	query := fmt.Sprintf("INSERT INTO %s.post (post_id, facebook_post_id, post_message, post_images, image_paths, hash_tags, post_fb_status, post_tw_status, post_li_status, post_priority, scheduled) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)", tenantNamespace)
-	logs.Logger.Info("Db Query: ", query)
-
-	result, err := db.Connection.Exec(query, id.String(), "", post.PostMessage, pq.Array(images), pq.Array(imagePaths), pq.Array(post.HashTags), false, false, false, post.PostPriority, false)
+	err = h.Posts.Create(tenantNamespace, pkg.DbPost{
+		PostId:       id.String(),
+		PostMessage:  post.PostMessage,
+		Media:        media,
+		ImagePaths:   post.ImagePaths,
+		HashTags:     post.HashTags,
+		PostPriority: post.PostPriority,
+	})
+	if err == repository.ErrUnknownMedia {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
 		return
 	}
 
-	go func() {
-		err = os.RemoveAll(path)
-		if err != nil {
-			_ = logs.Logger.Error(err)
-			return
-		}
-	}()
-
-	//Just to be sure data was inserted
-	insertId, _ := result.LastInsertId()
-	logs.Logger.Info("Last Insert Id: ", insertId)
-
 	// Build response
 	response := pkg.StandardResponse{
 		Data: pkg.Data{Id: id.String(), UiMessage: "Post Created!"},
@@ -190,7 +103,7 @@ func HandleCreatePost(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func HandleFetchPosts(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HandleFetchPosts(w http.ResponseWriter, r *http.Request) {
 	logs.Logger.Info("===========================================")
 	logs.Logger.Info("Handling Fetch post ...")
 	logs.Logger.Info("===========================================")
@@ -211,61 +124,35 @@ func HandleFetchPosts(w http.ResponseWriter, r *http.Request) {
 	// Logging the headers
 	logs.Logger.Infof("Headers => TraceId: %s, TenantNamespace: %s", traceId, tenantNamespace)
 
-	// TODO: refactor fetch post to send images as well
-	// Build the sql query
-	query := fmt.Sprintf("SELECT post_id, facebook_post_id, post_message, post_images, image_paths, hash_tags, post_fb_status, post_tw_status, post_li_status, post_priority, scheduled, created_at, updated_at FROM %s.post ORDER BY updated_at DESC LIMIT 1000", tenantNamespace)
-	logs.Logger.Info(query)
+	// see pkg.ParsePostQuery for the parameters
+	query, err := pkg.ParsePostQuery(r.URL.Query())
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
 
-	// Run the query on the db using that particular db connection
-	rows, err := db.Connection.Query(query)
+	page, err := h.Posts.List(tenantNamespace, query)
 	if err != nil {
 		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
 		return
 	}
+	postList := page.Posts
 
-	// Create a post instance
-	var postList []pkg.DbPost
-	//loop through the posts
-	//if rows.Next() {
-	for rows.Next() {
-		var post pkg.DbPost
-		// Set the db values to the post values
-		err = rows.Scan(
-			&post.PostId,
-			&post.FacebookPostId,
-			&post.PostMessage,
-			pq.Array(&post.PostImages),
-			pq.Array(&post.ImagePaths),
-			pq.Array(&post.HashTags),
-			&post.PostFbStatus,
-			&post.PostTwStatus,
-			&post.PostLiStatus,
-			&post.PostPriority,
-			&post.Scheduled,
-			&post.CreatedOn,
-			&post.UpdatedOn,
-		)
-		if err != nil {
-			pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
-			return
-		}
-
-		if post.ImagePaths == nil || len(post.ImagePaths) == 0 {
-			post.ImagePaths = []string{}
+	for i := range postList {
+		if postList[i].ImagePaths == nil || len(postList[i].ImagePaths) == 0 {
+			postList[i].ImagePaths = []string{}
 		}
 
-		if post.PostImages == nil || len(post.PostImages) == 0 {
-			post.PostImages = [][]byte{}
+		if postList[i].Media == nil || len(postList[i].Media) == 0 {
+			postList[i].Media = []pkg.MediaRef{}
 		}
 
-		if post.HashTags == nil || len(post.HashTags) == 0 {
-			post.HashTags = []string{}
+		if postList[i].HashTags == nil || len(postList[i].HashTags) == 0 {
+			postList[i].HashTags = []string{}
 		}
-		//	Build the post data list
-		postList = append(postList, post)
 	}
-	// Generate an id for this particular transaction
-	if postList != nil {
+
+	if len(postList) > 0 {
 		logs.Logger.Info(postList[0].PostMessage)
 	}
 
@@ -276,11 +163,15 @@ func HandleFetchPosts(w http.ResponseWriter, r *http.Request) {
 	//	If everything goes right build the response
 	response := pkg.FetchPostResponse{
 		Data: postList,
-		Meta: pkg.Meta{
-			Timestamp:     time.Now(),
-			TransactionId: transactionId.String(),
-			TraceId:       traceId,
-			Status:        "SUCCESS",
+		Meta: pkg.PageMeta{
+			Meta: pkg.Meta{
+				Timestamp:     time.Now(),
+				TransactionId: transactionId.String(),
+				TraceId:       traceId,
+				Status:        "SUCCESS",
+			},
+			NextCursor: page.NextCursor,
+			Total:      page.Total,
 		},
 	}
 
@@ -292,7 +183,7 @@ func HandleFetchPosts(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func HandleUpdatePost(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HandleUpdatePost(w http.ResponseWriter, r *http.Request) {
 	logs.Logger.Info("===========================================")
 	logs.Logger.Info("Handling Update Post ...")
 	logs.Logger.Info("===========================================")
@@ -348,12 +239,33 @@ func HandleUpdatePost(w http.ResponseWriter, r *http.Request) {
 	}
 	logs.Logger.Info(uPostId)
 
-	go func() {
-		if err = pkg.Update(tenantNamespace, err, uPostId, post); err != nil {
-			_ = logs.Logger.Error(err)
-			return
-		}
-	}()
+	// the post keeps the media listed in media_ids, new uploads are simply added to the list
+	media, err := h.mediaRefs(tenantNamespace, post.MediaIds)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+
+	err = h.Posts.Update(tenantNamespace, pkg.DbPost{
+		PostId:       uPostId.String(),
+		PostMessage:  post.PostMessage,
+		Media:        media,
+		ImagePaths:   post.ImagePaths,
+		HashTags:     post.HashTags,
+		PostPriority: post.PostPriority,
+	})
+	if err == repository.ErrNotFound {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusNotFound)
+		return
+	}
+	if err == repository.ErrUnknownMedia {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
+	}
 
 	response := &pkg.StandardResponse{
 		Data: pkg.Data{
@@ -372,7 +284,7 @@ func HandleUpdatePost(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(response)
 }
 
-func HandleDeletePost(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HandleDeletePost(w http.ResponseWriter, r *http.Request) {
 	logs.Logger.Info("===========================================")
 	logs.Logger.Info("Handling Delete Post ...")
 	logs.Logger.Info("===========================================")
@@ -398,19 +310,12 @@ func HandleDeletePost(w http.ResponseWriter, r *http.Request) {
 	postId := r.URL.Query().Get("post_id")
 	logs.Logger.Info(postId)
 
-	// TODO: Fetch query from post
-	query := fmt.Sprintf("DELETE FROM %s.post WHERE post_id = $1", tenantNamespace)
-	logs.Logger.Info(query)
-
-	val, err := db.Connection.Query(query, postId)
+	err = h.Posts.Delete(tenantNamespace, postId)
 	if err != nil {
 		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
 		return
 	}
 
-	arr, _ := val.Columns()
-	logs.Logger.Info(arr)
-
 	response := pkg.StandardResponse{
 		Data: pkg.Data{
 			Id:        postId,
@@ -428,7 +333,7 @@ func HandleDeletePost(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(&response)
 }
 
-func HandleBatchDelete(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HandleBatchDelete(w http.ResponseWriter, r *http.Request) {
 	logs.Logger.Info("===========================================")
 	logs.Logger.Info("Handling Batch Delete Post ...")
 	logs.Logger.Info("===========================================")
@@ -467,15 +372,10 @@ func HandleBatchDelete(w http.ResponseWriter, r *http.Request) {
 
 	logs.Logger.Info(request)
 
-	// build the query
-	query := fmt.Sprintf("DELETE FROM %s.post WHERE post_id = $1", tenantNamespace)
-	//iterate over the post ids from the request
-	for _, i := range request.PostIds {
-		_, err = db.Connection.Exec(query, i)
-		if err != nil {
-			pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
-			return
-		}
+	err = h.Posts.Delete(tenantNamespace, request.PostIds...)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
 	}
 
 	response := pkg.StandardResponse{
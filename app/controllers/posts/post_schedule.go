@@ -1,21 +1,18 @@
 package posts
 
 import (
-	"bytes"
 	"encoding/json"
-	"fmt"
-	"github.com/lib/pq"
 	"github.com/twinj/uuid"
-	"gitlab.com/pbobby001/postit-api/db"
+	"gitlab.com/pbobby001/postit-api/app/scheduler"
 	"gitlab.com/pbobby001/postit-api/pkg"
 	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"time"
 )
 
-func CountSchedule(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CountSchedule(w http.ResponseWriter, r *http.Request) {
 	logs.Logger.Info("===========================================")
 	logs.Logger.Info("Handling Count Data ...")
 	logs.Logger.Info("===========================================")
@@ -37,64 +34,23 @@ func CountSchedule(w http.ResponseWriter, r *http.Request) {
 	// Logging the headers
 	logs.Logger.Info("Headers => TraceId: %s, TenantNamespace: %s", traceId, tenantNamespace)
 
-	// generate uuid for post
-	id := uuid.NewV4()
-	logs.Logger.Info(id)
-
-	query := fmt.Sprintf(
-		"SELECT COUNT(post_id) FROM %s.post;",
-		tenantNamespace,
-	)
-
-	row := db.Connection.QueryRow(query)
-	if row.Err() != nil {
-		pkg.SendErrorResponse(w, transactionId, traceId, row.Err(), http.StatusBadRequest)
-		return
-	}
-	var postCount int
-	err = row.Scan(&postCount)
+	postCount, err := h.Posts.Count(tenantNamespace)
 	if err != nil {
-		pkg.SendErrorResponse(w, transactionId, traceId, row.Err(), http.StatusBadRequest)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
 		return
 	}
 	logs.Logger.Info(postCount)
 
-
-	query = fmt.Sprintf(
-		"SELECT COUNT(schedule_id) FROM %s.schedule;",
-		tenantNamespace,
-	)
-
-	row = db.Connection.QueryRow(query)
-	if row.Err() != nil {
-		pkg.SendErrorResponse(w, transactionId, traceId, row.Err(), http.StatusBadRequest)
-		return
-	}
-
-	var scheduleCount int
-	err = row.Scan(&scheduleCount)
+	scheduleCount, err := h.Schedules.Count(tenantNamespace)
 	if err != nil {
-		pkg.SendErrorResponse(w, transactionId, traceId, row.Err(), http.StatusBadRequest)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
 		return
 	}
 	logs.Logger.Info(scheduleCount)
 
-
-	query = fmt.Sprintf(
-		"SELECT COUNT(application_uuid) FROM %s.application_info;",
-		tenantNamespace,
-	)
-
-	row = db.Connection.QueryRow(query)
-	if row.Err() != nil {
-		pkg.SendErrorResponse(w, transactionId, traceId, row.Err(), http.StatusBadRequest)
-		return
-	}
-
-	var accountCount int
-	err = row.Scan(&accountCount)
+	accountCount, err := h.Accounts.Count(tenantNamespace)
 	if err != nil {
-		pkg.SendErrorResponse(w, transactionId, "", row.Err(), http.StatusBadRequest)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
 		return
 	}
 	logs.Logger.Info(accountCount)
@@ -114,7 +70,7 @@ func CountSchedule(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(&resp)
 }
 
-func HandleCreatePostSchedule(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HandleCreatePostSchedule(w http.ResponseWriter, r *http.Request) {
 	logs.Logger.Info("===========================================")
 	logs.Logger.Info("Handling Create Post Schedule ...")
 	logs.Logger.Info("===========================================")
@@ -195,28 +151,6 @@ func HandleCreatePostSchedule(w http.ResponseWriter, r *http.Request) {
 	}
 	logs.Logger.Info(postSchedule)
 
-	for _, postId := range postSchedule.PostIds {
-		query := fmt.Sprintf("UPDATE %s.post SET scheduled = $1 WHERE post_id = $2", tenantNamespace)
-		_, err = db.Connection.Exec(query, true, postId)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			logs.Logger.Info(err)
-			_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
-				Data: pkg.Data{
-					Id:        "",
-					UiMessage: "Something went wrong. Contact admin",
-				},
-				Meta: pkg.Meta{
-					Timestamp:     time.Now(),
-					TransactionId: transactionId.String(),
-					TraceId:       traceId,
-					Status:        "FAILED",
-				},
-			})
-			return
-		}
-	}
-
 	durationPerPostInSeconds := pkg.GenerateDurationForEachPost(postSchedule)
 	logs.Logger.Info(durationPerPostInSeconds)
 
@@ -229,37 +163,20 @@ func HandleCreatePostSchedule(w http.ResponseWriter, r *http.Request) {
 	postSchedule.Duration = durationPerPostInSeconds
 	logs.Logger.Info("Post Duration: ", postSchedule.Duration)
 
-	if postSchedule.Profiles.Facebook == nil {
-		postSchedule.Profiles.Facebook = []string{}
-	}
-
-	if postSchedule.Profiles.Twitter == nil {
-		postSchedule.Profiles.Twitter = []string{}
-	}
-
-	if postSchedule.Profiles.LinkedIn == nil {
-		postSchedule.Profiles.LinkedIn = []string{}
+	// the built in executor picks the schedule up from the database on its own,
+	// the scheduler micro service is notified through the outbox once the schedule is stored
+	var messages []pkg.OutboxMessage
+	if scheduler.External() {
+		message, err := pkg.NewOutboxMessage(tenantNamespace, pkg.TopicScheduleCreated, traceId, postSchedule)
+		if err != nil {
+			pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+			return
+		}
+		messages = append(messages, message)
 	}
 
-	// TODO: Build and use a crud service
-	//build query
-	query := fmt.Sprintf(
-		"INSERT INTO %s.schedule (schedule_id, schedule_title, post_to_feed, schedule_from, schedule_to, post_ids, facebook, twitter, linked_in, duration_per_post, is_due) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)", tenantNamespace)
-
-	result, err := db.Connection.Exec(
-		query,
-		postScheduleId.String(),
-		postSchedule.ScheduleTitle,
-		postSchedule.PostToFeed,
-		postSchedule.From,
-		postSchedule.To,
-		pq.Array(postSchedule.PostIds),
-		pq.Array(postSchedule.Profiles.Facebook),
-		pq.Array(postSchedule.Profiles.Twitter),
-		pq.Array(postSchedule.Profiles.LinkedIn),
-		durationPerPostInSeconds,
-		false,
-	)
+	// the schedule, its posts and the notification are stored in a single transaction
+	err = h.Schedules.Create(tenantNamespace, postSchedule, messages...)
 	if err != nil {
 		// TODO: Send appropriate error message
 		logs.Logger.Info(err)
@@ -279,92 +196,6 @@ func HandleCreatePostSchedule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Just to be sure data was inserted
-	insertId, _ := result.LastInsertId()
-	logs.Logger.Info(insertId)
-
-	// notify the scheduler micro service
-	reqBody, _ := json.Marshal(postSchedule)
-	client := &http.Client{}
-	req, err := http.NewRequest(http.MethodPost, os.Getenv("SCHEDULER_URL")+"/schedule", bytes.NewBuffer(reqBody))
-	if err != nil {
-		logs.Logger.Info(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	req.Header.Add("tenant-namespace", tenantNamespace)
-	req.Header.Add("trace-id", traceId)
-	resp, err := client.Do(req)
-	if err != nil {
-		// rollback migrations
-		query = fmt.Sprintf("DELETE FROM %s.schedule WHERE schedule_id = $1", tenantNamespace)
-		_, err = db.Connection.Exec(query, postScheduleId)
-		if err != nil {
-			logs.Logger.Info(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		for _, postId := range postSchedule.PostIds {
-			query := fmt.Sprintf("UPDATE %s.post SET scheduled = $1 WHERE post_id = $2", tenantNamespace)
-			_, err = db.Connection.Exec(query, false, postId)
-			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				logs.Logger.Info(err)
-				_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
-					Data: pkg.Data{
-						Id:        "",
-						UiMessage: "Something went wrong. Contact admin",
-					},
-					Meta: pkg.Meta{
-						Timestamp:     time.Now(),
-						TransactionId: transactionId.String(),
-						TraceId:       traceId,
-						Status:        "FAILED",
-					},
-				})
-				return
-			}
-		}
-
-		logs.Logger.Info(err)
-		w.WriteHeader(http.StatusServiceUnavailable)
-		logs.Logger.Info(err)
-		_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
-			Data: pkg.Data{
-				Id:        "",
-				UiMessage: "Something went wrong. Contact admin",
-			},
-			Meta: pkg.Meta{
-				Timestamp:     time.Now(),
-				TransactionId: transactionId.String(),
-				TraceId:       traceId,
-				Status:        "FAILED",
-			},
-		})
-		return
-	}
-	body, _ := ioutil.ReadAll(resp.Body)
-	logs.Logger.Info(string(body))
-
-	if resp.StatusCode != http.StatusOK {
-		_ = logs.Logger.Error(err)
-		w.WriteHeader(http.StatusServiceUnavailable)
-		return
-	}
-
-	for i := 0; i < len(postSchedule.PostIds); i++ {
-		// change scheduled status of posts
-		query = fmt.Sprintf(`UPDATE %s.post SET scheduled = $1 WHERE post_id = $2;`, tenantNamespace)
-		_, err = db.Connection.Exec(query, true, postSchedule.PostIds[i])
-		if err != nil {
-			_ = logs.Logger.Error(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-	}
-
 	// Build response
 	response := pkg.StandardResponse{
 		Data: pkg.Data{Id: postScheduleId.String(), UiMessage: "Schedule Created!"},
@@ -381,7 +212,7 @@ func HandleCreatePostSchedule(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func HandleFetchPostSchedule(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HandleFetchPostSchedule(w http.ResponseWriter, r *http.Request) {
 	logs.Logger.Info("===========================================")
 	logs.Logger.Info("Handling Fetch Post Schedule...")
 	logs.Logger.Info("===========================================")
@@ -434,12 +265,7 @@ func HandleFetchPostSchedule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Build the sql query
-	query := fmt.Sprintf("SELECT * FROM %s.schedule ORDER BY updated_at DESC LIMIT 200", tenantNamespace)
-	logs.Logger.Info(query)
-
-	// Run the query on the db using that particular db connection
-	rows, err := db.Connection.Query(query)
+	schedules, err := h.Schedules.List(tenantNamespace)
 	if err != nil {
 		// TODO: send appropriate error message
 		w.WriteHeader(http.StatusInternalServerError)
@@ -459,49 +285,6 @@ func HandleFetchPostSchedule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create a post schedule instance
-	var schedules []pkg.PostSchedule
-	//loop through the schedules
-	for rows.Next() {
-		// Set the db values to the post schedules values
-		var schedule pkg.PostSchedule
-		err = rows.Scan(
-			&schedule.ScheduleId,
-			&schedule.ScheduleTitle,
-			&schedule.PostToFeed,
-			&schedule.From,
-			&schedule.To,
-			pq.Array(&schedule.PostIds),
-			&schedule.Duration,
-			pq.Array(&schedule.Profiles.Facebook),
-			pq.Array(&schedule.Profiles.Twitter),
-			pq.Array(&schedule.Profiles.LinkedIn),
-			&schedule.IsDue,
-			&schedule.CreatedOn,
-			&schedule.UpdatedOn,
-		)
-		if err != nil {
-			// TODO: Send an appropriate error message
-			w.WriteHeader(http.StatusInternalServerError)
-			_ = logs.Logger.Error(err)
-			_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
-				Data: pkg.Data{
-					Id:        "",
-					UiMessage: "Something went wrong. Contact admin",
-				},
-				Meta: pkg.Meta{
-					Timestamp:     time.Now(),
-					TransactionId: transactionId.String(),
-					TraceId:       traceId,
-					Status:        "FAILED",
-				},
-			})
-			return
-		}
-		//	Build the post data list
-		schedules = append(schedules, schedule)
-	}
-
 	//	If everything goes right build the response
 	response := pkg.FetchSchedulePostResponse {
 		Data: schedules,
@@ -535,7 +318,7 @@ func HandleFetchPostSchedule(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func HandleUpdatePostSchedule(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HandleUpdatePostSchedule(w http.ResponseWriter, r *http.Request) {
 	logs.Logger.Info("===========================================")
 	logs.Logger.Info("Handling Update Post Schedule ...")
 	logs.Logger.Info("===========================================")
@@ -640,34 +423,14 @@ func HandleUpdatePostSchedule(w http.ResponseWriter, r *http.Request) {
 
 	logs.Logger.Info(uPostId)
 
-	//TODO: Validate post uuid
-	query := fmt.Sprintf("UPDATE %s.schedule SET schedule_title = $1, schedule_from = $2, schedule_to = $3, post_ids = $4, post_to_feed = $5, facebook = $6, twitter = $7, linked_in = $8 WHERE schedule_id = $9", tenantNamespace)
-	logs.Logger.Info(query)
-
-	if post.Profiles.Facebook == nil {
-		post.Profiles.Facebook = []string{}
-	}
-
-	if post.Profiles.Twitter == nil {
-		post.Profiles.Twitter = []string{}
-	}
-
-	if post.Profiles.LinkedIn == nil {
-		post.Profiles.LinkedIn = []string{}
+	post.ScheduleId = uPostId.String()
+	// the posts are spaced over the new period like a new schedule
+	post.Duration = pkg.GenerateDurationForEachPost(*post)
+	err = h.Schedules.Update(tenantNamespace, *post)
+	if err == repository.ErrNotFound {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusNotFound)
+		return
 	}
-
-	_, err = db.Connection.Exec(
-		query,
-		post.ScheduleTitle,
-		post.From,
-		post.To,
-		pq.Array(post.PostIds),
-		post.PostToFeed,
-		pq.Array(post.Profiles.Facebook),
-		pq.Array(post.Profiles.Twitter),
-		pq.Array(post.Profiles.LinkedIn),
-		uPostId,
-	)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		_ = json.NewEncoder(w).Encode(pkg.StandardResponse {
@@ -703,7 +466,7 @@ func HandleUpdatePostSchedule(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(response)
 }
 
-func HandleDeletePostSchedule(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HandleDeletePostSchedule(w http.ResponseWriter, r *http.Request) {
 	logs.Logger.Info("===========================================")
 	logs.Logger.Info("Handling delete post schedule...")
 	logs.Logger.Info("===========================================")
@@ -764,11 +527,11 @@ func HandleDeletePostSchedule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: Fetch query from post
-	query := fmt.Sprintf("DELETE FROM %s.schedule WHERE schedule_id = $1", tenantNamespace)
-	logs.Logger.Info(query)
-
-	val, err := db.Connection.Exec(query, scheduleId)
+	err = h.Schedules.Delete(tenantNamespace, scheduleId.String())
+	if err == repository.ErrNotFound {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		_ = json.NewEncoder(w).Encode(struct {
@@ -789,9 +552,7 @@ func HandleDeletePostSchedule(w http.ResponseWriter, r *http.Request) {
 		logs.Logger.Info(err)
 		return
 	}
-
-	arr, _ := val.LastInsertId()
-	logs.Logger.Info(arr)
+	pkg.ScheduleStatusHub.Forget(tenantNamespace, scheduleId.String())
 
 	response := pkg.StandardResponse{
 		Data: pkg.Data{
@@ -0,0 +1,368 @@
+package posts
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/twinj/uuid"
+	"gitlab.com/pbobby001/postit-api/app/scheduler"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
+	goimage "image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestHandler() *Handler {
+	posts := repository.NewMemoryPostRepository()
+	return NewHandler(
+		posts,
+		repository.NewMemoryScheduleRepository(posts),
+		repository.NewMemoryAccountRepository(),
+		repository.NewMemoryMediaRepository(posts),
+		storage.NewMemoryStore(),
+	)
+}
+
+func newTenantRequest(t *testing.T, method string, target string, body interface{}) *http.Request {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, target, bytes.NewReader(payload))
+	req.Header.Set("trace-id", "trace")
+	return req.WithContext(pkg.WithTenant(req.Context(), pkg.Tenant{TenantNamespace: "postit", Status: pkg.TenantActive}))
+}
+
+func TestHandleCreateAndFetchPosts(t *testing.T) {
+	h := newTestHandler()
+
+	rec := httptest.NewRecorder()
+	h.HandleCreatePost(rec, newTenantRequest(t, http.MethodPost, "/posts", pkg.Post{PostMessage: "hello", HashTags: []string{"go"}}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body)
+	}
+
+	var created pkg.StandardResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleFetchPosts(rec, newTenantRequest(t, http.MethodGet, "/posts", nil))
+
+	var fetched pkg.FetchPostResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &fetched); err != nil {
+		t.Fatal(err)
+	}
+	if len(fetched.Data) != 1 || fetched.Data[0].PostId != created.Data.Id {
+		t.Fatalf("expected the created post got %+v", fetched.Data)
+	}
+	if fetched.Data[0].HashTags[0] != "#go" {
+		t.Fatalf("expected hash tag #go got %s", fetched.Data[0].HashTags[0])
+	}
+}
+
+func TestHandleUpdateMissingPost(t *testing.T) {
+	h := newTestHandler()
+
+	rec := httptest.NewRecorder()
+	h.HandleUpdatePost(rec, newTenantRequest(t, http.MethodPut, "/posts?post_id=3f2504e0-4f89-11d3-9a0c-0305e82c3301", pkg.Post{PostMessage: "edited"}))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", rec.Code)
+	}
+}
+
+func TestHandleCreatePostSchedule(t *testing.T) {
+	h := newTestHandler()
+	if err := h.Posts.Create("postit", pkg.DbPost{PostId: "p1", PostMessage: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	from := time.Now().Add(time.Hour)
+	rec := httptest.NewRecorder()
+	h.HandleCreatePostSchedule(rec, newTenantRequest(t, http.MethodPost, "/schedule", pkg.PostSchedule{
+		ScheduleTitle: "launch",
+		From:          from,
+		To:            from.Add(time.Hour),
+		PostIds:       []string{"p1"},
+	}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body)
+	}
+
+	post, err := h.Posts.Get("postit", "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !post.Scheduled {
+		t.Fatal("expected the post to be scheduled")
+	}
+
+	rec = httptest.NewRecorder()
+	h.CountSchedule(rec, newTenantRequest(t, http.MethodGet, "/count", nil))
+
+	var count pkg.CountResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &count); err != nil {
+		t.Fatal(err)
+	}
+	if count.PostCount != 1 || count.ScheduleCount != 1 || count.AccountCount != 0 {
+		t.Fatalf("unexpected counts %+v", count)
+	}
+}
+
+func TestHandleCreatePostScheduleExternal(t *testing.T) {
+	_ = os.Setenv("SCHEDULER_MODE", scheduler.ModeExternal)
+	defer os.Unsetenv("SCHEDULER_MODE")
+
+	h := newTestHandler()
+	from := time.Now().Add(time.Hour)
+	rec := httptest.NewRecorder()
+	h.HandleCreatePostSchedule(rec, newTenantRequest(t, http.MethodPost, "/schedule", pkg.PostSchedule{
+		From:    from,
+		To:      from.Add(time.Hour),
+		PostIds: []string{"p1"},
+	}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body)
+	}
+
+	// the scheduler is only notified through the outbox
+	messages := h.Schedules.(*repository.MemoryScheduleRepository).Outbox()
+	if len(messages) != 1 || messages[0].Topic != pkg.TopicScheduleCreated || messages[0].TenantNamespace != "postit" {
+		t.Fatalf("unexpected outbox %+v", messages)
+	}
+}
+
+func TestHandleUpdatePostScheduleSpacesPosts(t *testing.T) {
+	h := newTestHandler()
+	for _, postId := range []string{"p1", "p2", "p3"} {
+		if err := h.Posts.Create("postit", pkg.DbPost{PostId: postId}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	from := time.Now().Add(time.Hour)
+	scheduleId := "3f2504e0-4f89-11d3-9a0c-0305e82c3301"
+	if err := h.Schedules.Create("postit", pkg.PostSchedule{ScheduleId: scheduleId, From: from, To: from.Add(time.Hour), PostIds: []string{"p1"}, Duration: 3600}); err != nil {
+		t.Fatal(err)
+	}
+
+	// three posts over half an hour are ten minutes apart
+	rec := httptest.NewRecorder()
+	h.HandleUpdatePostSchedule(rec, newTenantRequest(t, http.MethodPut, "/schedule?schedule_id="+scheduleId, pkg.PostSchedule{
+		ScheduleTitle: "launch",
+		From:          from,
+		To:            from.Add(30 * time.Minute),
+		PostIds:       []string{"p1", "p2", "p3"},
+	}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body)
+	}
+
+	schedules, err := h.Schedules.List("postit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 1 || schedules[0].Duration != 600 {
+		t.Fatalf("expected posts 600s apart got %+v", schedules)
+	}
+}
+
+func TestHandleDeletePostSchedule(t *testing.T) {
+	h := newTestHandler()
+	for _, postId := range []string{"p1", "p2"} {
+		if err := h.Posts.Create("postit", pkg.DbPost{PostId: postId}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scheduleId := "3f2504e0-4f89-11d3-9a0c-0305e82c3301"
+	if err := h.Schedules.Create("postit", pkg.PostSchedule{ScheduleId: scheduleId, PostIds: []string{"p1", "p2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Schedules.Create("postit", pkg.PostSchedule{ScheduleId: "other", PostIds: []string{"p2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleDeletePostSchedule(rec, newTenantRequest(t, http.MethodDelete, "/schedule?schedule_id="+scheduleId, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body)
+	}
+
+	// p2 still belongs to another schedule
+	for postId, scheduled := range map[string]bool{"p1": false, "p2": true} {
+		post, err := h.Posts.Get("postit", postId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if post.Scheduled != scheduled {
+			t.Errorf("expected %s scheduled to be %v", postId, scheduled)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleDeletePostSchedule(rec, newTenantRequest(t, http.MethodDelete, "/schedule?schedule_id="+scheduleId, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", rec.Code)
+	}
+}
+
+func TestHandleFetchPostsPages(t *testing.T) {
+	h := newTestHandler()
+	for _, mediaId := range []string{"m1", "m2", "m3"} {
+		if _, err := h.Media.Create("postit", pkg.Media{MediaId: mediaId}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, post := range []pkg.DbPost{
+		{PostId: uuid.NewV4().String(), HashTags: []string{"#go"}, Media: pkg.MediaRefs([]string{"m1"})},
+		{PostId: uuid.NewV4().String(), HashTags: []string{"#go"}, Media: pkg.MediaRefs([]string{"m2"})},
+		{PostId: uuid.NewV4().String(), HashTags: []string{"#go"}, Media: pkg.MediaRefs([]string{"m3"})},
+		{PostId: uuid.NewV4().String()},
+	} {
+		if err := h.Posts.Create("postit", post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var seen []string
+	target := "/posts?limit=2&hash_tag=go"
+	for page := 0; page < 3 && target != ""; page++ {
+		rec := httptest.NewRecorder()
+		h.HandleFetchPosts(rec, newTenantRequest(t, http.MethodGet, target, nil))
+
+		var fetched pkg.FetchPostResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &fetched); err != nil {
+			t.Fatal(err)
+		}
+		if fetched.Meta.Total != 3 {
+			t.Fatalf("expected a total of 3 got %d", fetched.Meta.Total)
+		}
+		for _, post := range fetched.Data {
+			if len(post.Media) != 1 || post.Media[0].Url != "/media/"+post.Media[0].Id {
+				t.Fatalf("expected a media reference got %+v", post.Media)
+			}
+			seen = append(seen, post.PostId)
+		}
+
+		target = ""
+		if fetched.Meta.NextCursor != "" {
+			target = "/posts?limit=2&hash_tag=go&after=" + fetched.Meta.NextCursor
+		}
+	}
+
+	if len(seen) != 3 || target != "" {
+		t.Fatalf("expected the three tagged posts on two pages got %v", seen)
+	}
+	found := make(map[string]bool)
+	for _, postId := range seen {
+		if found[postId] {
+			t.Fatalf("post %s returned twice", postId)
+		}
+		found[postId] = true
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleFetchPosts(rec, newTenantRequest(t, http.MethodGet, "/posts?after=broken", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 got %d", rec.Code)
+	}
+}
+
+func TestHandleBatchPostStoresMedia(t *testing.T) {
+	h := newTestHandler()
+	var image bytes.Buffer
+	if err := png.Encode(&image, goimage.NewRGBA(goimage.Rect(0, 0, 600, 300))); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleBatchPost(rec, newTenantRequest(t, http.MethodPost, "/batch-post", []pkg.Post{{PostMessage: "hello", PostImages: [][]byte{image.Bytes()}}}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body)
+	}
+
+	page, err := h.Posts.List("postit", pkg.PostQuery{Limit: 10, Sort: pkg.SortUpdatedAt, Order: pkg.OrderDesc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Posts) != 1 || len(page.Posts[0].Media) != 1 {
+		t.Fatalf("expected one post with one image got %+v", page.Posts)
+	}
+	media, err := h.Media.Get("postit", page.Posts[0].Media[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.ContentType != "image/png" || media.Width != 600 || media.Height != 300 || len(media.Renditions) != len(pkg.Renditions) {
+		t.Fatalf("unexpected media record %+v", media)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleBatchPost(rec, newTenantRequest(t, http.MethodPost, "/batch-post", []pkg.Post{{PostMessage: "hello", PostImages: [][]byte{[]byte("no image")}}}))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid image to be rejected got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	update := pkg.Post{PostMessage: "hello", MediaIds: []string{"unknown"}}
+	h.HandleUpdatePost(rec, newTenantRequest(t, http.MethodPut, "/posts?post_id="+page.Posts[0].PostId, update))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown media to be rejected got %d", rec.Code)
+	}
+}
+
+func TestHandleCreatePostAttachesUploads(t *testing.T) {
+	h := newTestHandler()
+	for _, upload := range []pkg.Media{{MediaId: "mine", UploadedBy: "ama"}, {MediaId: "theirs", UploadedBy: "kofi"}} {
+		if _, err := h.Media.Create("postit", upload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleCreatePost(rec, newTenantRequest(t, http.MethodPost, "/posts", pkg.Post{PostMessage: "cat", MediaIds: []string{"mine"}}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body)
+	}
+
+	page, err := h.Posts.List("postit", pkg.PostQuery{Limit: 10, Sort: pkg.SortUpdatedAt, Order: pkg.OrderDesc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Posts) != 1 || len(page.Posts[0].Media) != 1 || page.Posts[0].Media[0].Id != "mine" {
+		t.Fatalf("expected only the listed upload to be attached got %+v", page.Posts)
+	}
+
+	mine, _ := h.Media.Get("postit", "mine")
+	theirs, _ := h.Media.Get("postit", "theirs")
+	if !mine.Attached || theirs.Attached {
+		t.Fatalf("unexpected attachment state %+v %+v", mine, theirs)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleCreatePost(rec, newTenantRequest(t, http.MethodPost, "/posts", pkg.Post{PostMessage: "dog", MediaIds: []string{"missing"}}))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown uploads to be rejected got %d", rec.Code)
+	}
+
+	// an upload removed after it was checked fails the post instead of leaving a dangling id
+	if _, err := h.Media.DeleteUpload("postit", "theirs"); err != nil {
+		t.Fatal(err)
+	}
+	err = h.Posts.Create("postit", pkg.DbPost{PostId: "late", Media: pkg.MediaRefs([]string{"theirs"})})
+	if err != repository.ErrUnknownMedia {
+		t.Fatalf("expected %v got %v", repository.ErrUnknownMedia, err)
+	}
+	if _, err := h.Posts.Get("postit", "late"); err != repository.ErrNotFound {
+		t.Fatalf("expected the post not to be stored got %v", err)
+	}
+}
@@ -2,16 +2,16 @@ package social
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/twinj/uuid"
-	"gitlab.com/pbobby001/postit-api/db"
 	"gitlab.com/pbobby001/postit-api/pkg"
 	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/secrets"
 	"net/http"
+	"strings"
 	"time"
 )
 
-func AllAccounts(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) AllAccounts(w http.ResponseWriter, r *http.Request) {
 	transactionId := uuid.NewV4()
 
 	headers, err := pkg.ValidateHeaders(r)
@@ -27,9 +27,7 @@ func AllAccounts(w http.ResponseWriter, r *http.Request) {
 	// Logging the headers
 	logs.Logger.Infof("Headers => TraceId: %s TenantNamespace: %s", traceId, tenantNamespace)
 
-	query := fmt.Sprintf("SELECT * FROM %s.application_info ORDER BY updated_at DESC LIMIT 2000", tenantNamespace)
-	logs.Logger.Info(query)
-	rows, err := db.Connection.Query(query)
+	accounts, err := h.Accounts.List(tenantNamespace)
 	if err != nil {
 		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
 		return
@@ -38,47 +36,39 @@ func AllAccounts(w http.ResponseWriter, r *http.Request) {
 	var fb []pkg.FacebookPostitUserData
 	var tw []pkg.TwitterPostitUserData
 	var li []pkg.LinkedInPostitUserData
-	for rows.Next() {
-		var appInfo pkg.ApplicationInfo
-		err = rows.Scan(
-			&appInfo.ApplicationUuid,
-			&appInfo.ApplicationName,
-			&appInfo.ApplicationId,
-			&appInfo.ApplicationSecret,
-			&appInfo.ApplicationUrl,
-			&appInfo.UserAccessToken,
-			&appInfo.ExpiresIn,
-			&appInfo.UserName,
-			&appInfo.UserId,
-			&appInfo.CreatedAt,
-			&appInfo.UpdatedAt,
-		)
-		if err != nil {
-			pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
-			return
-		}
-
+	for _, appInfo := range accounts {
 		if appInfo.UserId == "" {
 			continue
 		}
 
 		if appInfo.ApplicationName == "facebook" {
 			fb = append(fb, pkg.FacebookPostitUserData{
-				Username:    appInfo.UserName,
-				UserId:      appInfo.UserId,
-				AccessToken: appInfo.UserAccessToken,
+				Username:         appInfo.UserName,
+				UserId:           appInfo.UserId,
+				TokenFingerprint: secrets.Fingerprint(appInfo.UserAccessToken),
+				ExpiresAt:        appInfo.TokenExpiresAt,
+				TokenStatus:      appInfo.TokenStatus,
 			})
 		} else if appInfo.ApplicationName == "twitter" {
 			tw = append(tw, pkg.TwitterPostitUserData{
-				Username:    appInfo.UserName,
-				UserId:      appInfo.UserId,
-				AccessToken: appInfo.UserAccessToken,
+				Username:         appInfo.UserName,
+				UserId:           appInfo.UserId,
+				TokenFingerprint: secrets.Fingerprint(appInfo.UserAccessToken),
+				ExpiresAt:        appInfo.TokenExpiresAt,
+				TokenStatus:      appInfo.TokenStatus,
 			})
 		} else if appInfo.ApplicationName == "linked_in" {
+			accountType := "person"
+			if strings.HasPrefix(appInfo.UserId, "urn:li:organization:") {
+				accountType = "organization"
+			}
 			li = append(li, pkg.LinkedInPostitUserData{
-				Username:    appInfo.UserName,
-				UserId:      appInfo.UserId,
-				AccessToken: appInfo.UserAccessToken,
+				Username:         appInfo.UserName,
+				UserId:           appInfo.UserId,
+				TokenFingerprint: secrets.Fingerprint(appInfo.UserAccessToken),
+				ExpiresAt:        appInfo.TokenExpiresAt,
+				TokenStatus:      appInfo.TokenStatus,
+				AccountType:      accountType,
 			})
 		}
 
@@ -0,0 +1,72 @@
+package social
+
+import (
+	"encoding/json"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTenantRequest(method string, target string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("trace-id", "trace")
+	return req.WithContext(pkg.WithTenant(req.Context(), pkg.Tenant{TenantNamespace: "postit", Status: pkg.TenantActive}))
+}
+
+func TestAllAccounts(t *testing.T) {
+	h := NewHandler(repository.NewMemoryAccountRepository(), repository.NewMemoryPostRepository())
+	err := h.Accounts.Save("postit",
+		pkg.ApplicationInfo{ApplicationName: "twitter", UserId: "1", UserName: "postit", UserAccessToken: "token"},
+		pkg.ApplicationInfo{ApplicationName: "linked_in", UserId: "urn:li:organization:2", UserName: "Postit"},
+		// user ids are only unique within a network
+		pkg.ApplicationInfo{ApplicationName: "facebook", UserId: "1", UserName: "Postit"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// accounts of other tenants stay hidden
+	err = h.Accounts.Save("other", pkg.ApplicationInfo{ApplicationName: "facebook", UserId: "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.AllAccounts(rec, newTenantRequest(http.MethodGet, "/accounts"))
+
+	var response struct {
+		Data pkg.PostitUserData `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Data.FacebookPostitUserData) != 1 || len(response.Data.TwitterPostitUserData) != 1 || len(response.Data.LinkedInPostitUserData) != 1 {
+		t.Fatalf("unexpected accounts %+v", response.Data)
+	}
+	if response.Data.LinkedInPostitUserData[0].AccountType != "organization" {
+		t.Fatalf("expected an organization got %s", response.Data.LinkedInPostitUserData[0].AccountType)
+	}
+	if response.Data.TwitterPostitUserData[0].TokenFingerprint == "token" {
+		t.Fatal("the access token must not be returned")
+	}
+}
+
+func TestHandleDeleteTwitterCode(t *testing.T) {
+	h := NewHandler(repository.NewMemoryAccountRepository(), repository.NewMemoryPostRepository())
+	if err := h.Accounts.Save("postit", pkg.ApplicationInfo{ApplicationName: "twitter", UserId: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleDeleteTwitterCode(rec, newTenantRequest(http.MethodDelete, "/tw/code?app_id=1"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleDeleteTwitterCode(rec, newTenantRequest(http.MethodDelete, "/tw/code?app_id=1"))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", rec.Code)
+	}
+}
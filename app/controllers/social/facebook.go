@@ -2,18 +2,20 @@ package social
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/twinj/uuid"
-	"gitlab.com/pbobby001/postit-api/db"
 	"gitlab.com/pbobby001/postit-api/pkg"
 	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/publisher"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 )
 
-func HandleFacebookCode(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HandleFacebookCode(w http.ResponseWriter, r *http.Request) {
 	transactionId := uuid.NewV4()
 
 	headers, err := pkg.ValidateHeaders(r)
@@ -71,7 +73,6 @@ func HandleFacebookCode(w http.ResponseWriter, r *http.Request) {
 	}
 
 	b, _ := ioutil.ReadAll(response.Body)
-	logs.Logger.Info(string(b))
 
 	var shortLivedFbAccessToken pkg.AuthResponse
 	err = json.Unmarshal(b, &shortLivedFbAccessToken)
@@ -82,7 +83,6 @@ func HandleFacebookCode(w http.ResponseWriter, r *http.Request) {
 	logs.Logger.Info(response.Header)
 	logs.Logger.Info(response.Status)
 	logs.Logger.Info(response.StatusCode)
-	logs.Logger.Info(shortLivedFbAccessToken.AccessToken)
 	logs.Logger.Info(shortLivedFbAccessToken.ExpiresIn)
 	logs.Logger.Info(shortLivedFbAccessToken.TokenType)
 
@@ -102,7 +102,6 @@ func HandleFacebookCode(w http.ResponseWriter, r *http.Request) {
 		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
 		return
 	}
-	logs.Logger.Info(string(body))
 
 	var longLivedFbAccessToken pkg.AuthResponse
 	err = json.Unmarshal(body, &longLivedFbAccessToken)
@@ -131,26 +130,24 @@ func HandleFacebookCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//Store inside the db
-	stmt := fmt.Sprintf("INSERT INTO %s.application_info(application_uuid, application_name, application_id, application_secret, application_url, user_access_token, expires_in, user_name, user_id) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)", tenantNamespace)
-	logs.Logger.Info("query", stmt)
-	lId, err := db.Connection.Exec(stmt,
-		&appUUid,
-		"facebook",
-		&appId,
-		&appSecret,
-		&appUrl,
-		&longLivedFbAccessToken.AccessToken,
-		&longLivedFbAccessToken.ExpiresIn,
-		&fbUser.Name,
-		&fbUser.Id,
-	)
+	//Store inside the db, the repository encrypts the credentials
+	err = h.Accounts.Save(tenantNamespace, pkg.ApplicationInfo{
+		ApplicationUuid:   appUUid,
+		ApplicationName:   "facebook",
+		ApplicationId:     appId,
+		ApplicationSecret: appSecret,
+		ApplicationUrl:    appUrl,
+		UserAccessToken:   longLivedFbAccessToken.AccessToken,
+		ExpiresIn:         strconv.Itoa(longLivedFbAccessToken.ExpiresIn),
+		UserName:          fbUser.Name,
+		UserId:            fbUser.Id,
+		TokenExpiresAt:    pkg.TokenExpiresAt(longLivedFbAccessToken.ExpiresIn, time.Now()),
+		TokenStatus:       pkg.TokenHealthy,
+	})
 	if err != nil {
 		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
 		return
 	}
-	i, _ := lId.LastInsertId()
-	logs.Logger.Info("Last insert Id: ", i)
 
 	_ = json.NewEncoder(w).Encode(struct {
 		Message string   `json:"message"`
@@ -166,7 +163,7 @@ func HandleFacebookCode(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func HandleDeleteFacebookCode(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HandleDeleteFacebookCode(w http.ResponseWriter, r *http.Request) {
 
 	transactionId := uuid.NewV4()
 
@@ -185,15 +182,13 @@ func HandleDeleteFacebookCode(w http.ResponseWriter, r *http.Request) {
 
 	appUuid := r.URL.Query().Get("app_id")
 	if appUuid == "" {
-		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		pkg.SendErrorResponse(w, transactionId, traceId, errors.New("app_id is required"), http.StatusBadRequest)
 		return
 	}
 
-	stmt := fmt.Sprintf("DELETE FROM %s.application_info WHERE user_id = '%s'", tenantNamespace, appUuid)
-	logs.Logger.Info(stmt)
-	_, err = db.Connection.Exec(stmt)
+	status, err := h.deleteAccount(tenantNamespace, "facebook", appUuid)
 	if err != nil {
-		_ = logs.Logger.Error(err)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, status)
 		return
 	}
 
@@ -211,7 +206,7 @@ func HandleDeleteFacebookCode(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func FetchFacebookPosts(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) FetchFacebookPosts(w http.ResponseWriter, r *http.Request) {
 	logs.Logger.Info("===========================================")
 	logs.Logger.Info("Handling Fetch Facebook Posts ...")
 	logs.Logger.Info("===========================================")
@@ -230,73 +225,32 @@ func FetchFacebookPosts(w http.ResponseWriter, r *http.Request) {
 	// Logging the headers
 	logs.Logger.Info("Headers => TraceId: " + traceId + ", TenantNamespace: " + tenantNamespace)
 
-	query := fmt.Sprintf("SELECT post_id, facebook_post_id, facebook_user_id, post_message FROM %s.post WHERE post_fb_status = $1", tenantNamespace)
-	rows, err := db.Connection.Query(query, true)
+	facebook, err := publisher.Get("facebook")
 	if err != nil {
-		pkg.SendErrorResponse(w, transactionId, "", err, http.StatusInternalServerError)
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
+	}
+
+	posts, err := h.Posts.ListFacebookPosts(tenantNamespace)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
 		return
 	}
 
-	var dbP pkg.FacebookPostData
 	var dbPosts []pkg.FacebookPostData
 	var comment pkg.Comment
-	for rows.Next() {
-		err := rows.Scan(
-			&dbP.PostId,
-			&dbP.FacebookPostId,
-			&dbP.FacebookUserId,
-			&dbP.PostMessage,
-		)
-		if err != nil {
-			pkg.SendErrorResponse(w, transactionId, "", err, http.StatusInternalServerError)
-			return
-		}
-
-		// get accessToken from db
-		var accessToken string
-		query := fmt.Sprintf("SELECT user_access_token FROM %s.application_info WHERE user_id = $1", tenantNamespace)
-		err = db.Connection.QueryRow(query, dbP.FacebookUserId).Scan(&accessToken)
+	for _, dbP := range posts {
+		// get the account the post was published with
+		account, err := h.Accounts.Get(tenantNamespace, "facebook", dbP.FacebookUserId)
 		if err != nil {
 			pkg.SendErrorResponse(w, transactionId, "", err, http.StatusInternalServerError)
 			return
 		}
-		// prepare request to facebook for the comments
-		client := &http.Client{}
-		req, err := http.NewRequest(http.MethodGet, os.Getenv("FACEBOOK_COMMENTS_URL")+"/v10.0/"+dbP.FacebookPostId+"/comments?access_token="+accessToken, nil)
-		if err != nil {
-			logs.Logger.Info(err)
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		logs.Logger.Info(req.URL.String())
 
-		// make the request
-		//req.URL.Query().Set("access_token", accessToken)
+		// fetch the comments from facebook
 		logs.Logger.Info(dbP)
 		logs.Logger.Info("fetching comments from facebook")
-		resp, err := client.Do(req)
-		if err != nil {
-			logs.Logger.Info(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			logs.Logger.Info(err)
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		logs.Logger.Info(string(body))
-		logs.Logger.Info(resp.Status)
-
-		if resp.StatusCode != 200 {
-			logs.Logger.Info(resp.StatusCode)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		err = json.Unmarshal(body, &comment)
+		comment, err = facebook.FetchComments(account, dbP.FacebookPostId)
 		if err != nil {
 			logs.Logger.Info(err)
 			w.WriteHeader(http.StatusInternalServerError)
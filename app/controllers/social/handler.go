@@ -0,0 +1,31 @@
+package social
+
+import (
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"net/http"
+)
+
+// Handler serves the social account endpoints using the repositories it is given
+type Handler struct {
+	Accounts repository.AccountRepository
+	Posts    repository.PostRepository
+}
+
+func NewHandler(accounts repository.AccountRepository, posts repository.PostRepository) *Handler {
+	return &Handler{
+		Accounts: accounts,
+		Posts:    posts,
+	}
+}
+
+// deleteAccount removes a connected account of the given network, 404 when it is not connected
+func (h *Handler) deleteAccount(tenantNamespace string, applicationName string, userId string) (int, error) {
+	err := h.Accounts.Delete(tenantNamespace, applicationName, userId)
+	if err == repository.ErrNotFound {
+		return http.StatusNotFound, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
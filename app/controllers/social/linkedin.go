@@ -0,0 +1,168 @@
+package social
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/twinj/uuid"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/publisher"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* HandleLinkedInCode completes the linkedin oauth flow started by the ui.
+The member is stored along with every company page they administer, pages are stored
+under their organization urn so posts scheduled to them are published as the page. */
+func (h *Handler) HandleLinkedInCode(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+
+	headers, err := pkg.ValidateHeaders(r)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, "", err, http.StatusBadRequest)
+		return
+	}
+
+	//Get the relevant headers
+	traceId := headers["trace-id"]
+	tenantNamespace := headers["tenant-namespace"]
+
+	// Logging the headers
+	logs.Logger.Info("Headers => TraceId: " + traceId + ", TenantNamespace: " + tenantNamespace)
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+
+	var requestBody pkg.LinkedInCode
+	err = json.Unmarshal(body, &requestBody)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestBody.Code == "" {
+		pkg.SendErrorResponse(w, transactionId, traceId, errors.New("code is required"), http.StatusBadRequest)
+		return
+	}
+
+	appId := os.Getenv("LINKEDIN_CLIENT_ID")
+	appSecret := os.Getenv("LINKEDIN_CLIENT_SECRET")
+	appUrl := os.Getenv("LINKEDIN_APP_URL")
+
+	// the api urls are taken from LINKEDIN_API_URL and LINKEDIN_AUTH_URL
+	linkedIn := &publisher.LinkedInPublisher{}
+
+	token, err := linkedIn.ExchangeCode(requestBody.Code, appId, appSecret, appUrl)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+	logs.Logger.Info(token.ExpiresIn)
+
+	liUser, err := linkedIn.Me(token.AccessToken)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+	logs.Logger.Info(liUser)
+
+	// pages need the rw_organization_admin scope, members who did not grant it only get their profile stored
+	organizations, err := linkedIn.Organizations(token.AccessToken)
+	if err != nil {
+		_ = logs.Logger.Error(err)
+	}
+
+	accounts := []pkg.LinkedInOrganization{{
+		Urn:  "urn:li:person:" + liUser.Id,
+		Name: strings.TrimSpace(liUser.FirstName + " " + liUser.LastName),
+	}}
+	accounts = append(accounts, organizations...)
+
+	//Store inside the db in a single transaction, reconnecting an account replaces its tokens
+	var infos []pkg.ApplicationInfo
+	for _, account := range accounts {
+		infos = append(infos, pkg.ApplicationInfo{
+			ApplicationUuid:   uuid.NewV4(),
+			ApplicationName:   "linked_in",
+			ApplicationId:     appId,
+			ApplicationSecret: appSecret,
+			ApplicationUrl:    appUrl,
+			UserAccessToken:   token.AccessToken,
+			RefreshToken:      token.RefreshToken,
+			ExpiresIn:         strconv.Itoa(token.ExpiresIn),
+			UserName:          account.Name,
+			UserId:            account.Urn,
+			TokenExpiresAt:    pkg.TokenExpiresAt(token.ExpiresIn, time.Now()),
+			TokenStatus:       pkg.TokenHealthy,
+		})
+	}
+
+	err = h.Accounts.Save(tenantNamespace, infos...)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(struct {
+		Message string   `json:"message"`
+		Meta    pkg.Meta `json:"meta"`
+	}{
+		Message: "Stored Code",
+		Meta: pkg.Meta{
+			Timestamp:     time.Now(),
+			TransactionId: transactionId.String(),
+			TraceId:       traceId,
+			Status:        "SUCCESS",
+		},
+	})
+}
+
+func (h *Handler) HandleDeleteLinkedInCode(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+
+	headers, err := pkg.ValidateHeaders(r)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, "", err, http.StatusBadRequest)
+		return
+	}
+
+	//Get the relevant headers
+	traceId := headers["trace-id"]
+	tenantNamespace := headers["tenant-namespace"]
+
+	// Logging the headers
+	logs.Logger.Info("Headers => TraceId: " + traceId + ", TenantNamespace: " + tenantNamespace)
+
+	// the member or page urn
+	userId := r.URL.Query().Get("app_id")
+	if userId == "" {
+		pkg.SendErrorResponse(w, transactionId, traceId, errors.New("app_id is required"), http.StatusBadRequest)
+		return
+	}
+
+	status, err := h.deleteAccount(tenantNamespace, "linked_in", userId)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, status)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
+		Data: pkg.Data{
+			Id:        "",
+			UiMessage: "Code Deleted",
+		},
+		Meta: pkg.Meta{
+			Timestamp:     time.Now(),
+			TransactionId: transactionId.String(),
+			TraceId:       traceId,
+			Status:        "SUCCESS",
+		},
+	})
+}
@@ -0,0 +1,152 @@
+package social
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/twinj/uuid"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/publisher"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+/* HandleTwitterCode completes the twitter oauth 2.0 flow started by the ui.
+The ui sends the authorization code along with the PKCE code verifier it generated,
+the resulting tokens are stored in application_info under the twitter application name. */
+func (h *Handler) HandleTwitterCode(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+
+	headers, err := pkg.ValidateHeaders(r)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, "", err, http.StatusBadRequest)
+		return
+	}
+
+	//Get the relevant headers
+	traceId := headers["trace-id"]
+	tenantNamespace := headers["tenant-namespace"]
+
+	// Logging the headers
+	logs.Logger.Info("Headers => TraceId: " + traceId + ", TenantNamespace: " + tenantNamespace)
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+
+	var requestBody pkg.TwitterCode
+	err = json.Unmarshal(body, &requestBody)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestBody.Code == "" || requestBody.CodeVerifier == "" {
+		pkg.SendErrorResponse(w, transactionId, traceId, errors.New("code and code_verifier are required"), http.StatusBadRequest)
+		return
+	}
+
+	appUUid := uuid.NewV4()
+	appId := os.Getenv("TWITTER_CLIENT_ID")
+	appSecret := os.Getenv("TWITTER_CLIENT_SECRET")
+	appUrl := os.Getenv("TWITTER_APP_URL")
+
+	// the api url is taken from TWITTER_API_URL
+	twitter := &publisher.TwitterPublisher{}
+
+	// use code to get the access and refresh tokens
+	token, err := twitter.ExchangeCode(requestBody, appId, appSecret, appUrl)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+	logs.Logger.Info(token.TokenType)
+	logs.Logger.Info(token.ExpiresIn)
+
+	twUser, err := twitter.Me(token.AccessToken)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+		return
+	}
+	logs.Logger.Info(twUser)
+
+	//Store inside the db, reconnecting an account replaces its tokens
+	err = h.Accounts.Save(tenantNamespace, pkg.ApplicationInfo{
+		ApplicationUuid:   appUUid,
+		ApplicationName:   "twitter",
+		ApplicationId:     appId,
+		ApplicationSecret: appSecret,
+		ApplicationUrl:    appUrl,
+		UserAccessToken:   token.AccessToken,
+		RefreshToken:      token.RefreshToken,
+		ExpiresIn:         strconv.Itoa(token.ExpiresIn),
+		UserName:          twUser.Username,
+		UserId:            twUser.Id,
+		TokenExpiresAt:    pkg.TokenExpiresAt(token.ExpiresIn, time.Now()),
+		TokenStatus:       pkg.TokenHealthy,
+	})
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(struct {
+		Message string   `json:"message"`
+		Meta    pkg.Meta `json:"meta"`
+	}{
+		Message: "Stored Code",
+		Meta: pkg.Meta{
+			Timestamp:     time.Now(),
+			TransactionId: transactionId.String(),
+			TraceId:       traceId,
+			Status:        "SUCCESS",
+		},
+	})
+}
+
+func (h *Handler) HandleDeleteTwitterCode(w http.ResponseWriter, r *http.Request) {
+	transactionId := uuid.NewV4()
+
+	headers, err := pkg.ValidateHeaders(r)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, "", err, http.StatusBadRequest)
+		return
+	}
+
+	//Get the relevant headers
+	traceId := headers["trace-id"]
+	tenantNamespace := headers["tenant-namespace"]
+
+	// Logging the headers
+	logs.Logger.Info("Headers => TraceId: " + traceId + ", TenantNamespace: " + tenantNamespace)
+
+	userId := r.URL.Query().Get("app_id")
+	if userId == "" {
+		pkg.SendErrorResponse(w, transactionId, traceId, errors.New("app_id is required"), http.StatusBadRequest)
+		return
+	}
+
+	status, err := h.deleteAccount(tenantNamespace, "twitter", userId)
+	if err != nil {
+		pkg.SendErrorResponse(w, transactionId, traceId, err, status)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
+		Data: pkg.Data{
+			Id:        "",
+			UiMessage: "Code Deleted",
+		},
+		Meta: pkg.Meta{
+			Timestamp:     time.Now(),
+			TransactionId: transactionId.String(),
+			TraceId:       traceId,
+			Status:        "SUCCESS",
+		},
+	})
+}
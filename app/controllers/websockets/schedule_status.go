@@ -0,0 +1,117 @@
+package websockets
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/twinj/uuid"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"net/http"
+	"time"
+)
+
+const (
+	// Time allowed for the client to send the handshake data after connecting
+	handShakeWait = 10 * time.Second
+	// Number of frames buffered per client
+	sendBufferSize = 256
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     checkOrigin,
+}
+
+/* checkOrigin only lets browsers on the UI origins connect, CORS does not apply to websocket handshakes.
+Clients outside a browser send no Origin and are let through, they still need a token in the handshake frame. */
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || pkg.UIOrigin(origin)
+}
+
+/* HandleScheduleStatus upgrades the request to a websocket connection.
+Browsers cannot set headers on a websocket request, so the first frame sent by the client
+must be a pkg.WebSocketHandShakeData carrying the tenant namespace and the auth token.
+Once validated, the client receives the current status of every schedule of the tenant
+followed by a new frame each time a post in one of them gets published. */
+func HandleScheduleStatus(w http.ResponseWriter, r *http.Request) {
+	logs.Logger.Info("===========================================")
+	logs.Logger.Info("Handling Schedule Status Socket ...")
+	logs.Logger.Info("===========================================")
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already replied with an http error
+		_ = logs.Logger.Error(err)
+		return
+	}
+
+	var handShake pkg.WebSocketHandShakeData
+	_ = conn.SetReadDeadline(time.Now().Add(handShakeWait))
+	err = conn.ReadJSON(&handShake)
+	if err != nil {
+		rejectConnection(conn, websocket.CloseProtocolError, "invalid handshake")
+		_ = logs.Logger.Error(err)
+		return
+	}
+
+	if handShake.TenantNamespace == "" || handShake.AuthToken == "" {
+		rejectConnection(conn, websocket.ClosePolicyViolation, "tenant_namespace and auth_token are required")
+		return
+	}
+
+	err = pkg.WebSocketTokenValidateToken(handShake.AuthToken, handShake.TenantNamespace)
+	if err != nil {
+		rejectConnection(conn, websocket.ClosePolicyViolation, "unauthorized")
+		_ = logs.Logger.Error(err)
+		return
+	}
+
+	// the namespace is only trusted once it is found in the tenants registry
+	_, err = pkg.ActiveTenant(handShake.TenantNamespace)
+	if err != nil {
+		rejectConnection(conn, websocket.ClosePolicyViolation, "unknown tenant")
+		_ = logs.Logger.Error(err)
+		return
+	}
+
+	client := &pkg.Client{
+		Id:              uuid.NewV4().String(),
+		TenantNamespace: handShake.TenantNamespace,
+		Hub:             pkg.ScheduleStatusHub,
+		Conn:            conn,
+		Send:            make(chan []byte, sendBufferSize),
+	}
+
+	// queue a snapshot so the client does not have to wait for the next change
+	statuses, err := pkg.FetchScheduleStatuses(client.TenantNamespace)
+	if err != nil {
+		_ = logs.Logger.Error(err)
+	}
+	for _, status := range statuses {
+		frame, err := json.Marshal(status)
+		if err != nil {
+			_ = logs.Logger.Error(err)
+			continue
+		}
+		select {
+		case client.Send <- frame:
+		default:
+		}
+	}
+
+	client.Hub.Register <- client
+
+	go client.WritePump()
+	go client.ReadPump()
+}
+
+func rejectConnection(conn *websocket.Conn, code int, reason string) {
+	_ = conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second),
+	)
+	_ = conn.Close()
+}
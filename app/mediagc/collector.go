@@ -0,0 +1,107 @@
+package mediagc
+
+import (
+	"gitlab.com/pbobby001/postit-api/db"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/pipeline"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
+	"os"
+	"time"
+)
+
+const (
+	// Key of the postgres advisory lock that makes sure only one instance collects uploads
+	advisoryLockKey = 5382
+	// Uploads no post attached within this time are removed
+	defaultTTL = 24 * time.Hour
+	// Upload jobs still processing after this time were interrupted by a restart
+	defaultJobTimeout = 30 * time.Minute
+)
+
+/* Start removes the uploads of every tenant that were never attached to a post once per interval and never returns.
+The time to live is read from MEDIA_UPLOAD_TTL and defaults to a day.
+Upload jobs processing for longer than MEDIA_JOB_TIMEOUT, half an hour by default, are failed along with it. */
+func Start(media repository.MediaRepository, jobs repository.UploadJobRepository, store storage.MediaStore, interval time.Duration) {
+	ttl, err := time.ParseDuration(os.Getenv("MEDIA_UPLOAD_TTL"))
+	if err != nil {
+		ttl = defaultTTL
+	}
+	jobTimeout, err := time.ParseDuration(os.Getenv("MEDIA_JOB_TIMEOUT"))
+	if err != nil {
+		jobTimeout = defaultJobTimeout
+	}
+
+	logs.Logger.Info("Starting upload collector, checking every ", interval)
+	for {
+		now := time.Now()
+		err := tick(media, jobs, store, now.Add(-ttl), now.Add(-jobTimeout))
+		if err != nil {
+			_ = logs.Logger.Error(err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func tick(media repository.MediaRepository, jobs repository.UploadJobRepository, store storage.MediaStore, cutoff time.Time, jobCutoff time.Time) error {
+	return db.WithAdvisoryLock(advisoryLockKey, func() error {
+		namespaces, err := db.TenantNamespaces(false)
+		if err != nil {
+			return err
+		}
+
+		for _, tenantNamespace := range namespaces {
+			failed, err := jobs.FailStale(tenantNamespace, jobCutoff, interrupted)
+			if err != nil {
+				_ = logs.Logger.Errorf("unable to fail the stale upload jobs of %s: %v", tenantNamespace, err)
+			}
+			if failed > 0 {
+				logs.Logger.Infof("Failed %d interrupted upload jobs of %s", failed, tenantNamespace)
+			}
+
+			count, err := collect(media, store, tenantNamespace, cutoff)
+			if err != nil {
+				_ = logs.Logger.Errorf("unable to collect the uploads of %s: %v", tenantNamespace, err)
+			}
+			if count > 0 {
+				logs.Logger.Infof("Removed %d unattached uploads of %s", count, tenantNamespace)
+			}
+		}
+		return nil
+	})
+}
+
+// interrupted is the error of upload jobs that never finished, their upload was only held in memory
+var interrupted = pkg.UploadError{Code: pkg.UploadFailed, Message: "the upload was interrupted, upload the file again"}
+
+/* collect removes the unattached uploads of a tenant created before cutoff and returns how many it removed.
+The record goes first, an upload attached in the meantime keeps its record and its content.
+The content is only removed along with the last record sharing it. */
+func collect(media repository.MediaRepository, store storage.MediaStore, tenantNamespace string, cutoff time.Time) (int, error) {
+	uploads, err := media.ExpiredUploads(tenantNamespace, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, upload := range uploads {
+		released, err := media.DeleteUpload(tenantNamespace, upload.MediaId)
+		if err == repository.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+		if !released {
+			continue
+		}
+
+		err = pipeline.Remove(store, tenantNamespace, upload)
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,71 @@
+package mediagc
+
+import (
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
+	"testing"
+	"time"
+)
+
+func TestCollect(t *testing.T) {
+	media := repository.NewMemoryMediaRepository(nil)
+	store := storage.NewMemoryStore()
+	now := time.Now()
+
+	uploads := map[string]pkg.Media{
+		"expired":  {MediaId: "expired", CreatedAt: now.Add(-48 * time.Hour)},
+		"attached": {MediaId: "attached", Attached: true, CreatedAt: now.Add(-48 * time.Hour)},
+		"recent":   {MediaId: "recent", CreatedAt: now.Add(-time.Hour)},
+	}
+	for _, upload := range uploads {
+		key, _ := pkg.MediaKey("postit", upload.MediaId)
+		if err := store.Put(key, []byte(upload.MediaId), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := media.Create("postit", upload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := collect(media, store, "postit", now.Add(-defaultTTL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected one upload to be collected got %d", count)
+	}
+
+	for mediaId := range uploads {
+		_, err := media.Get("postit", mediaId)
+		key, _ := pkg.MediaKey("postit", mediaId)
+		_, contentErr := store.Get(key)
+		if collected := mediaId == "expired"; collected != (err == repository.ErrNotFound) || collected != (contentErr == storage.ErrNotFound) {
+			t.Errorf("unexpected state of %s: %v, %v", mediaId, err, contentErr)
+		}
+	}
+}
+
+func TestFailStaleJobs(t *testing.T) {
+	jobs := repository.NewMemoryUploadJobRepository()
+	for _, jobId := range []string{"stale", "running"} {
+		if err := jobs.Create("postit", pkg.UploadJob{JobId: jobId, Status: pkg.JobProcessing}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	if err := jobs.Finish("postit", pkg.UploadJob{JobId: "running", Status: pkg.JobProcessing}); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := jobs.FailStale("postit", cutoff, interrupted)
+	if err != nil || count != 1 {
+		t.Fatalf("expected one job to be failed got %d: %v", count, err)
+	}
+	stale, _ := jobs.Get("postit", "stale")
+	running, _ := jobs.Get("postit", "running")
+	if stale.Status != pkg.JobFailed || stale.Error == nil || stale.Error.Code != pkg.UploadFailed || running.Status != pkg.JobProcessing {
+		t.Fatalf("unexpected jobs %+v %+v", stale, running)
+	}
+}
@@ -0,0 +1,63 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/twinj/uuid"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/auth"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"net/http"
+	"time"
+)
+
+/* RequireAccess guards a single route, it lets through callers holding at least role in the tenant of the request
+or API keys of that tenant granted scope. Scopes of user tokens span every tenant of their audience, so users always need the role.
+An empty role or scope grants nothing on its own.
+It runs after TenantMiddleware, which provides the tenant. */
+func RequireAccess(role auth.Role, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, hasClaims := pkg.ClaimsFromContext(r.Context())
+			tenant, hasTenant := pkg.TenantFromContext(r.Context())
+			if hasClaims && hasTenant {
+				if role != "" && claims.RoleIn(tenant.TenantNamespace).Includes(role) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				if scope != "" && claims.HasScopeIn(tenant.TenantNamespace, scope) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			logs.Logger.Infof("access denied to %s %s", r.Method, r.URL.Path)
+			sendForbidden(w, r.Header.Get("trace-id"), accessMessage(role, scope))
+		})
+	}
+}
+
+func accessMessage(role auth.Role, scope string) string {
+	switch {
+	case role != "" && scope != "":
+		return fmt.Sprintf("This requires the %s role in this tenant or the %s scope", role, scope)
+	case role != "":
+		return fmt.Sprintf("This requires the %s role in this tenant", role)
+	default:
+		return fmt.Sprintf("This requires the %s scope", scope)
+	}
+}
+
+// sendForbidden writes a 403 telling the caller what it lacks, unlike pkg.SendErrorResponse the message is kept
+func sendForbidden(w http.ResponseWriter, traceId string, message string) {
+	w.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(w).Encode(pkg.StandardResponse{
+		Data: pkg.Data{UiMessage: message},
+		Meta: pkg.Meta{
+			Timestamp:     time.Now(),
+			TransactionId: uuid.NewV4().String(),
+			TraceId:       traceId,
+			Status:        "FAILED",
+		},
+	})
+}
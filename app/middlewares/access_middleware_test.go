@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"github.com/cristalhq/jwt"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/auth"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireAccess(t *testing.T) {
+	handler := RequireAccess(auth.RoleEditor, auth.ScopePostsWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name   string
+		claims *auth.Claims
+		status int
+	}{
+		{"owner", &auth.Claims{Roles: map[string]auth.Role{"postit": auth.RoleOwner}}, http.StatusNoContent},
+		{"editor", &auth.Claims{Roles: map[string]auth.Role{"postit": auth.RoleEditor}}, http.StatusNoContent},
+		{"viewer", &auth.Claims{Roles: map[string]auth.Role{"postit": auth.RoleViewer}}, http.StatusForbidden},
+		{"editor elsewhere", &auth.Claims{Roles: map[string]auth.Role{"acme": auth.RoleOwner}}, http.StatusForbidden},
+		{"unknown role", &auth.Claims{Roles: map[string]auth.Role{"postit": "admin"}}, http.StatusForbidden},
+		{"api key scope", &auth.Claims{Scope: "posts:read posts:write", ScopeTenant: "postit"}, http.StatusNoContent},
+		{"api key other scope", &auth.Claims{Scope: "posts:read", ScopeTenant: "postit"}, http.StatusForbidden},
+		{"api key of another tenant", &auth.Claims{Scope: "posts:write", ScopeTenant: "acme"}, http.StatusForbidden},
+		// scopes of user tokens are not bound to a tenant, the role decides
+		{"viewer with scope", &auth.Claims{Roles: map[string]auth.Role{"postit": auth.RoleViewer}, Scope: "posts:write"}, http.StatusForbidden},
+		{"token scope", &auth.Claims{Scope: "posts:read posts:write"}, http.StatusForbidden},
+		{"no claims", nil, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/batch-post", nil)
+		req.Header.Set("trace-id", "trace")
+		ctx := pkg.WithTenant(req.Context(), pkg.Tenant{TenantNamespace: "postit", Status: pkg.TenantActive})
+		if test.claims != nil {
+			test.claims.StandardClaims = jwt.StandardClaims{Subject: "ama"}
+			ctx = pkg.WithClaims(ctx, test.claims)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req.WithContext(ctx))
+		if rec.Code != test.status {
+			t.Errorf("%s: expected %d got %d", test.name, test.status, rec.Code)
+			continue
+		}
+		if rec.Code != http.StatusForbidden {
+			continue
+		}
+		var response pkg.StandardResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.Meta.Status != "FAILED" || response.Meta.TraceId != "trace" ||
+			!strings.Contains(response.Data.UiMessage, "editor role") {
+			t.Errorf("%s: expected a structured 403 got %s", test.name, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"net/http"
+	"os"
+)
+
+/* AdminMiddleware guards the routes with the admin policy with the key in ADMIN_API_KEY sent as X-Admin-Key.
+Admin routes are refused altogether when no key is configured. */
+func AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := os.Getenv("ADMIN_API_KEY")
+		if key == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Key")), []byte(key)) != 1 {
+			logs.Logger.Info("Admin key required")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
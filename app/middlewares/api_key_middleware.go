@@ -0,0 +1,56 @@
+package middlewares
+
+import (
+	"github.com/twinj/uuid"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"net/http"
+)
+
+/* Authenticate lets callers in with an API key of the tenant in the X-Api-Key header or with a bearer token,
+requests without an API key are handed to JWTMiddleware. The request is served with the claims of the key,
+which carry its scopes and no role, so keys only pass routes declaring a scope.
+The tenant itself is resolved afterwards by TenantMiddleware like for tokens. */
+func Authenticate(keys repository.ApiKeyRepository) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		jwt := JWTMiddleware(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey := r.Header.Get(pkg.ApiKeyHeader)
+			if apiKey == "" {
+				jwt.ServeHTTP(w, r)
+				return
+			}
+
+			transactionId := uuid.NewV4()
+			traceId := r.Header.Get("trace-id")
+			if r.Header.Get("Authorization") != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				logs.Logger.Info("both an API key and a token were sent")
+				return
+			}
+
+			tenantNamespace := r.Header.Get("tenant-namespace")
+			if tenantNamespace == "" {
+				pkg.SendErrorResponse(w, transactionId, traceId, pkg.ErrTenantNotResolved, http.StatusBadRequest)
+				return
+			}
+			err := pkg.ValidateTenantNamespace(tenantNamespace)
+			if err != nil {
+				pkg.SendErrorResponse(w, transactionId, traceId, err, http.StatusBadRequest)
+				return
+			}
+
+			// unknown tenants fail the lookup like unknown keys
+			key, err := keys.Authenticate(tenantNamespace, pkg.HashApiKey(apiKey))
+			if err != nil {
+				logs.Logger.Info(err)
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte("contact admin"))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(pkg.WithClaims(r.Context(), pkg.ApiKeyClaims(key, tenantNamespace))))
+		})
+	}
+}
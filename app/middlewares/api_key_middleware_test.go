@@ -0,0 +1,82 @@
+package middlewares
+
+import (
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/auth"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticateWithApiKeys(t *testing.T) {
+	keys := repository.NewMemoryApiKeyRepository()
+	request, err := pkg.ValidateApiKeyRequest(pkg.ApiKeyRequest{Name: " cms ", Scopes: []string{auth.ScopePostsWrite, auth.ScopePostsWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := pkg.NewApiKey(request, "ama")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = keys.Create("postit", key); err != nil {
+		t.Fatal(err)
+	}
+	revoked, _ := pkg.NewApiKey(request, "ama")
+	_ = keys.Create("postit", revoked)
+	_ = keys.Revoke("postit", revoked.KeyId)
+
+	var served *auth.Claims
+	handler := Authenticate(keys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served, _ = pkg.ClaimsFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name          string
+		apiKey        string
+		tenant        string
+		authorization string
+		status        int
+	}{
+		{"valid", key.Key, "postit", "", http.StatusOK},
+		{"another tenant", key.Key, "bakery", "", http.StatusUnauthorized},
+		{"revoked", revoked.Key, "postit", "", http.StatusUnauthorized},
+		{"hash", key.Hash, "postit", "", http.StatusUnauthorized},
+		{"no tenant", key.Key, "", "", http.StatusBadRequest},
+		{"invalid tenant", key.Key, "Postit", "", http.StatusBadRequest},
+		{"with a token", key.Key, "postit", "Bearer token", http.StatusBadRequest},
+		// requests without a key go through JWTMiddleware
+		{"no key", "", "postit", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		served = nil
+		req := httptest.NewRequest(http.MethodPost, "/batch-post", nil)
+		req.Header.Set("tenant-namespace", test.tenant)
+		if test.apiKey != "" {
+			req.Header.Set(pkg.ApiKeyHeader, test.apiKey)
+		}
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.status || (served != nil) != (test.status == http.StatusOK) {
+			t.Errorf("%s: expected %d got %d", test.name, test.status, rec.Code)
+		}
+	}
+
+	// the key acts through its scopes only, never through a role
+	req := httptest.NewRequest(http.MethodPost, "/batch-post", nil)
+	req.Header.Set("tenant-namespace", "postit")
+	req.Header.Set(pkg.ApiKeyHeader, key.Key)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if served == nil || !served.HasScope(auth.ScopePostsWrite) || served.HasScope(auth.ScopePostsRead) || served.RoleIn("postit") != "" || served.Audience[0] != "postit" {
+		t.Fatalf("expected the claims of the key got %+v", served)
+	}
+	listed, _ := keys.List("postit")
+	for _, listedKey := range listed {
+		if listedKey.KeyId == key.KeyId && listedKey.LastUsedAt == nil {
+			t.Fatalf("expected the use of the key to be recorded")
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+type connContextKey struct{}
+
+// ConnContext keeps the connection of a request in its context, it is set as http.Server.ConnContext so ExtendDeadline finds it
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, conn)
+}
+
+/* ExtendDeadline gives a route timeout to read the request and write the response instead of the deadlines of the server.
+The server sets its deadlines again for the next request on the connection.
+Without a connection in the context, as in tests, the deadlines of the server are kept. */
+func ExtendDeadline(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if conn, ok := r.Context().Value(connContextKey{}).(net.Conn); ok {
+				deadline := time.Now().Add(timeout)
+				_ = conn.SetReadDeadline(deadline)
+				_ = conn.SetWriteDeadline(deadline)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package middlewares
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slowUpload sends a body over longer than the read timeout of the server and returns the status of the response
+func slowUpload(handler http.Handler) int {
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.ReadTimeout = 100 * time.Millisecond
+	server.Config.ConnContext = ConnContext
+	server.Start()
+	defer server.Close()
+
+	body, writer := io.Pipe()
+	go func() {
+		for i := 0; i < 5; i++ {
+			time.Sleep(60 * time.Millisecond)
+			if _, err := writer.Write([]byte("chunk")); err != nil {
+				return
+			}
+		}
+		_ = writer.Close()
+	}()
+
+	resp, err := http.Post(server.URL, "application/octet-stream", body)
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestExtendDeadline(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ioutil.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusRequestTimeout)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if status := slowUpload(handler); status == http.StatusNoContent {
+		t.Fatalf("slow upload without extended deadline = %d, want it cut by the read timeout", status)
+	}
+	if status := slowUpload(ExtendDeadline(2 * time.Second)(handler)); status != http.StatusNoContent {
+		t.Fatalf("slow upload with extended deadline = %d, want %d", status, http.StatusNoContent)
+	}
+}
@@ -0,0 +1,108 @@
+package middlewares
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/cristalhq/jwt"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/auth"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func signToken(t *testing.T, key *rsa.PrivateKey, subject string, expiresAt time.Time) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	body, _ := json.Marshal(jwt.StandardClaims{
+		Subject:   subject,
+		Audience:  jwt.Audience{"postit-audience", "postit"},
+		ExpiresAt: jwt.Timestamp(expiresAt.Unix()),
+	})
+	payload := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(payload))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTMiddlewareRefreshesTokens(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forger, _ := rsa.GenerateKey(rand.Reader, 2048)
+	auth.UseVerifier(&auth.Verifier{Keys: auth.StaticKeys{key.Public()}})
+
+	// the authentication server answers with whatever the test wants to hand out
+	var refreshed string
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("token")
+		if r.URL.Path != "/refresh-token" || refreshed == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("refresh-token", refreshed)
+	}))
+	defer server.Close()
+	previous := os.Getenv("AUTHENTICATION_SERVER_URL")
+	_ = os.Setenv("AUTHENTICATION_SERVER_URL", server.URL)
+	defer os.Setenv("AUTHENTICATION_SERVER_URL", previous)
+
+	var served *auth.Claims
+	handler := JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served, _ = pkg.ClaimsFromContext(r.Context())
+	}))
+	call := func(token string) *httptest.ResponseRecorder {
+		served = nil
+		req := httptest.NewRequest(http.MethodGet, "/posts", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("tenant-namespace", "postit")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	valid := signToken(t, key, "ama", time.Now().Add(time.Hour))
+	rec := call(valid)
+	if rec.Code != http.StatusOK || served == nil || served.Subject != "ama" || rec.Header().Get(pkg.RefreshedTokenHeader) != "" {
+		t.Fatalf("expected a valid token to pass untouched got %d %+v", rec.Code, served)
+	}
+
+	expired := signToken(t, key, "ama", time.Now().Add(-time.Hour))
+	refreshed = signToken(t, key, "ama", time.Now().Add(2*time.Hour))
+	rec = call(expired)
+	if rec.Code != http.StatusOK || rec.Header().Get(pkg.RefreshedTokenHeader) != refreshed || received != expired {
+		t.Fatalf("expected the refreshed token to be handed back got %d %q", rec.Code, rec.Header().Get(pkg.RefreshedTokenHeader))
+	}
+	if served == nil || served.ExpiresAt.Time().Before(time.Now().Add(time.Hour)) {
+		t.Fatalf("expected the request to be served with the refreshed claims got %+v", served)
+	}
+
+	tests := []struct {
+		name      string
+		refreshed string
+	}{
+		{"refused", ""},
+		{"forged", signToken(t, forger, "ama", time.Now().Add(time.Hour))},
+		{"another subject", signToken(t, key, "kofi", time.Now().Add(time.Hour))},
+		{"expired", signToken(t, key, "ama", time.Now().Add(-time.Minute))},
+	}
+	for _, test := range tests {
+		refreshed = test.refreshed
+		rec = call(expired)
+		if rec.Code != http.StatusUnauthorized || served != nil || rec.Header().Get(pkg.RefreshedTokenHeader) != "" {
+			t.Errorf("%s: expected 401 got %d", test.name, rec.Code)
+		}
+	}
+
+	if rec = call(signToken(t, forger, "ama", time.Now().Add(time.Hour))); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a forged token to be rejected got %d", rec.Code)
+	}
+}
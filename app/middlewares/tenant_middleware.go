@@ -0,0 +1,43 @@
+package middlewares
+
+import (
+	"errors"
+	"github.com/twinj/uuid"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"net/http"
+)
+
+/* TenantMiddleware resolves the tenant-namespace header against the tenants registry and the token audience
+and puts the tenant into the request context, handlers get it back through pkg.ValidateHeaders.
+It runs after JWTMiddleware which provides the claims. */
+func TenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		transactionId := uuid.NewV4()
+		traceId := r.Header.Get("trace-id")
+
+		claims, ok := pkg.ClaimsFromContext(r.Context())
+		if !ok {
+			pkg.SendErrorResponse(w, transactionId, traceId, errors.New("no token claims in the request context"), http.StatusUnauthorized)
+			return
+		}
+
+		tenant, err := pkg.ResolveTenant(r.Header.Get("tenant-namespace"), claims.Audience)
+		if err != nil {
+			pkg.SendErrorResponse(w, transactionId, traceId, err, tenantErrorStatus(err))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(pkg.WithTenant(r.Context(), tenant)))
+	})
+}
+
+func tenantErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, pkg.ErrTenantNotResolved), errors.Is(err, pkg.ErrInvalidNamespace):
+		return http.StatusBadRequest
+	case errors.Is(err, pkg.ErrTenantNotFound), errors.Is(err, pkg.ErrTenantSuspended), errors.Is(err, pkg.ErrTenantAudience):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
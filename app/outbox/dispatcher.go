@@ -0,0 +1,93 @@
+package outbox
+
+import (
+	"fmt"
+	"gitlab.com/pbobby001/postit-api/db"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"sync"
+	"time"
+)
+
+const (
+	// Key of the postgres advisory lock that makes sure only one instance delivers messages
+	advisoryLockKey = 5381
+	// Messages delivered per tick
+	batchSize = 50
+	// Failed deliveries are retried with an exponential backoff capped at maxBackoff
+	baseBackoff = 15 * time.Second
+	maxBackoff  = time.Hour
+)
+
+// Handler delivers a message, a returned error schedules another attempt
+type Handler func(message pkg.OutboxMessage) error
+
+var (
+	mu       sync.RWMutex
+	handlers = make(map[string]Handler)
+)
+
+// Register sets the handler delivering the messages of topic
+func Register(topic string, handler Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[topic] = handler
+}
+
+/* Start delivers the committed outbox messages once per interval and never returns.
+Messages are delivered at least once, the receivers have to cope with duplicates. */
+func Start(interval time.Duration) {
+	logs.Logger.Info("Starting outbox dispatcher, checking every ", interval)
+	for {
+		err := db.WithAdvisoryLock(advisoryLockKey, func() error {
+			return dispatch(time.Now().UTC())
+		})
+		if err != nil {
+			_ = logs.Logger.Error(err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func dispatch(now time.Time) error {
+	messages, err := pkg.PendingOutbox(now, batchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		err = deliver(message)
+		if err != nil {
+			_ = logs.Logger.Errorf("unable to deliver %s message %s of %s: %v", message.Topic, message.MessageId, message.TenantNamespace, err)
+			err = pkg.MarkOutboxFailed(message.MessageId, err, now.Add(backoff(message.Attempts+1)))
+		} else {
+			err = pkg.MarkOutboxDelivered(message.MessageId, now)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deliver(message pkg.OutboxMessage) error {
+	mu.RLock()
+	handler, ok := handlers[message.Topic]
+	mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler for topic %s", message.Topic)
+	}
+	return handler(message)
+}
+
+// backoff returns how long to wait before the next delivery after the given number of failed attempts
+func backoff(attempts int) time.Duration {
+	wait := baseBackoff
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return wait
+}
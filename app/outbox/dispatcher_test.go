@@ -0,0 +1,25 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 15 * time.Second},
+		{2, 30 * time.Second},
+		{5, 4 * time.Minute},
+		{9, time.Hour},
+		{40, time.Hour},
+	}
+
+	for _, c := range cases {
+		if got := backoff(c.attempts); got != c.expected {
+			t.Errorf("attempt %d: expected %s got %s", c.attempts, c.expected, got)
+		}
+	}
+}
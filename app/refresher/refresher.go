@@ -0,0 +1,135 @@
+package refresher
+
+import (
+	"gitlab.com/pbobby001/postit-api/db"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/publisher"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// Key of the postgres advisory lock that makes sure only one instance refreshes tokens
+	advisoryLockKey = 5380
+	// Tokens are refreshed once they expire within this window, short lived tokens use a quarter of their lifetime
+	defaultWindow = 7 * 24 * time.Hour
+)
+
+/* Start refreshes the tokens of every tenant that are close to expiry once per interval and never returns.
+The window is read from TOKEN_REFRESH_WINDOW and defaults to seven days. */
+func Start(interval time.Duration) {
+	window, err := time.ParseDuration(os.Getenv("TOKEN_REFRESH_WINDOW"))
+	if err != nil {
+		window = defaultWindow
+	}
+
+	logs.Logger.Info("Starting token refresher, checking every ", interval)
+	for {
+		err := tick(time.Now().UTC(), window)
+		if err != nil {
+			_ = logs.Logger.Error(err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func tick(now time.Time, window time.Duration) error {
+	return db.WithAdvisoryLock(advisoryLockKey, func() error {
+		namespaces, err := db.TenantNamespaces(true)
+		if err != nil {
+			return err
+		}
+
+		for _, tenantNamespace := range namespaces {
+			err = refreshTenant(tenantNamespace, now, window)
+			if err != nil {
+				_ = logs.Logger.Errorf("unable to refresh tokens of %s: %v", tenantNamespace, err)
+			}
+		}
+		return nil
+	})
+}
+
+func refreshTenant(tenantNamespace string, now time.Time, window time.Duration) error {
+	accounts, err := pkg.FetchAccounts(tenantNamespace)
+	if err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		if !due(account, now, window) {
+			continue
+		}
+
+		status := refresh(tenantNamespace, account, now)
+		if status == account.TokenStatus {
+			continue
+		}
+
+		err = pkg.SetTokenStatus(tenantNamespace, account.ApplicationUuid.String(), status)
+		if err != nil {
+			_ = logs.Logger.Error(err)
+			continue
+		}
+		logs.Logger.Infof("%s account %s of %s is now %s", account.ApplicationName, account.UserId, tenantNamespace, status)
+	}
+	return nil
+}
+
+// due reports whether the token of account expires soon enough to be refreshed
+func due(account pkg.ApplicationInfo, now time.Time, window time.Duration) bool {
+	if account.TokenExpiresAt == nil || account.TokenStatus == pkg.TokenReauthRequired {
+		return false
+	}
+
+	lifetime, err := strconv.Atoi(account.ExpiresIn)
+	if err == nil && lifetime > 0 && time.Duration(lifetime)*time.Second/4 < window {
+		window = time.Duration(lifetime) * time.Second / 4
+	}
+	return account.TokenExpiresAt.Sub(now) <= window
+}
+
+/* refresh exchanges the token of account and returns the status the account should be left in.
+Facebook can only exchange tokens that are still valid while the other networks use the refresh token,
+an account that cannot be refreshed stays expiring until its token has expired and then requires re-authorization. */
+func refresh(tenantNamespace string, account pkg.ApplicationInfo, now time.Time) string {
+	expired := !account.TokenExpiresAt.After(now)
+	failed := pkg.TokenExpiring
+	if expired {
+		failed = pkg.TokenReauthRequired
+	}
+
+	refreshable := !expired
+	if account.ApplicationName != "facebook" {
+		refreshable = account.RefreshToken != ""
+	}
+	if !refreshable {
+		return failed
+	}
+
+	p, err := publisher.Get(account.ApplicationName)
+	if err != nil {
+		_ = logs.Logger.Error(err)
+		return failed
+	}
+
+	token, err := p.RefreshToken(account)
+	if err != nil {
+		_ = logs.Logger.Errorf("unable to refresh %s account %s: %v", account.ApplicationName, account.UserId, err)
+		return failed
+	}
+
+	// keep the previous lifetime when the network leaves expires_in out of the response
+	if token.ExpiresIn == 0 {
+		token.ExpiresIn, _ = strconv.Atoi(account.ExpiresIn)
+	}
+
+	err = pkg.UpdateAccountToken(tenantNamespace, account.ApplicationUuid.String(), token, now)
+	if err != nil {
+		_ = logs.Logger.Error(err)
+		return failed
+	}
+	return pkg.TokenHealthy
+}
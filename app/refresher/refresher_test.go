@@ -0,0 +1,35 @@
+package refresher
+
+import (
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"testing"
+	"time"
+)
+
+func TestDue(t *testing.T) {
+	now := time.Date(2021, 3, 12, 9, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		expiresAt := now.Add(d)
+		return &expiresAt
+	}
+
+	tests := []struct {
+		name    string
+		account pkg.ApplicationInfo
+		due     bool
+	}{
+		{"no expiry", pkg.ApplicationInfo{ExpiresIn: "0"}, false},
+		{"facebook far from expiry", pkg.ApplicationInfo{ExpiresIn: "5184000", TokenExpiresAt: at(30 * 24 * time.Hour), TokenStatus: pkg.TokenHealthy}, false},
+		{"facebook within window", pkg.ApplicationInfo{ExpiresIn: "5184000", TokenExpiresAt: at(3 * 24 * time.Hour), TokenStatus: pkg.TokenHealthy}, true},
+		{"twitter early in lifetime", pkg.ApplicationInfo{ExpiresIn: "7200", TokenExpiresAt: at(time.Hour), TokenStatus: pkg.TokenHealthy}, false},
+		{"twitter late in lifetime", pkg.ApplicationInfo{ExpiresIn: "7200", TokenExpiresAt: at(20 * time.Minute), TokenStatus: pkg.TokenHealthy}, true},
+		{"expired", pkg.ApplicationInfo{ExpiresIn: "7200", TokenExpiresAt: at(-time.Hour), TokenStatus: pkg.TokenExpiring}, true},
+		{"needs re-authorization", pkg.ApplicationInfo{ExpiresIn: "7200", TokenExpiresAt: at(-time.Hour), TokenStatus: pkg.TokenReauthRequired}, false},
+	}
+
+	for _, test := range tests {
+		if got := due(test.account, now, defaultWindow); got != test.due {
+			t.Errorf("%s: expected due to be %v got %v", test.name, test.due, got)
+		}
+	}
+}
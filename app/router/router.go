@@ -1,29 +1,71 @@
 package router
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/gorilla/mux"
 	"gitlab.com/pbobby001/postit-api/app/controllers"
+	"gitlab.com/pbobby001/postit-api/app/controllers/admin"
+	"gitlab.com/pbobby001/postit-api/app/controllers/apikeys"
 	"gitlab.com/pbobby001/postit-api/app/controllers/emojiList"
+	"gitlab.com/pbobby001/postit-api/app/controllers/media"
 	"gitlab.com/pbobby001/postit-api/app/controllers/mediaupload"
 	"gitlab.com/pbobby001/postit-api/app/controllers/posts"
 	"gitlab.com/pbobby001/postit-api/app/controllers/social"
+	"gitlab.com/pbobby001/postit-api/app/controllers/websockets"
+	"gitlab.com/pbobby001/postit-api/app/middlewares"
+	"gitlab.com/pbobby001/postit-api/pkg/auth"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
 	"net/http"
+	"text/tabwriter"
+	"time"
 )
 
-//Route Create a single route object
+// Policy tells how the callers of a route are authenticated
+type Policy string
+
+const (
+	// AuthUser requires a bearer token meant for the tenant of the request or an API key of the tenant, routes declaring only a role or scope use it
+	AuthUser Policy = "user"
+	// AuthPublic lets every caller through
+	AuthPublic Policy = "public"
+	// AuthAdmin requires the ADMIN_API_KEY of the tenant administration
+	AuthAdmin Policy = "admin"
+)
+
+/* Route Create a single route object.
+Auth is the policy authenticating its callers, the middlewares of the route are chosen from it.
+Role is the least role a caller needs in the tenant and Scope the scope letting API keys of the tenant in without it.
+A route declaring none of them is refused by register, user routes open to every authenticated caller declare AuthUser.
+Timeout replaces the read and write deadlines of the server for routes taking longer, like uploads. */
 type Route struct {
 	Name    string
 	Path    string
 	Method  string
 	Handler http.HandlerFunc
+	Auth    Policy
+	Role    auth.Role
+	Scope   string
+	Timeout time.Duration
 }
 
 //Routes Create an object of different routes
 type Routes []Route
 
-// InitRoutes Set up routes
-func InitRoutes() *mux.Router {
-	router := mux.NewRouter()
+// InitRoutes Set up routes, uploads and media content are kept in store
+func InitRoutes(store storage.MediaStore) *mux.Router {
+	postRepository := repository.PostgresPostRepository{}
+	scheduleRepository := repository.PostgresScheduleRepository{}
+	accountRepository := repository.PostgresAccountRepository{}
+	mediaRepository := repository.PostgresMediaRepository{}
+	postHandler := posts.NewHandler(postRepository, scheduleRepository, accountRepository, mediaRepository, store)
+	mediaHandler := media.NewHandler(mediaRepository, store)
+	uploadHandler := mediaupload.NewHandler(mediaRepository, repository.PostgresUploadJobRepository{}, store)
+	socialHandler := social.NewHandler(accountRepository, postRepository)
+	apiKeyRepository := repository.PostgresApiKeyRepository{}
+	apiKeyHandler := apikeys.NewHandler(apiKeyRepository)
 
 	routes := Routes{
 		// health check
@@ -32,80 +74,102 @@ func InitRoutes() *mux.Router {
 			Path:    "/",
 			Method:  http.MethodGet,
 			Handler: controllers.HealthCheckHandler,
+			Auth:    AuthPublic,
 		},
 		Route{
 			Name:    "Email Notification Service",
 			Path:    "/send-email",
 			Method:  http.MethodPost,
 			Handler: controllers.EmailNotificationService,
+			Auth:    AuthPublic,
 		},
 		Route{
 			Name:    "Delete Uploaded Files",
 			Path:    "/delete/all",
 			Method:  http.MethodDelete,
-			Handler: mediaupload.DeleteUploadedFiles,
+			Handler: uploadHandler.DeleteUploadedFiles,
+			Role:    auth.RoleOwner,
 		},
 		// posts
 		Route{
 			Name:    "Create Post",
 			Path:    "/posts",
 			Method:  http.MethodPost,
-			Handler: posts.HandleCreatePost,
+			Handler: postHandler.HandleCreatePost,
+			Role:    auth.RoleEditor,
+			Scope:   auth.ScopePostsWrite,
 		},
 		Route{
 			Name:    "Fetch Posts",
 			Path:    "/posts",
 			Method:  http.MethodGet,
-			Handler: posts.HandleFetchPosts,
+			Handler: postHandler.HandleFetchPosts,
+			Role:    auth.RoleViewer,
+			Scope:   auth.ScopePostsRead,
 		},
 		Route{
 			Name:    "Delete Post",
 			Path:    "/posts",
 			Method:  http.MethodDelete,
-			Handler: posts.HandleDeletePost,
+			Handler: postHandler.HandleDeletePost,
+			Role:    auth.RoleEditor,
+			Scope:   auth.ScopePostsWrite,
 		},
 		Route{
 			Name:    "Update Post",
 			Path:    "/posts",
 			Method:  http.MethodPut,
-			Handler: posts.HandleUpdatePost,
+			Handler: postHandler.HandleUpdatePost,
+			Role:    auth.RoleEditor,
+			Scope:   auth.ScopePostsWrite,
 		},
 		Route{
 			Name:    "Batch delete",
 			Path:    "/batch-delete",
 			Method:  http.MethodPost,
-			Handler: posts.HandleBatchDelete,
+			Handler: postHandler.HandleBatchDelete,
+			Role:    auth.RoleOwner,
 		},
 		Route{
 			Name:    "Batch Post",
 			Path:    "/batch-post",
 			Method:  http.MethodPost,
-			Handler: posts.HandleBatchPost,
+			Handler: postHandler.HandleBatchPost,
+			Role:    auth.RoleEditor,
+			Scope:   auth.ScopePostsWrite,
 		},
 		// schedule
 		Route{
 			Name:    "Create Post Schedule",
 			Path:    "/schedule-post",
 			Method:  http.MethodPost,
-			Handler: posts.HandleCreatePostSchedule,
+			Handler: postHandler.HandleCreatePostSchedule,
+			Role:    auth.RoleEditor,
+			Scope:   auth.ScopeSchedulesWrite,
 		},
 		Route{
 			Name:    "Fetch Post Schedule",
 			Path:    "/schedule-post",
 			Method:  http.MethodGet,
-			Handler: posts.HandleFetchPostSchedule,
+			Handler: postHandler.HandleFetchPostSchedule,
+			Role:    auth.RoleViewer,
+			Scope:   auth.ScopeSchedulesRead,
 		},
 		Route{
 			Name:    "Update Post Schedule",
 			Path:    "/schedule-post",
 			Method:  http.MethodPut,
-			Handler: posts.HandleUpdatePostSchedule,
+			Handler: postHandler.HandleUpdatePostSchedule,
+			Role:    auth.RoleEditor,
+			Scope:   auth.ScopeSchedulesWrite,
 		},
 		Route{
 			Name:    "Delete Post Schedule",
 			Path:    "/schedule-post",
 			Method:  http.MethodDelete,
-			Handler: posts.HandleDeletePostSchedule,
+			Handler: postHandler.HandleDeletePostSchedule,
+			Role:    auth.RoleEditor,
+			Scope:   auth.ScopeSchedulesWrite,
 		},
 		// emojiList
 		Route{
@@ -113,68 +177,272 @@ func InitRoutes() *mux.Router {
 			Path:    "/emoji",
 			Method:  http.MethodGet,
 			Handler: emojiList.HandleGetEmoji,
+			Role:    auth.RoleViewer,
 		},
 		//Get Facebook Code
 		Route{
 			Name:    "Get Facebook Code",
 			Path:    "/fb/code",
 			Method:  http.MethodPost,
-			Handler: social.HandleFacebookCode,
+			Handler: socialHandler.HandleFacebookCode,
+			Role:    auth.RoleOwner,
 		},
 		Route{
 			Name:    "Delete Facebook Code",
 			Path:    "/fb/code",
 			Method:  http.MethodDelete,
-			Handler: social.HandleDeleteFacebookCode,
+			Handler: socialHandler.HandleDeleteFacebookCode,
+			Role:    auth.RoleOwner,
+		},
+		Route{
+			Name:    "Get Twitter Code",
+			Path:    "/tw/code",
+			Method:  http.MethodPost,
+			Handler: socialHandler.HandleTwitterCode,
+			Role:    auth.RoleOwner,
+		},
+		Route{
+			Name:    "Delete Twitter Code",
+			Path:    "/tw/code",
+			Method:  http.MethodDelete,
+			Handler: socialHandler.HandleDeleteTwitterCode,
+			Role:    auth.RoleOwner,
+		},
+		Route{
+			Name:    "Get LinkedIn Code",
+			Path:    "/li/code",
+			Method:  http.MethodPost,
+			Handler: socialHandler.HandleLinkedInCode,
+			Role:    auth.RoleOwner,
+		},
+		Route{
+			Name:    "Delete LinkedIn Code",
+			Path:    "/li/code",
+			Method:  http.MethodDelete,
+			Handler: socialHandler.HandleDeleteLinkedInCode,
+			Role:    auth.RoleOwner,
 		},
 		Route{
 			Name:    "Fetch Facebook Code",
 			Path:    "/all/code",
 			Method:  http.MethodGet,
-			Handler: social.AllAccounts,
+			Handler: socialHandler.AllAccounts,
+			Role:    auth.RoleViewer,
+			Scope:   auth.ScopeAccountsRead,
 		},
 		Route{
 			Name:    "Fetch facebook Posts",
 			Path:    "/fb/posts",
 			Method:  http.MethodGet,
-			Handler: social.FetchFacebookPosts,
+			Handler: socialHandler.FetchFacebookPosts,
+			Role:    auth.RoleViewer,
+			Scope:   auth.ScopeAccountsRead,
 		},
 		Route{
 			Name:    "Count Schedule",
 			Path:    "/count/data",
 			Method:  http.MethodGet,
-			Handler: posts.CountSchedule,
+			Handler: postHandler.CountSchedule,
+			Role:    auth.RoleViewer,
+			Scope:   auth.ScopeSchedulesRead,
 		},
 
 		Route{
 			Name:    "Media Upload",
 			Path:    "/file/upload",
 			Method:  http.MethodPost,
-			Handler: mediaupload.HandleMediaUpload,
+			Handler: uploadHandler.HandleMediaUpload,
+			Role:    auth.RoleEditor,
+			Scope:   auth.ScopeMediaWrite,
+			Timeout: uploadHandler.Timeout,
 		},
 
 		Route{
 			Name:    "Reverse Upload",
 			Path:    "/file/upload",
 			Method:  http.MethodDelete,
-			Handler: mediaupload.HandleCancelMediaUpload,
+			Handler: uploadHandler.HandleCancelMediaUpload,
+			Role:    auth.RoleEditor,
+			Scope:   auth.ScopeMediaWrite,
+		},
+
+		Route{
+			Name:    "Upload Job",
+			Path:    "/file/upload/jobs/{id}",
+			Method:  http.MethodGet,
+			Handler: uploadHandler.HandleUploadJob,
+			Role:    auth.RoleEditor,
+			Scope:   auth.ScopeMediaWrite,
+		},
+
+		Route{
+			Name:    "Get Media",
+			Path:    "/media/{id}",
+			Method:  http.MethodGet,
+			Handler: mediaHandler.HandleGetMedia,
+			Role:    auth.RoleViewer,
+			Scope:   auth.ScopeMediaRead,
+		},
+		Route{
+			Name:    "List Media",
+			Path:    "/media",
+			Method:  http.MethodGet,
+			Handler: mediaHandler.HandleListMedia,
+			Role:    auth.RoleViewer,
+			Scope:   auth.ScopeMediaRead,
+		},
+		Route{
+			Name:    "Update Media",
+			Path:    "/media/{id}",
+			Method:  http.MethodPatch,
+			Handler: mediaHandler.HandleUpdateMedia,
+			Role:    auth.RoleEditor,
+			Scope:   auth.ScopeMediaWrite,
+		},
+		Route{
+			Name:    "Delete Media",
+			Path:    "/media/{id}",
+			Method:  http.MethodDelete,
+			Handler: mediaHandler.HandleDeleteMedia,
+			Role:    auth.RoleEditor,
+			Scope:   auth.ScopeMediaWrite,
+		},
+
+		// API keys, owners only, keys themselves hold no role and cannot manage keys
+		Route{
+			Name:    "Create API Key",
+			Path:    "/api-keys",
+			Method:  http.MethodPost,
+			Handler: apiKeyHandler.HandleCreateApiKey,
+			Role:    auth.RoleOwner,
+		},
+		Route{
+			Name:    "List API Keys",
+			Path:    "/api-keys",
+			Method:  http.MethodGet,
+			Handler: apiKeyHandler.HandleListApiKeys,
+			Role:    auth.RoleOwner,
+		},
+		Route{
+			Name:    "Revoke API Key",
+			Path:    "/api-keys/{id}",
+			Method:  http.MethodDelete,
+			Handler: apiKeyHandler.HandleRevokeApiKey,
+			Role:    auth.RoleOwner,
 		},
 
-		//	Test
+		// websockets
 		Route{
-			Name:    "Test Endpoint",
-			Path:    "/tests",
+			Name:    "Schedule Status",
+			Path:    "/pws/schedule-status",
 			Method:  http.MethodGet,
-			Handler: controllers.EndPointForTests,
+			Handler: websockets.HandleScheduleStatus,
+			// the handshake frame carries the token and the tenant, see pkg.WebSocketTokenValidateToken
+			Auth:    AuthPublic,
+		},
+
+		// tenant administration
+		Route{
+			Name:    "Create Tenant",
+			Path:    "/admin/tenants",
+			Method:  http.MethodPost,
+			Handler: admin.HandleCreateTenant,
+			Auth:    AuthAdmin,
+		},
+		Route{
+			Name:    "Fetch Tenants",
+			Path:    "/admin/tenants",
+			Method:  http.MethodGet,
+			Handler: admin.HandleFetchTenants,
+			Auth:    AuthAdmin,
+		},
+		Route{
+			Name:    "Suspend Tenant",
+			Path:    "/admin/tenants/{namespace}/suspend",
+			Method:  http.MethodPut,
+			Handler: admin.HandleSuspendTenant,
+			Auth:    AuthAdmin,
+		},
+		Route{
+			Name:    "Resume Tenant",
+			Path:    "/admin/tenants/{namespace}/resume",
+			Method:  http.MethodPut,
+			Handler: admin.HandleResumeTenant,
+			Auth:    AuthAdmin,
+		},
+		Route{
+			Name:    "Drop Tenant",
+			Path:    "/admin/tenants/{namespace}",
+			Method:  http.MethodDelete,
+			Handler: admin.HandleDropTenant,
+			Auth:    AuthAdmin,
 		},
 	}
 
+	router := mux.NewRouter()
+	register(router, routes, apiKeyRepository)
+	logs.Logger.Info("routes:\n" + routeTable(routes))
+	return router
+}
+
+/* register adds the routes to router, each behind the middlewares of its policy, user routes accept the API keys in keys besides tokens.
+It panics on a route declaring no policy, role or scope so a forgotten policy never ends up open or closed by accident. */
+func register(router *mux.Router, routes Routes, keys repository.ApiKeyRepository) {
 	for _, route := range routes {
+		if route.Auth == "" && route.Role == "" && route.Scope == "" {
+			panic(fmt.Sprintf("route %s declares no policy, role or scope", route.Name))
+		}
 		router.Name(route.Name).
 			Methods(route.Method).
 			Path(route.Path).
-			Handler(route.Handler)
+			Handler(route.handler(keys))
 	}
+}
 
-	return router
+/* handler wraps the handler of the route in the middlewares of its policy.
+User routes verify the token or API key first, then resolve the tenant and check the role or scope of the route. */
+func (route Route) handler(keys repository.ApiKeyRepository) http.Handler {
+	handler := route.policyHandler(keys)
+	if route.Timeout > 0 {
+		handler = middlewares.ExtendDeadline(route.Timeout)(handler)
+	}
+	return handler
+}
+
+func (route Route) policyHandler(keys repository.ApiKeyRepository) http.Handler {
+	var handler http.Handler = route.Handler
+	switch route.Auth {
+	case AuthPublic:
+		return handler
+	case AuthAdmin:
+		return middlewares.AdminMiddleware(handler)
+	}
+
+	if route.Role != "" || route.Scope != "" {
+		handler = middlewares.RequireAccess(route.Role, route.Scope)(handler)
+	}
+	handler = middlewares.TenantMiddleware(handler)
+	return middlewares.Authenticate(keys)(handler)
+}
+
+// routeTable lists the routes along with what their callers need, it is logged at startup
+func routeTable(routes Routes) string {
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "METHOD\tPATH\tAUTH\tROLE\tSCOPE\tNAME")
+	for _, route := range routes {
+		policy, role, scope := route.Auth, string(route.Role), route.Scope
+		if policy == "" {
+			policy = AuthUser
+		}
+		if role == "" {
+			role = "-"
+		}
+		if scope == "" {
+			scope = "-"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", route.Method, route.Path, policy, role, scope, route.Name)
+	}
+	_ = w.Flush()
+	return table.String()
 }
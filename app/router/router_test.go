@@ -0,0 +1,86 @@
+package router
+
+import (
+	"github.com/gorilla/mux"
+	"gitlab.com/pbobby001/postit-api/pkg/auth"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRegisterChainsPerRoute(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	routes := Routes{
+		Route{Name: "Health Check", Path: "/", Method: http.MethodGet, Handler: ok, Auth: AuthPublic},
+		Route{Name: "Fetch Posts", Path: "/posts", Method: http.MethodGet, Handler: ok, Role: auth.RoleViewer, Scope: auth.ScopePostsRead},
+		Route{Name: "Fetch Tenants", Path: "/admin/tenants", Method: http.MethodGet, Handler: ok, Auth: AuthAdmin},
+	}
+	router := mux.NewRouter()
+	register(router, routes, repository.NewMemoryApiKeyRepository())
+
+	previous := os.Getenv("ADMIN_API_KEY")
+	_ = os.Setenv("ADMIN_API_KEY", "secret")
+	defer os.Setenv("ADMIN_API_KEY", previous)
+
+	tests := []struct {
+		path     string
+		adminKey string
+		apiKey   string
+		status   int
+	}{
+		{"/", "", "", http.StatusNoContent},
+		{"/posts", "", "", http.StatusUnauthorized},
+		// the admin key is no bearer token
+		{"/posts", "secret", "", http.StatusUnauthorized},
+		{"/posts", "", "pk_unknown", http.StatusUnauthorized},
+		{"/admin/tenants", "", "", http.StatusUnauthorized},
+		{"/admin/tenants", "", "secret", http.StatusUnauthorized},
+		{"/admin/tenants", "secret", "", http.StatusNoContent},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		req.Header.Set("tenant-namespace", "postit")
+		if test.adminKey != "" {
+			req.Header.Set("X-Admin-Key", test.adminKey)
+		}
+		if test.apiKey != "" {
+			req.Header.Set("X-Api-Key", test.apiKey)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s with key %q: expected %d got %d", test.path, test.adminKey, test.status, rec.Code)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(routeTable(routes)), "\n")
+	expected := []string{
+		"METHOD PATH AUTH ROLE SCOPE NAME",
+		"GET / public - - Health Check",
+		"GET /posts user viewer posts:read Fetch Posts",
+		"GET /admin/tenants admin - - Fetch Tenants",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected a row per route got %q", lines)
+	}
+	for i, line := range lines {
+		if strings.Join(strings.Fields(line), " ") != expected[i] {
+			t.Errorf("expected %q got %q", expected[i], line)
+		}
+	}
+}
+
+func TestRegisterRefusesUndeclaredRoutes(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a route without policy, role or scope to be refused")
+		}
+	}()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	register(mux.NewRouter(), Routes{Route{Name: "Test Endpoint", Path: "/tests", Method: http.MethodGet, Handler: ok}}, repository.NewMemoryApiKeyRepository())
+}
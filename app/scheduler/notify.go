@@ -0,0 +1,36 @@
+package scheduler
+
+import (
+	"bytes"
+	"fmt"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// Notify hands a schedule stored in the outbox over to the scheduler micro service at SCHEDULER_URL
+func Notify(message pkg.OutboxMessage) error {
+	req, err := http.NewRequest(http.MethodPost, os.Getenv("SCHEDULER_URL")+"/schedule", bytes.NewReader(message.Payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("tenant-namespace", message.TenantNamespace)
+	req.Header.Add("trace-id", message.TraceId)
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("scheduler responded with %s: %s", resp.Status, body)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package scheduler
+
+import (
+	"fmt"
+	"gitlab.com/pbobby001/postit-api/db"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/publisher"
+	"os"
+	"time"
+)
+
+const (
+	// ModeExternal hands schedules over to the scheduler micro service at SCHEDULER_URL
+	ModeExternal = "external"
+	// Key of the postgres advisory lock that makes sure only one instance executes schedules
+	advisoryLockKey = 5379
+	// MaxAttempts is how often a post is sent to a profile before it is given up
+	MaxAttempts = 5
+	// Failed deliveries are retried after retryDelay, doubled on every attempt
+	retryDelay = time.Minute
+)
+
+// Networks in the order posts are sent to them
+var networks = []string{"facebook", "twitter", "linked_in"}
+
+// Publisher sends a post of a tenant to a profile of a network
+type Publisher interface {
+	Publish(tenantNamespace string, network string, profile string, postId string) error
+}
+
+// NetworkPublisher loads the post and the account and publishes through pkg/publisher
+type NetworkPublisher struct{}
+
+func (NetworkPublisher) Publish(tenantNamespace string, network string, profile string, postId string) error {
+	post, err := pkg.FetchPost(tenantNamespace, postId)
+	if err != nil {
+		return fmt.Errorf("unable to load post %s: %v", postId, err)
+	}
+	account, err := pkg.FetchAccount(tenantNamespace, network, profile)
+	if err != nil {
+		return fmt.Errorf("unable to load %s profile %s: %v", network, profile, err)
+	}
+	return publisher.PublishPost(tenantNamespace, account, post)
+}
+
+/* Executor sends the due posts of schedules through Publisher and records every delivery in Store.
+A post goes out to each profile at most once: a delivery interrupted by a restart is given up rather than repeated,
+failed deliveries are retried until MaxAttempts. */
+type Executor struct {
+	Store     Store
+	Publisher Publisher
+	// Notify is called when deliveries of a schedule were recorded or it completed, nil to skip it
+	Notify func(tenantNamespace string, scheduleId string, completed bool)
+}
+
+// External reports whether schedules are executed by the external scheduler micro service
+func External() bool {
+	return os.Getenv("SCHEDULER_MODE") == ModeExternal
+}
+
+/* Start executes the due schedules of every tenant once per interval and never returns.
+All progress is kept in the database so a restarted instance resumes where the last one stopped. */
+func Start(interval time.Duration) {
+	logs.Logger.Info("Starting schedule executor, checking every ", interval)
+	executor := &Executor{Store: PostgresStore{}, Publisher: NetworkPublisher{}, Notify: notifyClients}
+	for {
+		err := executor.tick(time.Now().UTC())
+		if err != nil {
+			_ = logs.Logger.Error(err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (e *Executor) tick(now time.Time) error {
+	return db.WithAdvisoryLock(advisoryLockKey, func() error {
+		namespaces, err := db.TenantNamespaces(true)
+		if err != nil {
+			return err
+		}
+
+		for _, tenantNamespace := range namespaces {
+			err = e.ExecuteTenant(tenantNamespace, now)
+			if err != nil {
+				_ = logs.Logger.Errorf("unable to execute schedules of %s: %v", tenantNamespace, err)
+			}
+		}
+		return nil
+	})
+}
+
+// ExecuteTenant sends the posts of the schedules of a tenant that are due by now
+func (e *Executor) ExecuteTenant(tenantNamespace string, now time.Time) error {
+	schedules, err := e.Store.DueSchedules(tenantNamespace, now)
+	if err != nil {
+		return err
+	}
+
+	for _, schedule := range schedules {
+		err = e.executeSchedule(tenantNamespace, schedule, now)
+		if err != nil {
+			_ = logs.Logger.Errorf("unable to execute schedule %s: %v", schedule.ScheduleId, err)
+		}
+	}
+	return nil
+}
+
+/* executeSchedule sends the posts that are due, posts are spaced by duration_per_post starting from schedule_from.
+The schedule completes once every post is due and each of its deliveries was published or given up. */
+func (e *Executor) executeSchedule(tenantNamespace string, schedule Schedule, now time.Time) error {
+	if !schedule.Started {
+		err := e.Store.Start(tenantNamespace, schedule)
+		if err != nil {
+			return err
+		}
+		logs.Logger.Infof("Schedule %s of %s is due", schedule.ScheduleId, tenantNamespace)
+	}
+
+	deliveries, err := e.Store.Deliveries(schedule.ScheduleId)
+	if err != nil {
+		return err
+	}
+
+	completed := true
+	changed, finished := false, false
+	defer func() {
+		if changed && e.Notify != nil {
+			e.Notify(tenantNamespace, schedule.ScheduleId, finished)
+		}
+	}()
+
+	for i, postId := range schedule.PostIds {
+		dueAt := schedule.From.Add(time.Duration(float64(i) * schedule.Duration * float64(time.Second)))
+		if dueAt.After(now) {
+			completed = false
+			break
+		}
+
+		for _, network := range networks {
+			profiles := schedule.Profiles[network]
+			if len(profiles) == 0 || schedule.Posted[network][postId] {
+				continue
+			}
+
+			published := 0
+			for _, profile := range profiles {
+				target := Target{PostId: postId, Network: network, Profile: profile}
+				delivery, sent, err := e.deliver(tenantNamespace, schedule.ScheduleId, deliveries[target], target, now)
+				if err != nil {
+					return err
+				}
+				deliveries[target] = delivery
+				changed = changed || sent
+				switch delivery.Status {
+				case DeliveryPublished:
+					published++
+				case DeliveryRetry:
+					completed = false
+				}
+			}
+
+			if published == len(profiles) {
+				err = e.Store.MarkPosted(schedule.ScheduleId, network, postId)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if !completed {
+		return nil
+	}
+
+	err = e.Store.Complete(schedule.ScheduleId)
+	if err != nil {
+		return err
+	}
+	changed, finished = true, true
+	logs.Logger.Infof("Schedule %s of %s completed", schedule.ScheduleId, tenantNamespace)
+	return nil
+}
+
+/* deliver sends target unless its delivery is settled or waiting for its retry, sent tells whether anything was recorded.
+The attempt is claimed before the network is called, a delivery found claimed was interrupted
+and may have gone out already, it is given up so the post is never sent twice. */
+func (e *Executor) deliver(tenantNamespace string, scheduleId string, delivery Delivery, target Target, now time.Time) (Delivery, bool, error) {
+	switch delivery.Status {
+	case DeliveryPublished, DeliveryFailed:
+		return delivery, false, nil
+	case DeliverySending:
+		delivery.Status = DeliveryFailed
+		delivery.Error = "interrupted while sending, not retried in case it went out"
+		delivery.RetryAt = time.Time{}
+		_ = logs.Logger.Warnf("post %s to %s profile %s of schedule %s was interrupted, giving it up", target.PostId, target.Network, target.Profile, scheduleId)
+		return delivery, true, e.Store.Finish(scheduleId, delivery)
+	case DeliveryRetry:
+		if now.Before(delivery.RetryAt) {
+			return delivery, false, nil
+		}
+	}
+
+	delivery, err := e.Store.Claim(tenantNamespace, scheduleId, target)
+	if err != nil {
+		return delivery, false, err
+	}
+
+	err = e.Publisher.Publish(tenantNamespace, target.Network, target.Profile, target.PostId)
+	if err == nil {
+		delivery.Status, delivery.Error = DeliveryPublished, ""
+	} else {
+		_ = logs.Logger.Errorf("unable to publish post %s to %s profile %s (attempt %d): %v", target.PostId, target.Network, target.Profile, delivery.Attempts, err)
+		delivery.Status, delivery.Error = DeliveryRetry, err.Error()
+		delivery.RetryAt = now.Add(retryBackoff(delivery.Attempts))
+		if delivery.Attempts >= MaxAttempts {
+			delivery.Status, delivery.RetryAt = DeliveryFailed, time.Time{}
+		}
+	}
+	return delivery, true, e.Store.Finish(scheduleId, delivery)
+}
+
+// retryBackoff returns how long to wait before the attempt following the given number of failed attempts
+func retryBackoff(attempts int) time.Duration {
+	return retryDelay << uint(attempts-1)
+}
+
+// notifyClients pushes the new status of the schedule to the websocket clients of the tenant, completed schedules are forgotten after their last frame
+func notifyClients(tenantNamespace string, scheduleId string, completed bool) {
+	status, err := pkg.FetchScheduleStatus(tenantNamespace, scheduleId)
+	if err != nil {
+		_ = logs.Logger.Error(err)
+		return
+	}
+
+	err = pkg.ScheduleStatusHub.NotifyScheduleStatus(tenantNamespace, status)
+	if err != nil {
+		_ = logs.Logger.Error(err)
+	}
+	if completed {
+		pkg.ScheduleStatusHub.Forget(tenantNamespace, scheduleId)
+	}
+}
@@ -0,0 +1,244 @@
+package scheduler
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryStore keeps a single tenant's schedules and deliveries like PostgresStore
+type memoryStore struct {
+	schedules  []Schedule
+	started    map[string]bool
+	completed  map[string]bool
+	posted     map[string]map[string]map[string]bool
+	deliveries map[string]map[Target]Delivery
+}
+
+func newMemoryStore(schedules ...Schedule) *memoryStore {
+	return &memoryStore{
+		schedules:  schedules,
+		started:    make(map[string]bool),
+		completed:  make(map[string]bool),
+		posted:     make(map[string]map[string]map[string]bool),
+		deliveries: make(map[string]map[Target]Delivery),
+	}
+}
+
+func (m *memoryStore) DueSchedules(tenantNamespace string, now time.Time) ([]Schedule, error) {
+	var due []Schedule
+	for _, schedule := range m.schedules {
+		if schedule.From.After(now) || m.completed[schedule.ScheduleId] {
+			continue
+		}
+		schedule.Started = m.started[schedule.ScheduleId]
+		schedule.Posted = map[string]map[string]bool{}
+		for network, posts := range m.posted[schedule.ScheduleId] {
+			schedule.Posted[network] = map[string]bool{}
+			for postId := range posts {
+				schedule.Posted[network][postId] = true
+			}
+		}
+		due = append(due, schedule)
+	}
+	return due, nil
+}
+
+func (m *memoryStore) Start(tenantNamespace string, schedule Schedule) error {
+	m.started[schedule.ScheduleId] = true
+	m.deliveries[schedule.ScheduleId] = make(map[Target]Delivery)
+	m.posted[schedule.ScheduleId] = make(map[string]map[string]bool)
+	return nil
+}
+
+func (m *memoryStore) Deliveries(scheduleId string) (map[Target]Delivery, error) {
+	deliveries := make(map[Target]Delivery)
+	for target, delivery := range m.deliveries[scheduleId] {
+		deliveries[target] = delivery
+	}
+	return deliveries, nil
+}
+
+func (m *memoryStore) Claim(tenantNamespace string, scheduleId string, target Target) (Delivery, error) {
+	delivery := m.deliveries[scheduleId][target]
+	delivery.Target, delivery.Status = target, DeliverySending
+	delivery.Attempts++
+	m.deliveries[scheduleId][target] = delivery
+	return delivery, nil
+}
+
+func (m *memoryStore) Finish(scheduleId string, delivery Delivery) error {
+	m.deliveries[scheduleId][delivery.Target] = delivery
+	return nil
+}
+
+func (m *memoryStore) MarkPosted(scheduleId string, network string, postId string) error {
+	if m.posted[scheduleId][network] == nil {
+		m.posted[scheduleId][network] = make(map[string]bool)
+	}
+	m.posted[scheduleId][network][postId] = true
+	return nil
+}
+
+func (m *memoryStore) Complete(scheduleId string) error {
+	m.completed[scheduleId] = true
+	return nil
+}
+
+// fakePublisher records what it sent, fail decides whether a send fails
+type fakePublisher struct {
+	sent []string
+	fail func(network string, profile string, postId string) bool
+}
+
+func (f *fakePublisher) Publish(tenantNamespace string, network string, profile string, postId string) error {
+	if f.fail != nil && f.fail(network, profile, postId) {
+		return errors.New("network unavailable")
+	}
+	f.sent = append(f.sent, postId+"@"+network+"/"+profile)
+	return nil
+}
+
+func (f *fakePublisher) take() string {
+	sent := f.sent
+	f.sent = nil
+	sort.Strings(sent)
+	return strings.Join(sent, " ")
+}
+
+var from = time.Date(2021, 4, 9, 10, 0, 0, 0, time.UTC)
+
+func newSchedule(postIds ...string) Schedule {
+	return Schedule{
+		ScheduleId: "schedule",
+		From:       from,
+		PostIds:    postIds,
+		Duration:   600,
+		Profiles:   map[string][]string{"facebook": {"page"}, "twitter": {"bakery"}},
+	}
+}
+
+func TestExecuteSpacesPosts(t *testing.T) {
+	store := newMemoryStore(newSchedule("p1", "p2", "p3"))
+	fake := &fakePublisher{}
+	notified := 0
+	e := &Executor{Store: store, Publisher: fake, Notify: func(string, string, bool) { notified++ }}
+
+	steps := []struct {
+		at   time.Duration
+		sent string
+	}{
+		{-time.Minute, ""},
+		{0, "p1@facebook/page p1@twitter/bakery"},
+		{5 * time.Minute, ""},
+		{15 * time.Minute, "p2@facebook/page p2@twitter/bakery"},
+		{20 * time.Minute, "p3@facebook/page p3@twitter/bakery"},
+		{time.Hour, ""},
+	}
+	for _, step := range steps {
+		if err := e.ExecuteTenant("postit", from.Add(step.at)); err != nil {
+			t.Fatal(err)
+		}
+		if sent := fake.take(); sent != step.sent {
+			t.Errorf("at %s: expected %q got %q", step.at, step.sent, sent)
+		}
+	}
+	if !store.completed["schedule"] || !store.posted["schedule"]["twitter"]["p3"] || notified != 3 {
+		t.Fatalf("expected the schedule to complete with every post recorded got %v %v, %d notifications", store.completed, store.posted, notified)
+	}
+}
+
+func TestExecuteResumesWithoutSendingTwice(t *testing.T) {
+	store := newMemoryStore(newSchedule("p1", "p2"))
+	fake := &fakePublisher{}
+	if err := (&Executor{Store: store, Publisher: fake}).ExecuteTenant("postit", from); err != nil {
+		t.Fatal(err)
+	}
+	fake.take()
+
+	// the process stopped while p2 was being sent to facebook
+	target := Target{PostId: "p2", Network: "facebook", Profile: "page"}
+	if _, err := store.Claim("postit", "schedule", target); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := &Executor{Store: store, Publisher: fake}
+	if err := restarted.ExecuteTenant("postit", from.Add(10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if sent := fake.take(); sent != "p2@twitter/bakery" {
+		t.Fatalf("expected only the deliveries not attempted yet got %q", sent)
+	}
+	if delivery := store.deliveries["schedule"][target]; delivery.Status != DeliveryFailed || delivery.Attempts != 1 {
+		t.Fatalf("expected the interrupted delivery to be given up got %+v", delivery)
+	}
+	if !store.completed["schedule"] || store.posted["schedule"]["facebook"]["p2"] || !store.posted["schedule"]["twitter"]["p2"] {
+		t.Fatalf("expected only real successes to be recorded got %v", store.posted)
+	}
+}
+
+func TestExecuteRetriesFailures(t *testing.T) {
+	store := newMemoryStore(newSchedule("p1"))
+	down := true
+	fake := &fakePublisher{fail: func(network string, profile string, postId string) bool {
+		return network == "twitter" && down
+	}}
+	e := &Executor{Store: store, Publisher: fake}
+
+	now := from
+	if err := e.ExecuteTenant("postit", now); err != nil {
+		t.Fatal(err)
+	}
+	target := Target{PostId: "p1", Network: "twitter", Profile: "bakery"}
+	delivery := store.deliveries["schedule"][target]
+	if fake.take() != "p1@facebook/page" || delivery.Status != DeliveryRetry || store.completed["schedule"] || store.posted["schedule"]["twitter"]["p1"] {
+		t.Fatalf("expected twitter to be retried got %+v", delivery)
+	}
+
+	// nothing is sent before the retry is due
+	if err := e.ExecuteTenant("postit", now.Add(30*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if delivery = store.deliveries["schedule"][target]; delivery.Attempts != 1 {
+		t.Fatalf("expected no attempt before %s got %+v", delivery.RetryAt, delivery)
+	}
+
+	down = false
+	if err := e.ExecuteTenant("postit", delivery.RetryAt); err != nil {
+		t.Fatal(err)
+	}
+	if sent := fake.take(); sent != "p1@twitter/bakery" || !store.completed["schedule"] || !store.posted["schedule"]["twitter"]["p1"] {
+		t.Fatalf("expected the retry to go out got %q", sent)
+	}
+}
+
+func TestExecuteGivesUpAfterMaxAttempts(t *testing.T) {
+	store := newMemoryStore(newSchedule("p1"))
+	fake := &fakePublisher{fail: func(network string, profile string, postId string) bool {
+		return network == "twitter"
+	}}
+	e := &Executor{Store: store, Publisher: fake}
+
+	target := Target{PostId: "p1", Network: "twitter", Profile: "bakery"}
+	now := from
+	for i := 0; i < MaxAttempts+3; i++ {
+		if err := e.ExecuteTenant("postit", now); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(24 * time.Hour)
+	}
+	if delivery := store.deliveries["schedule"][target]; delivery.Status != DeliveryFailed || delivery.Attempts != MaxAttempts || delivery.Error == "" {
+		t.Fatalf("expected the delivery to be given up after %d attempts got %+v", MaxAttempts, delivery)
+	}
+	if !store.completed["schedule"] || store.posted["schedule"]["twitter"]["p1"] || !store.posted["schedule"]["facebook"]["p1"] {
+		t.Fatalf("expected the schedule to complete without recording the failure got %v", store.posted)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	if retryBackoff(1) != time.Minute || retryBackoff(4) != 8*time.Minute {
+		t.Fatalf("expected the delay to double got %s %s", retryBackoff(1), retryBackoff(4))
+	}
+}
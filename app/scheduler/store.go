@@ -0,0 +1,201 @@
+package scheduler
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"gitlab.com/pbobby001/postit-api/db"
+	"time"
+)
+
+// Delivery states
+const (
+	// DeliverySending is recorded before the network is called, a delivery left in it was interrupted
+	DeliverySending   = "sending"
+	DeliveryPublished = "published"
+	// DeliveryRetry failed and is tried again from RetryAt on
+	DeliveryRetry  = "retry"
+	DeliveryFailed = "failed"
+)
+
+// Columns of general_schedule_table recording the posts that went out to every profile of a network
+var postedColumns = map[string]string{
+	"facebook":  "posted_fb_ids",
+	"twitter":   "posted_tw_ids",
+	"linked_in": "posted_li_ids",
+}
+
+// Schedule is a schedule that has started along with the posts already sent to each network
+type Schedule struct {
+	ScheduleId string
+	From       time.Time
+	PostIds    []string
+	Duration   float64
+	Profiles   map[string][]string
+	Started    bool
+	Posted     map[string]map[string]bool
+}
+
+// Target is a post sent to a single profile of a network
+type Target struct {
+	PostId  string
+	Network string
+	Profile string
+}
+
+// Delivery is the progress of sending a post to a profile
+type Delivery struct {
+	Target
+	Status   string
+	Attempts int
+	Error    string
+	RetryAt  time.Time
+}
+
+/* Store keeps the schedules and the progress of their deliveries.
+Deliveries are claimed before the network is called so an interrupted attempt is never made twice. */
+type Store interface {
+	// DueSchedules returns the schedules of a tenant that started by now and are not completed
+	DueSchedules(tenantNamespace string, now time.Time) ([]Schedule, error)
+	// Start flags the schedule as due and creates its progress record
+	Start(tenantNamespace string, schedule Schedule) error
+	// Deliveries returns the deliveries recorded for a schedule so far
+	Deliveries(scheduleId string) (map[Target]Delivery, error)
+	// Claim records a new attempt of target as DeliverySending and returns it
+	Claim(tenantNamespace string, scheduleId string, target Target) (Delivery, error)
+	// Finish records the outcome of a claimed delivery
+	Finish(scheduleId string, delivery Delivery) error
+	// MarkPosted records that the post went out to every profile of network
+	MarkPosted(scheduleId string, network string, postId string) error
+	Complete(scheduleId string) error
+}
+
+// PostgresStore keeps the progress of schedules in general_schedule_table and schedule_delivery
+type PostgresStore struct{}
+
+func (PostgresStore) DueSchedules(tenantNamespace string, now time.Time) ([]Schedule, error) {
+	query := fmt.Sprintf(`SELECT s.schedule_id, s.schedule_from, s.post_ids, s.duration_per_post, s.facebook, s.twitter, s.linked_in,
+		g.schedule_id IS NOT NULL, COALESCE(g.posted_fb_ids, '{}'), COALESCE(g.posted_tw_ids, '{}'), COALESCE(g.posted_li_ids, '{}')
+		FROM %s.schedule s LEFT JOIN general_schedule_table g ON g.schedule_id = s.schedule_id
+		WHERE s.schedule_from <= $1 AND (g.completed IS NULL OR g.completed = false)`, pq.QuoteIdentifier(tenantNamespace))
+
+	rows, err := db.Connection.Query(query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []Schedule
+	for rows.Next() {
+		var facebook, twitter, linkedIn, postedFb, postedTw, postedLi []string
+		schedule := Schedule{}
+		err = rows.Scan(
+			&schedule.ScheduleId,
+			&schedule.From,
+			pq.Array(&schedule.PostIds),
+			&schedule.Duration,
+			pq.Array(&facebook),
+			pq.Array(&twitter),
+			pq.Array(&linkedIn),
+			&schedule.Started,
+			pq.Array(&postedFb),
+			pq.Array(&postedTw),
+			pq.Array(&postedLi),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		schedule.Profiles = map[string][]string{
+			"facebook":  facebook,
+			"twitter":   twitter,
+			"linked_in": linkedIn,
+		}
+		schedule.Posted = map[string]map[string]bool{
+			"facebook":  toSet(postedFb),
+			"twitter":   toSet(postedTw),
+			"linked_in": toSet(postedLi),
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, rows.Err()
+}
+
+func (PostgresStore) Start(tenantNamespace string, schedule Schedule) error {
+	query := fmt.Sprintf("UPDATE %s.schedule SET is_due = $1, updated_at = CURRENT_TIMESTAMP WHERE schedule_id = $2", pq.QuoteIdentifier(tenantNamespace))
+	_, err := db.Connection.Exec(query, true, schedule.ScheduleId)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Connection.Exec(
+		"INSERT INTO general_schedule_table (schedule_id, posted_fb_ids, posted_tw_ids, posted_li_ids, tenant_namespace, duration_per_post) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (schedule_id) DO NOTHING",
+		schedule.ScheduleId,
+		pq.Array([]string{}),
+		pq.Array([]string{}),
+		pq.Array([]string{}),
+		tenantNamespace,
+		schedule.Duration,
+	)
+	return err
+}
+
+func (PostgresStore) Deliveries(scheduleId string) (map[Target]Delivery, error) {
+	rows, err := db.Connection.Query("SELECT post_id, network, profile, status, attempts, last_error, retry_at FROM schedule_delivery WHERE schedule_id = $1", scheduleId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make(map[Target]Delivery)
+	for rows.Next() {
+		var delivery Delivery
+		var retryAt sql.NullTime
+		err = rows.Scan(&delivery.PostId, &delivery.Network, &delivery.Profile, &delivery.Status, &delivery.Attempts, &delivery.Error, &retryAt)
+		if err != nil {
+			return nil, err
+		}
+		delivery.RetryAt = retryAt.Time
+		deliveries[delivery.Target] = delivery
+	}
+	return deliveries, rows.Err()
+}
+
+func (PostgresStore) Claim(tenantNamespace string, scheduleId string, target Target) (Delivery, error) {
+	delivery := Delivery{Target: target, Status: DeliverySending}
+	err := db.Connection.QueryRow(`INSERT INTO schedule_delivery (schedule_id, tenant_namespace, post_id, network, profile, status, attempts) VALUES ($1, $2, $3, $4, $5, $6, 1)
+		ON CONFLICT (schedule_id, post_id, network, profile) DO UPDATE SET status = EXCLUDED.status, attempts = schedule_delivery.attempts + 1, updated_at = CURRENT_TIMESTAMP
+		RETURNING attempts`,
+		scheduleId, tenantNamespace, target.PostId, target.Network, target.Profile, DeliverySending,
+	).Scan(&delivery.Attempts)
+	return delivery, err
+}
+
+func (PostgresStore) Finish(scheduleId string, delivery Delivery) error {
+	var retryAt interface{}
+	if !delivery.RetryAt.IsZero() {
+		retryAt = delivery.RetryAt
+	}
+	_, err := db.Connection.Exec("UPDATE schedule_delivery SET status = $1, last_error = $2, retry_at = $3, updated_at = CURRENT_TIMESTAMP WHERE schedule_id = $4 AND post_id = $5 AND network = $6 AND profile = $7",
+		delivery.Status, delivery.Error, retryAt, scheduleId, delivery.PostId, delivery.Network, delivery.Profile)
+	return err
+}
+
+func (PostgresStore) MarkPosted(scheduleId string, network string, postId string) error {
+	query := fmt.Sprintf("UPDATE general_schedule_table SET %[1]s = array_append(%[1]s, $1), updated_at = CURRENT_TIMESTAMP WHERE schedule_id = $2", postedColumns[network])
+	_, err := db.Connection.Exec(query, postId, scheduleId)
+	return err
+}
+
+func (PostgresStore) Complete(scheduleId string) error {
+	_, err := db.Connection.Exec("UPDATE general_schedule_table SET completed = true, updated_at = CURRENT_TIMESTAMP WHERE schedule_id = $1", scheduleId)
+	return err
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
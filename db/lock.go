@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+)
+
+/* WithAdvisoryLock runs fn while holding the postgres advisory lock identified by key.
+It returns without calling fn when another instance holds the lock,
+which keeps background jobs to a single instance when several are deployed. */
+func WithAdvisoryLock(key int64, fn func() error) error {
+	ctx := context.Background()
+
+	// session level advisory locks are bound to a single connection
+	conn, err := Connection.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer func() {
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", key)
+		if err != nil {
+			_ = logs.Logger.Error(err)
+		}
+	}()
+
+	return fn()
+}
+
+/* TenantNamespaces lists the namespaces in the tenants registry.
+Suspended tenants are left out when activeOnly is set so background jobs skip them. */
+func TenantNamespaces(activeOnly bool) ([]string, error) {
+	query := "SELECT tenant_namespace FROM tenants"
+	if activeOnly {
+		query += " WHERE status = 'active'"
+	}
+	rows, err := Connection.Query(query + " ORDER BY tenant_namespace")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var namespaces []string
+	for rows.Next() {
+		var namespace string
+		err = rows.Scan(&namespace)
+		if err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces, rows.Err()
+}
@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE general_schedule_table
+    ADD COLUMN IF NOT EXISTS completed boolean NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS general_schedule_table_pending_idx
+    ON general_schedule_table (tenant_namespace)
+    WHERE completed = false;
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+DROP INDEX IF EXISTS general_schedule_table_pending_idx;
+ALTER TABLE general_schedule_table
+    DROP COLUMN IF EXISTS completed;
+-- SQL section 'Down' is executed when this migration is rolled back
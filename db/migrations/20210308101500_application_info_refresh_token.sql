@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE postit.application_info
+    ADD COLUMN IF NOT EXISTS refresh_token text NOT NULL DEFAULT '';
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+ALTER TABLE postit.application_info
+    DROP COLUMN IF EXISTS refresh_token;
+-- SQL section 'Down' is executed when this migration is rolled back
@@ -0,0 +1,11 @@
+-- +goose Up
+-- the same person commonly uses one name across networks and linkedin pages are stored per organization
+ALTER TABLE postit.application_info
+    DROP CONSTRAINT IF EXISTS application_info_user_name_key;
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+ALTER TABLE postit.application_info
+    ADD CONSTRAINT application_info_user_name_key UNIQUE (user_name);
+-- SQL section 'Down' is executed when this migration is rolled back
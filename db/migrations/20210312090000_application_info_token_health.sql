@@ -0,0 +1,17 @@
+-- +goose Up
+ALTER TABLE postit.application_info
+    ADD COLUMN IF NOT EXISTS token_expires_at timestamp with time zone,
+    ADD COLUMN IF NOT EXISTS token_status     character varying(20) NOT NULL DEFAULT 'healthy';
+
+-- tokens stored so far expire expires_in seconds after they were last written
+UPDATE postit.application_info
+SET token_expires_at = updated_at + expires_in * interval '1 second'
+WHERE expires_in > 0;
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+ALTER TABLE postit.application_info
+    DROP COLUMN IF EXISTS token_expires_at,
+    DROP COLUMN IF EXISTS token_status;
+-- SQL section 'Down' is executed when this migration is rolled back
@@ -0,0 +1,11 @@
+-- +goose Up
+-- encrypted secrets no longer fit in 200 characters, run the api with -encrypt-credentials after applying
+ALTER TABLE postit.application_info
+    ALTER COLUMN application_secret TYPE text;
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+ALTER TABLE postit.application_info
+    ALTER COLUMN application_secret TYPE character varying(200);
+-- SQL section 'Down' is executed when this migration is rolled back
@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS tenants
+(
+    tenant_namespace character varying(63)    NOT NULL,
+    tenant_name      character varying(200)   NOT NULL,
+    status           character varying(20)    NOT NULL DEFAULT 'active',
+    created_at       timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at       timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_namespace)
+);
+
+-- the postit schema was created by hand before tenants could be provisioned
+INSERT INTO tenants (tenant_namespace, tenant_name)
+VALUES ('postit', 'PostIt')
+ON CONFLICT (tenant_namespace) DO NOTHING;
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+DROP TABLE IF EXISTS tenants;
+-- SQL section 'Down' is executed when this migration is rolled back
@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS outbox
+(
+    message_id       uuid                     NOT NULL,
+    tenant_namespace character varying(63)    NOT NULL,
+    topic            character varying(100)   NOT NULL,
+    payload          jsonb                    NOT NULL,
+    trace_id         character varying(200)   NOT NULL DEFAULT '',
+    attempts         integer                  NOT NULL DEFAULT 0,
+    last_error       text,
+    available_at     timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at     timestamp with time zone,
+    created_at       timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id)
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (available_at) WHERE delivered_at IS NULL;
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+DROP TABLE IF EXISTS outbox;
+-- SQL section 'Down' is executed when this migration is rolled back
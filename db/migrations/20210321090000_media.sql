@@ -0,0 +1,67 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS postit.media
+(
+    media_id     uuid                     NOT NULL,
+    content_type character varying(100)   NOT NULL,
+    size         bigint                   NOT NULL,
+    checksum     character varying(64)    NOT NULL,
+    data         bytea                    NOT NULL,
+    created_at   timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (media_id)
+);
+
+ALTER TABLE postit.post
+    ADD COLUMN IF NOT EXISTS media_ids character varying(200)[] NOT NULL DEFAULT '{}';
+
+-- every inline image becomes a media record, the post keeps their ids in the original order
+CREATE TEMPORARY TABLE post_image_media AS
+SELECT p.post_id,
+       i.position,
+       i.data,
+       md5(p.post_id::text || i.position::text || random()::text)::uuid AS media_id
+FROM postit.post p,
+     unnest(p.post_images) WITH ORDINALITY AS i(data, position)
+WHERE i.data IS NOT NULL;
+
+INSERT INTO postit.media (media_id, content_type, size, checksum, data)
+SELECT media_id,
+       CASE
+           WHEN substring(data FROM 1 FOR 3) = '\xffd8ff'::bytea THEN 'image/jpeg'
+           WHEN substring(data FROM 1 FOR 8) = '\x89504e470d0a1a0a'::bytea THEN 'image/png'
+           WHEN substring(data FROM 1 FOR 4) = '\x47494638'::bytea THEN 'image/gif'
+           ELSE 'application/octet-stream'
+           END,
+       length(data),
+       encode(sha256(data), 'hex'),
+       data
+FROM post_image_media;
+
+UPDATE postit.post p
+SET media_ids = m.media_ids
+FROM (SELECT post_id, array_agg(media_id::text ORDER BY position) AS media_ids
+      FROM post_image_media
+      GROUP BY post_id) m
+WHERE p.post_id = m.post_id;
+
+DROP TABLE post_image_media;
+
+ALTER TABLE postit.post
+    DROP COLUMN IF EXISTS post_images;
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+ALTER TABLE postit.post
+    ADD COLUMN IF NOT EXISTS post_images bytea[];
+
+UPDATE postit.post p
+SET post_images = (SELECT array_agg(m.data ORDER BY array_position(p.media_ids, m.media_id::text))
+                   FROM postit.media m
+                   WHERE m.media_id::text = ANY (p.media_ids))
+WHERE cardinality(p.media_ids) > 0;
+
+ALTER TABLE postit.post
+    DROP COLUMN IF EXISTS media_ids;
+
+DROP TABLE IF EXISTS postit.media;
+-- SQL section 'Down' is executed when this migration is rolled back
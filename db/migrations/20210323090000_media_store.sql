@@ -0,0 +1,12 @@
+-- +goose Up
+-- media content moves to the media store, run the api with -move-media after applying
+ALTER TABLE postit.media
+    ALTER COLUMN data DROP NOT NULL;
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+-- fails while media content lives in the media store only, it is not copied back
+ALTER TABLE postit.media
+    ALTER COLUMN data SET NOT NULL;
+-- SQL section 'Down' is executed when this migration is rolled back
@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE postit.media
+    ADD COLUMN IF NOT EXISTS uploaded_by character varying(200) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS attached    boolean                NOT NULL DEFAULT false;
+
+-- every media record so far came from a post
+UPDATE postit.media SET attached = true;
+
+CREATE INDEX IF NOT EXISTS media_uploads_idx ON postit.media (created_at) WHERE NOT attached;
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+DROP INDEX IF EXISTS postit.media_uploads_idx;
+ALTER TABLE postit.media
+    DROP COLUMN IF EXISTS uploaded_by,
+    DROP COLUMN IF EXISTS attached;
+-- SQL section 'Down' is executed when this migration is rolled back
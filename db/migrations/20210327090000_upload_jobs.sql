@@ -0,0 +1,26 @@
+-- +goose Up
+ALTER TABLE postit.media
+    ADD COLUMN IF NOT EXISTS width  integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS height integer NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS postit.upload_job
+(
+    job_id        uuid                     NOT NULL,
+    status        character varying(20)    NOT NULL,
+    media_id      uuid,
+    error_code    character varying(50)    NOT NULL DEFAULT '',
+    error_message text                     NOT NULL DEFAULT '',
+    uploaded_by   character varying(200)   NOT NULL DEFAULT '',
+    created_at    timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at    timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (job_id)
+);
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+DROP TABLE IF EXISTS postit.upload_job;
+ALTER TABLE postit.media
+    DROP COLUMN IF EXISTS width,
+    DROP COLUMN IF EXISTS height;
+-- SQL section 'Down' is executed when this migration is rolled back
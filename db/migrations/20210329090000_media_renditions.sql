@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS postit.media_rendition
+(
+    media_id     uuid                   NOT NULL REFERENCES postit.media (media_id) ON DELETE CASCADE,
+    name         character varying(50)  NOT NULL,
+    network      character varying(50)  NOT NULL DEFAULT '',
+    content_type character varying(100) NOT NULL,
+    width        integer                NOT NULL,
+    height       integer                NOT NULL,
+    size         bigint                 NOT NULL,
+    PRIMARY KEY (media_id, name)
+);
+
+-- media stored so far has no renditions, the publishers send the original instead
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+DROP TABLE IF EXISTS postit.media_rendition;
+-- SQL section 'Down' is executed when this migration is rolled back
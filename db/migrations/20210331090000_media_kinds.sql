@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE postit.media
+    ADD COLUMN IF NOT EXISTS kind     character varying(20) NOT NULL DEFAULT 'image',
+    ADD COLUMN IF NOT EXISTS duration double precision      NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS codec    character varying(20) NOT NULL DEFAULT '';
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+ALTER TABLE postit.media
+    DROP COLUMN IF EXISTS kind,
+    DROP COLUMN IF EXISTS duration,
+    DROP COLUMN IF EXISTS codec;
+-- SQL section 'Down' is executed when this migration is rolled back
@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE postit.media
+    ADD COLUMN IF NOT EXISTS name character varying(255)   NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS tags character varying(100)[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS media_tags_idx ON postit.media USING GIN (tags);
+-- the library looks up the posts using a media record through media_ids
+CREATE INDEX IF NOT EXISTS post_media_ids_idx ON postit.post USING GIN (media_ids);
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+DROP INDEX IF EXISTS postit.post_media_ids_idx;
+DROP INDEX IF EXISTS postit.media_tags_idx;
+ALTER TABLE postit.media
+    DROP COLUMN IF EXISTS name,
+    DROP COLUMN IF EXISTS tags;
+-- SQL section 'Down' is executed when this migration is rolled back
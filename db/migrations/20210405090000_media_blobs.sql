@@ -0,0 +1,44 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS postit.media_blob
+(
+    blob_id      uuid                     NOT NULL,
+    checksum     character varying(64)    NOT NULL,
+    content_type character varying(100)   NOT NULL,
+    size         bigint                   NOT NULL,
+    ref_count    integer                  NOT NULL DEFAULT 0,
+    created_at   timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (blob_id)
+);
+CREATE INDEX IF NOT EXISTS media_blob_checksum_idx ON postit.media_blob (checksum);
+
+-- the content of the media stored so far lives under their own id, every one of them becomes its own blob
+INSERT INTO postit.media_blob (blob_id, checksum, content_type, size, ref_count, created_at)
+SELECT media_id, checksum, content_type, size, 1, created_at
+FROM postit.media;
+
+ALTER TABLE postit.media ADD COLUMN IF NOT EXISTS blob_id uuid;
+UPDATE postit.media SET blob_id = media_id;
+ALTER TABLE postit.media
+    ALTER COLUMN blob_id SET NOT NULL,
+    ADD CONSTRAINT media_blob_id_fkey FOREIGN KEY (blob_id) REFERENCES postit.media_blob (blob_id);
+CREATE INDEX IF NOT EXISTS media_blob_idx ON postit.media (blob_id);
+
+-- renditions belong to the blob they were made from, blob ids equal the media ids so far
+ALTER TABLE postit.media_rendition DROP CONSTRAINT IF EXISTS media_rendition_media_id_fkey;
+ALTER TABLE postit.media_rendition RENAME COLUMN media_id TO blob_id;
+ALTER TABLE postit.media_rendition
+    ADD CONSTRAINT media_rendition_blob_id_fkey FOREIGN KEY (blob_id) REFERENCES postit.media_blob (blob_id) ON DELETE CASCADE;
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+ALTER TABLE postit.media_rendition DROP CONSTRAINT IF EXISTS media_rendition_blob_id_fkey;
+ALTER TABLE postit.media_rendition RENAME COLUMN blob_id TO media_id;
+-- renditions of blobs whose first media record is gone cannot be kept
+DELETE FROM postit.media_rendition WHERE media_id NOT IN (SELECT media_id FROM postit.media);
+ALTER TABLE postit.media_rendition
+    ADD CONSTRAINT media_rendition_media_id_fkey FOREIGN KEY (media_id) REFERENCES postit.media (media_id) ON DELETE CASCADE;
+DROP INDEX IF EXISTS postit.media_blob_idx;
+ALTER TABLE postit.media DROP COLUMN IF EXISTS blob_id;
+DROP TABLE IF EXISTS postit.media_blob;
+-- SQL section 'Down' is executed when this migration is rolled back
@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS schedule_delivery
+(
+    schedule_id      uuid                     NOT NULL REFERENCES general_schedule_table (schedule_id) ON DELETE CASCADE,
+    tenant_namespace character varying(63)    NOT NULL,
+    post_id          character varying(200)   NOT NULL,
+    network          character varying(50)    NOT NULL,
+    profile          character varying(200)   NOT NULL,
+    status           character varying(20)    NOT NULL,
+    attempts         integer                  NOT NULL DEFAULT 0,
+    last_error       text                     NOT NULL DEFAULT '',
+    retry_at         timestamp with time zone,
+    created_at       timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at       timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (schedule_id, post_id, network, profile)
+);
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+DROP TABLE IF EXISTS schedule_delivery;
+-- SQL section 'Down' is executed when this migration is rolled back
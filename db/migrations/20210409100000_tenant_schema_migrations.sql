@@ -0,0 +1,15 @@
+-- +goose Up
+-- the tables of the tenant schemas are migrated by the api at startup, see pkg/tenant_migrations.go
+CREATE TABLE IF NOT EXISTS tenant_schema_migration
+(
+    tenant_namespace character varying(63)    NOT NULL REFERENCES tenants (tenant_namespace) ON DELETE CASCADE,
+    version          bigint                   NOT NULL,
+    applied_at       timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_namespace, version)
+);
+
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- +goose Down
+DROP TABLE IF EXISTS tenant_schema_migration;
+-- SQL section 'Down' is executed when this migration is rolled back
@@ -0,0 +1,209 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/lib/pq"
+	"gitlab.com/pbobby001/postit-api/db"
+	"gitlab.com/pbobby001/postit-api/pkg/secrets"
+	"time"
+)
+
+// Health states of a stored access token
+const (
+	TokenHealthy = "healthy"
+	// the token is close to expiry and could not be refreshed yet
+	TokenExpiring = "expiring"
+	// the token can no longer be refreshed, the user has to connect the account again
+	TokenReauthRequired = "reauth_required"
+)
+
+const applicationInfoColumns = "application_uuid, application_name, application_id, application_secret, application_url, user_access_token, refresh_token, expires_in, user_name, user_id, token_expires_at, token_status, created_at, updated_at"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanApplicationInfo(row rowScanner) (ApplicationInfo, error) {
+	var appInfo ApplicationInfo
+	err := row.Scan(
+		&appInfo.ApplicationUuid,
+		&appInfo.ApplicationName,
+		&appInfo.ApplicationId,
+		&appInfo.ApplicationSecret,
+		&appInfo.ApplicationUrl,
+		&appInfo.UserAccessToken,
+		&appInfo.RefreshToken,
+		&appInfo.ExpiresIn,
+		&appInfo.UserName,
+		&appInfo.UserId,
+		&appInfo.TokenExpiresAt,
+		&appInfo.TokenStatus,
+		&appInfo.CreatedAt,
+		&appInfo.UpdatedAt,
+	)
+	if err != nil {
+		return appInfo, err
+	}
+
+	err = secrets.DecryptAll(&appInfo.ApplicationSecret, &appInfo.UserAccessToken, &appInfo.RefreshToken)
+	return appInfo, err
+}
+
+/* FetchAccounts returns the social media accounts connected by a tenant,
+most recently updated first */
+func FetchAccounts(tenantNamespace string) ([]ApplicationInfo, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s.application_info ORDER BY updated_at DESC LIMIT 2000", applicationInfoColumns, pq.QuoteIdentifier(tenantNamespace))
+	rows, err := db.Connection.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []ApplicationInfo
+	for rows.Next() {
+		appInfo, err := scanApplicationInfo(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, appInfo)
+	}
+	return accounts, rows.Err()
+}
+
+// FetchAccount returns the account of the given network connected under userId
+func FetchAccount(tenantNamespace string, applicationName string, userId string) (ApplicationInfo, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s.application_info WHERE application_name = $1 AND user_id = $2", applicationInfoColumns, pq.QuoteIdentifier(tenantNamespace))
+	return scanApplicationInfo(db.Connection.QueryRow(query, applicationName, userId))
+}
+
+// TokenExpiresAt returns when a token issued now for expiresIn seconds expires, nil when it does not expire
+func TokenExpiresAt(expiresIn int, now time.Time) *time.Time {
+	if expiresIn <= 0 {
+		return nil
+	}
+	expiresAt := now.Add(time.Duration(expiresIn) * time.Second)
+	return &expiresAt
+}
+
+/* UpdateAccountToken stores a refreshed token and marks the account healthy.
+Networks that do not rotate refresh tokens leave token.RefreshToken empty so the current one is kept */
+func UpdateAccountToken(tenantNamespace string, applicationUuid string, token AuthResponse, now time.Time) error {
+	err := secrets.EncryptAll(&token.AccessToken, &token.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`UPDATE %s.application_info SET user_access_token = $1, refresh_token = COALESCE(NULLIF($2, ''), refresh_token), expires_in = $3,
+		token_expires_at = $4, token_status = $5, updated_at = CURRENT_TIMESTAMP WHERE application_uuid = $6`, pq.QuoteIdentifier(tenantNamespace))
+	_, err = db.Connection.Exec(query, token.AccessToken, token.RefreshToken, token.ExpiresIn, TokenExpiresAt(token.ExpiresIn, now), TokenHealthy, applicationUuid)
+	return err
+}
+
+/* EncryptAccounts encrypts the credentials of a tenant's accounts that are still plain text
+or sealed under a key other than the active one, it returns the number of accounts updated. */
+func EncryptAccounts(tenantNamespace string) (int, error) {
+	keyring, err := secrets.Default()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Connection.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	query := fmt.Sprintf("SELECT application_uuid, application_secret, user_access_token, refresh_token FROM %s.application_info FOR UPDATE", pq.QuoteIdentifier(tenantNamespace))
+	rows, err := tx.Query(query)
+	if err != nil {
+		return 0, err
+	}
+
+	type credentials struct {
+		applicationUuid string
+		values          []string
+	}
+	var stale []credentials
+	for rows.Next() {
+		c := credentials{values: make([]string, 3)}
+		err = rows.Scan(&c.applicationUuid, &c.values[0], &c.values[1], &c.values[2])
+		if err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		if !keyring.Current(c.values[0]) || !keyring.Current(c.values[1]) || !keyring.Current(c.values[2]) {
+			stale = append(stale, c)
+		}
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	update := fmt.Sprintf("UPDATE %s.application_info SET application_secret = $1, user_access_token = $2, refresh_token = $3 WHERE application_uuid = $4", pq.QuoteIdentifier(tenantNamespace))
+	for _, c := range stale {
+		for i, value := range c.values {
+			value, err = keyring.Decrypt(value)
+			if err != nil {
+				return 0, fmt.Errorf("account %s: %v", c.applicationUuid, err)
+			}
+			c.values[i], err = keyring.Encrypt(value)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		_, err = tx.Exec(update, c.values[0], c.values[1], c.values[2], c.applicationUuid)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(stale), tx.Commit()
+}
+
+// SetTokenStatus updates the health state of an account's token
+func SetTokenStatus(tenantNamespace string, applicationUuid string, status string) error {
+	query := fmt.Sprintf("UPDATE %s.application_info SET token_status = $1, updated_at = CURRENT_TIMESTAMP WHERE application_uuid = $2", pq.QuoteIdentifier(tenantNamespace))
+	_, err := db.Connection.Exec(query, status, applicationUuid)
+	return err
+}
+
+// FetchPost returns a single post
+func FetchPost(tenantNamespace string, postId string) (DbPost, error) {
+	var post DbPost
+	var facebookPostId *string
+	var mediaIds []string
+	var fb, tw, li, scheduled *bool
+	query := fmt.Sprintf("SELECT post_id, facebook_post_id, post_message, media_ids, image_paths, hash_tags, post_fb_status, post_tw_status, post_li_status, post_priority, scheduled, created_at, updated_at FROM %s.post WHERE post_id = $1", pq.QuoteIdentifier(tenantNamespace))
+	err := db.Connection.QueryRow(query, postId).Scan(
+		&post.PostId,
+		&facebookPostId,
+		&post.PostMessage,
+		pq.Array(&mediaIds),
+		pq.Array(&post.ImagePaths),
+		pq.Array(&post.HashTags),
+		&fb,
+		&tw,
+		&li,
+		&post.PostPriority,
+		&scheduled,
+		&post.CreatedOn,
+		&post.UpdatedOn,
+	)
+	if err != nil {
+		return post, err
+	}
+
+	if facebookPostId != nil {
+		post.FacebookPostId = *facebookPostId
+	}
+	post.Media = MediaRefs(mediaIds)
+	post.PostFbStatus = fb != nil && *fb
+	post.PostTwStatus = tw != nil && *tw
+	post.PostLiStatus = li != nil && *li
+	post.Scheduled = scheduled != nil && *scheduled
+	return post, nil
+}
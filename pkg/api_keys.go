@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/twinj/uuid"
+	"gitlab.com/pbobby001/postit-api/pkg/auth"
+	"strings"
+)
+
+// ApiKeyHeader carries an API key, it is accepted instead of Authorization: Bearer
+const ApiKeyHeader = "X-Api-Key"
+
+// ApiKeyPrefix starts every API key so leaked keys are easy to recognise
+const ApiKeyPrefix = "pk_"
+
+// apiKeyPrefixLength is how much of a key is kept in clear to tell keys apart
+const apiKeyPrefixLength = 10
+
+var ErrApiKeyScopes = errors.New("an API key needs at least one scope")
+
+/* ValidateApiKeyRequest trims the name and removes duplicate scopes,
+every scope has to be one of auth.Scopes. */
+func ValidateApiKeyRequest(request ApiKeyRequest) (ApiKeyRequest, error) {
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > 255 {
+		return request, errors.New("name is required and may be 255 characters at most")
+	}
+
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, scope := range request.Scopes {
+		if !knownScope(scope) {
+			return request, fmt.Errorf("unknown scope %q, scopes are %s", scope, strings.Join(auth.Scopes, ", "))
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return request, ErrApiKeyScopes
+	}
+	request.Scopes = scopes
+	return request, nil
+}
+
+func knownScope(scope string) bool {
+	for _, known := range auth.Scopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
+/* NewApiKey generates the secret of a new API key, the secret is set in Key and only its hash is meant to be stored.
+The request has to be validated with ValidateApiKeyRequest first. */
+func NewApiKey(request ApiKeyRequest, createdBy string) (ApiKey, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return ApiKey{}, err
+	}
+
+	key := ApiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return ApiKey{
+		KeyId:     uuid.NewV4().String(),
+		Name:      request.Name,
+		Prefix:    key[:apiKeyPrefixLength],
+		Key:       key,
+		Hash:      HashApiKey(key),
+		Scopes:    request.Scopes,
+		CreatedBy: createdBy,
+	}, nil
+}
+
+// HashApiKey is what is stored of a key, the keys are random enough for a plain SHA-256
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ApiKeyClaims are the claims requests authenticated with key are served with, the key acts in its tenant through its scopes only
+func ApiKeyClaims(key ApiKey, tenantNamespace string) *auth.Claims {
+	claims := &auth.Claims{Scope: strings.Join(key.Scopes, " "), ScopeTenant: tenantNamespace}
+	claims.Subject = "api-key:" + key.KeyId
+	claims.Audience = []string{tenantNamespace}
+	return claims
+}
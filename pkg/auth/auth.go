@@ -0,0 +1,172 @@
+/* Package auth verifies the tokens issued by the authentication server.
+Tokens must be signed with RS256 or ES256. The public keys are fetched from the JWKS endpoint in JWT_JWKS_URL
+and cached by kid, or read from the PEM file in JWT_KEY_FILE when no endpoint is configured.
+JWT_ISSUER is the expected issuer and JWT_LEEWAY the clock skew tolerated on exp and nbf. */
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cristalhq/jwt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMalformed   = errors.New("auth: malformed token")
+	ErrAlgorithm   = errors.New("auth: token must be signed with RS256 or ES256")
+	ErrUnknownKey  = errors.New("auth: token was signed with an unknown key")
+	ErrSignature   = errors.New("auth: invalid token signature")
+	ErrExpired     = errors.New("auth: token has expired")
+	ErrNotYetValid = errors.New("auth: token is not valid yet")
+	ErrIssuer      = errors.New("auth: token was issued by an unknown issuer")
+	ErrAudience    = errors.New("auth: token is not meant for this audience")
+)
+
+// DefaultLeeway is the clock skew tolerated between the authentication server and the API
+const DefaultLeeway = 30 * time.Second
+
+/* KeySource looks up the public keys a token may be signed with.
+kid is the key id of the token header, it may be empty. */
+type KeySource interface {
+	Keys(kid string) ([]crypto.PublicKey, error)
+}
+
+// Verifier checks the signature and the standard claims of tokens
+type Verifier struct {
+	Keys KeySource
+	// Issuer is compared with the iss claim unless empty
+	Issuer string
+	Leeway time.Duration
+	// Now is used instead of time.Now when set
+	Now func() time.Time
+}
+
+/* Verify parses token, checks its signature and its exp, nbf and iss claims,
+and that its audience holds one of audience when any is given.
+Expired tokens are returned along with ErrExpired once their signature is checked, so they can be refreshed. */
+func (v *Verifier) Verify(token string, audience ...string) (*Claims, error) {
+	parsed, err := jwt.Parse([]byte(token))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	alg := parsed.Header().Algorithm
+	if alg != jwt.RS256 && alg != jwt.ES256 {
+		return nil, ErrAlgorithm
+	}
+	keys, err := v.Keys.Keys(parsed.Header().KeyID)
+	if err != nil {
+		return nil, err
+	}
+	verified := false
+	for _, key := range keys {
+		if verifySignature(alg, key, parsed.Payload(), parsed.Signature()) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+
+	var claims Claims
+	err = json.Unmarshal(parsed.RawClaims(), &claims)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return nil, ErrIssuer
+	}
+	if len(audience) > 0 && jwt.AudienceChecker(audience)(&claims.StandardClaims) != nil {
+		return nil, ErrAudience
+	}
+
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if claims.NotBefore != 0 && now.Add(v.Leeway).Before(claims.NotBefore.Time()) {
+		return nil, ErrNotYetValid
+	}
+	if claims.ExpiresAt == 0 || now.Add(-v.Leeway).After(claims.ExpiresAt.Time()) {
+		return &claims, ErrExpired
+	}
+	return &claims, nil
+}
+
+// verifySignature checks signature over payload, keys of the wrong type for alg never match
+func verifySignature(alg jwt.Algorithm, key crypto.PublicKey, payload []byte, signature []byte) bool {
+	digest := sha256.Sum256(payload)
+	switch alg {
+	case jwt.RS256:
+		rsaKey, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) == nil
+	case jwt.ES256:
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != elliptic.P256() || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(ecKey, digest[:], r, s)
+	}
+	return false
+}
+
+var (
+	defaultVerifier *Verifier
+	defaultErr      error
+	once            sync.Once
+)
+
+/* Default returns the verifier configured by JWT_JWKS_URL or JWT_KEY_FILE, JWT_ISSUER and JWT_LEEWAY.
+JWT_KEY_FILE defaults to private.pem, the public key is taken from a private key. */
+func Default() (*Verifier, error) {
+	once.Do(func() {
+		defaultVerifier, defaultErr = FromEnv()
+	})
+	return defaultVerifier, defaultErr
+}
+
+// UseVerifier replaces the default verifier, tests use it to trust their own keys
+func UseVerifier(verifier *Verifier) {
+	once.Do(func() {})
+	defaultVerifier, defaultErr = verifier, nil
+}
+
+func FromEnv() (*Verifier, error) {
+	verifier := &Verifier{Issuer: os.Getenv("JWT_ISSUER"), Leeway: DefaultLeeway}
+	if leeway := os.Getenv("JWT_LEEWAY"); leeway != "" {
+		d, err := time.ParseDuration(leeway)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("auth: invalid JWT_LEEWAY %q", leeway)
+		}
+		verifier.Leeway = d
+	}
+
+	if url := os.Getenv("JWT_JWKS_URL"); url != "" {
+		verifier.Keys = NewJWKS(url)
+		return verifier, nil
+	}
+
+	file := os.Getenv("JWT_KEY_FILE")
+	if file == "" {
+		file = "private.pem"
+	}
+	keys, err := LoadKeyFile(file)
+	if err != nil {
+		return nil, err
+	}
+	verifier.Keys = keys
+	return verifier, nil
+}
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/cristalhq/jwt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2021, 4, 6, 12, 0, 0, 0, time.UTC)
+
+// sign builds a token the way the authentication server does, the library cannot sign ES256 properly
+func sign(t *testing.T, alg string, kid string, key crypto.Signer, claims jwt.StandardClaims) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	body, _ := json.Marshal(claims)
+	payload := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(payload))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() jwt.StandardClaims {
+	return jwt.StandardClaims{
+		Subject:   "ama",
+		Issuer:    "postit-auth",
+		Audience:  jwt.Audience{"postit"},
+		ExpiresAt: jwt.Timestamp(now.Add(time.Hour).Unix()),
+		NotBefore: jwt.Timestamp(now.Add(-time.Minute).Unix()),
+	}
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	verifier := &Verifier{
+		Keys:   StaticKeys{rsaKey.Public(), ecKey.Public()},
+		Issuer: "postit-auth",
+		Leeway: 30 * time.Second,
+		Now:    func() time.Time { return now },
+	}
+
+	for _, token := range []string{sign(t, "RS256", "", rsaKey, validClaims()), sign(t, "ES256", "", ecKey, validClaims())} {
+		claims, err := verifier.Verify(token, "postit-audience", "postit")
+		if err != nil || claims.Subject != "ama" {
+			t.Fatalf("expected the token to be valid got %+v: %v", claims, err)
+		}
+	}
+
+	expired := validClaims()
+	expired.ExpiresAt = jwt.Timestamp(now.Add(-time.Minute).Unix())
+	claims, err := verifier.Verify(sign(t, "RS256", "", rsaKey, expired))
+	if err != ErrExpired || claims == nil || claims.Subject != "ama" {
+		t.Fatalf("expected the expired claims got %+v: %v", claims, err)
+	}
+
+	skewed := validClaims()
+	skewed.ExpiresAt = jwt.Timestamp(now.Add(-10 * time.Second).Unix())
+	if _, err = verifier.Verify(sign(t, "RS256", "", rsaKey, skewed)); err != nil {
+		t.Fatalf("expected the leeway to be applied got %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"unknown key", sign(t, "RS256", "", otherKey, validClaims()), ErrSignature},
+		{"wrong key type", sign(t, "ES256", "", rsaKey, validClaims()), ErrSignature},
+		{"unsigned", func() string {
+			parts := strings.Split(sign(t, "RS256", "", rsaKey, validClaims()), ".")
+			return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+		}(), ErrAlgorithm},
+		{"malformed", "not a token", ErrMalformed},
+		{"not yet valid", func() string {
+			claims := validClaims()
+			claims.NotBefore = jwt.Timestamp(now.Add(time.Hour).Unix())
+			return sign(t, "RS256", "", rsaKey, claims)
+		}(), ErrNotYetValid},
+		{"issuer", func() string {
+			claims := validClaims()
+			claims.Issuer = "somebody"
+			return sign(t, "RS256", "", rsaKey, claims)
+		}(), ErrIssuer},
+		{"no expiry", func() string {
+			claims := validClaims()
+			claims.ExpiresAt = 0
+			return sign(t, "RS256", "", rsaKey, claims)
+		}(), ErrExpired},
+	}
+	for _, test := range tests {
+		if _, err := verifier.Verify(test.token, "postit"); err != test.err {
+			t.Errorf("%s: expected %v got %v", test.name, test.err, err)
+		}
+	}
+
+	// the payload cannot be swapped under a valid signature
+	token := sign(t, "RS256", "", rsaKey, validClaims())
+	forged := validClaims()
+	forged.Subject = "kofi"
+	body, _ := json.Marshal(forged)
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString(body) + "." + parts[2]
+	if _, err = verifier.Verify(tampered); err != ErrSignature {
+		t.Fatalf("expected a tampered token to be rejected got %v", err)
+	}
+
+	if _, err = verifier.Verify(sign(t, "RS256", "", rsaKey, validClaims()), "another-tenant"); err != ErrAudience {
+		t.Fatalf("expected the audience to be checked got %v", err)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`))
+	body := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"ama","exp":1893456000,"roles":{"postit":"editor"},"scope":"media:read"}`))
+	digest := sha256.Sum256([]byte(header + "." + body))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+
+	verifier := &Verifier{Keys: StaticKeys{rsaKey.Public()}, Now: func() time.Time { return now }}
+	claims, err := verifier.Verify(header + "." + body + "." + base64.RawURLEncoding.EncodeToString(signature))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.RoleIn("postit") != RoleEditor || claims.RoleIn("acme") != "" || !claims.HasScope(ScopeMediaRead) || claims.HasScope("media") {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	if !RoleOwner.Includes(RoleEditor) || !RoleEditor.Includes(RoleEditor) || RoleViewer.Includes(RoleEditor) || Role("").Includes("") {
+		t.Fatal("expected owner > editor > viewer")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	private, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	public, _ := x509.MarshalPKIXPublicKey(ecKey.Public())
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private}), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public})...)
+
+	keys, err := ParseKeys(data)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("expected both public keys got %d: %v", len(keys), err)
+	}
+	if _, ok := keys[0].(*rsa.PublicKey); !ok {
+		t.Fatalf("expected the public half of the private key got %T", keys[0])
+	}
+
+	if _, err = ParseKeys([]byte("no keys here")); err == nil {
+		t.Fatal("expected an error without keys")
+	}
+}
+
+func jwk(kid string, key crypto.PublicKey) map[string]string {
+	encode := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.Bytes())
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": encode(k.N), "e": encode(big.NewInt(int64(k.E)))}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": encode(k.X), "y": encode(k.Y)}
+	}
+	return nil
+}
+
+func TestJWKSRotation(t *testing.T) {
+	first, _ := rsa.GenerateKey(rand.Reader, 2048)
+	second, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var fetches int32
+	var published atomic.Value
+	published.Store([]map[string]string{jwk("k1", first.Public())})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": published.Load()})
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(server.URL)
+	verifier := &Verifier{Keys: jwks, Now: func() time.Time { return now }}
+
+	for i := 0; i < 3; i++ {
+		if _, err := verifier.Verify(sign(t, "RS256", "k1", first, validClaims())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("expected the keys to be cached got %d fetches", fetches)
+	}
+
+	// the server rotates to a new key, the first unknown kid is only looked up again after MinRefresh
+	published.Store([]map[string]string{jwk("k1", first.Public()), jwk("k2", second.Public())})
+	token := sign(t, "ES256", "k2", second, validClaims())
+	if _, err := verifier.Verify(token); err != ErrUnknownKey {
+		t.Fatalf("expected the kid to be unknown until the next refresh got %v", err)
+	}
+
+	jwks.MinRefresh = 0
+	if _, err := verifier.Verify(token); err != nil {
+		t.Fatalf("expected the rotated key to be fetched got %v", err)
+	}
+	if fetches != 2 {
+		t.Fatalf("expected one more fetch got %d", fetches)
+	}
+
+	// a failing endpoint keeps the cached keys
+	server.Close()
+	if _, err := verifier.Verify(sign(t, "RS256", "k1", first, validClaims())); err != nil {
+		t.Fatalf("expected the cached key to be used got %v", err)
+	}
+	if _, err := verifier.Verify(sign(t, "RS256", "k3", first, validClaims())); err != ErrUnknownKey {
+		t.Fatalf("expected an unknown kid to fail got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"github.com/cristalhq/jwt"
+	"strings"
+)
+
+// Role is what a subject may do within a tenant, every role includes the ones ranked below it
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleOwner  Role = "owner"
+)
+
+// rank orders the roles, unknown roles rank below viewer
+func (r Role) rank() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleEditor:
+		return 2
+	case RoleOwner:
+		return 3
+	}
+	return 0
+}
+
+// Includes tells whether r grants everything required grants
+func (r Role) Includes(required Role) bool {
+	return r.rank() > 0 && r.rank() >= required.rank()
+}
+
+// Scopes granted to tokens and API keys, routes name the scope that lets a caller in without a role
+const (
+	ScopePostsRead      = "posts:read"
+	ScopePostsWrite     = "posts:write"
+	ScopeSchedulesRead  = "schedules:read"
+	ScopeSchedulesWrite = "schedules:write"
+	ScopeMediaRead      = "media:read"
+	ScopeMediaWrite     = "media:write"
+	ScopeAccountsRead   = "accounts:read"
+)
+
+// Scopes lists every scope, API keys may only be granted these
+var Scopes = []string{
+	ScopePostsRead,
+	ScopePostsWrite,
+	ScopeSchedulesRead,
+	ScopeSchedulesWrite,
+	ScopeMediaRead,
+	ScopeMediaWrite,
+	ScopeAccountsRead,
+}
+
+/* Claims are the claims of a token issued by the authentication server.
+Roles maps the tenant namespaces the subject belongs to to its role there,
+Scope lists the scopes granted to the token separated by spaces.
+ScopeTenant is the only tenant the scopes are honoured in, it is set for API keys and never read from a token. */
+type Claims struct {
+	jwt.StandardClaims
+	Roles       map[string]Role `json:"roles,omitempty"`
+	Scope       string          `json:"scope,omitempty"`
+	ScopeTenant string          `json:"-"`
+}
+
+// RoleIn returns the role of the subject in a tenant, empty when it has none
+func (c *Claims) RoleIn(tenantNamespace string) Role {
+	return c.Roles[tenantNamespace]
+}
+
+// HasScopeIn reports whether scope lets the caller act in a tenant, scopes of user tokens are not bound to a tenant and never do
+func (c *Claims) HasScopeIn(tenantNamespace string, scope string) bool {
+	return c.ScopeTenant != "" && c.ScopeTenant == tenantNamespace && c.HasScope(scope)
+}
+
+func (c *Claims) HasScope(scope string) bool {
+	for _, granted := range strings.Fields(c.Scope) {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
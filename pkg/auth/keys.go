@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StaticKeys are the keys of a PEM file, they are tried whatever kid a token names
+type StaticKeys []crypto.PublicKey
+
+func (k StaticKeys) Keys(kid string) ([]crypto.PublicKey, error) {
+	return k, nil
+}
+
+/* LoadKeyFile reads the public keys from the PEM blocks of a file.
+Public keys, certificates and private keys are accepted, only the public half of a private key is kept. */
+func LoadKeyFile(path string) (StaticKeys, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("auth: %v", err)
+	}
+	return ParseKeys(data)
+}
+
+func ParseKeys(data []byte) (StaticKeys, error) {
+	var keys StaticKeys
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		key, err := parseBlock(block)
+		if err != nil {
+			return nil, fmt.Errorf("auth: %s: %v", block.Type, err)
+		}
+		if key != nil {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("auth: no public key found")
+	}
+	return keys, nil
+}
+
+// parseBlock returns the public key of a PEM block, nil for blocks holding no key
+func parseBlock(block *pem.Block) (crypto.PublicKey, error) {
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key")
+		}
+		return signer.Public(), nil
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public(), nil
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public(), nil
+	}
+	return nil, nil
+}
+
+/* JWKS fetches the signing keys of the authentication server and caches them by kid.
+The keys are fetched again once MaxAge passed, or when a token names a kid missing from the cache,
+which happens when the server rotated its keys. Unknown kids cause a fetch at most every MinRefresh. */
+type JWKS struct {
+	URL        string
+	Client     *http.Client
+	MaxAge     time.Duration
+	MinRefresh time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func NewJWKS(url string) *JWKS {
+	return &JWKS{
+		URL:        url,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		MaxAge:     time.Hour,
+		MinRefresh: time.Minute,
+	}
+}
+
+/* Keys returns the key named kid, or every cached key for tokens without a kid.
+A failed fetch keeps serving the cached keys. */
+func (j *JWKS) Keys(kid string) ([]crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_, known := j.keys[kid]
+	age := time.Since(j.fetched)
+	if j.keys == nil || age >= j.MaxAge || (kid != "" && !known && age >= j.MinRefresh) {
+		keys, err := j.fetch()
+		if err != nil && j.keys == nil {
+			return nil, err
+		}
+		if err == nil {
+			j.keys = keys
+		}
+		// failures are retried after MinRefresh as well
+		j.fetched = time.Now()
+	}
+
+	if kid == "" {
+		var keys []crypto.PublicKey
+		for _, key := range j.keys {
+			keys = append(keys, key)
+		}
+		return keys, nil
+	}
+	key, ok := j.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return []crypto.PublicKey{key}, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetch loads the key set, keys not meant for signatures or of other types are skipped
+func (j *JWKS) fetch() (map[string]crypto.PublicKey, error) {
+	resp, err := j.Client.Get(j.URL)
+	if err != nil {
+		return nil, fmt.Errorf("auth: jwks: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth: jwks: unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return nil, fmt.Errorf("auth: jwks: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("auth: jwks key %s: %v", jwk.Kid, err)
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// publicKey decodes RSA and P-256 keys, nil for other key types
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch {
+	case k.Kty == "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,34 @@
+package pkg
+
+import (
+	"context"
+	"gitlab.com/pbobby001/postit-api/pkg/auth"
+)
+
+type contextKey string
+
+const (
+	tenantContextKey contextKey = "tenant"
+	claimsContextKey contextKey = "claims"
+)
+
+// WithTenant returns a copy of ctx carrying the tenant resolved for the request
+func WithTenant(ctx context.Context, tenant Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey, tenant)
+}
+
+// TenantFromContext returns the tenant put into ctx by the tenant middleware
+func TenantFromContext(ctx context.Context) (Tenant, bool) {
+	tenant, ok := ctx.Value(tenantContextKey).(Tenant)
+	return tenant, ok
+}
+
+// WithClaims returns a copy of ctx carrying the claims of the request's token
+func WithClaims(ctx context.Context, claims *auth.Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+func ClaimsFromContext(ctx context.Context) (*auth.Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*auth.Claims)
+	return claims, ok && claims != nil
+}
@@ -5,13 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/cristalhq/jwt"
-	"github.com/lib/pq"
 	"github.com/twinj/uuid"
-	"gitlab.com/pbobby001/postit-api/db"
+	"gitlab.com/pbobby001/postit-api/pkg/auth"
 	"gitlab.com/pbobby001/postit-api/pkg/logs"
 	"html/template"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"net/smtp"
@@ -23,22 +20,23 @@ import (
 
 /* Validate header is a function used to make sure that the required  headers are sent to the API
 It takes the http request and extracts the headers from it and returns a map of the needed headers
-and an error. Other headers are essentially ignored.*/
+and an error. Other headers are essentially ignored.
+The tenant namespace is taken from the tenant resolved by the tenant middleware, never from the raw header.*/
 func ValidateHeaders(r *http.Request) (map[string]string, error) {
 	//Group the headers
 	receivedHeaders := make(map[string]string)
-	requiredHeaders := []string{"trace-id", "tenant-namespace"}
-
-	for _, header := range requiredHeaders {
-		value := r.Header.Get(header)
-		if value != "" {
-			receivedHeaders[header] = value
-		} else if value == "" {
-			return nil, errors.New("Required header: " + header + " not found")
-		} else {
-			return nil, errors.New("no headers received be sure to send some headers")
-		}
+
+	traceId := r.Header.Get("trace-id")
+	if traceId == "" {
+		return nil, errors.New("Required header: trace-id not found")
+	}
+	receivedHeaders["trace-id"] = traceId
+
+	tenant, ok := TenantFromContext(r.Context())
+	if !ok {
+		return nil, ErrTenantNotResolved
 	}
+	receivedHeaders["tenant-namespace"] = tenant.TenantNamespace
 
 	return receivedHeaders, nil
 }
@@ -127,36 +125,6 @@ func GenerateHashTags(hashT []string) []string {
 	return hashTags
 }
 
-/* Helper function to create post */
-func CreatePost(post Post, tenantNamespace string, postId uuid.UUID) error {
-	query := fmt.Sprintf("INSERT INTO %s.post (post_id, post_message, post_image, image_extension, hash_tags, post_priority) VALUES ($1, $2, $3, $4, $5, $6)", tenantNamespace)
-	if post.PostImages == nil {
-		_, err := db.Connection.Exec(query, postId.String(), &post.PostMessage, []byte{}, "", pq.Array(&post.HashTags), &post.PostPriority)
-		if err != nil {
-			return err
-		}
-	} else if post.PostImages != nil {
-		imageExtension := ""
-		switch http.DetectContentType(post.PostImages[0]) {
-		case "image/webp":
-			imageExtension = ".webp"
-		case "image/jpeg":
-			imageExtension = ".jpeg"
-		case "image/png":
-			imageExtension = ".png"
-		default:
-			imageExtension = ".jpg"
-		}
-
-		_, err := db.Connection.Exec(query, postId.String(), &post.PostMessage, &post.PostImages, imageExtension, pq.Array(&post.HashTags), &post.PostPriority)
-		if err != nil {
-			// db error
-			return err
-		}
-	}
-	return nil
-}
-
 /* Helper function to generate the duration for each post */
 func GenerateDurationForEachPost(schedule PostSchedule) float64 {
 	totalDuration := schedule.To.Sub(schedule.From)
@@ -189,176 +157,73 @@ func SendErrorResponse(w http.ResponseWriter, tId uuid.UUID, traceId string, err
 	return
 }
 
-func WebSocketTokenValidateToken(tokenString string, tenantNamespace string) error {
-	logs.Logger.Info(tokenString)
-	logs.Logger.Info(tenantNamespace)
+// RefreshedTokenHeader carries the token the authentication server issued for an expired one back to the client
+const RefreshedTokenHeader = "refresh-token"
 
-	jwtToken, err := jwt.Parse([]byte(tokenString))
-	if err != nil {
-		return err
-	}
+var ErrRefreshSubject = errors.New("the refreshed token belongs to another subject")
 
-	var jwtClaims *jwt.StandardClaims
-	claims := jwtToken.RawClaims()
-	err = json.Unmarshal(claims, &jwtClaims)
+/* ValidateToken verifies the signature and the claims of a token with the verifier configured in pkg/auth,
+audience holds the audiences the token may be meant for.
+Expired tokens are exchanged at the authentication server, the refreshed token is verified the same way
+and returned along with its claims, refreshed is empty when the token was still valid. */
+func ValidateToken(tokenString string, audience ...string) (claims *auth.Claims, refreshed string, err error) {
+	verifier, err := auth.Default()
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
-	//var newToken string
-	if jwtClaims.ExpiresAt.Time().Before(time.Now()) {
-		logs.Logger.Info("Token expired! getting a new one....")
-
-		client := &http.Client{}
-		req, err := http.NewRequest(http.MethodPost, os.Getenv("AUTHENTICATION_SERVER_URL")+"/refresh-token", nil)
-		if err != nil {
-			return err
-		}
-
-		req.Header.Set("token", tokenString)
-		resp, err := client.Do(req)
-		if err != nil {
-			return err
-		}
-
-		logs.Logger.Info("refresh-token: ", resp.Header.Get("refresh-token"))
-	}
-
-	validator := jwt.NewValidator(
-		jwt.AudienceChecker([]string{"postit-audience", tenantNamespace}),
-	)
-
-	if jwtClaims.Audience[0] != tenantNamespace {
-		return errors.New("invalid tenant namespace")
+	claims, err = verifier.Verify(tokenString, audience...)
+	if err != auth.ErrExpired {
+		return claims, "", err
 	}
 
-	err = validator.Validate(jwtClaims)
+	logs.Logger.Info("Token expired! getting a new one....")
+	refreshed, err = refreshToken(tokenString)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
-
-	return nil
-}
-
-/*
-updatePost function
-1. compare the length of the incoming post images list and the one in the db
-2. if they are different insert the incoming post images
-3. finally check if a new image has been uploaded
-4. if it has append them to the incoming post images list and insert the final value  in the db
-*/
-func updatePost(tenantNamespace string, err error, uPostId *uuid.UUID, post *Post) error {
-	var images [][]byte
-	var paths []string
-	query := fmt.Sprintf("SELECT post_images, image_paths FROM %s.post WHERE post_id = $1", tenantNamespace)
-	err = db.Connection.QueryRow(query, uPostId.String()).Scan(pq.Array(&images), pq.Array(&paths))
+	refreshedClaims, err := verifier.Verify(refreshed, audience...)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
-
-	// (1)
-	if len(images) != len(post.PostImages) {
-		query := fmt.Sprintf("UPDATE %s.post SET post_message = $1, hash_tags = $2, post_priority = $3, post_images = $4, image_paths = $5 WHERE post_id = $6", tenantNamespace)
-		_, err = db.Connection.Exec(query, &post.PostMessage, pq.Array(&post.HashTags), &post.PostPriority, pq.Array(&post.PostImages), pq.Array(&post.ImagePaths), &post.PostId)
-		if err != nil {
-			return err
-		}
+	if refreshedClaims.Subject != claims.Subject {
+		return nil, "", ErrRefreshSubject
 	}
+	return refreshedClaims, refreshed, nil
+}
 
-	logs.Logger.Infof("length of images: %v, length of postImages: %v", len(images), len(post.PostImages))
-	/*
-		(2)
-		get the working  directory
-	*/
-	wd, err := os.Getwd()
+// refreshToken exchanges an expired token at AUTHENTICATION_SERVER_URL, the new token comes back in its refresh-token header
+func refreshToken(tokenString string) (string, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	req, err := http.NewRequest(http.MethodPost, os.Getenv("AUTHENTICATION_SERVER_URL")+"/refresh-token", nil)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	/*
-		use that with the tenantNamespace to create a path to where the images might be
-	*/
-	path := filepath.Join(wd, "pkg/"+tenantNamespace)
-	logs.Logger.Info(path)
-
-	fileInfo, err := ioutil.ReadDir(path)
+	req.Header.Set("token", tokenString)
+	resp, err := client.Do(req)
 	if err != nil {
-		if os.IsNotExist(err) {
-			_ = logs.Logger.Warn(err)
-		} else {
-			return err
-		}
+		return "", err
 	}
+	_ = resp.Body.Close()
 
-	logs.Logger.Info(fileInfo)
-	// (3)
-	if fileInfo != nil {
-		for _, file := range fileInfo {
-			logs.Logger.Info(file.Name())
-
-			if file.Name() == "f.json" {
-				continue
-			}
-
-			fileLocation := filepath.Join(path, file.Name())
-
-			openImage, err := os.Open(fileLocation)
-			if err != nil {
-				return err
-			}
-
-			imageBytes, err := ioutil.ReadAll(openImage)
-			if err != nil {
-				return err
-			}
-			err = openImage.Close()
-			if err != nil {
-				return err
-			}
-			post.PostImages = append(post.PostImages, imageBytes)
-
-			jsonFile, err := os.Open(filepath.Join(path, "f.json"))
-			if err != nil {
-				return err
-			}
-
-			readFile, err := ioutil.ReadAll(jsonFile)
-			if err != nil {
-				return err
-			}
-			err = jsonFile.Close()
-			if err != nil {
-				return err
-			}
-
-			fileData := make(map[string]string)
-			err = json.Unmarshal(readFile, &fileData)
-			if err != nil {
-				return err
-			}
-
-			imagePath := fileData[file.Name()]
-			logs.Logger.Info(imagePath)
-			post.ImagePaths = append(post.ImagePaths, imagePath)
-		}
-		err = os.RemoveAll(path)
-		if err != nil {
-			return err
-		}
-
-		logs.Logger.Infof("new postImages length: %v", len(post.PostImages))
+	refreshed := resp.Header.Get("refresh-token")
+	if resp.StatusCode != http.StatusOK || refreshed == "" {
+		return "", auth.ErrExpired
 	}
-	// (4)
-	query = fmt.Sprintf("UPDATE %s.post SET post_message = $1, hash_tags = $2, post_priority = $3, post_images = $4, image_paths = $5 WHERE post_id = $6", tenantNamespace)
-	logs.Logger.Info(query)
-	_, err = db.Connection.Exec(query, &post.PostMessage, pq.Array(&post.HashTags), &post.PostPriority, pq.Array(&post.PostImages), pq.Array(&post.ImagePaths), uPostId)
+	return refreshed, nil
+}
+
+// WebSocketTokenValidateToken checks the token of a websocket handshake like JWTMiddleware, tenantNamespace must be its first audience
+func WebSocketTokenValidateToken(tokenString string, tenantNamespace string) error {
+	// the handshake has no way to hand a refreshed token back, the client refreshes it on its next request
+	claims, _, err := ValidateToken(tokenString, "postit-audience", tenantNamespace)
 	if err != nil {
 		return err
 	}
-	return nil
-}
 
-func Update(tenantNamespace string, err error, uPostId *uuid.UUID, post *Post) error {
-	err = updatePost(tenantNamespace, err, uPostId, post)
-	return err
+	if len(claims.Audience) == 0 || claims.Audience[0] != tenantNamespace {
+		return errors.New("invalid tenant namespace")
+	}
+	return nil
 }
@@ -16,7 +16,9 @@ func TestValidateHeaders(t *testing.T) {
 	traceId := uuid.NewV4().String()
 	tenantNamespace := "postit"
 	req.Header.Add("trace-id", traceId)
-	req.Header.Add("tenant-namespace", tenantNamespace)
+	// only the tenant resolved by the middleware counts, the raw header is ignored
+	req.Header.Add("tenant-namespace", "other")
+	req = req.WithContext(WithTenant(req.Context(), Tenant{TenantNamespace: tenantNamespace, Status: TenantActive}))
 
 	headers, err := ValidateHeaders(req)
 	if err != nil {
@@ -31,6 +33,20 @@ func TestValidateHeaders(t *testing.T) {
 	}
 }
 
+func TestValidateHeadersWithoutTenant(t *testing.T) {
+	req, err := http.NewRequest("GET", "/health-check", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("trace-id", uuid.NewV4().String())
+	req.Header.Add("tenant-namespace", "postit")
+
+	_, err = ValidateHeaders(req)
+	if err != ErrTenantNotResolved {
+		t.Fatalf("expected ErrTenantNotResolved got %v", err)
+	}
+}
+
 func TestGenerateHashTags(t *testing.T) {
 	newHash := GenerateHashTags([]string{"a", "b"})
 	expected := []string{"#a", "#b"}
@@ -9,6 +9,8 @@ import (
 var Logger see.LoggerInterface
 
 func init() {
+	// logging is disabled until the log files are created, they are missing outside the repository root as in tests
+	Logger = see.Disabled
 
 	common := "pkg/logs/common.log"
 	critical := "pkg/logs/critical.log"
@@ -43,7 +45,6 @@ func init() {
 		return
 	}
 
-	Logger = see.Disabled
 	loadAppConfig()
 }
 
@@ -0,0 +1,244 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/twinj/uuid"
+	"gitlab.com/pbobby001/postit-api/db"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Upload error codes
+const (
+	UploadMissingFile      = "MISSING_FILE"
+	UploadFileTooBig       = "FILE_TOO_BIG"
+	UploadUnsupportedMedia = "UNSUPPORTED_MEDIA"
+	UploadInvalidImage     = "INVALID_IMAGE"
+	UploadInvalidVideo     = "INVALID_VIDEO"
+	UploadLimitExceeded    = "MEDIA_LIMIT_EXCEEDED"
+	UploadFailed           = "UPLOAD_FAILED"
+	UploadBusy             = "UPLOADS_BUSY"
+)
+
+// Media kinds
+const (
+	MediaImage     = "image"
+	MediaVideo     = "video"
+	MediaAnimation = "animation"
+)
+
+/* MediaLimits holds the video and animated gif limits of every network.
+Uploads have to fit at least one network, a post is only published to the networks all of its media fit. */
+var MediaLimits = map[string]MediaLimit{
+	"facebook": {
+		MaxVideoSize:     1 << 30,
+		MinVideoDuration: 1,
+		MaxVideoDuration: 240 * 60,
+		VideoCodecs:      []string{"avc1", "avc3", "hvc1", "hev1", "mp4v"},
+		MaxAnimationSize: 25 << 20,
+	},
+	"twitter": {
+		MaxVideoSize:     512 << 20,
+		MinVideoDuration: 0.5,
+		MaxVideoDuration: 140,
+		VideoCodecs:      []string{"avc1", "avc3"},
+		MaxAnimationSize: 15 << 20,
+	},
+	"linked_in": {
+		MaxVideoSize:     200 << 20,
+		MinVideoDuration: 3,
+		MaxVideoDuration: 10 * 60,
+		VideoCodecs:      []string{"avc1", "avc3", "mp4v"},
+		MaxAnimationSize: 8 << 20,
+	},
+}
+
+// CheckLimits tells why network does not take media, images are always taken
+func CheckLimits(network string, media Media) error {
+	limit, ok := MediaLimits[network]
+	if !ok || media.Kind == MediaImage || media.Kind == "" {
+		return nil
+	}
+	exceeded := func(format string, args ...interface{}) error {
+		return &UploadError{Code: UploadLimitExceeded, Message: network + ": " + fmt.Sprintf(format, args...)}
+	}
+
+	if media.Kind == MediaAnimation {
+		if limit.MaxAnimationSize > 0 && media.Size > limit.MaxAnimationSize {
+			return exceeded("animations may be %d bytes at most", limit.MaxAnimationSize)
+		}
+		return nil
+	}
+
+	if limit.MaxVideoSize > 0 && media.Size > limit.MaxVideoSize {
+		return exceeded("videos may be %d bytes at most", limit.MaxVideoSize)
+	}
+	if media.Duration < limit.MinVideoDuration {
+		return exceeded("videos have to run for %gs at least", limit.MinVideoDuration)
+	}
+	if limit.MaxVideoDuration > 0 && media.Duration > limit.MaxVideoDuration {
+		return exceeded("videos may run for %gs at most", limit.MaxVideoDuration)
+	}
+	if len(limit.VideoCodecs) > 0 {
+		for _, codec := range limit.VideoCodecs {
+			if codec == media.Codec {
+				return nil
+			}
+		}
+		return exceeded("%s videos are not supported", media.Codec)
+	}
+	return nil
+}
+
+// MediaNetworks returns the networks that take media
+func MediaNetworks(media Media) []string {
+	var networks []string
+	for network := range MediaLimits {
+		if CheckLimits(network, media) == nil {
+			networks = append(networks, network)
+		}
+	}
+	sort.Strings(networks)
+	return networks
+}
+
+// Upload job statuses
+const (
+	JobProcessing = "processing"
+	JobDone       = "done"
+	JobFailed     = "failed"
+)
+
+func (e *UploadError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// NewMedia describes data as a new media record, the content type is sniffed from the data
+func NewMedia(data []byte) Media {
+	checksum := sha256.Sum256(data)
+	return Media{
+		MediaId:     uuid.NewV4().String(),
+		ContentType: http.DetectContentType(data),
+		Size:        int64(len(data)),
+		Kind:        MediaImage,
+		Checksum:    hex.EncodeToString(checksum[:]),
+		CreatedAt:   time.Now(),
+	}
+}
+
+// MediaUrl is the path the media record is served from, fetching it needs the credentials of the caller
+func MediaUrl(mediaId string) string {
+	return "/media/" + mediaId
+}
+
+// MediaRefs references the media records of a post by id and url
+func MediaRefs(mediaIds []string) []MediaRef {
+	refs := []MediaRef{}
+	for _, mediaId := range mediaIds {
+		refs = append(refs, MediaRef{Id: mediaId, Url: MediaUrl(mediaId)})
+	}
+	return refs
+}
+
+// MediaIds returns the ids of the referenced media records
+func MediaIds(refs []MediaRef) []string {
+	ids := []string{}
+	for _, ref := range refs {
+		ids = append(ids, ref.Id)
+	}
+	return ids
+}
+
+/* MediaKey is the key the content of a blob is stored under.
+Blobs are named after the media record that stored them first, media records created before blobs existed are their own blob. */
+func MediaKey(tenantNamespace string, blobId string) (string, error) {
+	return storage.Key(tenantNamespace, "media", blobId)
+}
+
+/* Renditions lists the sizes produced for every uploaded image, the original is kept as well.
+The preview is what the ui shows, the others are picked by the publisher of their network. */
+var Renditions = []RenditionSpec{
+	{Name: "preview", Width: 500},
+	{Name: "facebook_feed", Network: "facebook", Width: 1200, Height: 630, Crop: true},
+	{Name: "twitter_card", Network: "twitter", Width: 1200, Height: 675, Crop: true},
+	{Name: "linkedin_share", Network: "linked_in", Width: 1200, Height: 627, Crop: true},
+}
+
+// PosterRendition is the still image shown for videos and animations
+var PosterRendition = RenditionSpec{Name: "poster", Width: 1280, Height: 1280}
+
+// RenditionFor returns the rendition of an image published to network, videos and animations are published as they are
+func RenditionFor(network string) (RenditionSpec, bool) {
+	for _, spec := range Renditions {
+		if spec.Network == network {
+			return spec, true
+		}
+	}
+	return RenditionSpec{}, false
+}
+
+// RenditionKey is the key the content of a rendition of a blob is stored under
+func RenditionKey(tenantNamespace string, blobId string, name string) (string, error) {
+	return storage.Key(tenantNamespace, "renditions", blobId, name)
+}
+
+func RenditionUrl(mediaId string, name string) string {
+	return MediaUrl(mediaId) + "?rendition=" + name
+}
+
+/* MoveMediaToStore copies the content still kept in the media table into store and clears it in the table.
+It returns the number of media records moved. */
+func MoveMediaToStore(store storage.MediaStore, tenantNamespace string) (int, error) {
+	query := fmt.Sprintf("SELECT media_id, content_type FROM %s.media WHERE data IS NOT NULL", pq.QuoteIdentifier(tenantNamespace))
+	rows, err := db.Connection.Query(query)
+	if err != nil {
+		return 0, err
+	}
+
+	var media []Media
+	for rows.Next() {
+		var m Media
+		err = rows.Scan(&m.MediaId, &m.ContentType)
+		if err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		media = append(media, m)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// one record at a time keeps large images from piling up in memory
+	for i, m := range media {
+		var data []byte
+		query = fmt.Sprintf("SELECT data FROM %s.media WHERE media_id = $1", pq.QuoteIdentifier(tenantNamespace))
+		err = db.Connection.QueryRow(query, m.MediaId).Scan(&data)
+		if err != nil {
+			return i, err
+		}
+
+		key, err := MediaKey(tenantNamespace, m.MediaId)
+		if err != nil {
+			return i, err
+		}
+		err = store.Put(key, data, m.ContentType)
+		if err != nil {
+			return i, err
+		}
+
+		query = fmt.Sprintf("UPDATE %s.media SET data = NULL WHERE media_id = $1", pq.QuoteIdentifier(tenantNamespace))
+		_, err = db.Connection.Exec(query, m.MediaId)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(media), nil
+}
+
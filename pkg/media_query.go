@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	/* MediaQuery selects a page of the media library, newest first.
+	Empty filters are ignored and After is the cursor returned with the previous page. */
+	MediaQuery struct {
+		Limit  int
+		After  *MediaCursor
+		Tag    string
+		Search string
+		Kind   string
+	}
+
+	// MediaCursor points at the last media record of a page
+	MediaCursor struct {
+		CreatedAt time.Time `json:"v"`
+		MediaId   string    `json:"id"`
+	}
+
+	MediaPage struct {
+		Media      []Media
+		NextCursor string
+		Total      int
+	}
+)
+
+/* ParseMediaQuery reads the paging and filter parameters of GET /media.
+limit defaults to 50 and is capped at 200, tag matches one of the tags and q is searched for in the names. */
+func ParseMediaQuery(values url.Values) (MediaQuery, error) {
+	query := MediaQuery{
+		Limit:  DefaultPostLimit,
+		Tag:    NormalizeTag(values.Get("tag")),
+		Search: strings.TrimSpace(values.Get("q")),
+		Kind:   values.Get("kind"),
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return query, fmt.Errorf("limit must be a positive number")
+		}
+		if n > MaxPostLimit {
+			n = MaxPostLimit
+		}
+		query.Limit = n
+	}
+
+	if query.Kind != "" && query.Kind != MediaImage && query.Kind != MediaVideo && query.Kind != MediaAnimation {
+		return query, fmt.Errorf("kind must be %s, %s or %s", MediaImage, MediaVideo, MediaAnimation)
+	}
+
+	if after := values.Get("after"); after != "" {
+		cursor, err := DecodeMediaCursor(after)
+		if err != nil {
+			return query, err
+		}
+		query.After = &cursor
+	}
+	return query, nil
+}
+
+// Matches tells whether media passes the filters of the query, the cursor is not looked at
+func (q MediaQuery) Matches(media Media) bool {
+	if q.Kind != "" && media.Kind != q.Kind {
+		return false
+	}
+	if q.Search != "" && !strings.Contains(strings.ToLower(media.Name), strings.ToLower(q.Search)) {
+		return false
+	}
+	if q.Tag == "" {
+		return true
+	}
+	for _, tag := range media.Tags {
+		if tag == q.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// NextCursor points after media, the last media record of the current page
+func (q MediaQuery) NextCursor(media Media) string {
+	return MediaCursor{CreatedAt: media.CreatedAt, MediaId: media.MediaId}.Encode()
+}
+
+// Encode returns the opaque form of the cursor handed out to clients
+func (c MediaCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeMediaCursor(value string) (MediaCursor, error) {
+	var cursor MediaCursor
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	err = json.Unmarshal(b, &cursor)
+	if err != nil || cursor.MediaId == "" {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// NormalizeTag lowercases a library tag, a leading # is dropped
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// NormalizeTags normalizes the tags and drops empty and repeated ones
+func NormalizeTags(tags []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
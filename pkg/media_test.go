@@ -0,0 +1,22 @@
+package pkg
+
+import "testing"
+
+func TestCheckLimits(t *testing.T) {
+	video := Media{Kind: MediaVideo, Size: 1 << 20, Duration: 200, Codec: "avc1"}
+	if networks := MediaNetworks(video); len(networks) != 2 || networks[0] != "facebook" || networks[1] != "linked_in" {
+		t.Fatalf("expected twitter to refuse videos longer than 140s got %v", networks)
+	}
+	video.Codec = "hvc1"
+	if err := CheckLimits("linked_in", video); err == nil {
+		t.Fatal("expected linkedin to refuse hevc videos")
+	}
+
+	animation := Media{Kind: MediaAnimation, Size: 20 << 20}
+	if networks := MediaNetworks(animation); len(networks) != 1 || networks[0] != "facebook" {
+		t.Fatalf("unexpected networks %v", networks)
+	}
+	if err := CheckLimits("twitter", Media{Kind: MediaImage, Size: 1 << 30}); err != nil {
+		t.Fatalf("expected images to be taken got %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+package pkg
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/lib/pq"
+	"gitlab.com/pbobby001/postit-api/db"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"time"
+)
+
+const (
+	// Time allowed to write a message to the peer
+	writeWait = 10 * time.Second
+	// Time allowed to read the next pong message from the peer
+	pongWait = 60 * time.Second
+	// Send pings to peer with this period. Must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+	// Maximum message size allowed from peer
+	maxMessageSize = 4096
+)
+
+// ScheduleStatusHub is the message controller behind /pws/schedule-status
+var ScheduleStatusHub = NewMessageController()
+
+func NewMessageController() *MessageController {
+	return &MessageController{
+		Clients:    make(map[string]map[*Client]bool),
+		Broadcast:  make(chan TenantMessage),
+		Register:   make(chan *Client),
+		Unregister: make(chan *Client),
+		sent:       make(map[string]map[string][]byte),
+	}
+}
+
+/* Run registers and unregisters clients and fans out broadcast messages to the
+clients of the tenant they are addressed to. It must be started once in its own goroutine. */
+func (h *MessageController) Run() {
+	for {
+		select {
+		case client := <-h.Register:
+			h.mu.Lock()
+			if h.Clients[client.TenantNamespace] == nil {
+				h.Clients[client.TenantNamespace] = make(map[*Client]bool)
+			}
+			h.Clients[client.TenantNamespace][client] = true
+			h.mu.Unlock()
+			logs.Logger.Infof("client %s registered for %s", client.Id, client.TenantNamespace)
+
+		case client := <-h.Unregister:
+			h.mu.Lock()
+			h.removeClient(client)
+			h.mu.Unlock()
+			logs.Logger.Infof("client %s unregistered from %s", client.Id, client.TenantNamespace)
+
+		case message := <-h.Broadcast:
+			h.mu.Lock()
+			for client := range h.Clients[message.TenantNamespace] {
+				select {
+				case client.Send <- message.Data:
+				default:
+					// the client is not keeping up so drop it
+					h.removeClient(client)
+				}
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// removeClient must be called with the lock held
+func (h *MessageController) removeClient(client *Client) {
+	clients, ok := h.Clients[client.TenantNamespace]
+	if !ok {
+		return
+	}
+	if _, ok := clients[client]; ok {
+		delete(clients, client)
+		close(client.Send)
+	}
+	// new clients get a snapshot, nothing is left to compare with once the last one is gone
+	if len(clients) == 0 {
+		delete(h.Clients, client.TenantNamespace)
+		delete(h.sent, client.TenantNamespace)
+	}
+}
+
+// Namespaces returns the tenants that currently have at least one client connected
+func (h *MessageController) Namespaces() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var namespaces []string
+	for namespace := range h.Clients {
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces
+}
+
+/* NotifyScheduleStatus pushes a schedule status frame to every client of the tenant.
+Frames identical to the last one pushed for the same schedule are skipped. */
+func (h *MessageController) NotifyScheduleStatus(tenantNamespace string, status ScheduleStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	if bytes.Equal(h.sent[tenantNamespace][status.ScheduleId], data) {
+		h.mu.Unlock()
+		return nil
+	}
+	if h.sent[tenantNamespace] == nil {
+		h.sent[tenantNamespace] = make(map[string][]byte)
+	}
+	h.sent[tenantNamespace][status.ScheduleId] = data
+	h.mu.Unlock()
+
+	h.Broadcast <- TenantMessage{TenantNamespace: tenantNamespace, Data: data}
+	return nil
+}
+
+// Forget drops the last frame pushed for a schedule, it is called once the schedule completed or was deleted
+func (h *MessageController) Forget(tenantNamespace string, scheduleId string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.sent[tenantNamespace], scheduleId)
+	if len(h.sent[tenantNamespace]) == 0 {
+		delete(h.sent, tenantNamespace)
+	}
+}
+
+/* Watch periodically rebuilds the schedule statuses of every tenant with a connected
+client and pushes the ones that changed. This picks up posts published outside this process. */
+func (h *MessageController) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, namespace := range h.Namespaces() {
+			statuses, err := FetchScheduleStatuses(namespace)
+			if err != nil {
+				_ = logs.Logger.Error(err)
+				continue
+			}
+
+			for _, status := range statuses {
+				err = h.NotifyScheduleStatus(namespace, status)
+				if err != nil {
+					_ = logs.Logger.Error(err)
+				}
+			}
+		}
+	}
+}
+
+// ReadPump keeps the connection alive and unregisters the client once it goes away
+func (c *Client) ReadPump() {
+	defer func() {
+		c.Hub.Unregister <- c
+		_ = c.Conn.Close()
+	}()
+
+	c.Conn.SetReadLimit(maxMessageSize)
+	_ = c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		return c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		// clients are not expected to send anything after the handshake
+		_, _, err := c.Conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				_ = logs.Logger.Warn(err)
+			}
+			return
+		}
+	}
+}
+
+// WritePump forwards messages from the hub to the websocket connection
+func (c *Client) WritePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		_ = c.Conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.Send:
+			_ = c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// The hub closed the channel.
+				_ = c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			err := c.Conn.WriteMessage(websocket.TextMessage, message)
+			if err != nil {
+				_ = logs.Logger.Warn(err)
+				return
+			}
+
+		case <-ticker.C:
+			_ = c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+/* FetchScheduleStatuses builds the publishing status of the latest schedules of a tenant.
+A post counts as published once it has gone out to at least one network. */
+func FetchScheduleStatuses(tenantNamespace string) ([]ScheduleStatus, error) {
+	query := fmt.Sprintf("SELECT schedule_id, schedule_title, schedule_from, schedule_to, post_ids, created_at, updated_at FROM %s.schedule ORDER BY updated_at DESC LIMIT 200", pq.QuoteIdentifier(tenantNamespace))
+	return fetchScheduleStatuses(tenantNamespace, query)
+}
+
+// FetchScheduleStatus builds the publishing status of a single schedule
+func FetchScheduleStatus(tenantNamespace string, scheduleId string) (ScheduleStatus, error) {
+	query := fmt.Sprintf("SELECT schedule_id, schedule_title, schedule_from, schedule_to, post_ids, created_at, updated_at FROM %s.schedule WHERE schedule_id = $1", pq.QuoteIdentifier(tenantNamespace))
+	statuses, err := fetchScheduleStatuses(tenantNamespace, query, scheduleId)
+	if err != nil {
+		return ScheduleStatus{}, err
+	}
+	if len(statuses) == 0 {
+		return ScheduleStatus{}, sql.ErrNoRows
+	}
+	return statuses[0], nil
+}
+
+func fetchScheduleStatuses(tenantNamespace string, query string, args ...interface{}) ([]ScheduleStatus, error) {
+	rows, err := db.Connection.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statuses []ScheduleStatus
+	var postIds [][]string
+	for rows.Next() {
+		var status ScheduleStatus
+		var ids []string
+		err = rows.Scan(
+			&status.ScheduleId,
+			&status.ScheduleTitle,
+			&status.From,
+			&status.To,
+			pq.Array(&ids),
+			&status.CreatedAt,
+			&status.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		status.TotalPost = len(ids)
+		statuses = append(statuses, status)
+		postIds = append(postIds, ids)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range statuses {
+		statuses[i].Posts, err = fetchScheduledPosts(tenantNamespace, postIds[i])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, post := range statuses[i].Posts {
+			if post.PostStatus {
+				statuses[i].PostCount++
+			}
+		}
+	}
+
+	return statuses, nil
+}
+
+func fetchScheduledPosts(tenantNamespace string, postIds []string) ([]ScheduledPost, error) {
+	posts := []ScheduledPost{}
+	if len(postIds) == 0 {
+		return posts, nil
+	}
+
+	query := fmt.Sprintf("SELECT post_id, post_message, image_paths, hash_tags, post_fb_status, post_tw_status, post_li_status, post_priority, created_at, updated_at FROM %s.post WHERE post_id = ANY($1::uuid[])", pq.QuoteIdentifier(tenantNamespace))
+	rows, err := db.Connection.Query(query, pq.Array(postIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var post ScheduledPost
+		var fb, tw, li *bool
+		err = rows.Scan(
+			&post.PostId,
+			&post.PostMessage,
+			pq.Array(&post.ImagePaths),
+			pq.Array(&post.HashTags),
+			&fb,
+			&tw,
+			&li,
+			&post.PostPriority,
+			&post.CreatedOn,
+			&post.UpdatedOn,
+		)
+		if err != nil {
+			return nil, err
+		}
+		post.PostStatus = (fb != nil && *fb) || (tw != nil && *tw) || (li != nil && *li)
+		posts = append(posts, post)
+	}
+
+	return posts, rows.Err()
+}
@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMessageControllerNotifyScheduleStatus(t *testing.T) {
+	hub := NewMessageController()
+	go hub.Run()
+
+	tenantClient := &Client{Id: "a", TenantNamespace: "postit", Hub: hub, Send: make(chan []byte, 4)}
+	otherClient := &Client{Id: "b", TenantNamespace: "other", Hub: hub, Send: make(chan []byte, 4)}
+	hub.Register <- tenantClient
+	hub.Register <- otherClient
+
+	status := ScheduleStatus{ScheduleId: "s1", TotalPost: 2, PostCount: 1}
+	if err := hub.NotifyScheduleStatus("postit", status); err != nil {
+		t.Fatal(err)
+	}
+	// an unchanged frame must not be pushed twice
+	if err := hub.NotifyScheduleStatus("postit", status); err != nil {
+		t.Fatal(err)
+	}
+	status.PostCount = 2
+	if err := hub.NotifyScheduleStatus("postit", status); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []int{1, 2} {
+		select {
+		case frame := <-tenantClient.Send:
+			var received ScheduleStatus
+			if err := json.Unmarshal(frame, &received); err != nil {
+				t.Fatal(err)
+			}
+			if received.PostCount != expected {
+				t.Fatalf("expected post count %d got %d", expected, received.PostCount)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("frame not received")
+		}
+	}
+
+	select {
+	case frame := <-tenantClient.Send:
+		t.Fatalf("unexpected frame %s", frame)
+	case frame := <-otherClient.Send:
+		t.Fatalf("frame leaked to another tenant %s", frame)
+	default:
+	}
+}
+
+func TestMessageControllerForget(t *testing.T) {
+	hub := NewMessageController()
+	go hub.Run()
+
+	client := &Client{Id: "a", TenantNamespace: "postit", Hub: hub, Send: make(chan []byte, 4)}
+	hub.Register <- client
+
+	status := ScheduleStatus{ScheduleId: "s1", TotalPost: 1, PostCount: 1}
+	if err := hub.NotifyScheduleStatus("postit", status); err != nil {
+		t.Fatal(err)
+	}
+	<-client.Send
+	hub.Forget("postit", "s1")
+
+	hub.mu.RLock()
+	remaining := len(hub.sent)
+	hub.mu.RUnlock()
+	if remaining != 0 {
+		t.Fatalf("expected the frames of the schedule to be dropped got %d tenants", remaining)
+	}
+
+	// the frames of a tenant go along with its last client
+	if err := hub.NotifyScheduleStatus("postit", status); err != nil {
+		t.Fatal(err)
+	}
+	<-client.Send
+	hub.Unregister <- client
+	deadline := time.Now().Add(time.Second)
+	for {
+		hub.mu.RLock()
+		remaining = len(hub.sent)
+		hub.mu.RUnlock()
+		if remaining == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the frames of the tenant to be dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUIOrigin(t *testing.T) {
+	if !UIOrigin("https://postit-ui.herokuapp.com") || UIOrigin("https://evil.example.com") {
+		t.Fatal("expected only the default UI origins to be allowed")
+	}
+
+	previous := os.Getenv("UI_ORIGINS")
+	_ = os.Setenv("UI_ORIGINS", "https://app.postit.io, https://admin.postit.io")
+	defer os.Setenv("UI_ORIGINS", previous)
+	if !UIOrigin("https://admin.postit.io") || UIOrigin("https://postit-ui.herokuapp.com") {
+		t.Fatal("expected UI_ORIGINS to replace the default origins")
+	}
+}
@@ -1,8 +1,10 @@
 package pkg
 
 import (
+	"encoding/json"
 	"github.com/gorilla/websocket"
 	"github.com/twinj/uuid"
+	"sync"
 	"time"
 )
 
@@ -20,29 +22,171 @@ type (
 		ApplicationSecret string
 		ApplicationUrl    string
 		UserAccessToken   string
+		RefreshToken      string
 		ExpiresIn         string
 		UserId            string
 		UserName          string
-		CreatedAt         time.Time
-		UpdatedAt         time.Time
+		// nil when the network issued a token that does not expire
+		TokenExpiresAt *time.Time
+		TokenStatus    string
+		CreatedAt      time.Time
+		UpdatedAt      time.Time
 	}
 
 	TwitterPostitUserData struct {
-		Username    string `json:"username"`
-		UserId      string `json:"user_id"`
-		AccessToken string `json:"access_token"`
+		Username         string     `json:"username"`
+		UserId           string     `json:"user_id"`
+		TokenFingerprint string     `json:"token_fingerprint"`
+		ExpiresAt        *time.Time `json:"expires_at"`
+		TokenStatus      string     `json:"token_status"`
 	}
 
 	LinkedInPostitUserData struct {
-		Username    string `json:"username"`
-		UserId      string `json:"user_id"`
-		AccessToken string `json:"access_token"`
+		Username         string     `json:"username"`
+		UserId           string     `json:"user_id"`
+		TokenFingerprint string     `json:"token_fingerprint"`
+		ExpiresAt        *time.Time `json:"expires_at"`
+		TokenStatus      string     `json:"token_status"`
+		// person or organization, organizations are company pages
+		AccountType string `json:"account_type"`
 	}
 
 	FacebookPostitUserData struct {
-		Username    string `json:"username"`
-		UserId      string `json:"user_id"`
-		AccessToken string `json:"access_token"`
+		Username         string     `json:"username"`
+		UserId           string     `json:"user_id"`
+		TokenFingerprint string     `json:"token_fingerprint"`
+		ExpiresAt        *time.Time `json:"expires_at"`
+		TokenStatus      string     `json:"token_status"`
+	}
+
+	Tenant struct {
+		TenantNamespace string    `json:"tenant_namespace"`
+		Name            string    `json:"name"`
+		Status          string    `json:"status"`
+		CreatedAt       time.Time `json:"created_at"`
+		UpdatedAt       time.Time `json:"updated_at"`
+	}
+
+	// Media describes a stored file, the data itself is served from GET /media/{id}
+	Media struct {
+		MediaId string `json:"id"`
+		// Name is the file name of the upload until the media is renamed in the library
+		Name        string   `json:"name"`
+		Tags        []string `json:"tags"`
+		ContentType string   `json:"content_type"`
+		Size        int64    `json:"size"`
+		Width       int      `json:"width"`
+		Height      int      `json:"height"`
+		// Kind is one of the Media* kinds, Duration is given in seconds for videos and animations
+		Kind     string  `json:"kind"`
+		Duration float64 `json:"duration,omitempty"`
+		// Codec is the video codec named in the container, e.g. avc1
+		Codec    string `json:"codec,omitempty"`
+		Checksum string `json:"checksum"`
+		// BlobId names the stored content, media records with the same checksum share it
+		BlobId string `json:"-"`
+		// UploadedBy is the subject of the token the file was uploaded with
+		UploadedBy string `json:"-"`
+		// Attached is set once a post uses the media, unattached uploads are collected after a while
+		Attached bool `json:"attached"`
+		// Renditions are the sizes produced for the networks, see Renditions
+		Renditions []Rendition `json:"renditions"`
+		// PostIds are the posts using the media
+		PostIds   []string  `json:"post_ids"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	// MediaUpdate renames or tags a media record of the library, nil fields are left alone
+	MediaUpdate struct {
+		Name *string  `json:"name"`
+		Tags []string `json:"tags"`
+	}
+
+	// ApiKey lets a server call the API of a tenant without a user token, limited to its scopes
+	ApiKey struct {
+		KeyId string `json:"id"`
+		Name  string `json:"name"`
+		// Prefix is the start of the key, it tells keys apart in listings
+		Prefix string `json:"prefix"`
+		// Key is only set in the response creating the key, only its Hash is stored
+		Key        string     `json:"key,omitempty"`
+		Hash       string     `json:"-"`
+		Scopes     []string   `json:"scopes"`
+		CreatedBy  string     `json:"created_by"`
+		CreatedAt  time.Time  `json:"created_at"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+		RevokedAt  *time.Time `json:"revoked_at"`
+	}
+
+	ApiKeyRequest struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	// RenditionSpec describes a size a network expects, Crop fills the box and cuts the overflow, otherwise the image is fit into it
+	RenditionSpec struct {
+		Name    string
+		Network string
+		Width   int
+		Height  int
+		Crop    bool
+	}
+
+	// Rendition is a copy of a media record scaled to a RenditionSpec
+	Rendition struct {
+		Name        string `json:"name"`
+		Network     string `json:"network,omitempty"`
+		ContentType string `json:"content_type"`
+		Width       int    `json:"width"`
+		Height      int    `json:"height"`
+		Size        int64  `json:"size"`
+		Url         string `json:"url"`
+	}
+
+	// MediaLimit is what a network accepts, zero values are not limited
+	MediaLimit struct {
+		MaxVideoSize     int64
+		MinVideoDuration float64
+		MaxVideoDuration float64
+		VideoCodecs      []string
+		MaxAnimationSize int64
+	}
+
+	MediaRef struct {
+		Id  string `json:"id"`
+		Url string `json:"url"`
+	}
+
+	// UploadError tells the client why an upload was rejected, Code is one of the Upload* constants
+	UploadError struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+
+	// UploadJob tracks the processing of a large upload, Media is set once it is done and Error once it failed
+	UploadJob struct {
+		JobId      string       `json:"job_id"`
+		Status     string       `json:"status"`
+		Media      *Media       `json:"media,omitempty"`
+		Error      *UploadError `json:"error,omitempty"`
+		UploadedBy string       `json:"-"`
+		CreatedAt  time.Time    `json:"created_at"`
+		UpdatedAt  time.Time    `json:"updated_at"`
+	}
+
+	OutboxMessage struct {
+		MessageId       string
+		TenantNamespace string
+		Topic           string
+		Payload         json.RawMessage
+		TraceId         string
+		Attempts        int
+		CreatedAt       time.Time
+	}
+
+	TenantRequest struct {
+		TenantNamespace string `json:"tenant_namespace"`
+		Name            string `json:"name"`
 	}
 
 	WebSocketHandShakeData struct {
@@ -71,6 +215,8 @@ type (
 
 	Client struct {
 		Id string
+		// Tenant the client authenticated against
+		TenantNamespace string
 		// Message controller
 		Hub *MessageController
 		// Websocket connection
@@ -80,14 +226,22 @@ type (
 	}
 
 	MessageController struct {
-		// Registered Clients
-		Clients map[*Client]bool
-		// Channel for receiving incoming messages from client
-		Broadcast chan []byte
+		// Registered Clients grouped by tenant namespace
+		Clients map[string]map[*Client]bool
+		// Channel for messages to be pushed to the clients of a tenant
+		Broadcast chan TenantMessage
 		// Channel for registering requests from clients
 		Register chan *Client
 		// Channel for unregistering requests from clients
 		Unregister chan *Client
+		// Last frame pushed per schedule, grouped by tenant namespace
+		sent map[string]map[string][]byte
+		mu   sync.RWMutex
+	}
+
+	TenantMessage struct {
+		TenantNamespace string
+		Data            []byte
 	}
 
 	EmailRequest struct {
@@ -103,9 +257,10 @@ type (
 	}
 
 	AuthResponse struct {
-		AccessToken string `json:"access_token"`
-		TokenType   string `json:"token_type"`
-		ExpiresIn   int    `json:"expires_in"`
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	FacebookUserData struct {
@@ -126,9 +281,11 @@ type (
 	}
 
 	Post struct {
-		PostId       string    `json:"post_id"`
-		PostMessage  string    `json:"post_message"`
+		PostId      string `json:"post_id"`
+		PostMessage string `json:"post_message"`
+		// images sent inline by the batch upload, they are stored as media records
 		PostImages   [][]byte  `json:"post_images"`
+		MediaIds     []string  `json:"media_ids"`
 		ImagePaths   []string  `json:"image_paths"`
 		HashTags     []string  `json:"hash_tags"`
 		PostPriority bool      `json:"post_priority"`
@@ -158,7 +315,7 @@ type (
 
 	FetchPostResponse struct {
 		Data []DbPost `json:"data"`
-		Meta Meta     `json:"meta"`
+		Meta PageMeta `json:"meta"`
 	}
 
 	FetchFacebookPostResponse struct {
@@ -176,6 +333,36 @@ type (
 		Meta Meta `json:"meta"`
 	}
 
+	MediaResponse struct {
+		Data Media `json:"data"`
+		Meta Meta  `json:"meta"`
+	}
+
+	MediaListResponse struct {
+		Data []Media  `json:"data"`
+		Meta PageMeta `json:"meta"`
+	}
+
+	ApiKeyResponse struct {
+		Data ApiKey `json:"data"`
+		Meta Meta   `json:"meta"`
+	}
+
+	ApiKeyListResponse struct {
+		Data []ApiKey `json:"data"`
+		Meta Meta     `json:"meta"`
+	}
+
+	UploadJobResponse struct {
+		Data UploadJob `json:"data"`
+		Meta Meta      `json:"meta"`
+	}
+
+	UploadErrorResponse struct {
+		Error UploadError `json:"error"`
+		Meta  Meta        `json:"meta"`
+	}
+
 	Comment struct {
 		Data []CommentData `json:"data"`
 	}
@@ -201,19 +388,19 @@ type (
 	}
 
 	DbPost struct {
-		PostId         string    `json:"post_id"`
-		FacebookPostId string    `json:"facebook_post_id"`
-		PostMessage    string    `json:"post_message"`
-		PostImages     [][]byte  `json:"post_images"`
-		ImagePaths     []string  `json:"image_paths"`
-		HashTags       []string  `json:"hash_tags"`
-		Scheduled      bool      `json:"scheduled"`
-		PostFbStatus   bool      `json:"post_fb_status"`
-		PostTwStatus   bool      `json:"post_tw_status"`
-		PostLiStatus   bool      `json:"post_li_status"`
-		PostPriority   bool      `json:"post_priority"`
-		CreatedOn      time.Time `json:"created_on"`
-		UpdatedOn      time.Time `json:"updated_on"`
+		PostId         string     `json:"post_id"`
+		FacebookPostId string     `json:"facebook_post_id"`
+		PostMessage    string     `json:"post_message"`
+		Media          []MediaRef `json:"media"`
+		ImagePaths     []string   `json:"image_paths"`
+		HashTags       []string   `json:"hash_tags"`
+		Scheduled      bool       `json:"scheduled"`
+		PostFbStatus   bool       `json:"post_fb_status"`
+		PostTwStatus   bool       `json:"post_tw_status"`
+		PostLiStatus   bool       `json:"post_li_status"`
+		PostPriority   bool       `json:"post_priority"`
+		CreatedOn      time.Time  `json:"created_on"`
+		UpdatedOn      time.Time  `json:"updated_on"`
 	}
 
 	FileTooBigResponse struct {
@@ -231,6 +418,32 @@ type (
 		Code string `json:"code"`
 	}
 
+	TwitterCode struct {
+		Code         string `json:"code"`
+		CodeVerifier string `json:"code_verifier"`
+	}
+
+	LinkedInCode struct {
+		Code string `json:"code"`
+	}
+
+	LinkedInUserData struct {
+		Id        string `json:"id"`
+		FirstName string `json:"localizedFirstName"`
+		LastName  string `json:"localizedLastName"`
+	}
+
+	LinkedInOrganization struct {
+		Urn  string `json:"urn"`
+		Name string `json:"name"`
+	}
+
+	TwitterUserData struct {
+		Id       string `json:"id"`
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	}
+
 	Data struct {
 		Id        string `json:"id"`
 		UiMessage string `json:"ui_message"`
@@ -0,0 +1,33 @@
+package pkg
+
+import (
+	"os"
+	"strings"
+)
+
+// defaultUIOrigins are the origins the postit UI is served from
+var defaultUIOrigins = []string{"http://localhost:8080", "https://postit-ui.herokuapp.com", "https://postit-dev-ui.herokuapp.com"}
+
+// UIOrigins returns the origins of the UI, UI_ORIGINS replaces the default ones with a comma separated list
+func UIOrigins() []string {
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("UI_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		return defaultUIOrigins
+	}
+	return origins
+}
+
+// UIOrigin reports whether origin is one of the UI origins
+func UIOrigin(origin string) bool {
+	for _, allowed := range UIOrigins() {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/twinj/uuid"
+	"gitlab.com/pbobby001/postit-api/db"
+	"time"
+)
+
+// Outbox topics
+const (
+	// TopicScheduleCreated hands a new schedule over to the scheduler micro service
+	TopicScheduleCreated = "schedule.created"
+)
+
+// NewOutboxMessage builds a message for topic with payload encoded as json
+func NewOutboxMessage(tenantNamespace string, topic string, traceId string, payload interface{}) (OutboxMessage, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return OutboxMessage{}, err
+	}
+
+	return OutboxMessage{
+		MessageId:       uuid.NewV4().String(),
+		TenantNamespace: tenantNamespace,
+		Topic:           topic,
+		Payload:         body,
+		TraceId:         traceId,
+	}, nil
+}
+
+/* EnqueueOutbox records messages within tx.
+They only become visible to the outbox dispatcher once tx commits, so a rolled back change never notifies anyone. */
+func EnqueueOutbox(tx *sql.Tx, messages ...OutboxMessage) error {
+	for _, message := range messages {
+		_, err := tx.Exec(
+			"INSERT INTO outbox (message_id, tenant_namespace, topic, payload, trace_id) VALUES ($1, $2, $3, $4, $5)",
+			message.MessageId,
+			message.TenantNamespace,
+			message.Topic,
+			[]byte(message.Payload),
+			message.TraceId,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PendingOutbox returns up to limit undelivered messages that are available at now, oldest first
+func PendingOutbox(now time.Time, limit int) ([]OutboxMessage, error) {
+	rows, err := db.Connection.Query(
+		"SELECT message_id, tenant_namespace, topic, payload, trace_id, attempts, created_at FROM outbox WHERE delivered_at IS NULL AND available_at <= $1 ORDER BY created_at LIMIT $2",
+		now,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var message OutboxMessage
+		var payload []byte
+		err = rows.Scan(&message.MessageId, &message.TenantNamespace, &message.Topic, &payload, &message.TraceId, &message.Attempts, &message.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		message.Payload = payload
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+func MarkOutboxDelivered(messageId string, now time.Time) error {
+	_, err := db.Connection.Exec("UPDATE outbox SET delivered_at = $1, attempts = attempts + 1, last_error = NULL WHERE message_id = $2", now, messageId)
+	return err
+}
+
+// MarkOutboxFailed records a failed delivery, the message is retried from retryAt
+func MarkOutboxFailed(messageId string, cause error, retryAt time.Time) error {
+	_, err := db.Connection.Exec("UPDATE outbox SET attempts = attempts + 1, last_error = $1, available_at = $2 WHERE message_id = $3", cause.Error(), retryAt, messageId)
+	return err
+}
@@ -0,0 +1,169 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var errInvalidExif = errors.New("invalid exif data")
+
+const gpsInfoTag = 0x8825
+
+// sizes of the exif field types in bytes, indexed by type
+var exifTypeSizes = [...]uint64{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+/* StripGPS removes the GPS block from the exif data of jpeg, png and tiff files.
+The entries and the values they point to are zeroed in place and the block is left empty,
+so everything else in the file, the orientation included, stays as it is.
+Other files are returned unchanged, data itself is never modified. */
+func StripGPS(data []byte) ([]byte, error) {
+	stripped := append([]byte(nil), data...)
+
+	switch {
+	case bytes.HasPrefix(stripped, []byte{0xFF, 0xD8}):
+		return stripped, stripJpeg(stripped)
+	case bytes.HasPrefix(stripped, pngSignature):
+		return stripped, stripPng(stripped)
+	case bytes.HasPrefix(stripped, []byte("II*\x00")), bytes.HasPrefix(stripped, []byte("MM\x00*")):
+		return stripped, stripTiff(stripped)
+	}
+	return stripped, nil
+}
+
+// stripJpeg looks for the exif data in the APP1 segments in front of the image data
+func stripJpeg(data []byte) error {
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return errInvalidExif
+		}
+		marker := data[i+1]
+		// start of scan and end of image, no more metadata follows
+		if marker == 0xDA || marker == 0xD9 {
+			return nil
+		}
+		// markers without a length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) || marker == 0xFF {
+			i += 2
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return errInvalidExif
+		}
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			err := stripTiff(segment[6:])
+			if err != nil {
+				return err
+			}
+		}
+		i = end
+	}
+	return nil
+}
+
+// stripPng strips the eXIf chunk and updates its checksum
+func stripPng(data []byte) error {
+	i := len(pngSignature)
+	for i+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if end > len(data) {
+			return errInvalidExif
+		}
+
+		kind := string(data[i+4 : i+8])
+		if kind == "eXIf" {
+			err := stripTiff(data[i+8 : i+8+length])
+			if err != nil {
+				return err
+			}
+			binary.BigEndian.PutUint32(data[i+8+length:], crc32.ChecksumIEEE(data[i+4:i+8+length]))
+		}
+		if kind == "IEND" {
+			return nil
+		}
+		i = end
+	}
+	return nil
+}
+
+// stripTiff empties the GPS block referenced from the first image file directory of the tiff structure in data
+func stripTiff(data []byte) error {
+	if len(data) < 8 {
+		return errInvalidExif
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return errInvalidExif
+	}
+
+	entries, err := directory(data, order, uint64(order.Uint32(data[4:])))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if order.Uint16(data[entry:]) != gpsInfoTag {
+			continue
+		}
+
+		gps := uint64(order.Uint32(data[entry+8:]))
+		gpsEntries, err := directory(data, order, gps)
+		if err != nil {
+			return err
+		}
+		for _, gpsEntry := range gpsEntries {
+			clearEntry(data, order, gpsEntry)
+		}
+		order.PutUint16(data[gps:], 0)
+	}
+	return nil
+}
+
+// directory returns the offsets of the entries of the image file directory at offset
+func directory(data []byte, order binary.ByteOrder, offset uint64) ([]uint64, error) {
+	if offset+2 > uint64(len(data)) {
+		return nil, errInvalidExif
+	}
+	count := uint64(order.Uint16(data[offset:]))
+	if offset+2+count*12 > uint64(len(data)) {
+		return nil, errInvalidExif
+	}
+
+	entries := make([]uint64, count)
+	for i := range entries {
+		entries[i] = offset + 2 + uint64(i)*12
+	}
+	return entries, nil
+}
+
+// clearEntry zeroes a directory entry along with its value when that is kept outside of the entry
+func clearEntry(data []byte, order binary.ByteOrder, entry uint64) {
+	kind := uint64(order.Uint16(data[entry+2:]))
+	if kind < uint64(len(exifTypeSizes)) {
+		size := exifTypeSizes[kind] * uint64(order.Uint32(data[entry+4:]))
+		value := uint64(order.Uint32(data[entry+8:]))
+		if size > 4 && value+size <= uint64(len(data)) {
+			zero(data[value : value+size])
+		}
+	}
+	zero(data[entry : entry+12])
+}
+
+func zero(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
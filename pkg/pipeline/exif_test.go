@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+const (
+	gpsOffset      = 38
+	latitudeOffset = 68
+)
+
+// exifTiff builds little endian exif data with an orientation and a GPS block holding a latitude
+func exifTiff() []byte {
+	le := binary.LittleEndian
+	data := make([]byte, latitudeOffset+24)
+	copy(data, "II")
+	le.PutUint16(data[2:], 42)
+	le.PutUint32(data[4:], 8)
+
+	entry := func(offset int, tag uint16, kind uint16, count uint32, value uint32) {
+		le.PutUint16(data[offset:], tag)
+		le.PutUint16(data[offset+2:], kind)
+		le.PutUint32(data[offset+4:], count)
+		le.PutUint32(data[offset+8:], value)
+	}
+
+	le.PutUint16(data[8:], 2)
+	entry(10, 0x0112, 3, 1, 6)
+	entry(22, gpsInfoTag, 4, 1, gpsOffset)
+
+	le.PutUint16(data[gpsOffset:], 2)
+	entry(gpsOffset+2, 0x0001, 2, 2, uint32('N'))
+	entry(gpsOffset+14, 0x0002, 5, 3, latitudeOffset)
+	for i, value := range []uint32{5, 1, 36, 1, 1234, 100} {
+		le.PutUint32(data[latitudeOffset+i*4:], value)
+	}
+	return data
+}
+
+func jpegWithExif(t *testing.T) []byte {
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 40, 20)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := append([]byte("Exif\x00\x00"), exifTiff()...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	data := append([]byte{}, img.Bytes()[:2]...)
+	data = append(data, segment...)
+	return append(data, img.Bytes()[2:]...)
+}
+
+func TestStripGPS(t *testing.T) {
+	original := jpegWithExif(t)
+	stripped, err := StripGPS(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stripped) != len(original) {
+		t.Fatalf("expected the file to keep its size got %d instead of %d", len(stripped), len(original))
+	}
+
+	tiff := stripped[2+4+6:]
+	le := binary.LittleEndian
+	if count := le.Uint16(tiff[gpsOffset:]); count != 0 {
+		t.Fatalf("expected an empty GPS block got %d entries", count)
+	}
+	if !bytes.Equal(tiff[latitudeOffset:latitudeOffset+24], make([]byte, 24)) {
+		t.Fatalf("expected the latitude to be zeroed got %v", tiff[latitudeOffset:latitudeOffset+24])
+	}
+	if le.Uint16(tiff[10:]) != 0x0112 || le.Uint16(tiff[18:]) != 6 {
+		t.Fatal("expected the orientation to be kept")
+	}
+
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Fatalf("expected the stripped file to decode got %v", err)
+	}
+	if binary.LittleEndian.Uint16(original[2+4+6+gpsOffset:]) != 2 {
+		t.Fatal("expected the original data to be left alone")
+	}
+}
+
+func TestStripGPSRejectsBrokenExif(t *testing.T) {
+	data := jpegWithExif(t)
+	// point the GPS block past the end of the exif data
+	binary.LittleEndian.PutUint32(data[2+4+6+22+8:], 5000)
+
+	if _, err := StripGPS(data); err != errInvalidExif {
+		t.Fatalf("expected errInvalidExif got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"errors"
+	"io"
+)
+
+// MaxFrames caps the frames of animated gifs, MEDIA_MAX_FRAMES overrides it
+var MaxFrames = int(pixelsFromEnv("MEDIA_MAX_FRAMES", 1000))
+
+var errGIF = errors.New("gif: malformed block structure")
+
+/* scanGIF walks the blocks of a gif without decompressing any frame.
+It returns the number of frames and the sum of their delays in hundredths of a second. */
+func scanGIF(data []byte) (frames int, delay int, err error) {
+	pos := 0
+	skip := func(n int) error {
+		if n < 0 || pos+n > len(data) {
+			return io.ErrUnexpectedEOF
+		}
+		pos += n
+		return nil
+	}
+	// sub-blocks are a size byte followed by that many bytes, until a size of zero
+	skipSubBlocks := func() error {
+		for {
+			if pos >= len(data) {
+				return io.ErrUnexpectedEOF
+			}
+			size := int(data[pos])
+			pos++
+			if size == 0 {
+				return nil
+			}
+			if err := skip(size); err != nil {
+				return err
+			}
+		}
+	}
+	colorTable := func(flags byte) int {
+		if flags&0x80 == 0 {
+			return 0
+		}
+		return 3 << (uint(flags&0x07) + 1)
+	}
+
+	// header and logical screen descriptor
+	if len(data) < 13 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	pos = 13
+	if err = skip(colorTable(data[10])); err != nil {
+		return 0, 0, err
+	}
+
+	frameDelay := 0
+	for pos < len(data) {
+		block := data[pos]
+		pos++
+		switch block {
+		case 0x21:
+			if pos >= len(data) {
+				return 0, 0, io.ErrUnexpectedEOF
+			}
+			label := data[pos]
+			pos++
+			// the graphic control extension holds the delay of the next frame
+			if label == 0xF9 && pos+3 < len(data) && data[pos] >= 4 {
+				frameDelay = int(data[pos+2]) | int(data[pos+3])<<8
+			}
+			if err = skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+		case 0x2C:
+			if pos+9 > len(data) {
+				return 0, 0, io.ErrUnexpectedEOF
+			}
+			flags := data[pos+8]
+			// descriptor, local color table and the lzw code size
+			if err = skip(9 + colorTable(flags) + 1); err != nil {
+				return 0, 0, err
+			}
+			if err = skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+			frames++
+			delay += frameDelay
+			frameDelay = 0
+		case 0x3B:
+			return frames, delay, nil
+		default:
+			return 0, 0, errGIF
+		}
+	}
+	// like image/gif, a missing trailer is tolerated
+	return frames, delay, nil
+}
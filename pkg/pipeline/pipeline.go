@@ -0,0 +1,337 @@
+/* Package pipeline turns uploaded images and videos into media records.
+The original of an image is kept without its GPS data and a rendition is produced for every pkg.Renditions entry,
+the publishers pick the rendition of their network. Videos and animated gifs are kept as they are along with a poster. */
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/disintegration/imaging"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
+	"image"
+	"image/draw"
+	"image/gif"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* MaxPixels caps the width times the height of the images decoded, MEDIA_MAX_PIXELS overrides it.
+Headers are checked against it before anything is decoded, a small file may claim a huge image. */
+var MaxPixels = pixelsFromEnv("MEDIA_MAX_PIXELS", 50000000)
+
+// pixelsFromEnv reads a pixel count from the environment variable
+func pixelsFromEnv(name string, fallback int64) int64 {
+	pixels, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil || pixels <= 0 {
+		return fallback
+	}
+	return pixels
+}
+
+// Processed is an upload ready to be stored, Media lists the renditions whose content is kept in Renditions
+type Processed struct {
+	Media      pkg.Media
+	Original   []byte
+	Renditions map[string][]byte
+}
+
+// Upload is a file as a client sent it, Poster optionally holds the still image a client picked for a video
+type Upload struct {
+	Name         string
+	Data         []byte
+	DeclaredType string
+	Poster       []byte
+}
+
+/* Process validates an upload and prepares it for storage.
+Images are rendered to every rendition, videos and animated gifs are kept as they are along with a poster.
+Files that are not supported, cannot be decoded or fit no network are rejected with a *pkg.UploadError. */
+func Process(upload Upload) (Processed, error) {
+	var processed Processed
+	var err error
+	if isVideo(upload.Data) {
+		processed, err = processVideo(upload)
+	} else {
+		processed, err = processImage(upload)
+	}
+	if err != nil {
+		return processed, err
+	}
+	processed.Media.Name = upload.Name
+
+	if len(pkg.MediaNetworks(processed.Media)) == 0 {
+		var reasons []string
+		for network := range pkg.MediaLimits {
+			reasons = append(reasons, pkg.CheckLimits(network, processed.Media).(*pkg.UploadError).Message)
+		}
+		sort.Strings(reasons)
+		return processed, &pkg.UploadError{Code: pkg.UploadLimitExceeded, Message: strings.Join(reasons, "; ")}
+	}
+	return processed, nil
+}
+
+// processImage renders images to every rendition, animated gifs only get a poster
+func processImage(upload Upload) (Processed, error) {
+	var processed Processed
+	data := upload.Data
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == image.ErrFormat {
+		return processed, &pkg.UploadError{Code: pkg.UploadUnsupportedMedia, Message: http.DetectContentType(data) + " is not a supported image or video type"}
+	}
+	if err != nil {
+		return processed, &pkg.UploadError{Code: pkg.UploadInvalidImage, Message: "the image cannot be decoded: " + err.Error()}
+	}
+	if config.Width == 0 || config.Height == 0 {
+		return processed, &pkg.UploadError{Code: pkg.UploadInvalidImage, Message: "the image is empty"}
+	}
+	err = checkPixels("image", config)
+	if err != nil {
+		return processed, err
+	}
+	err = checkDeclaredType(upload.DeclaredType, "image/"+format)
+	if err != nil {
+		return processed, err
+	}
+
+	// the frames are counted without decoding them, a few kilobytes may hold thousands of frames
+	if format == "gif" {
+		frames, delay, err := scanGIF(data)
+		if err != nil {
+			return processed, &pkg.UploadError{Code: pkg.UploadInvalidImage, Message: "the image cannot be decoded: " + err.Error()}
+		}
+		if frames > MaxFrames {
+			return processed, &pkg.UploadError{Code: pkg.UploadLimitExceeded, Message: fmt.Sprintf("the animation has %d frames, animations may have %d frames at most", frames, MaxFrames)}
+		}
+		if frames > 1 {
+			return processAnimation(data, config, delay)
+		}
+	}
+
+	// the renditions are turned the way the exif orientation says, the original keeps it
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return processed, &pkg.UploadError{Code: pkg.UploadInvalidImage, Message: "the image cannot be decoded: " + err.Error()}
+	}
+
+	processed.Original, err = StripGPS(data)
+	if err != nil {
+		// broken exif data is dropped along with everything else by encoding the image again
+		_ = logs.Logger.Warn(err)
+		processed.Original, _, err = encode(img, format)
+		if err != nil {
+			return processed, err
+		}
+	}
+
+	processed.Media = pkg.NewMedia(processed.Original)
+	processed.Media.Width, processed.Media.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	processed.Renditions = make(map[string][]byte)
+	for _, spec := range pkg.Renditions {
+		err = addRendition(&processed, img, format, spec)
+		if err != nil {
+			return processed, err
+		}
+	}
+	return processed, nil
+}
+
+/* processAnimation keeps an animated gif as it is, its first frame becomes the poster.
+Only that frame is decoded, delay is the duration of the animation in hundredths of a second. */
+func processAnimation(data []byte, config image.Config, delay int) (Processed, error) {
+	processed := Processed{Original: data, Renditions: make(map[string][]byte)}
+	processed.Media = pkg.NewMedia(data)
+	processed.Media.Kind = pkg.MediaAnimation
+	processed.Media.Width, processed.Media.Height = config.Width, config.Height
+	processed.Media.Duration = float64(delay) / 100
+
+	frame, err := gif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return processed, &pkg.UploadError{Code: pkg.UploadInvalidImage, Message: "the image cannot be decoded: " + err.Error()}
+	}
+
+	// frames may only cover part of the canvas
+	first := image.NewNRGBA(image.Rect(0, 0, config.Width, config.Height))
+	draw.Draw(first, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+	return processed, addRendition(&processed, first, "png", pkg.PosterRendition)
+}
+
+/* processVideo validates the mp4 or mov container and keeps the video as it is.
+The poster is the image the client sent along, or else the cover art embedded in the file. */
+func processVideo(upload Upload) (Processed, error) {
+	var processed Processed
+	info, err := parseVideo(upload.Data)
+	if err != nil {
+		return processed, &pkg.UploadError{Code: pkg.UploadInvalidVideo, Message: "the video cannot be read: " + err.Error()}
+	}
+	err = checkDeclaredType(upload.DeclaredType, info.ContentType)
+	if err != nil {
+		return processed, err
+	}
+
+	processed = Processed{Original: upload.Data, Renditions: make(map[string][]byte)}
+	processed.Media = pkg.NewMedia(upload.Data)
+	processed.Media.ContentType = info.ContentType
+	processed.Media.Kind = pkg.MediaVideo
+	processed.Media.Width, processed.Media.Height = info.Width, info.Height
+	processed.Media.Duration = info.Duration
+	processed.Media.Codec = info.VideoCodec
+
+	poster := upload.Poster
+	if len(poster) == 0 {
+		poster = info.Cover
+	}
+	if len(poster) == 0 {
+		return processed, nil
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(poster))
+	if err != nil {
+		return processed, &pkg.UploadError{Code: pkg.UploadInvalidImage, Message: "the poster cannot be decoded: " + err.Error()}
+	}
+	err = checkPixels("poster", config)
+	if err != nil {
+		return processed, err
+	}
+	img, err := imaging.Decode(bytes.NewReader(poster), imaging.AutoOrientation(true))
+	if err != nil {
+		return processed, &pkg.UploadError{Code: pkg.UploadInvalidImage, Message: "the poster cannot be decoded: " + err.Error()}
+	}
+	return processed, addRendition(&processed, img, format, pkg.PosterRendition)
+}
+
+// checkPixels rejects images larger than MaxPixels, what refers to the image in the message
+func checkPixels(what string, config image.Config) error {
+	if int64(config.Width)*int64(config.Height) <= MaxPixels {
+		return nil
+	}
+	return &pkg.UploadError{Code: pkg.UploadLimitExceeded, Message: fmt.Sprintf("the %s is %dx%d, images may have %d pixels at most", what, config.Width, config.Height, MaxPixels)}
+}
+
+// checkDeclaredType rejects files sent as images that turn out to be videos and the other way round
+func checkDeclaredType(declared string, contentType string) error {
+	kind := strings.SplitN(declared, "/", 2)[0]
+	if (kind != "image" && kind != "video") || strings.HasPrefix(contentType, kind+"/") {
+		return nil
+	}
+	return &pkg.UploadError{Code: pkg.UploadUnsupportedMedia, Message: "the file was sent as " + declared + " but is " + contentType}
+}
+
+func addRendition(processed *Processed, img image.Image, format string, spec pkg.RenditionSpec) error {
+	rendered := render(img, spec)
+	content, contentType, err := encode(rendered, format)
+	if err != nil {
+		return err
+	}
+
+	processed.Renditions[spec.Name] = content
+	processed.Media.Renditions = append(processed.Media.Renditions, pkg.Rendition{
+		Name:        spec.Name,
+		Network:     spec.Network,
+		ContentType: contentType,
+		Width:       rendered.Bounds().Dx(),
+		Height:      rendered.Bounds().Dy(),
+		Size:        int64(len(content)),
+		Url:         pkg.RenditionUrl(processed.Media.MediaId, spec.Name),
+	})
+	return nil
+}
+
+// render scales img to spec, images are never scaled up to fit a box
+func render(img image.Image, spec pkg.RenditionSpec) image.Image {
+	bounds := img.Bounds()
+	switch {
+	case spec.Crop:
+		return imaging.Fill(img, spec.Width, spec.Height, imaging.Center, imaging.Lanczos)
+	case spec.Height == 0 && bounds.Dx() > spec.Width:
+		return imaging.Resize(img, spec.Width, 0, imaging.Lanczos)
+	case spec.Width == 0 && bounds.Dy() > spec.Height:
+		return imaging.Resize(img, 0, spec.Height, imaging.Lanczos)
+	case spec.Width > 0 && spec.Height > 0 && (bounds.Dx() > spec.Width || bounds.Dy() > spec.Height):
+		return imaging.Fit(img, spec.Width, spec.Height, imaging.Lanczos)
+	}
+	return imaging.Clone(img)
+}
+
+// encode keeps transparency for png and gif sources, everything else becomes a jpeg
+func encode(img image.Image, format string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if format == "png" || format == "gif" {
+		err := imaging.Encode(&buf, img, imaging.PNG)
+		return buf.Bytes(), "image/png", err
+	}
+	err := imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(85))
+	return buf.Bytes(), "image/jpeg", err
+}
+
+/* Save puts the original and the renditions into store before the media record is created and returns the stored record.
+The content is stored as a new blob named after the record, it is removed again when anything fails
+or when the tenant already had the same content and the record shares that blob instead. */
+func Save(store storage.MediaStore, media repository.MediaRepository, tenantNamespace string, processed Processed) (pkg.Media, error) {
+	contents := map[string][]byte{}
+	contentTypes := map[string]string{}
+	processed.Media.BlobId = processed.Media.MediaId
+
+	key, err := pkg.MediaKey(tenantNamespace, processed.Media.BlobId)
+	if err != nil {
+		return processed.Media, err
+	}
+	contents[key], contentTypes[key] = processed.Original, processed.Media.ContentType
+	for _, rendition := range processed.Media.Renditions {
+		key, err := pkg.RenditionKey(tenantNamespace, processed.Media.BlobId, rendition.Name)
+		if err != nil {
+			return processed.Media, err
+		}
+		contents[key], contentTypes[key] = processed.Renditions[rendition.Name], rendition.ContentType
+	}
+
+	for key, content := range contents {
+		err = store.Put(key, content, contentTypes[key])
+		if err != nil {
+			break
+		}
+	}
+	stored := processed.Media
+	if err == nil {
+		stored, err = media.Create(tenantNamespace, processed.Media)
+	}
+	if err != nil || stored.BlobId != processed.Media.BlobId {
+		_ = Remove(store, tenantNamespace, processed.Media)
+	}
+	return stored, err
+}
+
+/* Remove deletes the original and the renditions of the blob of a media record from store.
+It is only called once the blob was released, other records may share it until then. */
+func Remove(store storage.MediaStore, tenantNamespace string, media pkg.Media) error {
+	blobId := media.BlobId
+	if blobId == "" {
+		blobId = media.MediaId
+	}
+	key, err := pkg.MediaKey(tenantNamespace, blobId)
+	if err != nil {
+		return err
+	}
+	err = store.Delete(key)
+	if err != nil {
+		return err
+	}
+
+	for _, rendition := range media.Renditions {
+		key, err := pkg.RenditionKey(tenantNamespace, blobId, rendition.Name)
+		if err != nil {
+			return err
+		}
+		err = store.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
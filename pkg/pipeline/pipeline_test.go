@@ -0,0 +1,152 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestProcess(t *testing.T) {
+	processed, err := Process(Upload{Data: jpegWithExif(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the exif orientation turns the 40x20 image by 90 degrees
+	if processed.Media.Width != 20 || processed.Media.Height != 40 || processed.Media.ContentType != "image/jpeg" {
+		t.Fatalf("unexpected media %+v", processed.Media)
+	}
+	if len(processed.Media.Renditions) != len(pkg.Renditions) {
+		t.Fatalf("expected a rendition per spec got %+v", processed.Media.Renditions)
+	}
+	for i, rendition := range processed.Media.Renditions {
+		spec := pkg.Renditions[i]
+		if spec.Crop && (rendition.Width != spec.Width || rendition.Height != spec.Height) {
+			t.Errorf("expected %s to be cropped to %dx%d got %dx%d", spec.Name, spec.Width, spec.Height, rendition.Width, rendition.Height)
+		}
+		if !spec.Crop && (rendition.Width != 20 || rendition.Height != 40) {
+			t.Errorf("expected %s not to be scaled up got %dx%d", spec.Name, rendition.Width, rendition.Height)
+		}
+		if int64(len(processed.Renditions[spec.Name])) != rendition.Size {
+			t.Errorf("expected the content of %s to be kept", spec.Name)
+		}
+	}
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewNRGBA(image.Rect(0, 0, 1000, 400))); err != nil {
+		t.Fatal(err)
+	}
+	processed, err = Process(Upload{Data: img.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	preview := processed.Media.Renditions[0]
+	if preview.Name != "preview" || preview.Width != 500 || preview.Height != 200 || preview.ContentType != "image/png" {
+		t.Fatalf("unexpected preview %+v", preview)
+	}
+
+	_, err = Process(Upload{Data: []byte("plain text")})
+	if uploadError, ok := err.(*pkg.UploadError); !ok || uploadError.Code != pkg.UploadUnsupportedMedia {
+		t.Fatalf("expected an unsupported media error got %v", err)
+	}
+}
+
+// pngHeader is a png holding nothing but a header claiming an image of width x height
+func pngHeader(width uint32, height uint32) []byte {
+	chunk := func(kind string, data []byte) []byte {
+		var buf bytes.Buffer
+		_ = binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+		buf.WriteString(kind)
+		buf.Write(data)
+		_ = binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(kind), data...)))
+		return buf.Bytes()
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8], ihdr[9] = 8, 6
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = append(data, chunk("IHDR", ihdr)...)
+	return append(data, chunk("IEND", nil)...)
+}
+
+func TestProcessRejectsHugeImages(t *testing.T) {
+	// nothing is decoded, a header claiming 900 megapixels would take gigabytes
+	_, err := Process(Upload{Data: pngHeader(30000, 30000)})
+	if uploadError, ok := err.(*pkg.UploadError); !ok || uploadError.Code != pkg.UploadLimitExceeded || !strings.Contains(uploadError.Message, "30000x30000") {
+		t.Fatalf("expected the image to exceed the pixel limit got %v", err)
+	}
+
+	previous := MaxPixels
+	MaxPixels = 100
+	defer func() { MaxPixels = previous }()
+	var img bytes.Buffer
+	if err = png.Encode(&img, image.NewNRGBA(image.Rect(0, 0, 20, 10))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Process(Upload{Data: img.Bytes()}); err == nil {
+		t.Fatalf("expected a 20x10 image to exceed a 100 pixel limit")
+	}
+}
+
+func TestSaveAndRemove(t *testing.T) {
+	store := storage.NewMemoryStore()
+	media := repository.NewMemoryMediaRepository(nil)
+
+	processed, err := Process(Upload{Data: jpegWithExif(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := Save(store, media, "postit", processed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := store.List("postit/")
+	if len(keys) != 1+len(pkg.Renditions) || first.BlobId != first.MediaId {
+		t.Fatalf("expected the original and the renditions got %v", keys)
+	}
+
+	// the same file again shares the content of the first upload
+	processed, err = Process(Upload{Data: jpegWithExif(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Save(store, media, "postit", processed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.MediaId == first.MediaId || second.BlobId != first.BlobId || len(second.Renditions) != len(first.Renditions) {
+		t.Fatalf("expected a new record sharing the blob got %+v", second)
+	}
+	if keys, _ = store.List("postit/"); len(keys) != 1+len(pkg.Renditions) {
+		t.Fatalf("expected the content to be stored once got %v", keys)
+	}
+	if second.Renditions[0].Url != pkg.RenditionUrl(second.MediaId, second.Renditions[0].Name) {
+		t.Fatalf("expected the renditions to be served from the new record got %s", second.Renditions[0].Url)
+	}
+
+	for i, mediaId := range []string{first.MediaId, second.MediaId} {
+		stored, err := media.Get("postit", mediaId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		released, err := media.DeleteUpload("postit", mediaId)
+		if err != nil || released != (i == 1) {
+			t.Fatalf("expected only the last record to release the blob got %v: %v", released, err)
+		}
+		if released {
+			if err = Remove(store, "postit", stored); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if keys, _ = store.List("postit/"); len(keys) != 0 {
+		t.Fatalf("expected the content to be removed got %v", keys)
+	}
+}
@@ -0,0 +1,199 @@
+package pipeline
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+var errInvalidContainer = errors.New("invalid mp4 container")
+
+// videoInfo is what the container header tells about a video
+type videoInfo struct {
+	ContentType string
+	Duration    float64
+	Width       int
+	Height      int
+	// VideoCodec and AudioCodec are the sample entry formats, e.g. avc1 and mp4a
+	VideoCodec string
+	AudioCodec string
+	// Cover is the cover art some encoders embed, it serves as poster frame
+	Cover []byte
+}
+
+// box is an ISO base media file format box, data holds its payload
+type box struct {
+	kind string
+	data []byte
+}
+
+// isVideo tells whether data starts with the ftyp box of an mp4 or quicktime file
+func isVideo(data []byte) bool {
+	return len(data) >= 12 && string(data[4:8]) == "ftyp"
+}
+
+/* parseVideo reads the ftyp and moov boxes of an mp4 or mov file.
+Only the headers are looked at, the media data itself is never decoded. */
+func parseVideo(data []byte) (videoInfo, error) {
+	var info videoInfo
+	boxes, err := readBoxes(data)
+	if err != nil {
+		return info, err
+	}
+
+	var moov []byte
+	for _, b := range boxes {
+		switch b.kind {
+		case "ftyp":
+			if len(b.data) < 4 {
+				return info, errInvalidContainer
+			}
+			info.ContentType = "video/mp4"
+			if string(b.data[:4]) == "qt  " {
+				info.ContentType = "video/quicktime"
+			}
+		case "moov":
+			moov = b.data
+		}
+	}
+	if info.ContentType == "" || moov == nil {
+		return info, errors.New("the file has no movie header")
+	}
+
+	children, err := readBoxes(moov)
+	if err != nil {
+		return info, err
+	}
+	for _, b := range children {
+		switch b.kind {
+		case "mvhd":
+			info.Duration, err = movieDuration(b.data)
+			if err != nil {
+				return info, err
+			}
+		case "trak":
+			readTrack(b.data, &info)
+		case "udta":
+			info.Cover = findCover(b.data)
+		}
+	}
+
+	if info.VideoCodec == "" {
+		return info, errors.New("the file has no video track")
+	}
+	return info, nil
+}
+
+func readBoxes(data []byte) ([]box, error) {
+	var boxes []box
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errInvalidContainer
+		}
+		size := uint64(binary.BigEndian.Uint32(data))
+		kind := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			// the box runs to the end of the file
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errInvalidContainer
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, errInvalidContainer
+		}
+
+		boxes = append(boxes, box{kind: kind, data: data[header:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+func child(data []byte, kind string) []byte {
+	boxes, err := readBoxes(data)
+	if err != nil {
+		return nil
+	}
+	for _, b := range boxes {
+		if b.kind == kind {
+			return b.data
+		}
+	}
+	return nil
+}
+
+// movieDuration reads the duration of the movie header in seconds
+func movieDuration(mvhd []byte) (float64, error) {
+	if len(mvhd) < 1 {
+		return 0, errInvalidContainer
+	}
+	var timescale uint32
+	var duration uint64
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return 0, errInvalidContainer
+		}
+		timescale = binary.BigEndian.Uint32(mvhd[20:])
+		duration = binary.BigEndian.Uint64(mvhd[24:])
+	} else {
+		if len(mvhd) < 20 {
+			return 0, errInvalidContainer
+		}
+		timescale = binary.BigEndian.Uint32(mvhd[12:])
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:]))
+	}
+	if timescale == 0 {
+		return 0, errInvalidContainer
+	}
+	return float64(duration) / float64(timescale), nil
+}
+
+// readTrack sets the codec of the first video and audio track, the size of the video is taken from its track header
+func readTrack(trak []byte, info *videoInfo) {
+	mdia := child(trak, "mdia")
+	hdlr := child(mdia, "hdlr")
+	if len(hdlr) < 12 {
+		return
+	}
+	handler := string(hdlr[8:12])
+
+	stsd := child(child(child(mdia, "minf"), "stbl"), "stsd")
+	// version and flags, the entry count and the size of the first entry come before its format
+	if len(stsd) < 16 {
+		return
+	}
+	format := strings.TrimSpace(string(stsd[12:16]))
+
+	switch {
+	case handler == "vide" && info.VideoCodec == "":
+		info.VideoCodec = format
+		tkhd := child(trak, "tkhd")
+		if len(tkhd) >= 8 {
+			// width and height close the track header as 16.16 fixed point numbers
+			info.Width = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16)
+			info.Height = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16)
+		}
+	case handler == "soun" && info.AudioCodec == "":
+		info.AudioCodec = format
+	}
+}
+
+// findCover returns the cover art kept in the itunes metadata of udta, if any
+func findCover(udta []byte) []byte {
+	meta := child(udta, "meta")
+	// meta is a full box, its children follow the version and flags
+	if len(meta) < 4 {
+		return nil
+	}
+	data := child(child(child(meta[4:], "ilst"), "covr"), "data")
+	// the data box starts with its type and locale
+	if len(data) <= 8 {
+		return nil
+	}
+	return data[8:]
+}
@@ -0,0 +1,185 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func mp4Box(kind string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(8+len(data)))
+	copy(header[4:], kind)
+	return append(header, data...)
+}
+
+// mp4Track builds a track with the handler and the sample entry format, video tracks are 640x360
+func mp4Track(handler string, format string) []byte {
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:], 640<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], 360<<16)
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:], handler)
+	stsd := make([]byte, 16)
+	binary.BigEndian.PutUint32(stsd[4:], 1)
+	copy(stsd[12:], format)
+
+	stbl := mp4Box("stbl", mp4Box("stsd", stsd))
+	return mp4Box("trak", mp4Box("tkhd", tkhd), mp4Box("mdia", mp4Box("hdlr", hdlr), mp4Box("minf", stbl)))
+}
+
+// mp4Video builds the headers of a video running for seconds, cover is embedded as cover art when given
+func mp4Video(brand string, seconds uint32, cover []byte) []byte {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], seconds*1000)
+
+	moov := [][]byte{mp4Box("mvhd", mvhd), mp4Track("soun", "mp4a"), mp4Track("vide", "avc1")}
+	if cover != nil {
+		data := append(make([]byte, 8), cover...)
+		ilst := mp4Box("ilst", mp4Box("covr", mp4Box("data", data)))
+		moov = append(moov, mp4Box("udta", mp4Box("meta", make([]byte, 4), ilst)))
+	}
+	ftyp := mp4Box("ftyp", []byte(brand), make([]byte, 4))
+	return append(append(ftyp, mp4Box("moov", moov...)...), mp4Box("mdat", []byte("frames"))...)
+}
+
+func pngBytes(t *testing.T, width int, height int) []byte {
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewNRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return img.Bytes()
+}
+
+func TestParseVideo(t *testing.T) {
+	info, err := parseVideo(mp4Video("isom", 12, []byte("cover")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ContentType != "video/mp4" || info.Duration != 12 || info.Width != 640 || info.Height != 360 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if info.VideoCodec != "avc1" || info.AudioCodec != "mp4a" || string(info.Cover) != "cover" {
+		t.Fatalf("unexpected codecs or cover %+v", info)
+	}
+
+	info, err = parseVideo(mp4Video("qt  ", 12, nil))
+	if err != nil || info.ContentType != "video/quicktime" || info.Cover != nil {
+		t.Fatalf("expected a quicktime movie without cover got %+v: %v", info, err)
+	}
+
+	truncated := mp4Video("isom", 12, nil)
+	if _, err = parseVideo(truncated[:len(truncated)-3]); err != errInvalidContainer {
+		t.Fatalf("expected errInvalidContainer got %v", err)
+	}
+	if _, err = parseVideo(mp4Box("ftyp", []byte("isom"))); err == nil {
+		t.Fatal("expected a file without movie header to fail")
+	}
+}
+
+func TestProcessVideo(t *testing.T) {
+	processed, err := Process(Upload{Data: mp4Video("isom", 12, pngBytes(t, 1920, 1080)), DeclaredType: "video/mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	media := processed.Media
+	if media.Kind != pkg.MediaVideo || media.ContentType != "video/mp4" || media.Duration != 12 || media.Codec != "avc1" {
+		t.Fatalf("unexpected media %+v", media)
+	}
+	if len(media.Renditions) != 1 || media.Renditions[0].Name != "poster" || media.Renditions[0].Width != 1280 || media.Renditions[0].Height != 720 {
+		t.Fatalf("expected the cover art as poster got %+v", media.Renditions)
+	}
+
+	// the poster sent along wins over the cover art
+	processed, err = Process(Upload{Data: mp4Video("isom", 12, pngBytes(t, 1920, 1080)), Poster: pngBytes(t, 300, 200)})
+	if err != nil || processed.Media.Renditions[0].Width != 300 {
+		t.Fatalf("expected the poster that was sent got %+v: %v", processed.Media.Renditions, err)
+	}
+
+	_, err = Process(Upload{Data: mp4Video("isom", 12, nil), DeclaredType: "image/png"})
+	if uploadError, ok := err.(*pkg.UploadError); !ok || uploadError.Code != pkg.UploadUnsupportedMedia {
+		t.Fatalf("expected a video sent as image to be rejected got %v", err)
+	}
+
+	_, err = Process(Upload{Data: mp4Box("ftyp", []byte("isom"))})
+	if uploadError, ok := err.(*pkg.UploadError); !ok || uploadError.Code != pkg.UploadInvalidVideo {
+		t.Fatalf("expected an invalid video error got %v", err)
+	}
+
+	// no network takes videos running for five hours
+	_, err = Process(Upload{Data: mp4Video("isom", 5*60*60, nil)})
+	if uploadError, ok := err.(*pkg.UploadError); !ok || uploadError.Code != pkg.UploadLimitExceeded {
+		t.Fatalf("expected the limits to be exceeded got %v", err)
+	}
+}
+
+func TestProcessAnimation(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	animation := &gif.GIF{Delay: []int{50, 70}, Config: image.Config{ColorModel: palette, Width: 60, Height: 40}}
+	for i := 0; i < 2; i++ {
+		animation.Image = append(animation.Image, image.NewPaletted(image.Rect(0, 0, 30, 20), palette))
+	}
+	var data bytes.Buffer
+	if err := gif.EncodeAll(&data, animation); err != nil {
+		t.Fatal(err)
+	}
+
+	processed, err := Process(Upload{Data: data.Bytes(), DeclaredType: "image/gif"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	media := processed.Media
+	if media.Kind != pkg.MediaAnimation || media.Duration != 1.2 || media.Width != 60 || media.Height != 40 {
+		t.Fatalf("unexpected media %+v", media)
+	}
+	if !bytes.Equal(processed.Original, data.Bytes()) {
+		t.Fatal("expected the animation to be kept as it is")
+	}
+	if len(media.Renditions) != 1 || media.Renditions[0].Width != 60 || media.Renditions[0].ContentType != "image/png" {
+		t.Fatalf("expected the first frame as poster got %+v", media.Renditions)
+	}
+}
+
+func TestProcessAnimationLimits(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	animation := &gif.GIF{Config: image.Config{ColorModel: palette, Width: 60, Height: 40}}
+	for i := 0; i < 3; i++ {
+		animation.Image = append(animation.Image, image.NewPaletted(image.Rect(0, 0, 60, 40), palette))
+		animation.Delay = append(animation.Delay, 10)
+	}
+	var data bytes.Buffer
+	if err := gif.EncodeAll(&data, animation); err != nil {
+		t.Fatal(err)
+	}
+	frames, delay, err := scanGIF(data.Bytes())
+	if err != nil || frames != 3 || delay != 30 {
+		t.Fatalf("expected 3 frames lasting 30 got %d %d %v", frames, delay, err)
+	}
+	if _, _, err = scanGIF(data.Bytes()[:data.Len()/2]); err == nil {
+		t.Fatal("expected a truncated gif to be rejected")
+	}
+
+	previous := MaxFrames
+	MaxFrames = 2
+	defer func() { MaxFrames = previous }()
+	_, err = Process(Upload{Data: data.Bytes()})
+	if uploadError, ok := err.(*pkg.UploadError); !ok || uploadError.Code != pkg.UploadLimitExceeded {
+		t.Fatalf("expected too many frames to be rejected got %v", err)
+	}
+
+	// the logical screen is checked against the pixel limit before any frame is decoded
+	huge := append([]byte{}, data.Bytes()...)
+	huge[6], huge[7], huge[8], huge[9] = 0x30, 0x75, 0x30, 0x75
+	_, err = Process(Upload{Data: huge})
+	if uploadError, ok := err.(*pkg.UploadError); !ok || uploadError.Code != pkg.UploadLimitExceeded || !strings.Contains(uploadError.Message, "30000x30000") {
+		t.Fatalf("expected a 30000x30000 animation to be rejected got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/twinj/uuid"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Post sort fields and orders
+const (
+	SortUpdatedAt = "updated_at"
+	SortCreatedAt = "created_at"
+	OrderAsc      = "asc"
+	OrderDesc     = "desc"
+
+	DefaultPostLimit = 50
+	MaxPostLimit     = 200
+)
+
+var ErrInvalidCursor = errors.New("invalid or expired cursor")
+
+type (
+	/* PostQuery selects a page of posts.
+	Nil filters are ignored and After is the cursor returned with the previous page. */
+	PostQuery struct {
+		Limit        int
+		After        *PostCursor
+		Sort         string
+		Order        string
+		Scheduled    *bool
+		PostPriority *bool
+		PostFbStatus *bool
+		PostTwStatus *bool
+		PostLiStatus *bool
+		HashTag      string
+		CreatedFrom  *time.Time
+		CreatedTo    *time.Time
+	}
+
+	// PostCursor points at the last post of a page
+	PostCursor struct {
+		Sort   string    `json:"s"`
+		Order  string    `json:"o"`
+		Value  time.Time `json:"v"`
+		PostId string    `json:"id"`
+	}
+
+	PostPage struct {
+		Posts      []DbPost
+		NextCursor string
+		Total      int
+	}
+
+	PageMeta struct {
+		Meta
+		NextCursor string `json:"next_cursor"`
+		Total      int    `json:"total"`
+	}
+)
+
+/* ParsePostQuery reads the paging, filter and sort parameters of GET /posts.
+limit defaults to 50 and is capped at 200, sort is updated_at or created_at and order desc or asc.
+The date range accepts dates as well as RFC 3339 timestamps, a date as created_to includes the whole day. */
+func ParsePostQuery(values url.Values) (PostQuery, error) {
+	query := PostQuery{
+		Limit: DefaultPostLimit,
+		Sort:  SortUpdatedAt,
+		Order: OrderDesc,
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return query, fmt.Errorf("limit must be a positive number")
+		}
+		if n > MaxPostLimit {
+			n = MaxPostLimit
+		}
+		query.Limit = n
+	}
+
+	if sort := values.Get("sort"); sort != "" {
+		if sort != SortUpdatedAt && sort != SortCreatedAt {
+			return query, fmt.Errorf("sort must be %s or %s", SortUpdatedAt, SortCreatedAt)
+		}
+		query.Sort = sort
+	}
+
+	if order := strings.ToLower(values.Get("order")); order != "" {
+		if order != OrderAsc && order != OrderDesc {
+			return query, fmt.Errorf("order must be %s or %s", OrderAsc, OrderDesc)
+		}
+		query.Order = order
+	}
+
+	flags := map[string]**bool{
+		"scheduled":      &query.Scheduled,
+		"post_priority":  &query.PostPriority,
+		"post_fb_status": &query.PostFbStatus,
+		"post_tw_status": &query.PostTwStatus,
+		"post_li_status": &query.PostLiStatus,
+	}
+	for name, flag := range flags {
+		value := values.Get(name)
+		if value == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, fmt.Errorf("%s must be true or false", name)
+		}
+		*flag = &b
+	}
+
+	if hashTag := values.Get("hash_tag"); hashTag != "" {
+		query.HashTag = GenerateHashTags([]string{hashTag})[0]
+	}
+
+	var err error
+	query.CreatedFrom, err = parseDate(values.Get("created_from"), false)
+	if err != nil {
+		return query, fmt.Errorf("created_from: %v", err)
+	}
+	query.CreatedTo, err = parseDate(values.Get("created_to"), true)
+	if err != nil {
+		return query, fmt.Errorf("created_to: %v", err)
+	}
+
+	if after := values.Get("after"); after != "" {
+		cursor, err := DecodePostCursor(after)
+		if err != nil {
+			return query, err
+		}
+		// a cursor only makes sense in the order it was created for
+		if cursor.Sort != query.Sort || cursor.Order != query.Order {
+			return query, ErrInvalidCursor
+		}
+		query.After = &cursor
+	}
+
+	return query, nil
+}
+
+func parseDate(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return &t, nil
+	}
+
+	t, err = time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, errors.New("expected a date or an RFC 3339 timestamp")
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return &t, nil
+}
+
+// SortValue returns the value post is sorted by under sort
+func (q PostQuery) SortValue(post DbPost) time.Time {
+	if q.Sort == SortCreatedAt {
+		return post.CreatedOn
+	}
+	return post.UpdatedOn
+}
+
+// NextCursor points after post, the last post of the current page
+func (q PostQuery) NextCursor(post DbPost) string {
+	return PostCursor{Sort: q.Sort, Order: q.Order, Value: q.SortValue(post), PostId: post.PostId}.Encode()
+}
+
+// Encode returns the opaque form of the cursor handed out to clients
+func (c PostCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodePostCursor reads a cursor handed out by Encode, refusing cursors that do not point at a post id
+func DecodePostCursor(value string) (PostCursor, error) {
+	var cursor PostCursor
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	err = json.Unmarshal(b, &cursor)
+	if err != nil || cursor.PostId == "" {
+		return cursor, ErrInvalidCursor
+	}
+	if _, err := uuid.Parse(cursor.PostId); err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
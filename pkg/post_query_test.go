@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"github.com/twinj/uuid"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParsePostQuery(t *testing.T) {
+	values := url.Values{}
+	values.Set("limit", "500")
+	values.Set("sort", "created_at")
+	values.Set("order", "ASC")
+	values.Set("scheduled", "false")
+	values.Set("hash_tag", "go")
+	values.Set("created_to", "2021-03-01")
+
+	query, err := ParsePostQuery(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Limit != MaxPostLimit || query.Sort != SortCreatedAt || query.Order != OrderAsc {
+		t.Fatalf("unexpected paging %+v", query)
+	}
+	if query.Scheduled == nil || *query.Scheduled || query.PostPriority != nil {
+		t.Fatal("expected only the scheduled filter")
+	}
+	if query.HashTag != "#go" {
+		t.Fatalf("expected #go got %s", query.HashTag)
+	}
+	if !query.CreatedTo.Equal(time.Date(2021, 3, 1, 23, 59, 59, 999999999, time.UTC)) {
+		t.Fatalf("created_to should include the whole day, got %s", query.CreatedTo)
+	}
+
+	for _, invalid := range []string{"limit=0", "sort=post_message", "scheduled=maybe", "created_from=yesterday", "after=nonsense"} {
+		values, _ := url.ParseQuery(invalid)
+		if _, err := ParsePostQuery(values); err == nil {
+			t.Errorf("expected %s to be rejected", invalid)
+		}
+	}
+}
+
+func TestPostCursor(t *testing.T) {
+	query := PostQuery{Sort: SortCreatedAt, Order: OrderDesc}
+	created := time.Date(2021, 3, 1, 10, 0, 0, 123456000, time.UTC)
+	postId := uuid.NewV4().String()
+	next := query.NextCursor(DbPost{PostId: postId, CreatedOn: created})
+
+	values := url.Values{}
+	values.Set("sort", "created_at")
+	values.Set("after", next)
+	parsed, err := ParsePostQuery(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.After.PostId != postId || !parsed.After.Value.Equal(created) {
+		t.Fatalf("unexpected cursor %+v", parsed.After)
+	}
+
+	// the cursor belongs to the created_at order
+	values.Del("sort")
+	if _, err := ParsePostQuery(values); err != ErrInvalidCursor {
+		t.Fatalf("expected ErrInvalidCursor got %v", err)
+	}
+
+	// the post id of a cursor is used in the query, it has to be a uuid
+	values.Set("sort", "created_at")
+	values.Set("after", PostCursor{Sort: SortCreatedAt, Order: OrderDesc, Value: created, PostId: "1' OR '1'='1"}.Encode())
+	if _, err := ParsePostQuery(values); err != ErrInvalidCursor {
+		t.Fatalf("expected ErrInvalidCursor for a malformed post id got %v", err)
+	}
+}
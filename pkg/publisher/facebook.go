@@ -0,0 +1,136 @@
+package publisher
+
+import (
+	"fmt"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// FacebookPublisher publishes through the facebook graph api
+type FacebookPublisher struct {
+	// BaseURL overrides FACEBOOK_GRAPH_URL
+	BaseURL string
+}
+
+func (p *FacebookPublisher) url() string {
+	return baseUrl(p.BaseURL, "https://graph.facebook.com", "FACEBOOK_GRAPH_URL", "FACEBOOK_COMMENTS_URL")
+}
+
+/* Publish uploads the images as unpublished photos first and attaches them to the feed post.
+Feed posts cannot hold videos, posts with a video or an animation are published as that video with the message as description. */
+func (p *FacebookPublisher) Publish(account pkg.ApplicationInfo, post pkg.DbPost, attachments []Attachment) (string, error) {
+	if video, ok := firstOf(attachments, pkg.MediaVideo, pkg.MediaAnimation); ok {
+		return p.publishVideo(account, post, video)
+	}
+
+	form := url.Values{
+		"message":      {message(post)},
+		"access_token": {account.UserAccessToken},
+	}
+	for i, image := range attachments {
+		photoId, err := p.uploadPhoto(account, image)
+		if err != nil {
+			return "", err
+		}
+		form.Set(fmt.Sprintf("attached_media[%d]", i), fmt.Sprintf(`{"media_fbid":"%s"}`, photoId))
+	}
+	request, err := http.NewRequest(http.MethodPost, p.url()+"/v10.0/"+account.UserId+"/feed", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var published struct {
+		Id string `json:"id"`
+	}
+	_, err = do(request, &published)
+	return published.Id, err
+}
+
+func (p *FacebookPublisher) uploadPhoto(account pkg.ApplicationInfo, image Attachment) (string, error) {
+	body, contentType, err := multipartBody(map[string]string{
+		"published":    "false",
+		"access_token": account.UserAccessToken,
+	}, "source", image)
+	if err != nil {
+		return "", err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, p.url()+"/v10.0/"+account.UserId+"/photos", body)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", contentType)
+
+	var photo struct {
+		Id string `json:"id"`
+	}
+	_, err = do(request, &photo)
+	return photo.Id, err
+}
+
+// publishVideo uploads the video in one request, it is published right away
+func (p *FacebookPublisher) publishVideo(account pkg.ApplicationInfo, post pkg.DbPost, video Attachment) (string, error) {
+	body, contentType, err := multipartBody(map[string]string{
+		"description":  message(post),
+		"access_token": account.UserAccessToken,
+	}, "source", video)
+	if err != nil {
+		return "", err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, p.url()+"/v10.0/"+account.UserId+"/videos", body)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", contentType)
+
+	var published struct {
+		Id string `json:"id"`
+	}
+	_, err = do(request, &published)
+	return published.Id, err
+}
+
+func (p *FacebookPublisher) Delete(account pkg.ApplicationInfo, networkPostId string) error {
+	query := url.Values{"access_token": {account.UserAccessToken}}
+	request, err := http.NewRequest(http.MethodDelete, p.url()+"/v10.0/"+networkPostId+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = do(request, nil)
+	return err
+}
+
+func (p *FacebookPublisher) FetchComments(account pkg.ApplicationInfo, networkPostId string) (pkg.Comment, error) {
+	var comment pkg.Comment
+	query := url.Values{"access_token": {account.UserAccessToken}}
+	request, err := http.NewRequest(http.MethodGet, p.url()+"/v10.0/"+networkPostId+"/comments?"+query.Encode(), nil)
+	if err != nil {
+		return comment, err
+	}
+
+	_, err = do(request, &comment)
+	return comment, err
+}
+
+// RefreshToken exchanges the current long lived token for a new one
+func (p *FacebookPublisher) RefreshToken(account pkg.ApplicationInfo) (pkg.AuthResponse, error) {
+	var token pkg.AuthResponse
+	query := url.Values{
+		"grant_type":        {"fb_exchange_token"},
+		"client_id":         {account.ApplicationId},
+		"client_secret":     {account.ApplicationSecret},
+		"fb_exchange_token": {account.UserAccessToken},
+	}
+	request, err := http.NewRequest(http.MethodGet, p.url()+"/oauth/access_token?"+query.Encode(), nil)
+	if err != nil {
+		return token, err
+	}
+
+	_, err = do(request, &token)
+	return token, err
+}
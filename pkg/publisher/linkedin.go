@@ -0,0 +1,280 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LinkedInPublisher publishes through the linkedin v2 ugc posts api
+type LinkedInPublisher struct {
+	// BaseURL overrides LINKEDIN_API_URL
+	BaseURL string
+	// AuthURL overrides LINKEDIN_AUTH_URL
+	AuthURL string
+}
+
+func (p *LinkedInPublisher) url() string {
+	return baseUrl(p.BaseURL, "https://api.linkedin.com", "LINKEDIN_API_URL")
+}
+
+func (p *LinkedInPublisher) authUrl() string {
+	return baseUrl(p.AuthURL, "https://www.linkedin.com", "LINKEDIN_AUTH_URL")
+}
+
+// author returns the urn posts are published under, accounts store either a person id or a full urn
+func author(account pkg.ApplicationInfo) string {
+	if strings.HasPrefix(account.UserId, "urn:") {
+		return account.UserId
+	}
+	return "urn:li:person:" + account.UserId
+}
+
+/* Publish registers and uploads every image as an asset first and shares them along with the text.
+A share holds a single video, posts with a video only share that video. Animated gifs are shared as images. */
+func (p *LinkedInPublisher) Publish(account pkg.ApplicationInfo, post pkg.DbPost, attachments []Attachment) (string, error) {
+	content := map[string]interface{}{
+		"shareCommentary":    map[string]string{"text": message(post)},
+		"shareMediaCategory": "NONE",
+	}
+	category, recipe := "IMAGE", "feedshare-image"
+	if video, ok := firstOf(attachments, pkg.MediaVideo); ok {
+		category, recipe = "VIDEO", "feedshare-video"
+		attachments = []Attachment{video}
+	}
+	var media []map[string]string
+	for _, attachment := range attachments {
+		asset, err := p.upload(account, recipe, attachment)
+		if err != nil {
+			return "", err
+		}
+		media = append(media, map[string]string{"status": "READY", "media": asset})
+	}
+	if len(media) > 0 {
+		content["shareMediaCategory"] = category
+		content["media"] = media
+	}
+
+	share := map[string]interface{}{
+		"author":         author(account),
+		"lifecycleState": "PUBLISHED",
+		"specificContent": map[string]interface{}{
+			"com.linkedin.ugc.ShareContent": content,
+		},
+		"visibility": map[string]string{
+			"com.linkedin.ugc.MemberNetworkVisibility": "PUBLIC",
+		},
+	}
+	body, err := json.Marshal(share)
+	if err != nil {
+		return "", err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, p.url()+"/v2/ugcPosts", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	p.authorize(request, account)
+	request.Header.Set("Content-Type", "application/json")
+
+	var published struct {
+		Id string `json:"id"`
+	}
+	response, err := do(request, &published)
+	if err != nil {
+		return "", err
+	}
+	if published.Id == "" {
+		published.Id = response.Header.Get("X-RestLi-Id")
+	}
+	return published.Id, nil
+}
+
+// upload registers an asset of the recipe for the account and uploads its content, it returns the asset urn
+func (p *LinkedInPublisher) upload(account pkg.ApplicationInfo, recipe string, attachment Attachment) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"registerUploadRequest": map[string]interface{}{
+			"recipes": []string{"urn:li:digitalmediaRecipe:" + recipe},
+			"owner":   author(account),
+			"serviceRelationships": []map[string]string{
+				{"relationshipType": "OWNER", "identifier": "urn:li:userGeneratedContent"},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, p.url()+"/v2/assets?action=registerUpload", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	p.authorize(request, account)
+	request.Header.Set("Content-Type", "application/json")
+
+	var registered struct {
+		Value struct {
+			UploadMechanism struct {
+				Request struct {
+					UploadUrl string `json:"uploadUrl"`
+				} `json:"com.linkedin.digitalmedia.uploading.MediaUploadHttpRequest"`
+			} `json:"uploadMechanism"`
+			Asset string `json:"asset"`
+		} `json:"value"`
+	}
+	_, err = do(request, &registered)
+	if err != nil {
+		return "", err
+	}
+
+	request, err = http.NewRequest(http.MethodPut, registered.Value.UploadMechanism.Request.UploadUrl, bytes.NewReader(attachment.Data))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", "Bearer "+account.UserAccessToken)
+	request.Header.Set("Content-Type", attachment.ContentType)
+
+	_, err = do(request, nil)
+	return registered.Value.Asset, err
+}
+
+func (p *LinkedInPublisher) Delete(account pkg.ApplicationInfo, networkPostId string) error {
+	request, err := http.NewRequest(http.MethodDelete, p.url()+"/v2/ugcPosts/"+url.PathEscape(networkPostId), nil)
+	if err != nil {
+		return err
+	}
+	p.authorize(request, account)
+
+	_, err = do(request, nil)
+	return err
+}
+
+func (p *LinkedInPublisher) FetchComments(account pkg.ApplicationInfo, networkPostId string) (pkg.Comment, error) {
+	var comment pkg.Comment
+	request, err := http.NewRequest(http.MethodGet, p.url()+"/v2/socialActions/"+url.PathEscape(networkPostId)+"/comments", nil)
+	if err != nil {
+		return comment, err
+	}
+	p.authorize(request, account)
+
+	var comments struct {
+		Elements []struct {
+			Id      string `json:"id"`
+			Actor   string `json:"actor"`
+			Message struct {
+				Text string `json:"text"`
+			} `json:"message"`
+			Created struct {
+				Time int64 `json:"time"`
+			} `json:"created"`
+		} `json:"elements"`
+	}
+	_, err = do(request, &comments)
+	if err != nil {
+		return comment, err
+	}
+
+	comment.Data = []pkg.CommentData{}
+	for _, element := range comments.Elements {
+		comment.Data = append(comment.Data, pkg.CommentData{
+			Id:          element.Id,
+			Message:     element.Message.Text,
+			From:        pkg.From{Id: element.Actor},
+			CreatedTime: time.Unix(0, element.Created.Time*int64(time.Millisecond)).UTC().Format(time.RFC3339),
+		})
+	}
+	return comment, nil
+}
+
+// RefreshToken needs programmatic refresh tokens to be enabled on the linkedin application
+func (p *LinkedInPublisher) RefreshToken(account pkg.ApplicationInfo) (pkg.AuthResponse, error) {
+	var token pkg.AuthResponse
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {account.RefreshToken},
+		"client_id":     {account.ApplicationId},
+		"client_secret": {account.ApplicationSecret},
+	}
+	request, err := http.NewRequest(http.MethodPost, p.authUrl()+"/oauth/v2/accessToken", strings.NewReader(form.Encode()))
+	if err != nil {
+		return token, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	_, err = do(request, &token)
+	return token, err
+}
+
+// ExchangeCode trades an authorization code for an access token
+func (p *LinkedInPublisher) ExchangeCode(code string, clientId string, clientSecret string, redirectUri string) (pkg.AuthResponse, error) {
+	var token pkg.AuthResponse
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectUri},
+		"client_id":     {clientId},
+		"client_secret": {clientSecret},
+	}
+	request, err := http.NewRequest(http.MethodPost, p.authUrl()+"/oauth/v2/accessToken", strings.NewReader(form.Encode()))
+	if err != nil {
+		return token, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	_, err = do(request, &token)
+	return token, err
+}
+
+// Me returns the member the access token belongs to
+func (p *LinkedInPublisher) Me(accessToken string) (pkg.LinkedInUserData, error) {
+	var me pkg.LinkedInUserData
+	request, err := http.NewRequest(http.MethodGet, p.url()+"/v2/me", nil)
+	if err != nil {
+		return me, err
+	}
+	p.authorize(request, pkg.ApplicationInfo{UserAccessToken: accessToken})
+
+	_, err = do(request, &me)
+	return me, err
+}
+
+// Organizations returns the company pages the member administers
+func (p *LinkedInPublisher) Organizations(accessToken string) ([]pkg.LinkedInOrganization, error) {
+	query := "q=roleAssignee&role=ADMINISTRATOR&state=APPROVED&projection=(elements*(organization,organization~(localizedName)))"
+	request, err := http.NewRequest(http.MethodGet, p.url()+"/v2/organizationAcls?"+query, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.authorize(request, pkg.ApplicationInfo{UserAccessToken: accessToken})
+
+	var acls struct {
+		Elements []struct {
+			Organization string `json:"organization"`
+			Details      struct {
+				LocalizedName string `json:"localizedName"`
+			} `json:"organization~"`
+		} `json:"elements"`
+	}
+	_, err = do(request, &acls)
+	if err != nil {
+		return nil, err
+	}
+
+	var organizations []pkg.LinkedInOrganization
+	for _, element := range acls.Elements {
+		organizations = append(organizations, pkg.LinkedInOrganization{
+			Urn:  element.Organization,
+			Name: element.Details.LocalizedName,
+		})
+	}
+	return organizations, nil
+}
+
+func (p *LinkedInPublisher) authorize(request *http.Request, account pkg.ApplicationInfo) {
+	request.Header.Set("Authorization", "Bearer "+account.UserAccessToken)
+	request.Header.Set("X-Restli-Protocol-Version", "2.0.0")
+}
@@ -0,0 +1,261 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"gitlab.com/pbobby001/postit-api/db"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Publisher sends posts to a social network on behalf of a connected account
+type Publisher interface {
+	// Publish sends the post along with its attachments and returns the id the network gave it
+	Publish(account pkg.ApplicationInfo, post pkg.DbPost, attachments []Attachment) (string, error)
+	// Delete removes a published post from the network
+	Delete(account pkg.ApplicationInfo, networkPostId string) error
+	// FetchComments returns the comments left on a published post
+	FetchComments(account pkg.ApplicationInfo, networkPostId string) (pkg.Comment, error)
+	// RefreshToken exchanges the account credentials for a fresh access token
+	RefreshToken(account pkg.ApplicationInfo) (pkg.AuthResponse, error)
+}
+
+// Attachment is the content of a media record as it is sent to a network, Kind is one of the pkg.Media* kinds
+type Attachment struct {
+	Kind        string
+	ContentType string
+	Data        []byte
+}
+
+var (
+	// ErrUnknownApplication is returned when no publisher is registered for an application_name
+	ErrUnknownApplication = errors.New("no publisher registered for application")
+
+	mu         sync.RWMutex
+	publishers = make(map[string]Publisher)
+
+	client = &http.Client{Timeout: 30 * time.Second}
+
+	// posts are published without their media until the media records and their store are set
+	mediaRecords repository.MediaRepository
+	mediaStore   storage.MediaStore
+)
+
+// Post status columns set once a post has been published to a network
+var statusColumns = map[string]string{
+	"facebook":  "post_fb_status",
+	"twitter":   "post_tw_status",
+	"linked_in": "post_li_status",
+}
+
+func init() {
+	Register("facebook", &FacebookPublisher{})
+	Register("twitter", &TwitterPublisher{})
+	Register("linked_in", &LinkedInPublisher{})
+}
+
+// Register makes a publisher available for accounts stored with the given application_name
+func Register(applicationName string, publisher Publisher) {
+	mu.Lock()
+	defer mu.Unlock()
+	publishers[applicationName] = publisher
+}
+
+// Get returns the publisher registered for the application_name
+func Get(applicationName string) (Publisher, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	publisher, ok := publishers[applicationName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownApplication, applicationName)
+	}
+	return publisher, nil
+}
+
+// UseMedia makes the media of the posts available to the publishers
+func UseMedia(media repository.MediaRepository, store storage.MediaStore) {
+	mu.Lock()
+	defer mu.Unlock()
+	mediaRecords, mediaStore = media, store
+}
+
+/* PublishPost publishes the post through the publisher of the account's network and
+flags the post as published to that network.
+Posts with media the network does not take are not published to it. */
+func PublishPost(tenantNamespace string, account pkg.ApplicationInfo, post pkg.DbPost) error {
+	publisher, err := Get(account.ApplicationName)
+	if err != nil {
+		return err
+	}
+
+	mu.RLock()
+	media, store := mediaRecords, mediaStore
+	mu.RUnlock()
+	var attachments []Attachment
+	if media != nil && store != nil {
+		attachments, err = loadAttachments(media, store, tenantNamespace, account.ApplicationName, post)
+		if err != nil {
+			return err
+		}
+	}
+
+	networkPostId, err := publisher.Publish(account, post, attachments)
+	if err != nil {
+		return err
+	}
+
+	if account.ApplicationName == "facebook" {
+		// comments are fetched later through the facebook post id
+		query := fmt.Sprintf("UPDATE %s.post SET facebook_post_id = $1, facebook_user_id = $2, post_fb_status = $3, updated_at = CURRENT_TIMESTAMP WHERE post_id = $4", pq.QuoteIdentifier(tenantNamespace))
+		_, err = db.Connection.Exec(query, networkPostId, account.UserId, true, post.PostId)
+		return err
+	}
+
+	query := fmt.Sprintf("UPDATE %s.post SET %s = $1, updated_at = CURRENT_TIMESTAMP WHERE post_id = $2", pq.QuoteIdentifier(tenantNamespace), statusColumns[account.ApplicationName])
+	_, err = db.Connection.Exec(query, true, post.PostId)
+	return err
+}
+
+/* loadAttachments reads the media records of the post and checks them against the limits of network.
+Images are sent as the rendition made for network, media stored before renditions existed as well as
+videos and animations are sent as they are. */
+func loadAttachments(media repository.MediaRepository, store storage.MediaStore, tenantNamespace string, network string, post pkg.DbPost) ([]Attachment, error) {
+	var attachments []Attachment
+	for _, ref := range post.Media {
+		record, err := media.Get(tenantNamespace, ref.Id)
+		if err != nil {
+			return nil, fmt.Errorf("media %s: %v", ref.Id, err)
+		}
+		err = pkg.CheckLimits(network, record)
+		if err != nil {
+			return nil, fmt.Errorf("media %s: %v", ref.Id, err)
+		}
+		isImage := record.Kind == pkg.MediaImage || record.Kind == ""
+
+		var data []byte
+		err = storage.ErrNotFound
+		if spec, ok := pkg.RenditionFor(network); ok && isImage {
+			key, keyErr := pkg.RenditionKey(tenantNamespace, record.BlobId, spec.Name)
+			if keyErr != nil {
+				return nil, keyErr
+			}
+			data, err = store.Get(key)
+		}
+		if err == storage.ErrNotFound {
+			key, keyErr := pkg.MediaKey(tenantNamespace, record.BlobId)
+			if keyErr != nil {
+				return nil, keyErr
+			}
+			data, err = store.Get(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("media %s: %v", ref.Id, err)
+		}
+
+		attachment := Attachment{Kind: record.Kind, ContentType: record.ContentType, Data: data}
+		if isImage {
+			// renditions may be encoded differently than the original
+			attachment.Kind, attachment.ContentType = pkg.MediaImage, http.DetectContentType(data)
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+// firstOf returns the first attachment of one of the kinds
+func firstOf(attachments []Attachment, kinds ...string) (Attachment, bool) {
+	for _, attachment := range attachments {
+		for _, kind := range kinds {
+			if attachment.Kind == kind {
+				return attachment, true
+			}
+		}
+	}
+	return Attachment{}, false
+}
+
+// multipartBody builds a form with the fields and the attachment as a file under fileField
+func multipartBody(fields map[string]string, fileField string, attachment Attachment) (*bytes.Buffer, string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		err := form.WriteField(name, value)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="media"`, fileField))
+	header.Set("Content-Type", attachment.ContentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return nil, "", err
+	}
+	_, err = part.Write(attachment.Data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = form.Close()
+	return &body, form.FormDataContentType(), err
+}
+
+// message builds the text sent to the networks from the post message and its hash tags
+func message(post pkg.DbPost) string {
+	if len(post.HashTags) == 0 {
+		return post.PostMessage
+	}
+	return post.PostMessage + "\n\n" + strings.Join(post.HashTags, " ")
+}
+
+// baseUrl returns the configured url, then the first non empty environment variable, then the fallback
+func baseUrl(configured string, fallback string, envs ...string) string {
+	if configured != "" {
+		return strings.TrimRight(configured, "/")
+	}
+	for _, env := range envs {
+		if value := os.Getenv(env); value != "" {
+			return strings.TrimRight(value, "/")
+		}
+	}
+	return fallback
+}
+
+// do sends the request and decodes a successful json response into out
+func do(request *http.Request, out interface{}) (*http.Response, error) {
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return response, err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response, fmt.Errorf("%s responded with %s: %s", request.URL.Host, response.Status, string(body))
+	}
+
+	if out == nil || len(body) == 0 {
+		return response, nil
+	}
+	return response, json.Unmarshal(body, out)
+}
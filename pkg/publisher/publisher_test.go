@@ -0,0 +1,472 @@
+package publisher
+
+import (
+	"encoding/json"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testPost = pkg.DbPost{
+	PostId:      "8b0b2b0c-0a4b-4c55-9d8b-0d0d7e2f1f0a",
+	PostMessage: "Fresh bread today",
+	HashTags:    []string{"#bakery"},
+}
+
+func TestFacebookPublisher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v10.0/42/feed":
+			_ = r.ParseForm()
+			if r.Form.Get("access_token") != "fb-token" || r.Form.Get("message") != "Fresh bread today\n\n#bakery" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"id":"42_1"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v10.0/42_1/comments":
+			_, _ = w.Write([]byte(`{"data":[{"id":"c1","message":"yum","from":{"name":"Ama","id":"7"}}]}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/oauth/access_token":
+			if r.URL.Query().Get("fb_exchange_token") != "fb-token" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token":"fb-token-2","token_type":"bearer","expires_in":5183944}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	publisher := &FacebookPublisher{BaseURL: server.URL}
+	account := pkg.ApplicationInfo{ApplicationName: "facebook", UserId: "42", UserAccessToken: "fb-token"}
+
+	id, err := publisher.Publish(account, testPost, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "42_1" {
+		t.Fatalf("expected post id 42_1 got %s", id)
+	}
+
+	comment, err := publisher.FetchComments(account, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comment.Data) != 1 || comment.Data[0].From.Name != "Ama" {
+		t.Fatalf("unexpected comments %+v", comment)
+	}
+
+	token, err := publisher.RefreshToken(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "fb-token-2" {
+		t.Fatalf("unexpected token %+v", token)
+	}
+}
+
+func TestTwitterPublisher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tw-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/2/tweets":
+			var tweet map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&tweet)
+			if !strings.HasPrefix(tweet["text"], "Fresh bread today") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"data":{"id":"1001","text":"Fresh bread today"}}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/2/tweets/search/recent":
+			_, _ = w.Write([]byte(`{"data":[{"id":"1002","text":"@bakery yes","author_id":"9"}],"includes":{"users":[{"id":"9","username":"kofi"}]}}`))
+		case r.Method == http.MethodDelete && r.URL.Path == "/2/tweets/1001":
+			_, _ = w.Write([]byte(`{"data":{"deleted":true}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	publisher := &TwitterPublisher{BaseURL: server.URL}
+	account := pkg.ApplicationInfo{ApplicationName: "twitter", UserId: "9", UserAccessToken: "tw-token"}
+
+	id, err := publisher.Publish(account, testPost, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "1001" {
+		t.Fatalf("expected tweet id 1001 got %s", id)
+	}
+
+	comment, err := publisher.FetchComments(account, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comment.Data) != 1 || comment.Data[0].From.Name != "kofi" {
+		t.Fatalf("unexpected comments %+v", comment)
+	}
+
+	if err = publisher.Delete(account, id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTwitterOAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2/oauth2/token":
+			_ = r.ParseForm()
+			clientId, clientSecret, ok := r.BasicAuth()
+			if !ok || clientId != "client" || clientSecret != "secret" || r.Form.Get("code_verifier") != "verifier" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"token_type":"bearer","expires_in":7200,"access_token":"tw-token","refresh_token":"tw-refresh"}`))
+		case "/2/users/me":
+			if r.Header.Get("Authorization") != "Bearer tw-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"id":"9","name":"Kofi","username":"kofi"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	publisher := &TwitterPublisher{BaseURL: server.URL}
+	token, err := publisher.ExchangeCode(pkg.TwitterCode{Code: "code", CodeVerifier: "verifier"}, "client", "secret", "http://localhost:8080/twitter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "tw-token" || token.RefreshToken != "tw-refresh" {
+		t.Fatalf("unexpected token %+v", token)
+	}
+
+	user, err := publisher.Me(token.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id != "9" || user.Username != "kofi" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+}
+
+func TestLinkedInPublisher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v2/ugcPosts" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"author":"urn:li:organization:5"`) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("X-RestLi-Id", "urn:li:share:77")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	publisher := &LinkedInPublisher{BaseURL: server.URL}
+	account := pkg.ApplicationInfo{ApplicationName: "linked_in", UserId: "urn:li:organization:5", UserAccessToken: "li-token"}
+
+	id, err := publisher.Publish(account, testPost, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "urn:li:share:77" {
+		t.Fatalf("expected share urn got %s", id)
+	}
+}
+
+func TestGetUnknownApplication(t *testing.T) {
+	if _, err := Get("myspace"); err == nil {
+		t.Fatal("expected an error for an unknown application")
+	}
+}
+
+func TestLinkedInOAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/v2/accessToken":
+			_ = r.ParseForm()
+			if r.Form.Get("code") != "code" || r.Form.Get("client_secret") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token":"li-token","expires_in":5184000}`))
+		case "/v2/me":
+			_, _ = w.Write([]byte(`{"id":"abc","localizedFirstName":"Ama","localizedLastName":"Owusu"}`))
+		case "/v2/organizationAcls":
+			if r.URL.Query().Get("role") != "ADMINISTRATOR" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"elements":[{"organization":"urn:li:organization:5","organization~":{"localizedName":"Ama Bakery"}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	publisher := &LinkedInPublisher{BaseURL: server.URL, AuthURL: server.URL}
+	token, err := publisher.ExchangeCode("code", "client", "secret", "http://localhost:8080/linkedin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "li-token" {
+		t.Fatalf("unexpected token %+v", token)
+	}
+
+	user, err := publisher.Me(token.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id != "abc" || user.FirstName != "Ama" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	organizations, err := publisher.Organizations(token.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(organizations) != 1 || organizations[0].Urn != "urn:li:organization:5" || organizations[0].Name != "Ama Bakery" {
+		t.Fatalf("unexpected organizations %+v", organizations)
+	}
+}
+
+func TestPublishImages(t *testing.T) {
+	image := Attachment{Kind: pkg.MediaImage, ContentType: "image/jpeg", Data: []byte("jpeg")}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v10.0/42/photos" || r.URL.Path == "/2/media/upload":
+			file, header, err := r.FormFile("source")
+			if r.URL.Path == "/2/media/upload" {
+				file, header, err = r.FormFile("media")
+			}
+			if err != nil || header.Header.Get("Content-Type") != "image/jpeg" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data, _ := ioutil.ReadAll(file)
+			if string(data) != "jpeg" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"id":"photo-1","data":{"id":"media-1"}}`))
+		case r.URL.Path == "/v10.0/42/feed":
+			_ = r.ParseForm()
+			if r.Form.Get("attached_media[0]") != `{"media_fbid":"photo-1"}` || r.Form.Get("published") != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"id":"42_1"}`))
+		case r.URL.Path == "/2/tweets":
+			body, _ := ioutil.ReadAll(r.Body)
+			if !strings.Contains(string(body), `"media":{"media_ids":["media-1","media-1","media-1","media-1"]}`) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"id":"1001"}}`))
+		case r.URL.Path == "/v2/assets" && r.URL.Query().Get("action") == "registerUpload":
+			_, _ = w.Write([]byte(`{"value":{"uploadMechanism":{"com.linkedin.digitalmedia.uploading.MediaUploadHttpRequest":{"uploadUrl":"` + server.URL + `/upload/1"}},"asset":"urn:li:digitalmediaAsset:1"}}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/upload/1":
+			data, _ := ioutil.ReadAll(r.Body)
+			if string(data) != "jpeg" || r.Header.Get("Authorization") != "Bearer li-token" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case r.URL.Path == "/v2/ugcPosts":
+			body, _ := ioutil.ReadAll(r.Body)
+			if !strings.Contains(string(body), `"shareMediaCategory":"IMAGE"`) || !strings.Contains(string(body), `"media":"urn:li:digitalmediaAsset:1"`) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("X-RestLi-Id", "urn:li:share:77")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	if _, err := (&FacebookPublisher{BaseURL: server.URL}).Publish(pkg.ApplicationInfo{UserId: "42", UserAccessToken: "fb-token"}, testPost, []Attachment{image}); err != nil {
+		t.Fatal(err)
+	}
+	// twitter takes four images at most
+	images := []Attachment{image, image, image, image, image}
+	if _, err := (&TwitterPublisher{BaseURL: server.URL}).Publish(pkg.ApplicationInfo{UserId: "9", UserAccessToken: "tw-token"}, testPost, images); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&LinkedInPublisher{BaseURL: server.URL}).Publish(pkg.ApplicationInfo{UserId: "5", UserAccessToken: "li-token"}, testPost, []Attachment{image}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishVideo(t *testing.T) {
+	video := Attachment{Kind: pkg.MediaVideo, ContentType: "video/mp4", Data: []byte("mp4 video")}
+	image := Attachment{Kind: pkg.MediaImage, ContentType: "image/jpeg", Data: []byte("jpeg")}
+	var commands []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v10.0/42/videos":
+			file, header, err := r.FormFile("source")
+			if err != nil || header.Header.Get("Content-Type") != "video/mp4" || r.FormValue("description") != message(testPost) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data, _ := ioutil.ReadAll(file)
+			if string(data) != "mp4 video" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"id":"video-1"}`))
+		case r.URL.Path == "/2/media/upload":
+			if r.Method == http.MethodGet {
+				commands = append(commands, r.URL.Query().Get("command"))
+				_, _ = w.Write([]byte(`{"data":{"id":"media-1","processing_info":{"state":"succeeded"}}}`))
+				return
+			}
+			_ = r.ParseMultipartForm(1 << 20)
+			command := r.FormValue("command")
+			commands = append(commands, command)
+			switch command {
+			case "INIT":
+				if r.FormValue("media_category") != "tweet_video" || r.FormValue("total_bytes") != "9" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				_, _ = w.Write([]byte(`{"data":{"id":"media-1"}}`))
+			case "APPEND":
+				file, _, err := r.FormFile("media")
+				if err != nil || r.FormValue("media_id") != "media-1" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				data, _ := ioutil.ReadAll(file)
+				if string(data) != "mp4 video" {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+			case "FINALIZE":
+				_, _ = w.Write([]byte(`{"data":{"id":"media-1","processing_info":{"state":"pending","check_after_secs":0}}}`))
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		case r.URL.Path == "/2/tweets":
+			body, _ := ioutil.ReadAll(r.Body)
+			if !strings.Contains(string(body), `"media":{"media_ids":["media-1"]}`) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"id":"1001"}}`))
+		case r.URL.Path == "/v2/assets" && r.URL.Query().Get("action") == "registerUpload":
+			body, _ := ioutil.ReadAll(r.Body)
+			if !strings.Contains(string(body), "urn:li:digitalmediaRecipe:feedshare-video") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"value":{"uploadMechanism":{"com.linkedin.digitalmedia.uploading.MediaUploadHttpRequest":{"uploadUrl":"` + server.URL + `/upload/1"}},"asset":"urn:li:digitalmediaAsset:1"}}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/upload/1":
+			w.WriteHeader(http.StatusCreated)
+		case r.URL.Path == "/v2/ugcPosts":
+			body, _ := ioutil.ReadAll(r.Body)
+			if !strings.Contains(string(body), `"shareMediaCategory":"VIDEO"`) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("X-RestLi-Id", "urn:li:share:77")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	attachments := []Attachment{image, video}
+	id, err := (&FacebookPublisher{BaseURL: server.URL}).Publish(pkg.ApplicationInfo{UserId: "42", UserAccessToken: "fb-token"}, testPost, attachments)
+	if err != nil || id != "video-1" {
+		t.Fatalf("expected the video to be published got %s: %v", id, err)
+	}
+	if _, err = (&TwitterPublisher{BaseURL: server.URL}).Publish(pkg.ApplicationInfo{UserId: "9", UserAccessToken: "tw-token"}, testPost, attachments); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(commands, ",") != "INIT,APPEND,FINALIZE,STATUS" {
+		t.Fatalf("unexpected upload commands %v", commands)
+	}
+	if _, err = (&LinkedInPublisher{BaseURL: server.URL}).Publish(pkg.ApplicationInfo{UserId: "5", UserAccessToken: "li-token"}, testPost, attachments); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadAttachments(t *testing.T) {
+	store := storage.NewMemoryStore()
+	media := repository.NewMemoryMediaRepository(nil)
+	put := func(key string, err error, data string) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = store.Put(key, []byte(data), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	create := func(record pkg.Media) string {
+		if _, err := media.Create("postit", record); err != nil {
+			t.Fatal(err)
+		}
+		return record.MediaId
+	}
+
+	with := create(pkg.NewMedia([]byte("original")))
+	key, err := pkg.MediaKey("postit", with)
+	put(key, err, "original")
+	key, err = pkg.RenditionKey("postit", with, "twitter_card")
+	put(key, err, "twitter card")
+	// stored before renditions existed
+	without := create(pkg.NewMedia([]byte("old original")))
+	key, err = pkg.MediaKey("postit", without)
+	put(key, err, "old original")
+
+	video := pkg.NewMedia([]byte("mp4"))
+	video.Kind, video.ContentType, video.Duration, video.Codec = pkg.MediaVideo, "video/mp4", 30, "avc1"
+	create(video)
+	key, err = pkg.MediaKey("postit", video.MediaId)
+	put(key, err, "mp4")
+
+	post := pkg.DbPost{Media: pkg.MediaRefs([]string{with, without, video.MediaId})}
+	attachments, err := loadAttachments(media, store, "postit", "twitter", post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 3 || string(attachments[0].Data) != "twitter card" || string(attachments[1].Data) != "old original" {
+		t.Fatalf("unexpected attachments %+v", attachments)
+	}
+	if attachments[2].Kind != pkg.MediaVideo || attachments[2].ContentType != "video/mp4" || string(attachments[2].Data) != "mp4" {
+		t.Fatalf("expected the video to be sent as it is got %+v", attachments[2])
+	}
+
+	// linkedin takes videos of three seconds at least
+	video = pkg.NewMedia([]byte("short mp4"))
+	video.Kind, video.ContentType, video.Duration, video.Codec = pkg.MediaVideo, "video/mp4", 1, "avc1"
+	post.Media = pkg.MediaRefs([]string{create(video)})
+	if _, err = loadAttachments(media, store, "postit", "linked_in", post); err == nil || !strings.Contains(err.Error(), pkg.UploadLimitExceeded) {
+		t.Fatalf("expected the video to exceed the limits of linkedin got %v", err)
+	}
+
+	post.Media = pkg.MediaRefs([]string{"missing"})
+	if _, err = loadAttachments(media, store, "postit", "twitter", post); err == nil {
+		t.Fatal("expected missing media to fail the post")
+	}
+}
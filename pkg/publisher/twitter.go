@@ -0,0 +1,316 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TwitterPublisher publishes through the twitter v2 api with oauth 2.0 user tokens
+type TwitterPublisher struct {
+	// BaseURL overrides TWITTER_API_URL
+	BaseURL string
+}
+
+func (p *TwitterPublisher) url() string {
+	return baseUrl(p.BaseURL, "https://api.twitter.com", "TWITTER_API_URL")
+}
+
+// twitter takes up to four images or a single video or animated gif per tweet
+const maxTweetImages = 4
+
+// videos are uploaded in chunks of this size, their processing is checked this often before giving up
+const (
+	tweetChunkSize      = 4 << 20
+	maxTweetMediaChecks = 60
+)
+
+/* Publish uploads the attachments through the media endpoint first.
+Images beyond the fourth are left out, a video or animated gif is sent on its own. */
+func (p *TwitterPublisher) Publish(account pkg.ApplicationInfo, post pkg.DbPost, attachments []Attachment) (string, error) {
+	tweet := map[string]interface{}{"text": message(post)}
+	var mediaIds []string
+	if video, ok := firstOf(attachments, pkg.MediaVideo, pkg.MediaAnimation); ok {
+		mediaId, err := p.uploadVideo(account, video)
+		if err != nil {
+			return "", err
+		}
+		mediaIds = append(mediaIds, mediaId)
+	} else {
+		if len(attachments) > maxTweetImages {
+			attachments = attachments[:maxTweetImages]
+		}
+		for _, image := range attachments {
+			mediaId, err := p.uploadMedia(account, image)
+			if err != nil {
+				return "", err
+			}
+			mediaIds = append(mediaIds, mediaId)
+		}
+	}
+	if len(mediaIds) > 0 {
+		tweet["media"] = map[string][]string{"media_ids": mediaIds}
+	}
+
+	body, err := json.Marshal(tweet)
+	if err != nil {
+		return "", err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, p.url()+"/2/tweets", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", "Bearer "+account.UserAccessToken)
+	request.Header.Set("Content-Type", "application/json")
+
+	var created struct {
+		Data struct {
+			Id string `json:"id"`
+		} `json:"data"`
+	}
+	_, err = do(request, &created)
+	return created.Data.Id, err
+}
+
+func (p *TwitterPublisher) uploadMedia(account pkg.ApplicationInfo, image Attachment) (string, error) {
+	body, contentType, err := multipartBody(map[string]string{"media_category": "tweet_image"}, "media", image)
+	if err != nil {
+		return "", err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, p.url()+"/2/media/upload", body)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", "Bearer "+account.UserAccessToken)
+	request.Header.Set("Content-Type", contentType)
+
+	var media twitterMedia
+	_, err = do(request, &media)
+	return media.Data.Id, err
+}
+
+// twitterMedia is the answer of the media endpoint, ProcessingInfo is only set for videos and animated gifs
+type twitterMedia struct {
+	Data struct {
+		Id             string `json:"id"`
+		ProcessingInfo *struct {
+			State          string `json:"state"`
+			CheckAfterSecs int    `json:"check_after_secs"`
+			Error          struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"processing_info"`
+	} `json:"data"`
+}
+
+/* uploadVideo sends a video or animated gif through the chunked upload commands.
+Twitter processes the file after FINALIZE, the media id can only be used once STATUS tells it succeeded. */
+func (p *TwitterPublisher) uploadVideo(account pkg.ApplicationInfo, video Attachment) (string, error) {
+	category := "tweet_video"
+	if video.Kind == pkg.MediaAnimation {
+		category = "tweet_gif"
+	}
+	media, err := p.mediaCommand(account, url.Values{
+		"command":        {"INIT"},
+		"media_type":     {video.ContentType},
+		"total_bytes":    {strconv.Itoa(len(video.Data))},
+		"media_category": {category},
+	})
+	if err != nil {
+		return "", err
+	}
+	mediaId := media.Data.Id
+
+	for i := 0; i*tweetChunkSize < len(video.Data); i++ {
+		end := (i + 1) * tweetChunkSize
+		if end > len(video.Data) {
+			end = len(video.Data)
+		}
+		chunk := Attachment{Kind: video.Kind, ContentType: "application/octet-stream", Data: video.Data[i*tweetChunkSize : end]}
+		body, contentType, err := multipartBody(map[string]string{
+			"command":       "APPEND",
+			"media_id":      mediaId,
+			"segment_index": strconv.Itoa(i),
+		}, "media", chunk)
+		if err != nil {
+			return "", err
+		}
+
+		request, err := http.NewRequest(http.MethodPost, p.url()+"/2/media/upload", body)
+		if err != nil {
+			return "", err
+		}
+		request.Header.Set("Authorization", "Bearer "+account.UserAccessToken)
+		request.Header.Set("Content-Type", contentType)
+		_, err = do(request, nil)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	media, err = p.mediaCommand(account, url.Values{"command": {"FINALIZE"}, "media_id": {mediaId}})
+	for checks := 0; err == nil && media.Data.ProcessingInfo != nil; checks++ {
+		if checks == maxTweetMediaChecks {
+			return "", fmt.Errorf("twitter is still processing media %s", mediaId)
+		}
+		switch media.Data.ProcessingInfo.State {
+		case "succeeded":
+			return mediaId, nil
+		case "failed":
+			return "", fmt.Errorf("twitter could not process media %s: %s", mediaId, media.Data.ProcessingInfo.Error.Message)
+		}
+		time.Sleep(time.Duration(media.Data.ProcessingInfo.CheckAfterSecs) * time.Second)
+		media, err = p.mediaStatus(account, mediaId)
+	}
+	return mediaId, err
+}
+
+func (p *TwitterPublisher) mediaCommand(account pkg.ApplicationInfo, form url.Values) (twitterMedia, error) {
+	var media twitterMedia
+	request, err := http.NewRequest(http.MethodPost, p.url()+"/2/media/upload", strings.NewReader(form.Encode()))
+	if err != nil {
+		return media, err
+	}
+	request.Header.Set("Authorization", "Bearer "+account.UserAccessToken)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	_, err = do(request, &media)
+	return media, err
+}
+
+func (p *TwitterPublisher) mediaStatus(account pkg.ApplicationInfo, mediaId string) (twitterMedia, error) {
+	var media twitterMedia
+	query := url.Values{"command": {"STATUS"}, "media_id": {mediaId}}
+	request, err := http.NewRequest(http.MethodGet, p.url()+"/2/media/upload?"+query.Encode(), nil)
+	if err != nil {
+		return media, err
+	}
+	request.Header.Set("Authorization", "Bearer "+account.UserAccessToken)
+
+	_, err = do(request, &media)
+	return media, err
+}
+
+func (p *TwitterPublisher) Delete(account pkg.ApplicationInfo, networkPostId string) error {
+	request, err := http.NewRequest(http.MethodDelete, p.url()+"/2/tweets/"+networkPostId, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+account.UserAccessToken)
+
+	_, err = do(request, nil)
+	return err
+}
+
+// FetchComments returns the recent replies in the conversation started by the tweet
+func (p *TwitterPublisher) FetchComments(account pkg.ApplicationInfo, networkPostId string) (pkg.Comment, error) {
+	var comment pkg.Comment
+	query := url.Values{
+		"query":        {"conversation_id:" + networkPostId},
+		"tweet.fields": {"author_id,created_at"},
+		"expansions":   {"author_id"},
+	}
+	request, err := http.NewRequest(http.MethodGet, p.url()+"/2/tweets/search/recent?"+query.Encode(), nil)
+	if err != nil {
+		return comment, err
+	}
+	request.Header.Set("Authorization", "Bearer "+account.UserAccessToken)
+
+	var replies struct {
+		Data []struct {
+			Id        string `json:"id"`
+			Text      string `json:"text"`
+			AuthorId  string `json:"author_id"`
+			CreatedAt string `json:"created_at"`
+		} `json:"data"`
+		Includes struct {
+			Users []struct {
+				Id       string `json:"id"`
+				Username string `json:"username"`
+			} `json:"users"`
+		} `json:"includes"`
+	}
+	_, err = do(request, &replies)
+	if err != nil {
+		return comment, err
+	}
+
+	usernames := make(map[string]string)
+	for _, user := range replies.Includes.Users {
+		usernames[user.Id] = user.Username
+	}
+
+	comment.Data = []pkg.CommentData{}
+	for _, reply := range replies.Data {
+		comment.Data = append(comment.Data, pkg.CommentData{
+			Id:          reply.Id,
+			Message:     reply.Text,
+			From:        pkg.From{Name: usernames[reply.AuthorId], Id: reply.AuthorId},
+			CreatedTime: reply.CreatedAt,
+		})
+	}
+	return comment, nil
+}
+
+// RefreshToken uses the stored refresh token, twitter rotates it on every refresh
+func (p *TwitterPublisher) RefreshToken(account pkg.ApplicationInfo) (pkg.AuthResponse, error) {
+	var token pkg.AuthResponse
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {account.RefreshToken},
+		"client_id":     {account.ApplicationId},
+	}
+	request, err := http.NewRequest(http.MethodPost, p.url()+"/2/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return token, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(account.ApplicationId, account.ApplicationSecret)
+
+	_, err = do(request, &token)
+	return token, err
+}
+
+// ExchangeCode trades an oauth 2.0 authorization code obtained with PKCE for user tokens
+func (p *TwitterPublisher) ExchangeCode(code pkg.TwitterCode, clientId string, clientSecret string, redirectUri string) (pkg.AuthResponse, error) {
+	var token pkg.AuthResponse
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code.Code},
+		"code_verifier": {code.CodeVerifier},
+		"redirect_uri":  {redirectUri},
+		"client_id":     {clientId},
+	}
+	request, err := http.NewRequest(http.MethodPost, p.url()+"/2/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return token, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(clientId, clientSecret)
+
+	_, err = do(request, &token)
+	return token, err
+}
+
+// Me returns the twitter user the access token belongs to
+func (p *TwitterPublisher) Me(accessToken string) (pkg.TwitterUserData, error) {
+	var me struct {
+		Data pkg.TwitterUserData `json:"data"`
+	}
+	request, err := http.NewRequest(http.MethodGet, p.url()+"/2/users/me", nil)
+	if err != nil {
+		return me.Data, err
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+
+	_, err = do(request, &me)
+	return me.Data, err
+}
@@ -0,0 +1,772 @@
+package repository
+
+import (
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryPostRepository keeps posts per tenant in memory
+type MemoryPostRepository struct {
+	mu    sync.RWMutex
+	posts map[string]map[string]pkg.DbPost
+	// media attaches the media of the posts, it is set by NewMemoryMediaRepository
+	media *MemoryMediaRepository
+}
+
+// MemoryScheduleRepository keeps schedules per tenant in memory and flags their posts in posts as scheduled
+type MemoryScheduleRepository struct {
+	mu        sync.RWMutex
+	schedules map[string]map[string]pkg.PostSchedule
+	posts     *MemoryPostRepository
+	outbox    []pkg.OutboxMessage
+}
+
+// MemoryAccountRepository keeps social media accounts per tenant in memory, keyed by network and user id like the application_info constraint
+type MemoryAccountRepository struct {
+	mu       sync.RWMutex
+	accounts map[string]map[string]pkg.ApplicationInfo
+}
+
+// MemoryMediaRepository keeps media records per tenant in memory, the posts using them are looked up in posts
+type MemoryMediaRepository struct {
+	mu    sync.RWMutex
+	media map[string]map[string]pkg.Media
+	posts *MemoryPostRepository
+}
+
+// MemoryUploadJobRepository keeps upload jobs per tenant in memory
+type MemoryUploadJobRepository struct {
+	mu   sync.RWMutex
+	jobs map[string]map[string]pkg.UploadJob
+}
+
+// MemoryApiKeyRepository keeps API keys per tenant in memory
+type MemoryApiKeyRepository struct {
+	mu   sync.RWMutex
+	keys map[string]map[string]pkg.ApiKey
+}
+
+func NewMemoryPostRepository() *MemoryPostRepository {
+	return &MemoryPostRepository{posts: make(map[string]map[string]pkg.DbPost)}
+}
+
+func NewMemoryScheduleRepository(posts *MemoryPostRepository) *MemoryScheduleRepository {
+	return &MemoryScheduleRepository{schedules: make(map[string]map[string]pkg.PostSchedule), posts: posts}
+}
+
+func NewMemoryAccountRepository() *MemoryAccountRepository {
+	return &MemoryAccountRepository{accounts: make(map[string]map[string]pkg.ApplicationInfo)}
+}
+
+func NewMemoryMediaRepository(posts *MemoryPostRepository) *MemoryMediaRepository {
+	m := &MemoryMediaRepository{media: make(map[string]map[string]pkg.Media), posts: posts}
+	if posts != nil {
+		posts.media = m
+	}
+	return m
+}
+
+func NewMemoryUploadJobRepository() *MemoryUploadJobRepository {
+	return &MemoryUploadJobRepository{jobs: make(map[string]map[string]pkg.UploadJob)}
+}
+
+func NewMemoryApiKeyRepository() *MemoryApiKeyRepository {
+	return &MemoryApiKeyRepository{keys: make(map[string]map[string]pkg.ApiKey)}
+}
+
+func (m *MemoryPostRepository) Create(tenantNamespace string, post pkg.DbPost) error {
+	unlock, err := m.attach(tenantNamespace, post.Media)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.posts[tenantNamespace] == nil {
+		m.posts[tenantNamespace] = make(map[string]pkg.DbPost)
+	}
+	now := time.Now()
+	post.CreatedOn, post.UpdatedOn = now, now
+	m.posts[tenantNamespace][post.PostId] = post
+	return nil
+}
+
+func (m *MemoryPostRepository) List(tenantNamespace string, query pkg.PostQuery) (pkg.PostPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	page := pkg.PostPage{Posts: []pkg.DbPost{}}
+	var matching []pkg.DbPost
+	for _, post := range m.posts[tenantNamespace] {
+		if matches(post, query) {
+			matching = append(matching, post)
+		}
+	}
+	page.Total = len(matching)
+
+	// before reports whether a comes first in the requested order
+	before := func(a, b pkg.DbPost) bool {
+		va, vb := query.SortValue(a), query.SortValue(b)
+		if !va.Equal(vb) {
+			return va.Before(vb) == (query.Order == pkg.OrderAsc)
+		}
+		if a.PostId == b.PostId {
+			return false
+		}
+		return (a.PostId < b.PostId) == (query.Order == pkg.OrderAsc)
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return before(matching[i], matching[j])
+	})
+
+	for _, post := range matching {
+		if query.After != nil && !before(pkg.DbPost{PostId: query.After.PostId, CreatedOn: query.After.Value, UpdatedOn: query.After.Value}, post) {
+			continue
+		}
+		if len(page.Posts) == query.Limit {
+			page.NextCursor = query.NextCursor(page.Posts[len(page.Posts)-1])
+			break
+		}
+		page.Posts = append(page.Posts, post)
+	}
+	return page, nil
+}
+
+func matches(post pkg.DbPost, query pkg.PostQuery) bool {
+	flags := []struct {
+		value  bool
+		filter *bool
+	}{
+		{post.Scheduled, query.Scheduled},
+		{post.PostPriority, query.PostPriority},
+		{post.PostFbStatus, query.PostFbStatus},
+		{post.PostTwStatus, query.PostTwStatus},
+		{post.PostLiStatus, query.PostLiStatus},
+	}
+	for _, flag := range flags {
+		if flag.filter != nil && *flag.filter != flag.value {
+			return false
+		}
+	}
+
+	if query.HashTag != "" {
+		found := false
+		for _, hashTag := range post.HashTags {
+			found = found || hashTag == query.HashTag
+		}
+		if !found {
+			return false
+		}
+	}
+	if query.CreatedFrom != nil && post.CreatedOn.Before(*query.CreatedFrom) {
+		return false
+	}
+	if query.CreatedTo != nil && post.CreatedOn.After(*query.CreatedTo) {
+		return false
+	}
+	return true
+}
+
+func (m *MemoryPostRepository) Get(tenantNamespace string, postId string) (pkg.DbPost, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	post, ok := m.posts[tenantNamespace][postId]
+	if !ok {
+		return post, ErrNotFound
+	}
+	return post, nil
+}
+
+func (m *MemoryPostRepository) Update(tenantNamespace string, post pkg.DbPost) error {
+	unlock, err := m.attach(tenantNamespace, post.Media)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.posts[tenantNamespace][post.PostId]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.PostMessage = post.PostMessage
+	stored.HashTags = post.HashTags
+	stored.PostPriority = post.PostPriority
+	stored.Media = post.Media
+	stored.ImagePaths = post.ImagePaths
+	stored.UpdatedOn = time.Now()
+	m.posts[tenantNamespace][post.PostId] = stored
+	return nil
+}
+
+func (m *MemoryPostRepository) Delete(tenantNamespace string, postIds ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, postId := range postIds {
+		delete(m.posts[tenantNamespace], postId)
+	}
+	return nil
+}
+
+/* attach marks the media of a post as attached and keeps the media repository locked until unlock is called,
+the media lock is always taken before the post lock like MemoryMediaRepository.Delete does. */
+func (m *MemoryPostRepository) attach(tenantNamespace string, refs []pkg.MediaRef) (unlock func(), err error) {
+	if m.media == nil {
+		return func() {}, nil
+	}
+	m.media.mu.Lock()
+	for _, ref := range refs {
+		if _, ok := m.media.media[tenantNamespace][ref.Id]; !ok {
+			m.media.mu.Unlock()
+			return nil, ErrUnknownMedia
+		}
+	}
+	for _, ref := range refs {
+		media := m.media.media[tenantNamespace][ref.Id]
+		media.Attached = true
+		m.media.media[tenantNamespace][ref.Id] = media
+	}
+	return m.media.mu.Unlock, nil
+}
+
+// usingMedia returns the ids of the posts using the media record, oldest first
+func (m *MemoryPostRepository) usingMedia(tenantNamespace string, mediaId string) []string {
+	postIds := []string{}
+	if m == nil {
+		return postIds
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var posts []pkg.DbPost
+	for _, post := range m.posts[tenantNamespace] {
+		for _, ref := range post.Media {
+			if ref.Id == mediaId {
+				posts = append(posts, post)
+				break
+			}
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].CreatedOn.Before(posts[j].CreatedOn)
+	})
+	for _, post := range posts {
+		postIds = append(postIds, post.PostId)
+	}
+	return postIds
+}
+
+// removeMedia drops the media record from every post using it
+func (m *MemoryPostRepository) removeMedia(tenantNamespace string, mediaId string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for postId, post := range m.posts[tenantNamespace] {
+		refs := []pkg.MediaRef{}
+		for _, ref := range post.Media {
+			if ref.Id != mediaId {
+				refs = append(refs, ref)
+			}
+		}
+		if len(refs) == len(post.Media) {
+			continue
+		}
+		post.Media = refs
+		post.UpdatedOn = time.Now()
+		m.posts[tenantNamespace][postId] = post
+	}
+}
+
+func (m *MemoryPostRepository) setScheduled(tenantNamespace string, scheduled bool, postIds []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, postId := range postIds {
+		post, ok := m.posts[tenantNamespace][postId]
+		if !ok {
+			continue
+		}
+		post.Scheduled = scheduled
+		post.UpdatedOn = time.Now()
+		m.posts[tenantNamespace][postId] = post
+	}
+}
+
+func (m *MemoryPostRepository) ListFacebookPosts(tenantNamespace string) ([]pkg.FacebookPostData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var posts []pkg.FacebookPostData
+	for _, post := range m.posts[tenantNamespace] {
+		if !post.PostFbStatus {
+			continue
+		}
+		posts = append(posts, pkg.FacebookPostData{
+			PostId:         post.PostId,
+			FacebookPostId: post.FacebookPostId,
+			PostMessage:    post.PostMessage,
+		})
+	}
+	return posts, nil
+}
+
+func (m *MemoryPostRepository) Count(tenantNamespace string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.posts[tenantNamespace]), nil
+}
+
+func (m *MemoryScheduleRepository) Create(tenantNamespace string, schedule pkg.PostSchedule, messages ...pkg.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.schedules[tenantNamespace] == nil {
+		m.schedules[tenantNamespace] = make(map[string]pkg.PostSchedule)
+	}
+	now := time.Now()
+	schedule.Profiles = withProfiles(schedule.Profiles)
+	schedule.CreatedOn, schedule.UpdatedOn = now, now
+	m.schedules[tenantNamespace][schedule.ScheduleId] = schedule
+
+	m.posts.setScheduled(tenantNamespace, true, schedule.PostIds)
+	m.outbox = append(m.outbox, messages...)
+	return nil
+}
+
+func (m *MemoryScheduleRepository) List(tenantNamespace string) ([]pkg.PostSchedule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var schedules []pkg.PostSchedule
+	for _, schedule := range m.schedules[tenantNamespace] {
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].UpdatedOn.After(schedules[j].UpdatedOn)
+	})
+	return schedules, nil
+}
+
+func (m *MemoryScheduleRepository) Update(tenantNamespace string, schedule pkg.PostSchedule, messages ...pkg.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.schedules[tenantNamespace][schedule.ScheduleId]
+	if !ok {
+		return ErrNotFound
+	}
+	previous := stored.PostIds
+	stored.ScheduleTitle = schedule.ScheduleTitle
+	stored.From = schedule.From
+	stored.To = schedule.To
+	stored.PostIds = schedule.PostIds
+	stored.Duration = schedule.Duration
+	stored.PostToFeed = schedule.PostToFeed
+	stored.Profiles = withProfiles(schedule.Profiles)
+	stored.UpdatedOn = time.Now()
+	m.schedules[tenantNamespace][schedule.ScheduleId] = stored
+
+	m.posts.setScheduled(tenantNamespace, true, schedule.PostIds)
+	m.unschedule(tenantNamespace, previous)
+	m.outbox = append(m.outbox, messages...)
+	return nil
+}
+
+func (m *MemoryScheduleRepository) Delete(tenantNamespace string, scheduleId string, messages ...pkg.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.schedules[tenantNamespace][scheduleId]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.schedules[tenantNamespace], scheduleId)
+
+	m.unschedule(tenantNamespace, stored.PostIds)
+	m.outbox = append(m.outbox, messages...)
+	return nil
+}
+
+// Outbox returns the messages recorded along with the schedule changes
+func (m *MemoryScheduleRepository) Outbox() []pkg.OutboxMessage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]pkg.OutboxMessage(nil), m.outbox...)
+}
+
+// unschedule clears the scheduled flag of the posts no schedule refers to anymore, m.mu has to be held
+func (m *MemoryScheduleRepository) unschedule(tenantNamespace string, postIds []string) {
+	referenced := make(map[string]bool)
+	for _, schedule := range m.schedules[tenantNamespace] {
+		for _, postId := range schedule.PostIds {
+			referenced[postId] = true
+		}
+	}
+
+	var unscheduled []string
+	for _, postId := range postIds {
+		if !referenced[postId] {
+			unscheduled = append(unscheduled, postId)
+		}
+	}
+	m.posts.setScheduled(tenantNamespace, false, unscheduled)
+}
+
+func (m *MemoryScheduleRepository) Count(tenantNamespace string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.schedules[tenantNamespace]), nil
+}
+
+func (m *MemoryAccountRepository) List(tenantNamespace string) ([]pkg.ApplicationInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var accounts []pkg.ApplicationInfo
+	for _, account := range m.accounts[tenantNamespace] {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].UserId == accounts[j].UserId {
+			return accounts[i].ApplicationName < accounts[j].ApplicationName
+		}
+		return accounts[i].UserId < accounts[j].UserId
+	})
+	return accounts, nil
+}
+
+func (m *MemoryAccountRepository) Get(tenantNamespace string, applicationName string, userId string) (pkg.ApplicationInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	account, ok := m.accounts[tenantNamespace][accountKey(applicationName, userId)]
+	if !ok {
+		return pkg.ApplicationInfo{}, ErrNotFound
+	}
+	return account, nil
+}
+
+func (m *MemoryAccountRepository) Save(tenantNamespace string, accounts ...pkg.ApplicationInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.accounts[tenantNamespace] == nil {
+		m.accounts[tenantNamespace] = make(map[string]pkg.ApplicationInfo)
+	}
+	now := time.Now()
+	for _, account := range accounts {
+		key := accountKey(account.ApplicationName, account.UserId)
+		if stored, ok := m.accounts[tenantNamespace][key]; ok {
+			account.ApplicationUuid = stored.ApplicationUuid
+			account.CreatedAt = stored.CreatedAt
+		} else {
+			account.CreatedAt = now
+		}
+		account.UpdatedAt = now
+		m.accounts[tenantNamespace][key] = account
+	}
+	return nil
+}
+
+func (m *MemoryAccountRepository) Delete(tenantNamespace string, applicationName string, userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := accountKey(applicationName, userId)
+	if _, ok := m.accounts[tenantNamespace][key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.accounts[tenantNamespace], key)
+	return nil
+}
+
+// accountKey identifies an account, user ids are only unique within a network
+func accountKey(applicationName string, userId string) string {
+	return applicationName + "/" + userId
+}
+
+func (m *MemoryAccountRepository) Count(tenantNamespace string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.accounts[tenantNamespace]), nil
+}
+
+// Create shares the blob and renditions of a record with the same checksum, blobs are counted by their records
+func (m *MemoryMediaRepository) Create(tenantNamespace string, media pkg.Media) (pkg.Media, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.media[tenantNamespace] == nil {
+		m.media[tenantNamespace] = make(map[string]pkg.Media)
+	}
+	if media.BlobId == "" {
+		media.BlobId = media.MediaId
+	}
+	for _, stored := range m.media[tenantNamespace] {
+		if media.Checksum == "" || stored.Checksum != media.Checksum {
+			continue
+		}
+		media.BlobId = stored.BlobId
+		media.Renditions = nil
+		for _, rendition := range stored.Renditions {
+			rendition.Url = pkg.RenditionUrl(media.MediaId, rendition.Name)
+			media.Renditions = append(media.Renditions, rendition)
+		}
+		break
+	}
+	media.Tags = pkg.NormalizeTags(media.Tags)
+	m.media[tenantNamespace][media.MediaId] = media
+	return media, nil
+}
+
+func (m *MemoryMediaRepository) Get(tenantNamespace string, mediaId string) (pkg.Media, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	media, ok := m.media[tenantNamespace][mediaId]
+	if !ok {
+		return media, ErrNotFound
+	}
+	media.PostIds = m.posts.usingMedia(tenantNamespace, mediaId)
+	return media, nil
+}
+
+func (m *MemoryMediaRepository) List(tenantNamespace string, query pkg.MediaQuery) (pkg.MediaPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var page pkg.MediaPage
+	var matching []pkg.Media
+	for _, media := range m.media[tenantNamespace] {
+		if query.Matches(media) {
+			matching = append(matching, media)
+		}
+	}
+	page.Total = len(matching)
+	sort.Slice(matching, func(i, j int) bool {
+		return newer(matching[i], matching[j])
+	})
+
+	page.Media = []pkg.Media{}
+	for _, media := range matching {
+		if query.After != nil && !newer(pkg.Media{MediaId: query.After.MediaId, CreatedAt: query.After.CreatedAt}, media) {
+			continue
+		}
+		if len(page.Media) == query.Limit {
+			page.NextCursor = query.NextCursor(page.Media[len(page.Media)-1])
+			break
+		}
+		media.PostIds = m.posts.usingMedia(tenantNamespace, media.MediaId)
+		page.Media = append(page.Media, media)
+	}
+	return page, nil
+}
+
+// newer orders media like the library, by creation time and id descending
+func newer(a pkg.Media, b pkg.Media) bool {
+	if a.CreatedAt.Equal(b.CreatedAt) {
+		return a.MediaId > b.MediaId
+	}
+	return a.CreatedAt.After(b.CreatedAt)
+}
+
+func (m *MemoryMediaRepository) Update(tenantNamespace string, media pkg.Media) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.media[tenantNamespace][media.MediaId]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Name = media.Name
+	stored.Tags = pkg.NormalizeTags(media.Tags)
+	stored.Attached = true
+	m.media[tenantNamespace][media.MediaId] = stored
+	return nil
+}
+
+func (m *MemoryMediaRepository) Delete(tenantNamespace string, mediaId string, force bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.media[tenantNamespace][mediaId]; !ok {
+		return false, ErrNotFound
+	}
+	if len(m.posts.usingMedia(tenantNamespace, mediaId)) > 0 {
+		if !force {
+			return false, ErrInUse
+		}
+		m.posts.removeMedia(tenantNamespace, mediaId)
+	}
+	return m.release(tenantNamespace, mediaId), nil
+}
+
+func (m *MemoryMediaRepository) ListUploads(tenantNamespace string, uploadedBy string) ([]pkg.Media, error) {
+	return m.uploads(tenantNamespace, func(media pkg.Media) bool {
+		return media.UploadedBy == uploadedBy
+	}), nil
+}
+
+func (m *MemoryMediaRepository) ExpiredUploads(tenantNamespace string, cutoff time.Time) ([]pkg.Media, error) {
+	return m.uploads(tenantNamespace, func(media pkg.Media) bool {
+		return media.CreatedAt.Before(cutoff)
+	}), nil
+}
+
+func (m *MemoryMediaRepository) DeleteUpload(tenantNamespace string, mediaId string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	media, ok := m.media[tenantNamespace][mediaId]
+	if !ok || media.Attached {
+		return false, ErrNotFound
+	}
+	return m.release(tenantNamespace, mediaId), nil
+}
+
+// release deletes the record and tells whether it was the last one of its blob
+func (m *MemoryMediaRepository) release(tenantNamespace string, mediaId string) bool {
+	blobId := m.media[tenantNamespace][mediaId].BlobId
+	delete(m.media[tenantNamespace], mediaId)
+	for _, media := range m.media[tenantNamespace] {
+		if media.BlobId == blobId {
+			return false
+		}
+	}
+	return true
+}
+
+// uploads returns the unattached media matching filter, oldest first
+func (m *MemoryMediaRepository) uploads(tenantNamespace string, filter func(pkg.Media) bool) []pkg.Media {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var uploads []pkg.Media
+	for _, media := range m.media[tenantNamespace] {
+		if !media.Attached && filter(media) {
+			uploads = append(uploads, media)
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].CreatedAt.Before(uploads[j].CreatedAt)
+	})
+	return uploads
+}
+
+func (m *MemoryUploadJobRepository) Create(tenantNamespace string, job pkg.UploadJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.jobs[tenantNamespace] == nil {
+		m.jobs[tenantNamespace] = make(map[string]pkg.UploadJob)
+	}
+	now := time.Now()
+	job.CreatedAt, job.UpdatedAt = now, now
+	m.jobs[tenantNamespace][job.JobId] = job
+	return nil
+}
+
+func (m *MemoryUploadJobRepository) Get(tenantNamespace string, jobId string) (pkg.UploadJob, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, ok := m.jobs[tenantNamespace][jobId]
+	if !ok {
+		return job, ErrNotFound
+	}
+	return job, nil
+}
+
+func (m *MemoryUploadJobRepository) Finish(tenantNamespace string, job pkg.UploadJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.jobs[tenantNamespace][job.JobId]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Status, stored.Media, stored.Error = job.Status, job.Media, job.Error
+	stored.UpdatedAt = time.Now()
+	m.jobs[tenantNamespace][job.JobId] = stored
+	return nil
+}
+
+func (m *MemoryUploadJobRepository) FailStale(tenantNamespace string, cutoff time.Time, uploadError pkg.UploadError) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for jobId, job := range m.jobs[tenantNamespace] {
+		if job.Status != pkg.JobProcessing || !job.UpdatedAt.Before(cutoff) {
+			continue
+		}
+		failure := uploadError
+		job.Status, job.Error = pkg.JobFailed, &failure
+		job.UpdatedAt = time.Now()
+		m.jobs[tenantNamespace][jobId] = job
+		count++
+	}
+	return count, nil
+}
+
+func (m *MemoryApiKeyRepository) Create(tenantNamespace string, key pkg.ApiKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.keys[tenantNamespace] == nil {
+		m.keys[tenantNamespace] = make(map[string]pkg.ApiKey)
+	}
+	key.Key = ""
+	key.CreatedAt = time.Now()
+	m.keys[tenantNamespace][key.KeyId] = key
+	return nil
+}
+
+func (m *MemoryApiKeyRepository) List(tenantNamespace string) ([]pkg.ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := []pkg.ApiKey{}
+	for _, key := range m.keys[tenantNamespace] {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (m *MemoryApiKeyRepository) Revoke(tenantNamespace string, keyId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.keys[tenantNamespace][keyId]
+	if !ok || key.RevokedAt != nil {
+		return ErrNotFound
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	m.keys[tenantNamespace][keyId] = key
+	return nil
+}
+
+func (m *MemoryApiKeyRepository) Authenticate(tenantNamespace string, hash string) (pkg.ApiKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, key := range m.keys[tenantNamespace] {
+		if key.Hash == hash && key.RevokedAt == nil {
+			now := time.Now()
+			key.LastUsedAt = &now
+			m.keys[tenantNamespace][id] = key
+			return key, nil
+		}
+	}
+	return pkg.ApiKey{}, ErrNotFound
+}
@@ -0,0 +1,925 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"gitlab.com/pbobby001/postit-api/db"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/secrets"
+	"strings"
+	"time"
+)
+
+// PostgresPostRepository stores posts in the post table of the tenant schema
+type PostgresPostRepository struct{}
+
+// PostgresScheduleRepository stores schedules in the schedule table of the tenant schema
+type PostgresScheduleRepository struct{}
+
+// PostgresAccountRepository stores social media accounts in the application_info table of the tenant schema
+type PostgresAccountRepository struct{}
+
+// PostgresMediaRepository stores media records in the media table of the tenant schema
+type PostgresMediaRepository struct{}
+
+// PostgresUploadJobRepository stores upload jobs in the upload_job table of the tenant schema
+type PostgresUploadJobRepository struct{}
+
+// PostgresApiKeyRepository stores API keys in the api_key table of the tenant schema
+type PostgresApiKeyRepository struct{}
+
+func (PostgresPostRepository) Create(tenantNamespace string, post pkg.DbPost) error {
+	if post.ImagePaths == nil {
+		post.ImagePaths = []string{}
+	}
+
+	return inTransaction(func(tx *sql.Tx) error {
+		err := attachMediaTx(tx, tenantNamespace, pkg.MediaIds(post.Media))
+		if err != nil {
+			return err
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s.post (post_id, facebook_post_id, post_message, media_ids, image_paths, hash_tags, post_fb_status, post_tw_status, post_li_status, post_priority, scheduled) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)", pq.QuoteIdentifier(tenantNamespace))
+		_, err = tx.Exec(query, post.PostId, "", post.PostMessage, pq.Array(pkg.MediaIds(post.Media)), pq.Array(post.ImagePaths), pq.Array(post.HashTags), false, false, false, post.PostPriority, false)
+		return err
+	})
+}
+
+func (PostgresPostRepository) List(tenantNamespace string, query pkg.PostQuery) (pkg.PostPage, error) {
+	var page pkg.PostPage
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	flags := []struct {
+		column string
+		value  *bool
+	}{
+		{"scheduled", query.Scheduled},
+		{"post_priority", query.PostPriority},
+		{"post_fb_status", query.PostFbStatus},
+		{"post_tw_status", query.PostTwStatus},
+		{"post_li_status", query.PostLiStatus},
+	}
+	for _, flag := range flags {
+		if flag.value != nil {
+			conditions = append(conditions, fmt.Sprintf("COALESCE(%s, false) = %s", flag.column, arg(*flag.value)))
+		}
+	}
+	if query.HashTag != "" {
+		conditions = append(conditions, fmt.Sprintf("%s = ANY(hash_tags)", arg(query.HashTag)))
+	}
+	if query.CreatedFrom != nil {
+		conditions = append(conditions, fmt.Sprintf("created_at >= %s", arg(*query.CreatedFrom)))
+	}
+	if query.CreatedTo != nil {
+		conditions = append(conditions, fmt.Sprintf("created_at <= %s", arg(*query.CreatedTo)))
+	}
+
+	table := fmt.Sprintf("%s.post", pq.QuoteIdentifier(tenantNamespace))
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// the total ignores the cursor so it stays the same on every page
+	err := db.Connection.QueryRow("SELECT COUNT(*) FROM "+table+where, args...).Scan(&page.Total)
+	if err != nil {
+		return page, err
+	}
+
+	comparison, direction := "<", "DESC"
+	if query.Order == pkg.OrderAsc {
+		comparison, direction = ">", "ASC"
+	}
+	if query.After != nil {
+		conditions = append(conditions, fmt.Sprintf("(%s, post_id) %s (%s, %s::uuid)", query.Sort, comparison, arg(query.After.Value), arg(query.After.PostId)))
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// one extra row tells whether there is a next page
+	statement := fmt.Sprintf("SELECT post_id, facebook_post_id, post_message, media_ids, image_paths, hash_tags, post_fb_status, post_tw_status, post_li_status, post_priority, scheduled, created_at, updated_at FROM %s%s ORDER BY %s %s, post_id %s LIMIT %s",
+		table, where, query.Sort, direction, direction, arg(query.Limit+1))
+	rows, err := db.Connection.Query(statement, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	page.Posts = []pkg.DbPost{}
+	for rows.Next() {
+		var post pkg.DbPost
+		var facebookPostId *string
+		var mediaIds []string
+		var fb, tw, li, scheduled *bool
+		err = rows.Scan(
+			&post.PostId,
+			&facebookPostId,
+			&post.PostMessage,
+			pq.Array(&mediaIds),
+			pq.Array(&post.ImagePaths),
+			pq.Array(&post.HashTags),
+			&fb,
+			&tw,
+			&li,
+			&post.PostPriority,
+			&scheduled,
+			&post.CreatedOn,
+			&post.UpdatedOn,
+		)
+		if err != nil {
+			return page, err
+		}
+
+		if facebookPostId != nil {
+			post.FacebookPostId = *facebookPostId
+		}
+		post.Media = pkg.MediaRefs(mediaIds)
+		post.PostFbStatus = fb != nil && *fb
+		post.PostTwStatus = tw != nil && *tw
+		post.PostLiStatus = li != nil && *li
+		post.Scheduled = scheduled != nil && *scheduled
+		page.Posts = append(page.Posts, post)
+	}
+	if err = rows.Err(); err != nil {
+		return page, err
+	}
+
+	if len(page.Posts) > query.Limit {
+		page.Posts = page.Posts[:query.Limit]
+		page.NextCursor = query.NextCursor(page.Posts[query.Limit-1])
+	}
+	return page, nil
+}
+
+func (PostgresPostRepository) Get(tenantNamespace string, postId string) (pkg.DbPost, error) {
+	post, err := pkg.FetchPost(tenantNamespace, postId)
+	if err == sql.ErrNoRows {
+		return post, ErrNotFound
+	}
+	return post, err
+}
+
+func (PostgresPostRepository) Update(tenantNamespace string, post pkg.DbPost) error {
+	return inTransaction(func(tx *sql.Tx) error {
+		err := attachMediaTx(tx, tenantNamespace, pkg.MediaIds(post.Media))
+		if err != nil {
+			return err
+		}
+
+		query := fmt.Sprintf("UPDATE %s.post SET post_message = $1, hash_tags = $2, post_priority = $3, media_ids = $4, image_paths = $5, updated_at = CURRENT_TIMESTAMP WHERE post_id = $6", pq.QuoteIdentifier(tenantNamespace))
+		result, err := tx.Exec(query, post.PostMessage, pq.Array(post.HashTags), post.PostPriority, pq.Array(pkg.MediaIds(post.Media)), pq.Array(post.ImagePaths), post.PostId)
+		if err != nil {
+			return err
+		}
+		return expectRows(result)
+	})
+}
+
+/* attachMediaTx marks the media records as used by a post, attached media are never collected.
+The update keeps the rows locked until the post is stored, Delete locks the record before it looks for posts using it,
+so either the post waits and fails with ErrUnknownMedia or Delete waits and finds the post. */
+func attachMediaTx(tx *sql.Tx, tenantNamespace string, mediaIds []string) error {
+	if len(mediaIds) == 0 {
+		return nil
+	}
+	query := fmt.Sprintf("UPDATE %s.media SET attached = true WHERE media_id::text = ANY($1)", pq.QuoteIdentifier(tenantNamespace))
+	result, err := tx.Exec(query, pq.Array(mediaIds))
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if int(affected) < len(mediaIds) {
+		return ErrUnknownMedia
+	}
+	return nil
+}
+
+func (PostgresPostRepository) Delete(tenantNamespace string, postIds ...string) error {
+	query := fmt.Sprintf("DELETE FROM %s.post WHERE post_id = ANY($1::uuid[])", pq.QuoteIdentifier(tenantNamespace))
+	_, err := db.Connection.Exec(query, pq.Array(postIds))
+	return err
+}
+
+func (PostgresPostRepository) ListFacebookPosts(tenantNamespace string) ([]pkg.FacebookPostData, error) {
+	query := fmt.Sprintf("SELECT post_id, COALESCE(facebook_post_id, ''), COALESCE(facebook_user_id, ''), post_message FROM %s.post WHERE post_fb_status = $1", pq.QuoteIdentifier(tenantNamespace))
+	rows, err := db.Connection.Query(query, true)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []pkg.FacebookPostData
+	for rows.Next() {
+		var post pkg.FacebookPostData
+		err = rows.Scan(&post.PostId, &post.FacebookPostId, &post.FacebookUserId, &post.PostMessage)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+func (PostgresPostRepository) Count(tenantNamespace string) (int, error) {
+	return count(tenantNamespace, "post")
+}
+
+func (PostgresScheduleRepository) Create(tenantNamespace string, schedule pkg.PostSchedule, messages ...pkg.OutboxMessage) error {
+	schedule.Profiles = withProfiles(schedule.Profiles)
+	return inTransaction(func(tx *sql.Tx) error {
+		query := fmt.Sprintf("INSERT INTO %s.schedule (schedule_id, schedule_title, post_to_feed, schedule_from, schedule_to, post_ids, facebook, twitter, linked_in, duration_per_post, is_due) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)", pq.QuoteIdentifier(tenantNamespace))
+		_, err := tx.Exec(
+			query,
+			schedule.ScheduleId,
+			schedule.ScheduleTitle,
+			schedule.PostToFeed,
+			schedule.From,
+			schedule.To,
+			pq.Array(schedule.PostIds),
+			pq.Array(schedule.Profiles.Facebook),
+			pq.Array(schedule.Profiles.Twitter),
+			pq.Array(schedule.Profiles.LinkedIn),
+			schedule.Duration,
+			false,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = scheduleTx(tx, tenantNamespace, schedule.PostIds)
+		if err != nil {
+			return err
+		}
+		return pkg.EnqueueOutbox(tx, messages...)
+	})
+}
+
+func (PostgresScheduleRepository) List(tenantNamespace string) ([]pkg.PostSchedule, error) {
+	query := fmt.Sprintf("SELECT schedule_id, COALESCE(schedule_title, ''), post_to_feed, schedule_from, schedule_to, post_ids, duration_per_post, facebook, twitter, linked_in, COALESCE(is_due, false), created_at, updated_at FROM %s.schedule ORDER BY updated_at DESC LIMIT 200", pq.QuoteIdentifier(tenantNamespace))
+	rows, err := db.Connection.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []pkg.PostSchedule
+	for rows.Next() {
+		var schedule pkg.PostSchedule
+		err = rows.Scan(
+			&schedule.ScheduleId,
+			&schedule.ScheduleTitle,
+			&schedule.PostToFeed,
+			&schedule.From,
+			&schedule.To,
+			pq.Array(&schedule.PostIds),
+			&schedule.Duration,
+			pq.Array(&schedule.Profiles.Facebook),
+			pq.Array(&schedule.Profiles.Twitter),
+			pq.Array(&schedule.Profiles.LinkedIn),
+			&schedule.IsDue,
+			&schedule.CreatedOn,
+			&schedule.UpdatedOn,
+		)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, rows.Err()
+}
+
+func (PostgresScheduleRepository) Update(tenantNamespace string, schedule pkg.PostSchedule, messages ...pkg.OutboxMessage) error {
+	schedule.Profiles = withProfiles(schedule.Profiles)
+	return inTransaction(func(tx *sql.Tx) error {
+		var previous []string
+		query := fmt.Sprintf("SELECT post_ids FROM %s.schedule WHERE schedule_id = $1 FOR UPDATE", pq.QuoteIdentifier(tenantNamespace))
+		err := tx.QueryRow(query, schedule.ScheduleId).Scan(pq.Array(&previous))
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		query = fmt.Sprintf("UPDATE %s.schedule SET schedule_title = $1, schedule_from = $2, schedule_to = $3, post_ids = $4, duration_per_post = $5, post_to_feed = $6, facebook = $7, twitter = $8, linked_in = $9, updated_at = CURRENT_TIMESTAMP WHERE schedule_id = $10", pq.QuoteIdentifier(tenantNamespace))
+		_, err = tx.Exec(
+			query,
+			schedule.ScheduleTitle,
+			schedule.From,
+			schedule.To,
+			pq.Array(schedule.PostIds),
+			schedule.Duration,
+			schedule.PostToFeed,
+			pq.Array(schedule.Profiles.Facebook),
+			pq.Array(schedule.Profiles.Twitter),
+			pq.Array(schedule.Profiles.LinkedIn),
+			schedule.ScheduleId,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = scheduleTx(tx, tenantNamespace, schedule.PostIds)
+		if err != nil {
+			return err
+		}
+		err = unscheduleTx(tx, tenantNamespace, previous)
+		if err != nil {
+			return err
+		}
+		return pkg.EnqueueOutbox(tx, messages...)
+	})
+}
+
+func (PostgresScheduleRepository) Delete(tenantNamespace string, scheduleId string, messages ...pkg.OutboxMessage) error {
+	return inTransaction(func(tx *sql.Tx) error {
+		var postIds []string
+		query := fmt.Sprintf("DELETE FROM %s.schedule WHERE schedule_id = $1 RETURNING post_ids", pq.QuoteIdentifier(tenantNamespace))
+		err := tx.QueryRow(query, scheduleId).Scan(pq.Array(&postIds))
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		// progress of the in process scheduler
+		_, err = tx.Exec("DELETE FROM general_schedule_table WHERE schedule_id = $1", scheduleId)
+		if err != nil {
+			return err
+		}
+
+		err = unscheduleTx(tx, tenantNamespace, postIds)
+		if err != nil {
+			return err
+		}
+		return pkg.EnqueueOutbox(tx, messages...)
+	})
+}
+
+func (PostgresScheduleRepository) Count(tenantNamespace string) (int, error) {
+	return count(tenantNamespace, "schedule")
+}
+
+func (PostgresAccountRepository) List(tenantNamespace string) ([]pkg.ApplicationInfo, error) {
+	return pkg.FetchAccounts(tenantNamespace)
+}
+
+func (PostgresAccountRepository) Get(tenantNamespace string, applicationName string, userId string) (pkg.ApplicationInfo, error) {
+	account, err := pkg.FetchAccount(tenantNamespace, applicationName, userId)
+	if err == sql.ErrNoRows {
+		return account, ErrNotFound
+	}
+	return account, err
+}
+
+// Save encrypts the credentials of the accounts before they are written
+func (PostgresAccountRepository) Save(tenantNamespace string, accounts ...pkg.ApplicationInfo) error {
+	stmt := fmt.Sprintf(`INSERT INTO %s.application_info(application_uuid, application_name, application_id, application_secret, application_url, user_access_token, refresh_token, expires_in, user_name, user_id, token_expires_at, token_status)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+		ON CONFLICT (application_name, user_id) DO UPDATE SET user_access_token = EXCLUDED.user_access_token, refresh_token = EXCLUDED.refresh_token, expires_in = EXCLUDED.expires_in,
+		token_expires_at = EXCLUDED.token_expires_at, token_status = EXCLUDED.token_status, user_name = EXCLUDED.user_name, updated_at = CURRENT_TIMESTAMP`, pq.QuoteIdentifier(tenantNamespace))
+	return inTransaction(func(tx *sql.Tx) error {
+		for _, account := range accounts {
+			err := secrets.EncryptAll(&account.ApplicationSecret, &account.UserAccessToken, &account.RefreshToken)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(stmt,
+				account.ApplicationUuid,
+				account.ApplicationName,
+				account.ApplicationId,
+				account.ApplicationSecret,
+				account.ApplicationUrl,
+				account.UserAccessToken,
+				account.RefreshToken,
+				account.ExpiresIn,
+				account.UserName,
+				account.UserId,
+				account.TokenExpiresAt,
+				account.TokenStatus,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (PostgresAccountRepository) Delete(tenantNamespace string, applicationName string, userId string) error {
+	stmt := fmt.Sprintf("DELETE FROM %s.application_info WHERE user_id = $1 AND application_name = $2", pq.QuoteIdentifier(tenantNamespace))
+	result, err := db.Connection.Exec(stmt, userId, applicationName)
+	if err != nil {
+		return err
+	}
+	return expectRows(result)
+}
+
+func (PostgresAccountRepository) Count(tenantNamespace string) (int, error) {
+	return count(tenantNamespace, "application_info")
+}
+
+const mediaColumns = "media_id, name, tags, content_type, size, width, height, kind, duration, codec, checksum, blob_id, uploaded_by, attached, created_at"
+
+/* Create stores the media record along with its blob and renditions.
+A blob of the tenant with the same checksum is counted once more instead, its renditions are loaded along.
+The checksum is unique, concurrent uploads of the same content end up sharing a single blob. */
+func (PostgresMediaRepository) Create(tenantNamespace string, media pkg.Media) (pkg.Media, error) {
+	if media.BlobId == "" {
+		media.BlobId = media.MediaId
+	}
+	shared := false
+	err := inTransaction(func(tx *sql.Tx) error {
+		var blobId string
+		query := fmt.Sprintf("INSERT INTO %s.media_blob (blob_id, checksum, content_type, size, ref_count) VALUES ($1, $2, $3, $4, 1) ON CONFLICT (checksum) WHERE checksum <> '' DO UPDATE SET ref_count = media_blob.ref_count + 1 RETURNING blob_id", pq.QuoteIdentifier(tenantNamespace))
+		err := tx.QueryRow(query, media.BlobId, media.Checksum, media.ContentType, media.Size).Scan(&blobId)
+		if err != nil {
+			return err
+		}
+		shared = blobId != media.BlobId
+		media.BlobId = blobId
+
+		query = fmt.Sprintf("INSERT INTO %s.media (media_id, name, tags, content_type, size, width, height, kind, duration, codec, checksum, blob_id, uploaded_by, attached) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)", pq.QuoteIdentifier(tenantNamespace))
+		_, err = tx.Exec(query, media.MediaId, media.Name, pq.Array(pkg.NormalizeTags(media.Tags)), media.ContentType, media.Size, media.Width, media.Height, media.Kind, media.Duration, media.Codec, media.Checksum, media.BlobId, media.UploadedBy, media.Attached)
+		if err != nil || shared {
+			return err
+		}
+
+		query = fmt.Sprintf("INSERT INTO %s.media_rendition (blob_id, name, network, content_type, width, height, size) VALUES ($1, $2, $3, $4, $5, $6, $7)", pq.QuoteIdentifier(tenantNamespace))
+		for _, rendition := range media.Renditions {
+			_, err = tx.Exec(query, media.BlobId, rendition.Name, rendition.Network, rendition.ContentType, rendition.Width, rendition.Height, rendition.Size)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || !shared {
+		return media, err
+	}
+
+	list := []pkg.Media{media}
+	list[0].Renditions = nil
+	err = loadRenditions(tenantNamespace, list)
+	return list[0], err
+}
+
+func (PostgresMediaRepository) Get(tenantNamespace string, mediaId string) (pkg.Media, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s.media WHERE media_id::text = $1", mediaColumns, pq.QuoteIdentifier(tenantNamespace))
+	media, err := scanMedia(db.Connection.QueryRow(query, mediaId))
+	if err == sql.ErrNoRows {
+		return media, ErrNotFound
+	}
+	if err != nil {
+		return media, err
+	}
+
+	list := []pkg.Media{media}
+	err = loadDetails(tenantNamespace, list)
+	return list[0], err
+}
+
+func (PostgresMediaRepository) List(tenantNamespace string, query pkg.MediaQuery) (pkg.MediaPage, error) {
+	var page pkg.MediaPage
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if query.Tag != "" {
+		conditions = append(conditions, fmt.Sprintf("tags @> ARRAY[%s]::character varying(100)[]", arg(query.Tag)))
+	}
+	if query.Search != "" {
+		// the search term is matched literally
+		search := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query.Search)
+		conditions = append(conditions, fmt.Sprintf("name ILIKE %s", arg("%"+search+"%")))
+	}
+	if query.Kind != "" {
+		conditions = append(conditions, fmt.Sprintf("kind = %s", arg(query.Kind)))
+	}
+
+	table := fmt.Sprintf("%s.media", pq.QuoteIdentifier(tenantNamespace))
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// the total ignores the cursor so it stays the same on every page
+	err := db.Connection.QueryRow("SELECT COUNT(*) FROM "+table+where, args...).Scan(&page.Total)
+	if err != nil {
+		return page, err
+	}
+
+	if query.After != nil {
+		conditions = append(conditions, fmt.Sprintf("(created_at, media_id) < (%s, %s::uuid)", arg(query.After.CreatedAt), arg(query.After.MediaId)))
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// one extra row tells whether there is a next page
+	statement := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY created_at DESC, media_id DESC LIMIT %s", mediaColumns, table, where, arg(query.Limit+1))
+	page.Media, err = queryMedia(tenantNamespace, statement, args...)
+	if err != nil {
+		return page, err
+	}
+
+	if len(page.Media) > query.Limit {
+		page.Media = page.Media[:query.Limit]
+		page.NextCursor = query.NextCursor(page.Media[query.Limit-1])
+	}
+	return page, nil
+}
+
+func (PostgresMediaRepository) Update(tenantNamespace string, media pkg.Media) error {
+	query := fmt.Sprintf("UPDATE %s.media SET name = $1, tags = $2, attached = true WHERE media_id::text = $3", pq.QuoteIdentifier(tenantNamespace))
+	result, err := db.Connection.Exec(query, media.Name, pq.Array(pkg.NormalizeTags(media.Tags)), media.MediaId)
+	if err != nil {
+		return err
+	}
+	return expectRows(result)
+}
+
+/* Delete locks the media record first, a post attaching it in the meantime either commits before and is found
+or waits and fails once the record is gone. The posts using it are locked while it is removed from them. */
+func (PostgresMediaRepository) Delete(tenantNamespace string, mediaId string, force bool) (bool, error) {
+	released := false
+	err := inTransaction(func(tx *sql.Tx) error {
+		query := fmt.Sprintf("SELECT media_id FROM %s.media WHERE media_id::text = $1 FOR UPDATE", pq.QuoteIdentifier(tenantNamespace))
+		err := tx.QueryRow(query, mediaId).Scan(new(string))
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		query = fmt.Sprintf("SELECT post_id FROM %s.post WHERE media_ids @> ARRAY[$1]::character varying(200)[] FOR UPDATE", pq.QuoteIdentifier(tenantNamespace))
+		rows, err := tx.Query(query, mediaId)
+		if err != nil {
+			return err
+		}
+		used := false
+		for rows.Next() {
+			used = true
+		}
+		_ = rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if used && !force {
+			return ErrInUse
+		}
+
+		if used {
+			query = fmt.Sprintf("UPDATE %s.post SET media_ids = array_remove(media_ids, $1::character varying(200)), updated_at = CURRENT_TIMESTAMP WHERE media_ids @> ARRAY[$1]::character varying(200)[]", pq.QuoteIdentifier(tenantNamespace))
+			_, err = tx.Exec(query, mediaId)
+			if err != nil {
+				return err
+			}
+		}
+
+		query = fmt.Sprintf("DELETE FROM %s.media WHERE media_id::text = $1 RETURNING blob_id", pq.QuoteIdentifier(tenantNamespace))
+		released, err = deleteMediaTx(tx, tenantNamespace, query, mediaId)
+		return err
+	})
+	return released, err
+}
+
+func (PostgresMediaRepository) ListUploads(tenantNamespace string, uploadedBy string) ([]pkg.Media, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s.media WHERE NOT attached AND uploaded_by = $1 ORDER BY created_at", mediaColumns, pq.QuoteIdentifier(tenantNamespace))
+	return queryMedia(tenantNamespace, query, uploadedBy)
+}
+
+func (PostgresMediaRepository) ExpiredUploads(tenantNamespace string, cutoff time.Time) ([]pkg.Media, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s.media WHERE NOT attached AND created_at < $1 ORDER BY created_at", mediaColumns, pq.QuoteIdentifier(tenantNamespace))
+	return queryMedia(tenantNamespace, query, cutoff)
+}
+
+func (PostgresMediaRepository) DeleteUpload(tenantNamespace string, mediaId string) (bool, error) {
+	released := false
+	err := inTransaction(func(tx *sql.Tx) error {
+		query := fmt.Sprintf("DELETE FROM %s.media WHERE media_id::text = $1 AND NOT attached RETURNING blob_id", pq.QuoteIdentifier(tenantNamespace))
+		var err error
+		released, err = deleteMediaTx(tx, tenantNamespace, query, mediaId)
+		return err
+	})
+	return released, err
+}
+
+/* deleteMediaTx runs query, a DELETE of a media record returning its blob_id, and releases the blob.
+The last record of a blob deletes it, the renditions go along through the foreign key. */
+func deleteMediaTx(tx *sql.Tx, tenantNamespace string, query string, mediaId string) (bool, error) {
+	var blobId string
+	err := tx.QueryRow(query, mediaId).Scan(&blobId)
+	if err == sql.ErrNoRows {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var refCount int
+	query = fmt.Sprintf("UPDATE %s.media_blob SET ref_count = ref_count - 1 WHERE blob_id = $1 RETURNING ref_count", pq.QuoteIdentifier(tenantNamespace))
+	err = tx.QueryRow(query, blobId).Scan(&refCount)
+	if err != nil {
+		return false, err
+	}
+	if refCount > 0 {
+		return false, nil
+	}
+
+	query = fmt.Sprintf("DELETE FROM %s.media_blob WHERE blob_id = $1", pq.QuoteIdentifier(tenantNamespace))
+	_, err = tx.Exec(query, blobId)
+	return err == nil, err
+}
+
+func queryMedia(tenantNamespace string, query string, args ...interface{}) ([]pkg.Media, error) {
+	rows, err := db.Connection.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var media []pkg.Media
+	for rows.Next() {
+		m, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		media = append(media, m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return media, loadDetails(tenantNamespace, media)
+}
+
+// loadDetails sets the renditions of the media records and the posts using them
+func loadDetails(tenantNamespace string, media []pkg.Media) error {
+	err := loadRenditions(tenantNamespace, media)
+	if err != nil {
+		return err
+	}
+	return loadReferences(tenantNamespace, media)
+}
+
+// loadReferences sets the ids of the posts using the media records with a single query
+func loadReferences(tenantNamespace string, media []pkg.Media) error {
+	if len(media) == 0 {
+		return nil
+	}
+	index := make(map[string]int)
+	var ids []string
+	for i := range media {
+		index[media[i].MediaId] = i
+		ids = append(ids, media[i].MediaId)
+		media[i].PostIds = []string{}
+	}
+
+	query := fmt.Sprintf("SELECT media_id, post_id FROM %s.post, unnest(media_ids) AS media_id WHERE media_ids && $1::character varying(200)[] AND media_id = ANY($1) ORDER BY created_at", pq.QuoteIdentifier(tenantNamespace))
+	rows, err := db.Connection.Query(query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mediaId, postId string
+		err = rows.Scan(&mediaId, &postId)
+		if err != nil {
+			return err
+		}
+		if i, ok := index[mediaId]; ok {
+			media[i].PostIds = append(media[i].PostIds, postId)
+		}
+	}
+	return rows.Err()
+}
+
+// loadRenditions sets the renditions of the blobs of the media records with a single query
+func loadRenditions(tenantNamespace string, media []pkg.Media) error {
+	if len(media) == 0 {
+		return nil
+	}
+	index := make(map[string][]int)
+	var ids []string
+	for i := range media {
+		if _, ok := index[media[i].BlobId]; !ok {
+			ids = append(ids, media[i].BlobId)
+		}
+		index[media[i].BlobId] = append(index[media[i].BlobId], i)
+	}
+
+	query := fmt.Sprintf("SELECT blob_id, name, network, content_type, width, height, size FROM %s.media_rendition WHERE blob_id::text = ANY($1) ORDER BY name", pq.QuoteIdentifier(tenantNamespace))
+	rows, err := db.Connection.Query(query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var blobId string
+		var rendition pkg.Rendition
+		err = rows.Scan(&blobId, &rendition.Name, &rendition.Network, &rendition.ContentType, &rendition.Width, &rendition.Height, &rendition.Size)
+		if err != nil {
+			return err
+		}
+		for _, i := range index[blobId] {
+			rendition.Url = pkg.RenditionUrl(media[i].MediaId, rendition.Name)
+			media[i].Renditions = append(media[i].Renditions, rendition)
+		}
+	}
+	return rows.Err()
+}
+
+func scanMedia(row interface{ Scan(...interface{}) error }) (pkg.Media, error) {
+	var media pkg.Media
+	err := row.Scan(&media.MediaId, &media.Name, pq.Array(&media.Tags), &media.ContentType, &media.Size, &media.Width, &media.Height, &media.Kind, &media.Duration, &media.Codec, &media.Checksum, &media.BlobId, &media.UploadedBy, &media.Attached, &media.CreatedAt)
+	return media, err
+}
+
+func (PostgresUploadJobRepository) Create(tenantNamespace string, job pkg.UploadJob) error {
+	query := fmt.Sprintf("INSERT INTO %s.upload_job (job_id, status, uploaded_by) VALUES ($1, $2, $3)", pq.QuoteIdentifier(tenantNamespace))
+	_, err := db.Connection.Exec(query, job.JobId, job.Status, job.UploadedBy)
+	return err
+}
+
+// Get loads the media record of a finished job along with it
+func (PostgresUploadJobRepository) Get(tenantNamespace string, jobId string) (pkg.UploadJob, error) {
+	query := fmt.Sprintf("SELECT job_id, status, media_id, error_code, error_message, uploaded_by, created_at, updated_at FROM %s.upload_job WHERE job_id::text = $1", pq.QuoteIdentifier(tenantNamespace))
+
+	var job pkg.UploadJob
+	var mediaId sql.NullString
+	var uploadError pkg.UploadError
+	err := db.Connection.QueryRow(query, jobId).Scan(&job.JobId, &job.Status, &mediaId, &uploadError.Code, &uploadError.Message, &job.UploadedBy, &job.CreatedAt, &job.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return job, ErrNotFound
+	}
+	if err != nil {
+		return job, err
+	}
+
+	if mediaId.Valid {
+		media, err := PostgresMediaRepository{}.Get(tenantNamespace, mediaId.String)
+		if err != nil {
+			return job, err
+		}
+		job.Media = &media
+	}
+	if uploadError.Code != "" {
+		job.Error = &uploadError
+	}
+	return job, nil
+}
+
+func (PostgresUploadJobRepository) Finish(tenantNamespace string, job pkg.UploadJob) error {
+	var mediaId interface{}
+	if job.Media != nil {
+		mediaId = job.Media.MediaId
+	}
+	var uploadError pkg.UploadError
+	if job.Error != nil {
+		uploadError = *job.Error
+	}
+
+	query := fmt.Sprintf("UPDATE %s.upload_job SET status = $1, media_id = $2, error_code = $3, error_message = $4, updated_at = CURRENT_TIMESTAMP WHERE job_id = $5", pq.QuoteIdentifier(tenantNamespace))
+	result, err := db.Connection.Exec(query, job.Status, mediaId, uploadError.Code, uploadError.Message, job.JobId)
+	if err != nil {
+		return err
+	}
+	return expectRows(result)
+}
+
+func (PostgresUploadJobRepository) FailStale(tenantNamespace string, cutoff time.Time, uploadError pkg.UploadError) (int, error) {
+	query := fmt.Sprintf("UPDATE %s.upload_job SET status = $1, error_code = $2, error_message = $3, updated_at = CURRENT_TIMESTAMP WHERE status = $4 AND updated_at < $5", pq.QuoteIdentifier(tenantNamespace))
+	result, err := db.Connection.Exec(query, pkg.JobFailed, uploadError.Code, uploadError.Message, pkg.JobProcessing, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+const apiKeyColumns = "key_id, name, prefix, key_hash, scopes, created_by, created_at, last_used_at, revoked_at"
+
+func (PostgresApiKeyRepository) Create(tenantNamespace string, key pkg.ApiKey) error {
+	query := fmt.Sprintf("INSERT INTO %s.api_key (key_id, name, prefix, key_hash, scopes, created_by) VALUES ($1, $2, $3, $4, $5, $6)", pq.QuoteIdentifier(tenantNamespace))
+	_, err := db.Connection.Exec(query, key.KeyId, key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), key.CreatedBy)
+	return err
+}
+
+func (PostgresApiKeyRepository) List(tenantNamespace string) ([]pkg.ApiKey, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s.api_key ORDER BY created_at DESC", apiKeyColumns, pq.QuoteIdentifier(tenantNamespace))
+	rows, err := db.Connection.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []pkg.ApiKey{}
+	for rows.Next() {
+		key, err := scanApiKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (PostgresApiKeyRepository) Revoke(tenantNamespace string, keyId string) error {
+	query := fmt.Sprintf("UPDATE %s.api_key SET revoked_at = CURRENT_TIMESTAMP WHERE key_id::text = $1 AND revoked_at IS NULL", pq.QuoteIdentifier(tenantNamespace))
+	result, err := db.Connection.Exec(query, keyId)
+	if err != nil {
+		return err
+	}
+	return expectRows(result)
+}
+
+func (PostgresApiKeyRepository) Authenticate(tenantNamespace string, hash string) (pkg.ApiKey, error) {
+	query := fmt.Sprintf("UPDATE %s.api_key SET last_used_at = CURRENT_TIMESTAMP WHERE key_hash = $1 AND revoked_at IS NULL RETURNING %s", pq.QuoteIdentifier(tenantNamespace), apiKeyColumns)
+	key, err := scanApiKey(db.Connection.QueryRow(query, hash))
+	if err == sql.ErrNoRows {
+		return key, ErrNotFound
+	}
+	return key, err
+}
+
+func scanApiKey(row interface{ Scan(...interface{}) error }) (pkg.ApiKey, error) {
+	var key pkg.ApiKey
+	err := row.Scan(&key.KeyId, &key.Name, &key.Prefix, &key.Hash, pq.Array(&key.Scopes), &key.CreatedBy, &key.CreatedAt, &key.LastUsedAt, &key.RevokedAt)
+	return key, err
+}
+
+func inTransaction(fn func(tx *sql.Tx) error) error {
+	tx, err := db.Connection.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func scheduleTx(tx *sql.Tx, tenantNamespace string, postIds []string) error {
+	query := fmt.Sprintf("UPDATE %s.post SET scheduled = true, updated_at = CURRENT_TIMESTAMP WHERE post_id::text = ANY($1)", pq.QuoteIdentifier(tenantNamespace))
+	_, err := tx.Exec(query, pq.Array(postIds))
+	return err
+}
+
+// unscheduleTx clears the scheduled flag of the posts no schedule refers to anymore
+func unscheduleTx(tx *sql.Tx, tenantNamespace string, postIds []string) error {
+	query := fmt.Sprintf(`UPDATE %[1]s.post p SET scheduled = false, updated_at = CURRENT_TIMESTAMP WHERE p.post_id::text = ANY($1)
+		AND NOT EXISTS (SELECT 1 FROM %[1]s.schedule s WHERE p.post_id::text = ANY(s.post_ids))`, pq.QuoteIdentifier(tenantNamespace))
+	_, err := tx.Exec(query, pq.Array(postIds))
+	return err
+}
+
+func count(tenantNamespace string, table string) (int, error) {
+	var total int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s.%s", pq.QuoteIdentifier(tenantNamespace), pq.QuoteIdentifier(table))
+	err := db.Connection.QueryRow(query).Scan(&total)
+	return total, err
+}
+
+func expectRows(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// withProfiles replaces missing profile lists with empty ones, the columns are not nullable
+func withProfiles(profiles pkg.SocialMediaProfiles) pkg.SocialMediaProfiles {
+	if profiles.Facebook == nil {
+		profiles.Facebook = []string{}
+	}
+	if profiles.Twitter == nil {
+		profiles.Twitter = []string{}
+	}
+	if profiles.LinkedIn == nil {
+		profiles.LinkedIn = []string{}
+	}
+	return profiles
+}
@@ -0,0 +1,101 @@
+/* Package repository holds the data access used by the http handlers.
+Every repository has a Postgres implementation working on the tenant schemas
+and an in-memory implementation used to test the handlers without a database. */
+package repository
+
+import (
+	"errors"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"time"
+)
+
+var ErrNotFound = errors.New("record not found")
+
+// ErrInUse is returned when a record cannot be removed while others still refer to it
+var ErrInUse = errors.New("record is in use")
+
+// ErrUnknownMedia is returned when a post refers to a media record that does not exist (anymore)
+var ErrUnknownMedia = errors.New("unknown media")
+
+/* PostRepository stores posts along with the media they use.
+Create and Update attach the media of the post in the same transaction, a media record removed in the meantime fails them with ErrUnknownMedia. */
+type PostRepository interface {
+	Create(tenantNamespace string, post pkg.DbPost) error
+	// List returns a page of the posts matching query along with the cursor of the next page
+	List(tenantNamespace string, query pkg.PostQuery) (pkg.PostPage, error)
+	Get(tenantNamespace string, postId string) (pkg.DbPost, error)
+	// Update replaces the message, hash tags, priority and media of a post
+	Update(tenantNamespace string, post pkg.DbPost) error
+	Delete(tenantNamespace string, postIds ...string) error
+	// ListFacebookPosts returns the posts published to facebook
+	ListFacebookPosts(tenantNamespace string) ([]pkg.FacebookPostData, error)
+	Count(tenantNamespace string) (int, error)
+}
+
+/* ScheduleRepository changes schedules together with the scheduled flag of their posts.
+Every change runs in a single transaction along with the outbox messages passed to it,
+posts are only unscheduled once no schedule refers to them anymore. */
+type ScheduleRepository interface {
+	Create(tenantNamespace string, schedule pkg.PostSchedule, messages ...pkg.OutboxMessage) error
+	List(tenantNamespace string) ([]pkg.PostSchedule, error)
+	// Update replaces the title, period, posts, spacing of the posts and profiles of a schedule
+	Update(tenantNamespace string, schedule pkg.PostSchedule, messages ...pkg.OutboxMessage) error
+	Delete(tenantNamespace string, scheduleId string, messages ...pkg.OutboxMessage) error
+	Count(tenantNamespace string) (int, error)
+}
+
+type AccountRepository interface {
+	List(tenantNamespace string) ([]pkg.ApplicationInfo, error)
+	Get(tenantNamespace string, applicationName string, userId string) (pkg.ApplicationInfo, error)
+	// Save stores the accounts in a single transaction, reconnecting an account replaces its tokens
+	Save(tenantNamespace string, accounts ...pkg.ApplicationInfo) error
+	Delete(tenantNamespace string, applicationName string, userId string) error
+	Count(tenantNamespace string) (int, error)
+}
+
+/* MediaRepository describes the media library of a tenant, posts refer to media by id through media_ids.
+The content itself lives in the media store under pkg.MediaKey, the renditions under pkg.RenditionKey, both keyed by blob.
+Media records with the same checksum share a blob, which counts its records and is released along with the last one.
+Media records are returned along with the ids of the posts using them. */
+type MediaRepository interface {
+	/* Create stores the record, a blob of the tenant with the same checksum is shared instead of media.BlobId.
+	The record is returned as stored, shared blobs come with their own renditions. */
+	Create(tenantNamespace string, media pkg.Media) (pkg.Media, error)
+	Get(tenantNamespace string, mediaId string) (pkg.Media, error)
+	// List returns a page of the library matching query, newest first
+	List(tenantNamespace string, query pkg.MediaQuery) (pkg.MediaPage, error)
+	// Update replaces the name and tags of a record, media kept in the library this way are never collected
+	Update(tenantNamespace string, media pkg.Media) error
+	/* Delete removes a record, ErrInUse while posts use it unless force removes it from those posts as well.
+	released tells whether it was the last record of its blob, the content is left to the caller. */
+	Delete(tenantNamespace string, mediaId string, force bool) (released bool, err error)
+	// ListUploads returns the unattached uploads of uploadedBy, oldest first
+	ListUploads(tenantNamespace string, uploadedBy string) ([]pkg.Media, error)
+	// ExpiredUploads returns the unattached uploads created before cutoff
+	ExpiredUploads(tenantNamespace string, cutoff time.Time) ([]pkg.Media, error)
+	// DeleteUpload removes an unattached upload like Delete, ErrNotFound when it is missing or attached by now
+	DeleteUpload(tenantNamespace string, mediaId string) (released bool, err error)
+}
+
+/* UploadJobRepository tracks the large uploads processed after the request returned.
+A job is created as pkg.JobProcessing and finished once with its media or its error. */
+type UploadJobRepository interface {
+	Create(tenantNamespace string, job pkg.UploadJob) error
+	Get(tenantNamespace string, jobId string) (pkg.UploadJob, error)
+	// Finish stores the status, media and error of a job
+	Finish(tenantNamespace string, job pkg.UploadJob) error
+	// FailStale fails the jobs still processing that were last updated before cutoff with uploadError
+	FailStale(tenantNamespace string, cutoff time.Time, uploadError pkg.UploadError) (int, error)
+}
+
+/* ApiKeyRepository stores the API keys of a tenant, keys are looked up by the hash of their secret.
+Revoked keys are kept so the listing shows when they were revoked. */
+type ApiKeyRepository interface {
+	Create(tenantNamespace string, key pkg.ApiKey) error
+	// List returns the keys of a tenant, newest first
+	List(tenantNamespace string) ([]pkg.ApiKey, error)
+	// Revoke stops a key from being accepted, ErrNotFound when it is missing or revoked already
+	Revoke(tenantNamespace string, keyId string) error
+	// Authenticate returns the key with hash unless it was revoked and records that it was used
+	Authenticate(tenantNamespace string, hash string) (pkg.ApiKey, error)
+}
@@ -0,0 +1,232 @@
+/* Package secrets encrypts credentials at rest with envelope encryption.
+Every value gets its own random data key which encrypts the value with AES-GCM,
+the data key is wrapped by a key encryption key taken from CREDENTIALS_KEYS.
+CREDENTIALS_KEYS holds comma separated kid:base64-key pairs, the first key encrypts new values
+and the others are kept to decrypt values written before a rotation. */
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Prefix marks encrypted values, values without it are legacy plain text
+const Prefix = "enc:v1:"
+
+var (
+	ErrNoKeys     = errors.New("secrets: CREDENTIALS_KEYS is not set")
+	ErrUnknownKey = errors.New("secrets: value was encrypted with an unknown key")
+	ErrMalformed  = errors.New("secrets: malformed encrypted value")
+)
+
+// Keyring holds the key encryption keys by kid
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+/* NewKeyring parses a kid:base64-key list, the first key is the active one.
+Keys must decode to 16, 24 or 32 bytes. */
+func NewKeyring(spec string) (*Keyring, error) {
+	keyring := &Keyring{keys: map[string][]byte{}}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("secrets: invalid key entry %q", parts[0])
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("secrets: key %s is not base64: %v", parts[0], err)
+		}
+		if _, err = aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("secrets: key %s: %v", parts[0], err)
+		}
+
+		if keyring.active == "" {
+			keyring.active = parts[0]
+		}
+		keyring.keys[parts[0]] = key
+	}
+
+	if keyring.active == "" {
+		return nil, ErrNoKeys
+	}
+	return keyring, nil
+}
+
+// Encrypt seals value under the active key, empty values stay empty
+func (k *Keyring) Encrypt(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return "", err
+	}
+
+	wrapped, err := seal(k.keys[k.active], dek)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dek, []byte(value))
+	if err != nil {
+		return "", err
+	}
+
+	return Prefix + k.active + ":" + base64.RawStdEncoding.EncodeToString(wrapped) + ":" + base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt opens a value produced by Encrypt, legacy plain text values are returned as they are
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, Prefix) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, Prefix), ":")
+	if len(parts) != 3 {
+		return "", ErrMalformed
+	}
+	kek, ok := k.keys[parts[0]]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	dek, err := open(kek, wrapped)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dek, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Current reports whether value is empty or already encrypted under the active key
+func (k *Keyring) Current(value string) bool {
+	return value == "" || strings.HasPrefix(value, Prefix+k.active+":")
+}
+
+// seal encrypts plaintext with AES-GCM and prepends the nonce
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key []byte, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Fingerprint masks a credential so it can be told apart without being revealed
+func Fingerprint(value string) string {
+	if value == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+var (
+	defaultKeyring *Keyring
+	defaultErr     error
+	once           sync.Once
+)
+
+// Default returns the keyring configured by CREDENTIALS_KEYS
+func Default() (*Keyring, error) {
+	once.Do(func() {
+		defaultKeyring, defaultErr = NewKeyring(os.Getenv("CREDENTIALS_KEYS"))
+	})
+	return defaultKeyring, defaultErr
+}
+
+// EncryptAll encrypts every value in place with the default keyring
+func EncryptAll(values ...*string) error {
+	keyring, err := Default()
+	if err != nil {
+		return err
+	}
+
+	for _, value := range values {
+		*value, err = keyring.Encrypt(*value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* DecryptAll decrypts every value in place with the default keyring.
+Plain text values are left alone so rows written before encryption keep working without keys. */
+func DecryptAll(values ...*string) error {
+	var keyring *Keyring
+	for _, value := range values {
+		if !strings.HasPrefix(*value, Prefix) {
+			continue
+		}
+
+		if keyring == nil {
+			var err error
+			keyring, err = Default()
+			if err != nil {
+				return err
+			}
+		}
+
+		plaintext, err := keyring.Decrypt(*value)
+		if err != nil {
+			return err
+		}
+		*value = plaintext
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	oldKey = "2021-01:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	newKey = "2021-03:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	keyring, err := NewKeyring(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := keyring.Encrypt("EAAB-access-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, Prefix+"2021-03:") || strings.Contains(sealed, "access-token") {
+		t.Fatalf("unexpected encrypted value %s", sealed)
+	}
+
+	again, _ := keyring.Encrypt("EAAB-access-token")
+	if again == sealed {
+		t.Fatal("expected a fresh data key for every value")
+	}
+
+	plaintext, err := keyring.Decrypt(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext != "EAAB-access-token" {
+		t.Fatalf("expected the original token got %s", plaintext)
+	}
+}
+
+func TestDecryptLegacyPlainText(t *testing.T) {
+	keyring, _ := NewKeyring(newKey)
+	plaintext, err := keyring.Decrypt("plain-token")
+	if err != nil || plaintext != "plain-token" {
+		t.Fatalf("expected plain text to pass through got %q %v", plaintext, err)
+	}
+	if keyring.Current("plain-token") {
+		t.Fatal("plain text should need encryption")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	before, _ := NewKeyring(oldKey)
+	sealed, err := before.Encrypt("tw-refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := NewKeyring(newKey + "," + oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Current(sealed) {
+		t.Fatal("values sealed under the old key should be rotated")
+	}
+	plaintext, err := after.Decrypt(sealed)
+	if err != nil || plaintext != "tw-refresh" {
+		t.Fatalf("expected the old key to still decrypt got %q %v", plaintext, err)
+	}
+
+	onlyNew, _ := NewKeyring(newKey)
+	if _, err = onlyNew.Decrypt(sealed); err != ErrUnknownKey {
+		t.Fatalf("expected ErrUnknownKey got %v", err)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	keyring, _ := NewKeyring(newKey)
+	sealed, _ := keyring.Encrypt("secret")
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if tampered == sealed {
+		tampered = sealed[:len(sealed)-2] + "BB"
+	}
+	if _, err := keyring.Decrypt(tampered); err == nil {
+		t.Fatal("expected tampered values to be rejected")
+	}
+}
+
+func TestNewKeyringErrors(t *testing.T) {
+	if _, err := NewKeyring(""); err != ErrNoKeys {
+		t.Fatalf("expected ErrNoKeys got %v", err)
+	}
+	if _, err := NewKeyring("k1:c2hvcnQ="); err == nil {
+		t.Fatal("expected short keys to be rejected")
+	}
+}
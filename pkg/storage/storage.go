@@ -0,0 +1,82 @@
+/* Package storage keeps uploaded media out of the database and the source tree.
+Objects are addressed by slash separated keys, the first segment being the tenant namespace. */
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Media store backends selected by MEDIA_STORE
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+var (
+	ErrNotFound   = errors.New("media object not found")
+	ErrInvalidKey = errors.New("invalid media object key")
+)
+
+type MediaStore interface {
+	Put(key string, data []byte, contentType string) error
+	Get(key string) ([]byte, error)
+	// Delete removes the object, deleting a missing object is not an error
+	Delete(key string) error
+	// List returns the keys starting with prefix
+	List(prefix string) ([]string, error)
+}
+
+/* FromEnv builds the store configured by MEDIA_STORE.
+local keeps the objects below MEDIA_ROOT, media by default.
+s3 talks to the bucket MEDIA_BUCKET of MEDIA_S3_ENDPOINT with MEDIA_S3_ACCESS_KEY and MEDIA_S3_SECRET_KEY,
+MEDIA_S3_REGION defaults to us-east-1. */
+func FromEnv() (MediaStore, error) {
+	switch backend := os.Getenv("MEDIA_STORE"); backend {
+	case "", BackendLocal:
+		root := os.Getenv("MEDIA_ROOT")
+		if root == "" {
+			root = "media"
+		}
+		return NewLocalStore(root)
+	case BackendS3:
+		region := os.Getenv("MEDIA_S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		return NewS3Store(S3Config{
+			Endpoint:  os.Getenv("MEDIA_S3_ENDPOINT"),
+			Region:    region,
+			Bucket:    os.Getenv("MEDIA_BUCKET"),
+			AccessKey: os.Getenv("MEDIA_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("MEDIA_S3_SECRET_KEY"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown MEDIA_STORE %q", backend)
+	}
+}
+
+// Key joins the segments of a key, it fails on segments that would escape their prefix
+func Key(segments ...string) (string, error) {
+	for _, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, "/\\") {
+			return "", ErrInvalidKey
+		}
+	}
+	return path.Join(segments...), nil
+}
+
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(root)
+	}()
+
+	store, err := NewLocalStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+
+	if err := store.Put("../escape", []byte("x"), ""); err != ErrInvalidKey {
+		t.Fatalf("expected keys leaving the root to be rejected got %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestKey(t *testing.T) {
+	key, err := Key("postit", "uploads", "cat.png")
+	if err != nil || key != "postit/uploads/cat.png" {
+		t.Fatalf("unexpected key %s: %v", key, err)
+	}
+	for _, segment := range []string{"", "..", "a/b", `a\b`} {
+		if _, err := Key("postit", segment); err != ErrInvalidKey {
+			t.Errorf("expected %q to be rejected", segment)
+		}
+	}
+}
+
+// testStore runs the behaviour every MediaStore has to share
+func testStore(t *testing.T, store MediaStore) {
+	if _, err := store.Get("postit/media/missing"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+
+	objects := map[string]string{
+		"postit/uploads/a.png": "a",
+		"postit/uploads/b.png": "b",
+		"postit/media/c":       "c",
+		"other/uploads/d.png":  "d",
+	}
+	for key, data := range objects {
+		if err := store.Put(key, []byte(data), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := store.Get("postit/uploads/a.png")
+	if err != nil || string(data) != "a" {
+		t.Fatalf("unexpected object %q: %v", data, err)
+	}
+
+	keys, err := store.List("postit/uploads/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"postit/uploads/a.png", "postit/uploads/b.png"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	if err := store.Delete("postit/uploads/a.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("postit/uploads/a.png"); err != nil {
+		t.Fatalf("deleting a missing object should succeed got %v", err)
+	}
+	keys, _ = store.List("postit/uploads/")
+	if !reflect.DeepEqual(keys, []string{"postit/uploads/b.png"}) {
+		t.Fatalf("unexpected keys after delete %v", keys)
+	}
+}
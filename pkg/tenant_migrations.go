@@ -0,0 +1,414 @@
+package pkg
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"gitlab.com/pbobby001/postit-api/db"
+	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"strings"
+)
+
+/* TenantMigration changes the tables of a tenant schema.
+In its statements %[1]s is replaced by the quoted schema name and %[2]s by the schema name as a string literal.
+Tenant schemas were created in different shapes over time, so every statement has to cope with the change being there already. */
+type TenantMigration struct {
+	Version    int64
+	Name       string
+	Statements []string
+}
+
+/* tenantMigrations build and change the tenant schemas, in the order of their versions.
+The goose migrations in db/migrations only change the shared tables, tenant tables are changed by adding a migration here.
+The versions up to 20210407090000 repeat the goose migrations that changed the postit schema by hand. */
+var tenantMigrations = []TenantMigration{
+	{
+		Version: 20210317100000,
+		Name:    "tenants",
+		Statements: []string{
+			`CREATE SCHEMA IF NOT EXISTS %[1]s`,
+			`CREATE TABLE IF NOT EXISTS %[1]s.post
+			(
+				post_id          uuid UNIQUE              NOT NULL,
+				facebook_post_id character varying(200),
+				facebook_user_id character varying(200),
+				post_message     text                     NOT NULL,
+				post_images      bytea[],
+				image_paths      character varying(200)[],
+				hash_tags        text[],
+				post_fb_status   boolean,
+				post_tw_status   boolean,
+				post_li_status   boolean,
+				scheduled        boolean,
+				post_priority    boolean                  NOT NULL,
+				created_at       timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at       timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (post_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS %[1]s.schedule
+			(
+				schedule_id       uuid UNIQUE                 NOT NULL,
+				schedule_title    character varying(200),
+				post_to_feed      boolean                     NOT NULL,
+				schedule_from     timestamp without time zone NOT NULL,
+				schedule_to       timestamp without time zone NOT NULL,
+				post_ids          character varying(200)[]    NOT NULL,
+				duration_per_post float                       NOT NULL,
+				facebook          character varying(200)[]    NOT NULL,
+				twitter           character varying(200)[]    NOT NULL,
+				linked_in         character varying(200)[]    NOT NULL,
+				is_due            boolean,
+				created_at        timestamp with time zone    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at        timestamp with time zone    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (schedule_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS %[1]s.application_info
+			(
+				application_uuid   uuid UNIQUE                   NOT NULL,
+				application_name   character varying(200)        NOT NULL,
+				application_id     character varying(200)        NOT NULL,
+				application_secret text                          NOT NULL,
+				application_url    character varying(200)        NOT NULL,
+				user_access_token  text                          NOT NULL,
+				refresh_token      text                          NOT NULL DEFAULT '',
+				expires_in         integer                       NOT NULL,
+				user_name          character varying(200)        NOT NULL,
+				user_id            character varying(200)        NOT NULL,
+				token_expires_at   timestamp with time zone,
+				token_status       character varying(20)         NOT NULL DEFAULT 'healthy',
+				created_at         timestamp with time zone      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at         timestamp with time zone      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (application_uuid),
+				UNIQUE (application_name, user_id)
+			)`,
+		},
+	},
+	{
+		Version: 20210321090000,
+		Name:    "media",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS %[1]s.media
+			(
+				media_id     uuid                     NOT NULL,
+				content_type character varying(100)   NOT NULL,
+				size         bigint                   NOT NULL,
+				checksum     character varying(64)    NOT NULL,
+				data         bytea                    NOT NULL,
+				created_at   timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (media_id)
+			)`,
+			`ALTER TABLE %[1]s.post ADD COLUMN IF NOT EXISTS media_ids character varying(200)[] NOT NULL DEFAULT '{}'`,
+			// every inline image becomes a media record, the post keeps their ids in the original order
+			`DO $$
+			BEGIN
+				IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = %[2]s AND table_name = 'post' AND column_name = 'post_images') THEN
+					CREATE TEMPORARY TABLE post_image_media AS
+					SELECT p.post_id, i.position, i.data, md5(p.post_id::text || i.position::text || random()::text)::uuid AS media_id
+					FROM %[1]s.post p, unnest(p.post_images) WITH ORDINALITY AS i(data, position)
+					WHERE i.data IS NOT NULL;
+
+					INSERT INTO %[1]s.media (media_id, content_type, size, checksum, data)
+					SELECT media_id,
+						CASE
+							WHEN substring(data FROM 1 FOR 3) = '\xffd8ff'::bytea THEN 'image/jpeg'
+							WHEN substring(data FROM 1 FOR 8) = '\x89504e470d0a1a0a'::bytea THEN 'image/png'
+							WHEN substring(data FROM 1 FOR 4) = '\x47494638'::bytea THEN 'image/gif'
+							ELSE 'application/octet-stream'
+						END,
+						length(data), encode(sha256(data), 'hex'), data
+					FROM post_image_media;
+
+					UPDATE %[1]s.post p SET media_ids = m.media_ids
+					FROM (SELECT post_id, array_agg(media_id::text ORDER BY position) AS media_ids FROM post_image_media GROUP BY post_id) m
+					WHERE p.post_id = m.post_id;
+
+					DROP TABLE post_image_media;
+					ALTER TABLE %[1]s.post DROP COLUMN post_images;
+				END IF;
+			END $$`,
+		},
+	},
+	{
+		Version: 20210323090000,
+		Name:    "media store",
+		Statements: []string{
+			// media content moves to the media store, run the api with -move-media afterwards
+			`ALTER TABLE %[1]s.media ALTER COLUMN data DROP NOT NULL`,
+		},
+	},
+	{
+		Version: 20210325090000,
+		Name:    "media uploads",
+		Statements: []string{
+			`ALTER TABLE %[1]s.media ADD COLUMN IF NOT EXISTS uploaded_by character varying(200) NOT NULL DEFAULT ''`,
+			// every media record stored before uploads came from a post
+			`DO $$
+			BEGIN
+				IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = %[2]s AND table_name = 'media' AND column_name = 'attached') THEN
+					ALTER TABLE %[1]s.media ADD COLUMN attached boolean NOT NULL DEFAULT false;
+					UPDATE %[1]s.media SET attached = true;
+				END IF;
+			END $$`,
+			`CREATE INDEX IF NOT EXISTS media_uploads_idx ON %[1]s.media (created_at) WHERE NOT attached`,
+		},
+	},
+	{
+		Version: 20210327090000,
+		Name:    "upload jobs",
+		Statements: []string{
+			`ALTER TABLE %[1]s.media
+				ADD COLUMN IF NOT EXISTS width  integer NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS height integer NOT NULL DEFAULT 0`,
+			`CREATE TABLE IF NOT EXISTS %[1]s.upload_job
+			(
+				job_id        uuid                     NOT NULL,
+				status        character varying(20)    NOT NULL,
+				media_id      uuid,
+				error_code    character varying(50)    NOT NULL DEFAULT '',
+				error_message text                     NOT NULL DEFAULT '',
+				uploaded_by   character varying(200)   NOT NULL DEFAULT '',
+				created_at    timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at    timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (job_id)
+			)`,
+		},
+	},
+	{
+		Version: 20210329090000,
+		Name:    "media renditions",
+		Statements: []string{
+			// schemas created with media blobs have the table keyed by blob_id already
+			`CREATE TABLE IF NOT EXISTS %[1]s.media_rendition
+			(
+				media_id     uuid                   NOT NULL REFERENCES %[1]s.media (media_id) ON DELETE CASCADE,
+				name         character varying(50)  NOT NULL,
+				network      character varying(50)  NOT NULL DEFAULT '',
+				content_type character varying(100) NOT NULL,
+				width        integer                NOT NULL,
+				height       integer                NOT NULL,
+				size         bigint                 NOT NULL,
+				PRIMARY KEY (media_id, name)
+			)`,
+		},
+	},
+	{
+		Version: 20210331090000,
+		Name:    "media kinds",
+		Statements: []string{
+			`ALTER TABLE %[1]s.media
+				ADD COLUMN IF NOT EXISTS kind     character varying(20) NOT NULL DEFAULT 'image',
+				ADD COLUMN IF NOT EXISTS duration double precision      NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS codec    character varying(20) NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		Version: 20210402090000,
+		Name:    "media library",
+		Statements: []string{
+			`ALTER TABLE %[1]s.media
+				ADD COLUMN IF NOT EXISTS name character varying(255)   NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS tags character varying(100)[] NOT NULL DEFAULT '{}'`,
+			`CREATE INDEX IF NOT EXISTS media_tags_idx ON %[1]s.media USING GIN (tags)`,
+			// the library looks up the posts using a media record through media_ids
+			`CREATE INDEX IF NOT EXISTS post_media_ids_idx ON %[1]s.post USING GIN (media_ids)`,
+		},
+	},
+	{
+		Version: 20210405090000,
+		Name:    "media blobs",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS %[1]s.media_blob
+			(
+				blob_id      uuid                     NOT NULL,
+				checksum     character varying(64)    NOT NULL,
+				content_type character varying(100)   NOT NULL,
+				size         bigint                   NOT NULL,
+				ref_count    integer                  NOT NULL DEFAULT 0,
+				created_at   timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (blob_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS media_blob_checksum_idx ON %[1]s.media_blob (checksum)`,
+			// the content of the media stored so far lives under their own id, every one of them becomes its own blob
+			`DO $$
+			BEGIN
+				IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = %[2]s AND table_name = 'media' AND column_name = 'blob_id') THEN
+					INSERT INTO %[1]s.media_blob (blob_id, checksum, content_type, size, ref_count, created_at)
+					SELECT media_id, checksum, content_type, size, 1, created_at FROM %[1]s.media;
+
+					ALTER TABLE %[1]s.media ADD COLUMN blob_id uuid;
+					UPDATE %[1]s.media SET blob_id = media_id;
+					ALTER TABLE %[1]s.media
+						ALTER COLUMN blob_id SET NOT NULL,
+						ADD CONSTRAINT media_blob_id_fkey FOREIGN KEY (blob_id) REFERENCES %[1]s.media_blob (blob_id);
+				END IF;
+
+				-- renditions belong to the blob they were made from, blob ids equal the media ids so far
+				IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = %[2]s AND table_name = 'media_rendition' AND column_name = 'media_id') THEN
+					ALTER TABLE %[1]s.media_rendition DROP CONSTRAINT IF EXISTS media_rendition_media_id_fkey;
+					ALTER TABLE %[1]s.media_rendition RENAME COLUMN media_id TO blob_id;
+					ALTER TABLE %[1]s.media_rendition
+						ADD CONSTRAINT media_rendition_blob_id_fkey FOREIGN KEY (blob_id) REFERENCES %[1]s.media_blob (blob_id) ON DELETE CASCADE;
+				END IF;
+			END $$`,
+			`CREATE INDEX IF NOT EXISTS media_blob_idx ON %[1]s.media (blob_id)`,
+		},
+	},
+	{
+		Version: 20210407090000,
+		Name:    "api keys",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS %[1]s.api_key
+			(
+				key_id       uuid                     NOT NULL,
+				name         character varying(255)   NOT NULL,
+				prefix       character varying(20)    NOT NULL,
+				key_hash     character(64)            NOT NULL,
+				scopes       character varying(50)[]  NOT NULL DEFAULT '{}',
+				created_by   character varying(200)   NOT NULL DEFAULT '',
+				created_at   timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				last_used_at timestamp with time zone,
+				revoked_at   timestamp with time zone,
+				PRIMARY KEY (key_id)
+			)`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS api_key_hash_idx ON %[1]s.api_key (key_hash)`,
+		},
+	},
+	{
+		Version: 20210411090000,
+		Name:    "unique media blob checksums",
+		Statements: []string{
+			// blobs stored twice for the same content are merged into the oldest one, their renditions go along
+			`WITH blob AS (
+				SELECT blob_id, first_value(blob_id) OVER (PARTITION BY checksum ORDER BY created_at, blob_id) AS keep_id
+				FROM %[1]s.media_blob WHERE checksum <> ''
+			), moved AS (
+				UPDATE %[1]s.media SET blob_id = blob.keep_id FROM blob
+				WHERE media.blob_id = blob.blob_id AND blob.blob_id <> blob.keep_id
+				RETURNING blob.keep_id
+			)
+			UPDATE %[1]s.media_blob SET ref_count = media_blob.ref_count + merged.count
+			FROM (SELECT keep_id, COUNT(*) AS count FROM moved GROUP BY keep_id) merged
+			WHERE media_blob.blob_id = merged.keep_id`,
+			`DELETE FROM %[1]s.media_blob WHERE checksum <> '' AND blob_id NOT IN (
+				SELECT DISTINCT ON (checksum) blob_id FROM %[1]s.media_blob WHERE checksum <> '' ORDER BY checksum, created_at, blob_id
+			)`,
+			// content stored before checksums were kept has none and is never shared
+			`DROP INDEX IF EXISTS %[1]s.media_blob_checksum_idx`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS media_blob_checksum_key ON %[1]s.media_blob (checksum) WHERE checksum <> ''`,
+		},
+	},
+	{
+		Version: 20210413090000,
+		Name:    "accounts per network",
+		Statements: []string{
+			// user ids are only unique within a network, linkedin organizations have ids of their own as well
+			`ALTER TABLE %[1]s.application_info DROP CONSTRAINT IF EXISTS application_info_user_id_key`,
+			`DO $$
+			BEGIN
+				IF NOT EXISTS (
+					SELECT 1 FROM pg_constraint JOIN pg_namespace ON pg_namespace.oid = pg_constraint.connamespace
+					WHERE pg_namespace.nspname = %[2]s AND pg_constraint.conname = 'application_info_application_name_user_id_key'
+				) THEN
+					ALTER TABLE %[1]s.application_info ADD CONSTRAINT application_info_application_name_user_id_key UNIQUE (application_name, user_id);
+				END IF;
+			END $$`,
+		},
+	},
+}
+
+/* MigrateTenants applies the pending tenant migrations to the schema of every registered tenant, suspended ones included.
+A tenant failing to migrate is logged and left at its version, the others are migrated regardless. */
+func MigrateTenants() error {
+	namespaces, err := db.TenantNamespaces(false)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, namespace := range namespaces {
+		err = MigrateTenant(namespace)
+		if err != nil {
+			_ = logs.Logger.Errorf("unable to migrate the schema of tenant %s: %v", namespace, err)
+			failed = append(failed, namespace)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to migrate tenants %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// MigrateTenant applies the pending tenant migrations to the schema of a tenant in a single transaction
+func MigrateTenant(namespace string) error {
+	tx, err := db.Connection.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// locking the registry entry keeps several instances from migrating the same tenant at once
+	var locked string
+	err = tx.QueryRow("SELECT tenant_namespace FROM tenants WHERE tenant_namespace = $1 FOR UPDATE", namespace).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return ErrTenantNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	applied, err := migrateTenantTx(tx, namespace)
+	if err != nil {
+		return err
+	}
+	if applied > 0 {
+		logs.Logger.Infof("applied %d migrations to the schema of tenant %s", applied, namespace)
+	}
+	return tx.Commit()
+}
+
+// migrateTenantTx applies the migrations the tenant has no version recorded for and records them, it returns how many were applied
+func migrateTenantTx(tx *sql.Tx, namespace string) (int, error) {
+	rows, err := tx.Query("SELECT version FROM tenant_schema_migration WHERE tenant_namespace = $1", namespace)
+	if err != nil {
+		return 0, err
+	}
+	versions := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		err = rows.Scan(&version)
+		if err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		versions[version] = true
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, migration := range tenantMigrations {
+		if versions[migration.Version] {
+			continue
+		}
+		for _, statement := range migration.Statements {
+			_, err = tx.Exec(tenantStatement(statement, namespace))
+			if err != nil {
+				return applied, fmt.Errorf("migration %d %s: %v", migration.Version, migration.Name, err)
+			}
+		}
+		_, err = tx.Exec("INSERT INTO tenant_schema_migration (tenant_namespace, version) VALUES ($1, $2)", namespace, migration.Version)
+		if err != nil {
+			return applied, err
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+func tenantStatement(statement string, namespace string) string {
+	return fmt.Sprintf(statement, pq.QuoteIdentifier(namespace), pq.QuoteLiteral(namespace))
+}
@@ -0,0 +1,215 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"gitlab.com/pbobby001/postit-api/db"
+	"regexp"
+	"strings"
+)
+
+// Tenant states
+const (
+	TenantActive    = "active"
+	TenantSuspended = "suspended"
+)
+
+var (
+	ErrInvalidNamespace  = errors.New("tenant namespace must start with a letter and only contain lower case letters, digits and underscores")
+	ErrTenantExists      = errors.New("tenant already exists")
+	ErrTenantNotFound    = errors.New("tenant not found")
+	ErrTenantActive      = errors.New("only suspended tenants can be dropped")
+	ErrTenantSuspended   = errors.New("tenant is suspended")
+	ErrTenantAudience    = errors.New("token was not issued for this tenant")
+	ErrTenantNotResolved = errors.New("Required header: tenant-namespace not found")
+
+	namespacePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{2,62}$`)
+)
+
+// ValidateTenantNamespace makes sure a namespace is usable as a postgres schema name
+func ValidateTenantNamespace(namespace string) error {
+	if !namespacePattern.MatchString(namespace) || strings.HasPrefix(namespace, "pg_") || namespace == "public" || namespace == "information_schema" {
+		return ErrInvalidNamespace
+	}
+	return nil
+}
+
+/* ResolveTenant returns the active tenant registered under namespace.
+The token audience has to name the tenant itself, a token issued for another tenant is rejected. */
+func ResolveTenant(namespace string, audience []string) (Tenant, error) {
+	var tenant Tenant
+	if namespace == "" {
+		return tenant, ErrTenantNotResolved
+	}
+
+	err := ValidateTenantNamespace(namespace)
+	if err != nil {
+		return tenant, err
+	}
+
+	if !hasAudience(audience, namespace) {
+		return tenant, ErrTenantAudience
+	}
+
+	return ActiveTenant(namespace)
+}
+
+// ActiveTenant returns the registered tenant, ErrTenantSuspended when it is suspended
+func ActiveTenant(namespace string) (Tenant, error) {
+	tenant, err := FetchTenant(namespace)
+	if err != nil {
+		return tenant, err
+	}
+	if tenant.Status != TenantActive {
+		return tenant, ErrTenantSuspended
+	}
+	return tenant, nil
+}
+
+func hasAudience(audience []string, namespace string) bool {
+	for _, aud := range audience {
+		if aud == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateTenant records the tenant in the registry and builds its schema with the tenant migrations in a single transaction
+func CreateTenant(namespace string, name string) (Tenant, error) {
+	var tenant Tenant
+	err := ValidateTenantNamespace(namespace)
+	if err != nil {
+		return tenant, err
+	}
+
+	tx, err := db.Connection.Begin()
+	if err != nil {
+		return tenant, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	err = tx.QueryRow(
+		"INSERT INTO tenants (tenant_namespace, tenant_name, status) VALUES ($1, $2, $3) ON CONFLICT (tenant_namespace) DO NOTHING RETURNING tenant_namespace, tenant_name, status, created_at, updated_at",
+		namespace,
+		name,
+		TenantActive,
+	).Scan(&tenant.TenantNamespace, &tenant.Name, &tenant.Status, &tenant.CreatedAt, &tenant.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return tenant, ErrTenantExists
+	}
+	if err != nil {
+		return tenant, err
+	}
+
+	// a schema left behind by hand counts as an existing tenant
+	var exists bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM information_schema.schemata WHERE schema_name = $1)", namespace).Scan(&exists)
+	if err != nil {
+		return tenant, err
+	}
+	if exists {
+		return tenant, ErrTenantExists
+	}
+
+	_, err = migrateTenantTx(tx, namespace)
+	if err != nil {
+		return tenant, err
+	}
+
+	return tenant, tx.Commit()
+}
+
+// FetchTenants lists the registered tenants
+func FetchTenants() ([]Tenant, error) {
+	rows, err := db.Connection.Query("SELECT tenant_namespace, tenant_name, status, created_at, updated_at FROM tenants ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := []Tenant{}
+	for rows.Next() {
+		var tenant Tenant
+		err = rows.Scan(&tenant.TenantNamespace, &tenant.Name, &tenant.Status, &tenant.CreatedAt, &tenant.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, rows.Err()
+}
+
+// FetchTenant returns ErrTenantNotFound when the namespace is not registered
+func FetchTenant(namespace string) (Tenant, error) {
+	var tenant Tenant
+	err := db.Connection.QueryRow("SELECT tenant_namespace, tenant_name, status, created_at, updated_at FROM tenants WHERE tenant_namespace = $1", namespace).
+		Scan(&tenant.TenantNamespace, &tenant.Name, &tenant.Status, &tenant.CreatedAt, &tenant.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return tenant, ErrTenantNotFound
+	}
+	return tenant, err
+}
+
+// SetTenantStatus suspends or reactivates a tenant, suspended tenants keep their data
+func SetTenantStatus(namespace string, status string) error {
+	result, err := db.Connection.Exec("UPDATE tenants SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE tenant_namespace = $2", status, namespace)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrTenantNotFound
+	}
+	return nil
+}
+
+/* DropTenant deletes a suspended tenant's schema, its schedule progress, its outbox messages and its registry entry.
+Requiring the tenant to be suspended first keeps a single request from destroying a live tenant. */
+func DropTenant(namespace string) error {
+	tenant, err := FetchTenant(namespace)
+	if err != nil {
+		return err
+	}
+	if tenant.Status != TenantSuspended {
+		return ErrTenantActive
+	}
+
+	tx, err := db.Connection.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", pq.QuoteIdentifier(namespace)))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM general_schedule_table WHERE tenant_namespace = $1", namespace)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM outbox WHERE tenant_namespace = $1", namespace)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM tenants WHERE tenant_namespace = $1", namespace)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
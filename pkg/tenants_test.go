@@ -0,0 +1,51 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTenantNamespace(t *testing.T) {
+	valid := []string{"postit", "acme_bakery", "shop42"}
+	for _, namespace := range valid {
+		if err := ValidateTenantNamespace(namespace); err != nil {
+			t.Errorf("expected %q to be valid got %v", namespace, err)
+		}
+	}
+
+	invalid := []string{"", "ab", "Acme", "1shop", "acme-bakery", "acme; DROP SCHEMA postit", "public", "pg_temp", "information_schema"}
+	for _, namespace := range invalid {
+		if err := ValidateTenantNamespace(namespace); err != ErrInvalidNamespace {
+			t.Errorf("expected %q to be rejected", namespace)
+		}
+	}
+}
+
+func TestResolveTenantAudience(t *testing.T) {
+	_, err := ResolveTenant("acme", []string{"postit-audience", "postit"})
+	if err != ErrTenantAudience {
+		t.Fatalf("expected ErrTenantAudience got %v", err)
+	}
+
+	_, err = ResolveTenant("acme; DROP SCHEMA postit", []string{"acme; DROP SCHEMA postit"})
+	if err != ErrInvalidNamespace {
+		t.Fatalf("expected ErrInvalidNamespace got %v", err)
+	}
+}
+
+func TestTenantMigrations(t *testing.T) {
+	var previous int64
+	for _, migration := range tenantMigrations {
+		if migration.Version <= previous {
+			t.Errorf("expected migration %d %s to come after %d", migration.Version, migration.Name, previous)
+		}
+		previous = migration.Version
+
+		for _, statement := range migration.Statements {
+			sql := tenantStatement(statement, "acme_bakery")
+			if strings.Contains(sql, "%!") || strings.Contains(sql, "postit.") {
+				t.Errorf("migration %d %s: expected a statement templated on the schema got %s", migration.Version, migration.Name, sql)
+			}
+		}
+	}
+}
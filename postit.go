@@ -2,12 +2,22 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/gorilla/handlers"
 	_ "github.com/joho/godotenv/autoload"
+	"gitlab.com/pbobby001/postit-api/app/mediagc"
 	"gitlab.com/pbobby001/postit-api/app/middlewares"
+	"gitlab.com/pbobby001/postit-api/app/outbox"
+	"gitlab.com/pbobby001/postit-api/app/refresher"
 	"gitlab.com/pbobby001/postit-api/app/router"
+	"gitlab.com/pbobby001/postit-api/app/scheduler"
 	"gitlab.com/pbobby001/postit-api/db"
+	"gitlab.com/pbobby001/postit-api/pkg"
+	"gitlab.com/pbobby001/postit-api/pkg/auth"
 	"gitlab.com/pbobby001/postit-api/pkg/logs"
+	"gitlab.com/pbobby001/postit-api/pkg/publisher"
+	"gitlab.com/pbobby001/postit-api/pkg/repository"
+	"gitlab.com/pbobby001/postit-api/pkg/storage"
 	"golang.org/x/net/context"
 	"io/ioutil"
 	"net/http"
@@ -19,14 +29,60 @@ import (
 func main() {
 	var wait time.Duration
 	flag.DurationVar(&wait, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
+	var encryptCredentials bool
+	flag.BoolVar(&encryptCredentials, "encrypt-credentials", false, "encrypt the stored social media credentials with the active CREDENTIALS_KEYS key and exit")
+	var moveMedia bool
+	flag.BoolVar(&moveMedia, "move-media", false, "move the media content still kept in the database to the configured media store and exit")
 	flag.Parse()
 
 	// Is this better?
 	db.Connect()
 
-	r := router.InitRoutes()
+	// tenant schemas are brought to the latest tenant migration, tenants failing to migrate are logged
+	err := pkg.MigrateTenants()
+	if err != nil {
+		_ = logs.Logger.Error(err)
+	}
+
+	if encryptCredentials {
+		err := encryptAccounts()
+		db.Disconnect()
+		if err != nil {
+			_ = logs.Logger.Error(err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	origins := handlers.AllowedOrigins([]string{"*", "http://localhost:8080", "https://postit-ui.herokuapp.com", "https://postit-dev-ui.herokuapp.com"})
+	store, err := storage.FromEnv()
+	if err != nil {
+		_ = logs.Logger.Error(err)
+		db.Disconnect()
+		os.Exit(1)
+	}
+
+	if moveMedia {
+		err := moveMediaToStore(store)
+		db.Disconnect()
+		if err != nil {
+			_ = logs.Logger.Error(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// tokens cannot be verified without keys, a JWKS endpoint is only fetched along with the first token
+	_, err = auth.Default()
+	if err != nil {
+		_ = logs.Logger.Error(err)
+		db.Disconnect()
+		os.Exit(1)
+	}
+
+	r := router.InitRoutes(store)
+	publisher.UseMedia(repository.PostgresMediaRepository{}, store)
+
+	origins := handlers.AllowedOrigins(append([]string{"*"}, pkg.UIOrigins()...))
 	headers := handlers.AllowedHeaders([]string{
 		"Content-Type",
 		"Content-Length",
@@ -39,13 +95,18 @@ func main() {
 		"Access-Control-Allow-Origin",
 		"tenant-namespace",
 		"trace-id",
+		"X-Admin-Key",
+		pkg.ApiKeyHeader,
 	})
+	// clients pick up the token refreshed by JWTMiddleware from the response
+	exposed := handlers.ExposedHeaders([]string{pkg.RefreshedTokenHeader})
 	methods := handlers.AllowedMethods([]string{
 		http.MethodPost,
 		http.MethodGet,
 		http.MethodPut,
 		http.MethodDelete,
 		http.MethodPut,
+		http.MethodPatch,
 	})
 
 	var port string
@@ -60,11 +121,50 @@ func main() {
 		WriteTimeout: time.Second * 15,
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
-		Handler:      handlers.CORS(origins, headers, methods)(r), // Pass our instance of gorilla/mux in.
+		Handler:      handlers.CORS(origins, headers, methods, exposed)(r), // Pass our instance of gorilla/mux in.
+		// routes like the upload replace the deadlines above through the connection of their request
+		ConnContext: middlewares.ConnContext,
 	}
 
+	// authentication is chosen per route by router.InitRoutes
 	r.Use(middlewares.JSONMiddleware)
-	r.Use(middlewares.JWTMiddleware)
+
+	// push schedule status frames to the websocket clients
+	go pkg.ScheduleStatusHub.Run()
+	go pkg.ScheduleStatusHub.Watch(5 * time.Second)
+
+	// execute schedules in process unless the scheduler micro service is used
+	if !scheduler.External() {
+		interval, err := time.ParseDuration(os.Getenv("SCHEDULER_INTERVAL"))
+		if err != nil {
+			interval = 15 * time.Second
+		}
+		go scheduler.Start(interval)
+	}
+
+	// deliver the notifications recorded in the outbox once their transaction committed
+	if scheduler.External() {
+		outbox.Register(pkg.TopicScheduleCreated, scheduler.Notify)
+	}
+	outboxInterval, err := time.ParseDuration(os.Getenv("OUTBOX_INTERVAL"))
+	if err != nil {
+		outboxInterval = 5 * time.Second
+	}
+	go outbox.Start(outboxInterval)
+
+	// refresh social media tokens before they expire
+	refreshInterval, err := time.ParseDuration(os.Getenv("TOKEN_REFRESH_INTERVAL"))
+	if err != nil {
+		refreshInterval = time.Hour
+	}
+	go refresher.Start(refreshInterval)
+
+	// remove uploads no post was created with and fail the upload jobs a restart interrupted
+	collectInterval, err := time.ParseDuration(os.Getenv("MEDIA_GC_INTERVAL"))
+	if err != nil {
+		collectInterval = time.Hour
+	}
+	go mediagc.Start(repository.PostgresMediaRepository{}, repository.PostgresUploadJobRepository{}, store, collectInterval)
 
 	go func() {
 		for {
@@ -106,7 +206,7 @@ func main() {
 
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
-	err := server.Shutdown(ctx)
+	err = server.Shutdown(ctx)
 	if err != nil {
 		_ = logs.Logger.Error(err)
 		os.Exit(0)
@@ -118,3 +218,37 @@ func main() {
 	_ = logs.Logger.Warn("shutting down")
 	os.Exit(0)
 }
+
+// encryptAccounts encrypts plain text credentials and rotates those sealed under an old key for every tenant
+func encryptAccounts() error {
+	namespaces, err := db.TenantNamespaces(false)
+	if err != nil {
+		return err
+	}
+
+	for _, tenantNamespace := range namespaces {
+		count, err := pkg.EncryptAccounts(tenantNamespace)
+		if err != nil {
+			return fmt.Errorf("%s: %v", tenantNamespace, err)
+		}
+		logs.Logger.Infof("Encrypted the credentials of %d accounts of %s", count, tenantNamespace)
+	}
+	return nil
+}
+
+// moveMediaToStore moves the media content kept in the media table of every tenant to store
+func moveMediaToStore(store storage.MediaStore) error {
+	namespaces, err := db.TenantNamespaces(false)
+	if err != nil {
+		return err
+	}
+
+	for _, tenantNamespace := range namespaces {
+		count, err := pkg.MoveMediaToStore(store, tenantNamespace)
+		if err != nil {
+			return fmt.Errorf("%s: %v", tenantNamespace, err)
+		}
+		logs.Logger.Infof("Moved %d media records of %s to the media store", count, tenantNamespace)
+	}
+	return nil
+}